@@ -2,99 +2,161 @@
 package binvox
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"log"
 	"strings"
 
+	"github.com/gmlewis/irmf-slicer/v3/format"
 	"github.com/gmlewis/irmf-slicer/v3/irmf"
 	"github.com/gmlewis/stldice/v4/binvox"
 )
 
-// Slicer represents a slicer that writes binvox files for multiple
-// materials (from an IRMF model).
-type Slicer interface {
-	NumMaterials() int
-	MaterialName(materialNum int) string // 1-based
-	MBB() (min, max [3]float32)          // in millimeters
-
-	PrepareRenderZ() error
-	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
-	NumXSlices() int
-	NumYSlices() int
-	NumZSlices() int
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model). It is shared by all output formats.
+type Slicer = format.Slicer
+
+func init() {
+	format.Register(format.Format{
+		Name:        "binvox",
+		Extension:   ".binvox",
+		Description: "binvox files, one per material",
+		Write: func(ctx context.Context, baseFilename string, slicer format.Slicer, params format.Params) error {
+			return SliceContext(ctx, baseFilename, slicer)
+		},
+	})
 }
 
+// maxAllVoxels bounds the number of voxels of all materials (as if every
+// voxel were filled) kept in memory to render the materials at once.
+// The materials of larger models are rendered and written one at a time.
+var maxAllVoxels = 1 << 26
+
 // Slice slices an IRMF model into one or more binvox files (one per material).
+// All materials are rendered at once unless the model is too large to keep
+// them all in memory. The translation of each binvox file is the origin of
+// the sliced region, so the outputs of a partial slicing
+// (see irmf.Slicer.SetRegion) keep their position in the whole model.
 func Slice(baseFilename string, slicer Slicer) error {
-	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
-		materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
-
-		filename := fmt.Sprintf("%v-mat%02d-%v.binvox", baseFilename, materialNum, materialName)
-
-		min, max := slicer.MBB()
-		scale := float64(max[2] - min[2])
-		b := binvox.New(
-			slicer.NumXSlices(),
-			slicer.NumYSlices(),
-			slicer.NumZSlices(),
-			float64(min[0]),
-			float64(min[1]),
-			float64(min[2]),
-			scale,
-			false,
-		)
-
-		c := new(b, slicer)
-
-		if err := slicer.PrepareRenderZ(); err != nil {
-			return fmt.Errorf("PrepareRenderZ: %v", err)
-		}
+	return SliceContext(context.Background(), baseFilename, slicer)
+}
 
-		log.Printf("Slicing material %v...", materialName)
-		if err := slicer.RenderZSlices(materialNum, c, irmf.MinToMax); err != nil {
-			return fmt.Errorf("RenderZSlices: %v", err)
+// SliceContext is like Slice, but stops slicing and returns ctx.Err()
+// when ctx is done. Only the files of the materials that were completely
+// sliced are written.
+func SliceContext(ctx context.Context, baseFilename string, slicer Slicer) error {
+	if err := slicer.PrepareRenderZContext(ctx); err != nil {
+		return fmt.Errorf("PrepareRenderZ: %w", err)
+	}
+
+	numMaterials := slicer.NumMaterials()
+	if numMaterials*slicer.NumXSlices()*slicer.NumYSlices()*slicer.NumZSlices() > maxAllVoxels {
+		for materialNum := 1; materialNum <= numMaterials; materialNum++ {
+			c := new([]*binvox.BinVOX{newModel(slicer)}, slicer)
+			log.Printf("Slicing material %v...", slicer.MaterialName(materialNum))
+			if err := slicer.RenderZSlicesContext(ctx, materialNum, c, irmf.MinToMax); err != nil {
+				return fmt.Errorf("RenderZSlices: %w", err)
+			}
+			if err := write(baseFilename, slicer, materialNum, c.models[0]); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+
+	var models []*binvox.BinVOX
+	for range numMaterials {
+		models = append(models, newModel(slicer))
+	}
+	c := new(models, slicer)
 
-		log.Printf("Writing: %v", filename)
-		if err := b.Write(filename, 0, 0, 0, b.NX, b.NY, b.NZ); err != nil {
-			return fmt.Errorf("Write: %v", err)
+	log.Printf("Slicing %v materials...", len(models))
+	if err := slicer.RenderAllZSlicesContext(ctx, c, irmf.MinToMax); err != nil {
+		return fmt.Errorf("RenderAllZSlices: %w", err)
+	}
+
+	for i, b := range models {
+		if err := write(baseFilename, slicer, i+1, b); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
+// newModel returns an empty model of the voxels of a material.
+func newModel(slicer Slicer) *binvox.BinVOX {
+	min, max := slicer.MBB()
+	scale := float64(max[2] - min[2])
+	return binvox.New(
+		slicer.NumXSlices(),
+		slicer.NumYSlices(),
+		slicer.NumZSlices(),
+		float64(min[0]),
+		float64(min[1]),
+		float64(min[2]),
+		scale,
+		false,
+	)
+}
+
+// write writes the binvox file of the material.
+func write(baseFilename string, slicer Slicer, materialNum int, b *binvox.BinVOX) error {
+	materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
+	filename := fmt.Sprintf("%v-mat%02d-%v.binvox", baseFilename, materialNum, materialName)
+
+	log.Printf("Writing: %v", filename)
+	if err := b.Write(filename, 0, 0, 0, b.NX, b.NY, b.NZ); err != nil {
+		return &irmf.WriteError{Filename: filename, Err: err}
+	}
 	return nil
 }
 
 // client represents an IRMF-to-binvox converter.
-// It implements the irmf.SliceProcessor interface.
+// It implements the irmf.MultiZSliceProcessor interface, and the
+// irmf.ZSliceProcessor interface for a single material.
 type client struct {
-	b      *binvox.BinVOX
+	models []*binvox.BinVOX // one per material
 	slicer Slicer
 }
 
-// client implements the ZSliceProcessor interface.
-var _ irmf.ZSliceProcessor = &client{}
+// client implements the MultiZSliceProcessor and ZSliceProcessor interfaces.
+var (
+	_ irmf.MultiZSliceProcessor = &client{}
+	_ irmf.ZSliceProcessor      = &client{}
+)
 
 // new returns a new IRMF-to-binvox client.
-func new(b *binvox.BinVOX, slicer Slicer) *client {
-	return &client{b: b, slicer: slicer}
+func new(models []*binvox.BinVOX, slicer Slicer) *client {
+	return &client{models: models, slicer: slicer}
+}
+
+func (c *client) ProcessZSlices(sliceNum int, z, voxelRadius float32, imgs []image.Image) error {
+	for i, img := range imgs {
+		addSlice(c.models[i], sliceNum, img)
+	}
+	return nil
 }
 
 func (c *client) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	addSlice(c.models[0], sliceNum, img)
+	return nil
+}
+
+// addSlice adds the voxels of the slice image to the model.
+func addSlice(model *binvox.BinVOX, sliceNum int, img image.Image) {
 	b := img.Bounds()
 	uSize := b.Max.X - b.Min.X
 	vSize := b.Max.Y - b.Min.Y
-	c.b.NX = uSize
-	c.b.NY = vSize
+	model.NX = uSize
+	model.NY = vSize
 
 	for v := b.Min.Y; v < b.Max.Y; v++ {
 		for u := b.Min.X; u < b.Max.X; u++ {
 			color := img.At(u, v)
 			if r, _, _, _ := color.RGBA(); r > 0 {
-				c.b.Add(u, v, sliceNum)
+				model.Add(u, v, sliceNum)
 			}
 		}
 	}
-
-	return nil
 }
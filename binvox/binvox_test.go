@@ -1,9 +1,15 @@
 package binvox
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gmlewis/irmf-slicer/v3/irmf"
@@ -11,22 +17,47 @@ import (
 )
 
 type mockSlicer struct {
-	nx, ny, nz int
-	matName    string
+	nx, ny, nz   int
+	matName      string
+	numMaterials int // 1 if 0
+
+	// renders records the rendered materials, 0 for all at once.
+	renders []int
 }
 
-func (m *mockSlicer) NumMaterials() int { return 1 }
+func (m *mockSlicer) IRMF() *irmf.IRMF              { return nil }
+func (m *mockSlicer) PixelFormat() irmf.PixelFormat { return irmf.RGBA8 }
+func (m *mockSlicer) Offset() [3]int                { return [3]int{} }
+func (m *mockSlicer) NumMaterials() int             { return max(1, m.numMaterials) }
 func (m *mockSlicer) MaterialName(materialNum int) string {
 	if m.matName != "" {
 		return m.matName
 	}
-	return "mat1"
+	return fmt.Sprintf("mat%v", materialNum)
 }
 func (m *mockSlicer) MBB() (min, max [3]float32) {
 	return [3]float32{0, 0, 0}, [3]float32{float32(m.nx), float32(m.ny), float32(m.nz)}
 }
-func (m *mockSlicer) PrepareRenderZ() error { return nil }
-func (m *mockSlicer) RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error {
+func (m *mockSlicer) PrepareRenderZContext(ctx context.Context) error { return ctx.Err() }
+func (m *mockSlicer) RenderZSlicesContext(ctx context.Context, materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error {
+	m.renders = append(m.renders, materialNum)
+	return m.render(ctx, func(i int, img image.Image) error {
+		return sp.ProcessZSlice(i, float32(i)+0.5, 0.5, img)
+	})
+}
+func (m *mockSlicer) RenderAllZSlicesContext(ctx context.Context, sp irmf.MultiZSliceProcessor, order irmf.Order) error {
+	m.renders = append(m.renders, 0)
+	return m.render(ctx, func(i int, img image.Image) error {
+		imgs := make([]image.Image, m.NumMaterials())
+		for j := range imgs {
+			imgs[j] = img
+		}
+		return sp.ProcessZSlices(i, float32(i)+0.5, 0.5, imgs)
+	})
+}
+
+// render calls process with the (solid) image of each slice.
+func (m *mockSlicer) render(ctx context.Context, process func(sliceNum int, img image.Image) error) error {
 	img := image.NewRGBA(image.Rect(0, 0, m.nx, m.ny))
 	for y := 0; y < m.ny; y++ {
 		for x := 0; x < m.nx; x++ {
@@ -35,7 +66,10 @@ func (m *mockSlicer) RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, ord
 	}
 
 	for i := 0; i < m.nz; i++ {
-		if err := sp.ProcessZSlice(i, float32(i)+0.5, 0.5, img); err != nil {
+		if err := process(i, img); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 	}
@@ -74,3 +108,64 @@ func TestSliceSolid(t *testing.T) {
 		t.Errorf("Expected dimensions 3x3x3, got %vx%vx%v", b.NX, b.NY, b.NZ)
 	}
 }
+
+func TestSliceContextCanceled(t *testing.T) {
+	slicer := &mockSlicer{nx: 3, ny: 3, nz: 3}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := SliceContext(ctx, "test-canceled", slicer)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SliceContext error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat("test-canceled-mat01-mat1.binvox"); !os.IsNotExist(err) {
+		os.Remove("test-canceled-mat01-mat1.binvox")
+		t.Errorf("SliceContext wrote a binvox file after being canceled")
+	}
+}
+
+func TestSliceWriteError(t *testing.T) {
+	slicer := &mockSlicer{nx: 3, ny: 3, nz: 3}
+
+	err := Slice(filepath.Join(t.TempDir(), "missing", "test"), slicer)
+	var we *irmf.WriteError
+	if !errors.As(err, &we) || !strings.HasSuffix(we.Filename, "test-mat01-mat1.binvox") {
+		t.Errorf("Slice error = %#v, want an *irmf.WriteError of the binvox file", err)
+	}
+}
+
+func TestSliceMaterials(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxAllVoxels int
+		wantRenders  []int
+	}{
+		{name: "all at once", maxAllVoxels: 54, wantRenders: []int{0}},
+		{name: "one at a time", maxAllVoxels: 53, wantRenders: []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n int) { maxAllVoxels = n }(maxAllVoxels)
+			maxAllVoxels = tt.maxAllVoxels
+
+			slicer := &mockSlicer{nx: 3, ny: 3, nz: 3, numMaterials: 2}
+			base := filepath.Join(t.TempDir(), "test")
+			if err := Slice(base, slicer); err != nil {
+				t.Fatalf("Slice: %v", err)
+			}
+			if !reflect.DeepEqual(slicer.renders, tt.wantRenders) {
+				t.Errorf("rendered materials %v, want %v", slicer.renders, tt.wantRenders)
+			}
+			for n := 1; n <= 2; n++ {
+				b, err := binvox.Read(fmt.Sprintf("%v-mat%02d-mat%v.binvox", base, n, n), 0, 0, 0, 0, 0, 0)
+				if err != nil {
+					t.Fatalf("Read: %v", err)
+				}
+				if got := len(b.WhiteVoxels); got != 27 {
+					t.Errorf("material %v has %v voxels, want 27", n, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+// Package cli implements the irmf-slicer command, which slices one or more
+// IRMF shaders into voxel image slices at the requested resolution and
+// writes them in the output formats selected by its -format flag.
+//
+// Programs can reuse the command with their own output formats by
+// registering them (see package format) and calling Main:
+//
+//	import (
+//		"github.com/gmlewis/irmf-slicer/v3/cli"
+//		_ "example.com/myformat" // registers "myformat"
+//	)
+//
+//	func main() { cli.Main() }
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gmlewis/irmf-slicer/v3/format"
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/go-gl/mathgl/mgl32"
+
+	// The built-in output formats.
+	_ "github.com/gmlewis/irmf-slicer/v3/binvox"
+	_ "github.com/gmlewis/irmf-slicer/v3/photon"
+	_ "github.com/gmlewis/irmf-slicer/v3/voxels"
+	_ "github.com/gmlewis/irmf-slicer/v3/zipper"
+)
+
+const defaultRes = 42
+
+// setFlags collects the repeated -set and -format-option flags.
+type setFlags []string
+
+func (s *setFlags) String() string { return strings.Join(*s, ",") }
+
+func (s *setFlags) Set(v string) error {
+	if _, _, ok := strings.Cut(v, "="); !ok {
+		return errors.New("want name=value")
+	}
+	*s = append(*s, v)
+	return nil
+}
+
+var (
+	options       setFlags
+	formatOptions setFlags
+
+	microns = flag.Float64("res", 0.0, "Resolution in microns (default is 42.0)")
+	view    = flag.Bool("view", false, "Render slicing to window")
+	cpu     = flag.Bool("cpu", false, "Render slices on the CPU (no GPU or display required, but much slower)")
+
+	progress = flag.Duration("progress", 0, "Log the slicing progress and the estimated time remaining at this interval (e.g. '30s'; 0 disables)")
+
+	supersampleXY = flag.Int("supersample-xy", 1, "Sample each voxel on an NxN grid in the plane of the slice and write the occupied fraction")
+	supersampleZ  = flag.Int("supersample-z", 1, "Sample each voxel at N depths along the Z axis and write the occupied fraction")
+
+	scale     = flag.String("scale", "", "Scale the model before slicing by a factor or by comma-separated X,Y,Z factors")
+	mirror    = flag.String("mirror", "", "Mirror the model before slicing along the given axes (e.g. 'x' or 'xz')")
+	rotate    = flag.String("rotate", "", "Rotate the model before slicing by comma-separated X,Y,Z angles in degrees, applied about the X, then Y, then Z axis (e.g. '90,0,0' lays it on its side)")
+	translate = flag.String("translate", "", "Translate the model before slicing by comma-separated X,Y,Z millimeters")
+
+	region = flag.String("region", "", "Only slice the part of the model inside comma-separated minX,minY,minZ,maxX,maxY,maxZ (in model units, after -scale, -rotate, etc.); the outputs record its offset in the whole model")
+	layers = flag.String("layers", "", "Only slice the Z layers start:end (0-based, excluding end; an empty end slices through the last layer)")
+
+	pixelFormat = flag.String("pixel-format", "rgba8", "Render slices as 8-bit 'rgba8' or as floating-point 'r16f' or 'r32f' densities (-svx and -zip then write 16-bit PNG slices)")
+
+	includePath  = flag.String("I", "", "Comma-separated list of directories to search for #include files (e.g. a vendored copy of LYGIA)")
+	includeCache = flag.String("include-cache", irmf.DefaultIncludeCacheDir(), "Directory for the cache of downloaded #include files (empty disables the cache)")
+	offline      = flag.Bool("offline", false, "Never download #include files from the network")
+	updateLock   = flag.Bool("update-lock", false, "Re-download remote #include files and record the SHA-256 of every #include file in "+irmf.LockFileName+" next to each IRMF file, without slicing")
+
+	formatNames = flag.String("format", "", "Comma-separated list of the output formats to write, e.g. 'zip,stl' (see -formats)")
+	listFormats = flag.Bool("formats", false, "List the output formats, their default resolutions and their options")
+
+	// The formats can also be selected by these flags.
+	writeBinvox = flag.Bool("binvox", false, "Write binvox files, one per material (same as -format binvox)")
+	writeDLP    = flag.Bool("dlp", false, "Write ChiTuBox .cbddlp files (same as AnyCubic .photon), one per material (same as -format dlp)")
+	writeSTL    = flag.Bool("stl", false, "Write stl files, one per material (same as -format stl)")
+	writeSVX    = flag.Bool("svx", false, "Write slices to svx voxel files, one per material (same as -format svx)")
+	writeZip    = flag.Bool("zip", false, "Write slices to zip files, one per material (same as -format zip)")
+)
+
+// Main runs the irmf-slicer command with the flags and arguments
+// of the process.
+func Main() {
+	flag.Var(&options, "set", "Set a model option as name=value, overriding its default (may be repeated)")
+	flag.Var(&formatOptions, "format-option", "Set an option of an output format as format.option=value, e.g. 'dlp.exposure=8' (see -formats; may be repeated)")
+	flag.Parse()
+
+	if *listFormats {
+		printFormats(os.Stdout)
+		return
+	}
+
+	formats, err := selectedFormats()
+	check("%v", err)
+	optionValues, err := parseFormatOptions(formats)
+	check("%v", err)
+
+	if !*updateLock && len(formats) == 0 {
+		log.Printf("-format (or -binvox, -dlp, -stl, -svx, or -zip) must be supplied to generate output. Testing IRMF shader compilation only.")
+	}
+
+	// The default resolution is that of the first format that has one.
+	xRes, yRes, zRes := float32(defaultRes), float32(defaultRes), float32(defaultRes)
+	if *microns != 0.0 {
+		xRes, yRes, zRes = float32(*microns), float32(*microns), float32(*microns)
+	} else {
+		for _, f := range formats {
+			if f.Resolution != ([3]float32{}) {
+				xRes, yRes, zRes = f.Resolution[0], f.Resolution[1], f.Resolution[2]
+				break
+			}
+		}
+	}
+	log.Printf("Resolution in microns: X: %v, Y: %v, Z: %v", xRes, yRes, zRes)
+
+	var params []format.Params
+	for _, f := range formats {
+		p, err := f.NewParams(xRes, yRes, zRes, optionValues[f.Name])
+		check("-format-option: %v", err)
+		params = append(params, p)
+	}
+
+	// Interrupting the slicer stops it after the current slice
+	// and removes the partially written files.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var searchPath []string
+	if *includePath != "" {
+		searchPath = strings.Split(*includePath, ",")
+	}
+
+	pixFmt, err := irmf.ParsePixelFormat(*pixelFormat)
+	check("-pixel-format: %v", err)
+
+	slicer := irmf.Init(*view, xRes, yRes, zRes)
+	slicer.UseCPU(*cpu)
+	slicer.SetFallback(func(err *irmf.DeviceError) {
+		log.Printf("Unable to initialize GPU renderer (%v); falling back to the CPU renderer.", err)
+	})
+	slicer.SetPixelFormat(pixFmt)
+	slicer.SetSupersampling(*supersampleXY, *supersampleZ)
+	transform, err := placement()
+	check("%v", err)
+	err = slicer.SetTransform(transform)
+	check("%v", err)
+	err = setRegion(slicer)
+	check("%v", err)
+	if *progress > 0 {
+		slicer.SetProgress(logProgress(*progress))
+	}
+	defer slicer.Close()
+
+	for _, arg := range flag.Args() {
+		if !strings.HasSuffix(arg, ".irmf") {
+			log.Printf("Skipping non-IRMF file %q", arg)
+			continue
+		}
+
+		log.Printf("Processing IRMF shader %q...", arg)
+		buf, err := os.ReadFile(arg)
+		check("ReadFile: %v", err)
+
+		slicer.SetIncludeOptions(irmf.IncludeOptions{
+			Dir:        filepath.Dir(arg),
+			Filename:   arg,
+			SearchPath: searchPath,
+			CacheDir:   *includeCache,
+			Offline:    *offline,
+			LockFile:   filepath.Join(filepath.Dir(arg), irmf.LockFileName),
+			UpdateLock: *updateLock,
+		})
+
+		err = slicer.NewModel(buf)
+		check("%v: %v", arg, err)
+
+		if *updateLock {
+			log.Printf("Updated %v", filepath.Join(filepath.Dir(arg), irmf.LockFileName))
+			continue
+		}
+
+		for _, option := range options {
+			name, value, _ := strings.Cut(option, "=")
+			err = slicer.IRMF().SetOption(name, value)
+			check("%v: -set %v: %v", arg, option, err)
+		}
+
+		baseName := strings.TrimSuffix(arg, ".irmf")
+
+		for i, f := range formats {
+			log.Printf("Slicing %v materials into separate %v files (%v slices each)...", slicer.NumMaterials(), f.Extension, slicer.NumZSlices())
+			err = f.Write(ctx, baseName, slicer, params[i])
+			check("%v: %v", f.Name, err)
+		}
+	}
+
+	log.Println("Done.")
+}
+
+// selectedFormats returns the formats selected by the -format flag
+// and by the flags of the built-in formats, without duplicates.
+func selectedFormats() ([]format.Format, error) {
+	var names []string
+	if *formatNames != "" {
+		names = strings.Split(*formatNames, ",")
+	}
+	for _, sel := range []struct {
+		name string
+		set  bool
+	}{
+		{"binvox", *writeBinvox},
+		{"dlp", *writeDLP},
+		{"stl", *writeSTL},
+		{"svx", *writeSVX},
+		{"zip", *writeZip},
+	} {
+		if sel.set {
+			names = append(names, sel.name)
+		}
+	}
+
+	var formats []format.Format
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		f, ok := format.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("-format: unknown format %q (see -formats)", name)
+		}
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
+
+// parseFormatOptions returns the values of the -format-option flags
+// by format and option name.
+func parseFormatOptions(formats []format.Format) (map[string]map[string]string, error) {
+	selected := map[string]bool{}
+	for _, f := range formats {
+		selected[f.Name] = true
+	}
+	values := map[string]map[string]string{}
+	for _, option := range formatOptions {
+		key, value, _ := strings.Cut(option, "=")
+		formatName, name, ok := strings.Cut(key, ".")
+		if !ok {
+			return nil, fmt.Errorf("-format-option: want format.option=value, got %q", option)
+		}
+		if !selected[formatName] {
+			return nil, fmt.Errorf("-format-option %v: the %q format is not selected", option, formatName)
+		}
+		if values[formatName] == nil {
+			values[formatName] = map[string]string{}
+		}
+		values[formatName][name] = value
+	}
+	return values, nil
+}
+
+// printFormats lists the registered formats and their options.
+func printFormats(w io.Writer) {
+	for _, f := range format.Formats() {
+		fmt.Fprintf(w, "%v (%v): %v\n", f.Name, f.Extension, f.Description)
+		if f.Resolution != ([3]float32{}) {
+			fmt.Fprintf(w, "    default resolution in microns: X: %v, Y: %v, Z: %v\n", f.Resolution[0], f.Resolution[1], f.Resolution[2])
+		}
+		for _, o := range f.Options {
+			fmt.Fprintf(w, "    -format-option %v.%v=%v: %v\n", f.Name, o.Name, o.Default, o.Usage)
+		}
+	}
+}
+
+// logProgress returns a progress function that logs the progress
+// at most once per interval, and at the end of each run of slices.
+func logProgress(interval time.Duration) func(irmf.Progress) {
+	var last time.Time
+	return func(p irmf.Progress) {
+		if p.Slice < p.Total && time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+		log.Printf("Slice %v of %v (%.0f%%): %v elapsed, about %v remaining",
+			p.Slice, p.Total, 100*float64(p.Slice)/float64(p.Total), p.Elapsed.Round(time.Second), p.ETA.Round(time.Second))
+	}
+}
+
+// placement returns the transform given by the -scale, -mirror, -rotate
+// and -translate flags, applied in that order.
+func placement() (mgl32.Mat4, error) {
+	s, err := parseVec3("-scale", *scale, 1, true)
+	if err != nil {
+		return mgl32.Mat4{}, err
+	}
+	for _, axis := range *mirror {
+		i := strings.IndexRune("xyz", axis)
+		if i < 0 {
+			return mgl32.Mat4{}, fmt.Errorf("-mirror: unknown axis %q: must be x, y or z", axis)
+		}
+		s[i] = -s[i]
+	}
+	r, err := parseVec3("-rotate", *rotate, 0, false)
+	if err != nil {
+		return mgl32.Mat4{}, err
+	}
+	t, err := parseVec3("-translate", *translate, 0, false)
+	if err != nil {
+		return mgl32.Mat4{}, err
+	}
+	rotation := mgl32.HomogRotate3DZ(mgl32.DegToRad(r[2])).Mul4(mgl32.HomogRotate3DY(mgl32.DegToRad(r[1]))).Mul4(mgl32.HomogRotate3DX(mgl32.DegToRad(r[0])))
+	return mgl32.Translate3D(t[0], t[1], t[2]).Mul4(rotation).Mul4(mgl32.Scale3D(s[0], s[1], s[2])), nil
+}
+
+// setRegion limits the slicer to the -region and -layers flags.
+func setRegion(slicer *irmf.Slicer) error {
+	if *region != "" {
+		parts := strings.Split(*region, ",")
+		if len(parts) != 6 {
+			return fmt.Errorf("-region: want minX,minY,minZ,maxX,maxY,maxZ, got %q", *region)
+		}
+		var min, max [3]float32
+		for i, part := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+			if err != nil {
+				return fmt.Errorf("-region: %v", err)
+			}
+			if i < 3 {
+				min[i] = float32(f)
+			} else {
+				max[i-3] = float32(f)
+			}
+		}
+		if err := slicer.SetRegion(min, max); err != nil {
+			return fmt.Errorf("-region: %v", err)
+		}
+	}
+
+	if *layers != "" {
+		startStr, endStr, ok := strings.Cut(*layers, ":")
+		if !ok {
+			return fmt.Errorf("-layers: want start:end, got %q", *layers)
+		}
+		start, err := strconv.Atoi(startStr)
+		if err != nil {
+			return fmt.Errorf("-layers: %v", err)
+		}
+		var end int
+		if endStr != "" {
+			if end, err = strconv.Atoi(endStr); err != nil {
+				return fmt.Errorf("-layers: %v", err)
+			}
+		}
+		if err := slicer.SetLayerRange(start, end); err != nil {
+			return fmt.Errorf("-layers: %v", err)
+		}
+	}
+	return nil
+}
+
+// parseVec3 parses the comma-separated X,Y,Z values of the named flag,
+// or a single value for all three if single is true. An empty value
+// returns def for all three.
+func parseVec3(name, value string, def float32, single bool) (mgl32.Vec3, error) {
+	if value == "" {
+		return mgl32.Vec3{def, def, def}, nil
+	}
+	parts := strings.Split(value, ",")
+	if single && len(parts) == 1 {
+		parts = []string{parts[0], parts[0], parts[0]}
+	}
+	if len(parts) != 3 {
+		return mgl32.Vec3{}, fmt.Errorf("%v: want X,Y,Z, got %q", name, value)
+	}
+	var v mgl32.Vec3
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return mgl32.Vec3{}, fmt.Errorf("%v: %v", name, err)
+		}
+		v[i] = float32(f)
+	}
+	return v, nil
+}
+
+func check(fmtStr string, args ...interface{}) {
+	err := args[len(args)-1]
+	if err != nil {
+		log.Fatalf(fmtStr, args...)
+	}
+}
@@ -0,0 +1,132 @@
+// Package format is the registry of the output formats of the slicer.
+//
+// Each output package (such as binvox, photon, voxels and zipper) registers
+// its formats when it is imported, and irmf-slicer writes the formats
+// selected by its -format flag. Other formats can be added by registering
+// them from the init function of their own package:
+//
+//	func init() {
+//		format.Register(format.Format{
+//			Name:      "myformat",
+//			Extension: ".myf",
+//			Write:     write,
+//		})
+//	}
+package format
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model) to the output formats. It is implemented
+// by *irmf.Slicer.
+type Slicer interface {
+	IRMF() *irmf.IRMF
+	NumMaterials() int
+	MaterialName(materialNum int) string // 1-based
+	MBB() (min, max [3]float32)          // in millimeters
+	NumXSlices() int
+	NumYSlices() int
+	NumZSlices() int
+	PixelFormat() irmf.PixelFormat
+	Offset() [3]int // in voxels of the whole model
+
+	PrepareRenderZContext(ctx context.Context) error
+	RenderZSlicesContext(ctx context.Context, materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+	RenderAllZSlicesContext(ctx context.Context, sp irmf.MultiZSliceProcessor, order irmf.Order) error
+}
+
+// Slicer is implemented by *irmf.Slicer.
+var _ Slicer = &irmf.Slicer{}
+
+// Format represents an output format.
+type Format struct {
+	Name        string // the name selected by the -format flag, e.g. "zip"
+	Extension   string // the extension of the files written, e.g. ".zip"
+	Description string
+
+	// Resolution is the default X, Y and Z resolution in microns, used
+	// when no resolution is given, or zero for the default of the slicer.
+	Resolution [3]float32
+
+	// Options are the options of the format, such as exposure times.
+	Options []Option
+
+	// Write slices the model and writes the files of the format
+	// (usually one per material) named after baseFilename.
+	Write func(ctx context.Context, baseFilename string, slicer Slicer, params Params) error
+}
+
+// Option represents an option of a Format.
+type Option struct {
+	Name    string
+	Default string
+	Usage   string
+}
+
+// Params are the parameters of the Write function of a Format.
+type Params struct {
+	XRes, YRes, ZRes float32           // the resolution of the slices in microns
+	Options          map[string]string // the value of every option of the format
+}
+
+// NewParams returns the Params for the given resolution in microns and
+// option values. Every option missing from values takes its default.
+func (f Format) NewParams(xRes, yRes, zRes float32, values map[string]string) (Params, error) {
+	params := Params{XRes: xRes, YRes: yRes, ZRes: zRes, Options: map[string]string{}}
+	for _, o := range f.Options {
+		params.Options[o.Name] = o.Default
+	}
+	for name, value := range values {
+		if _, ok := params.Options[name]; !ok {
+			return Params{}, fmt.Errorf("format %q has no option %q", f.Name, name)
+		}
+		params.Options[name] = value
+	}
+	return params, nil
+}
+
+var (
+	mu      sync.RWMutex
+	formats = map[string]Format{}
+)
+
+// Register registers an output format. It panics if the format has no name
+// or Write function, or if a format with the same name is already registered.
+func Register(f Format) {
+	mu.Lock()
+	defer mu.Unlock()
+	if f.Name == "" || f.Write == nil {
+		panic("format: Register of a format without a name or Write function")
+	}
+	if _, ok := formats[f.Name]; ok {
+		panic(fmt.Sprintf("format: Register called twice for format %q", f.Name))
+	}
+	formats[f.Name] = f
+}
+
+// Lookup returns the registered format with the given name.
+func Lookup(name string) (Format, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, ok := formats[name]
+	return f, ok
+}
+
+// Formats returns the registered formats, sorted by name.
+func Formats() []Format {
+	mu.RLock()
+	defer mu.RUnlock()
+	var result []Format
+	for _, f := range formats {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
@@ -0,0 +1,66 @@
+package format
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	write := func(ctx context.Context, baseFilename string, slicer Slicer, params Params) error { return nil }
+	Register(Format{Name: "test-b", Extension: ".b", Write: write})
+	Register(Format{Name: "test-a", Extension: ".a", Write: write, Options: []Option{{Name: "speed", Default: "1"}}})
+
+	var names []string
+	for _, f := range Formats() {
+		names = append(names, f.Name)
+	}
+	if want := []string{"test-a", "test-b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Formats = %v, want %v", names, want)
+	}
+	if f, ok := Lookup("test-a"); !ok || f.Extension != ".a" {
+		t.Errorf("Lookup(test-a) = %+v, %v", f, ok)
+	}
+	if _, ok := Lookup("missing"); ok {
+		t.Error("Lookup(missing) found a format")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("registering test-a twice: want a panic")
+			}
+		}()
+		Register(Format{Name: "test-a", Write: write})
+	}()
+}
+
+func TestNewParams(t *testing.T) {
+	f := Format{Name: "test", Options: []Option{{Name: "speed", Default: "1"}, {Name: "mode", Default: "fast"}}}
+
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "defaults", want: map[string]string{"speed": "1", "mode": "fast"}},
+		{name: "set", values: map[string]string{"speed": "2"}, want: map[string]string{"speed": "2", "mode": "fast"}},
+		{name: "unknown", values: map[string]string{"color": "red"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.NewParams(10, 20, 30, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewParams error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.XRes != 10 || got.YRes != 20 || got.ZRes != 30 || !reflect.DeepEqual(got.Options, tt.want) {
+				t.Errorf("NewParams = %+v, want resolution 10, 20, 30 and options %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package irmf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf/internal/shader"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// CPURenderer is a pure-Go reference renderer that evaluates the
+// IRMF shader on the CPU. It needs no GPU or display, so it can be
+// used on headless machines and in tests, although it is much slower
+// than the OpenGL and WebGPU renderers.
+//
+// Its output matches the OpenGL renderer: each pixel holds the material
+// value (clamped to [0,1]) in all four RGBA channels (or the unclamped
+// value in a *Gray32f for the float PixelFormats) and row 0 of the
+// image is the bottom of the slice.
+type CPURenderer struct {
+	width  int
+	height int
+	format PixelFormat
+
+	wgsl     bool
+	machines []*shader.Machine // one per worker
+	frag     []mgl32.Vec3      // fragVert for each pixel
+
+	planeVertices []float32
+	camera, model mgl32.Mat4
+
+	// The state needed to compile the shader used by RenderAll.
+	irmf        *IRMF
+	opts        []optionValue
+	vec3Str     string
+	allMachines []*shader.Machine
+}
+
+var _ Renderer = &CPURenderer{}
+
+// Init sets the size of the rendered images. view is ignored.
+func (r *CPURenderer) Init(width, height int, view bool) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid image size %vx%v", width, height)
+	}
+	r.width = width
+	r.height = height
+	return nil
+}
+
+func (r *CPURenderer) setPixelFormat(f PixelFormat) { r.format = f }
+
+// Prepare compiles the shader and computes the model-space position
+// of every pixel on the slicing plane.
+func (r *CPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []float32, projection, camera, model mgl32.Mat4) error {
+	opts, err := irmf.uniformOptions()
+	if err != nil {
+		return err
+	}
+	r.irmf, r.opts, r.vec3Str = irmf, opts, vec3Str
+	r.allMachines = nil
+	r.wgsl = irmf.Language == "wgsl"
+	if r.wgsl {
+		r.machines, err = r.newMachines(wgslHeader(opts)+wgslFSHeader, genWGSLFooter(len(irmf.Materials), vec3Str))
+	} else {
+		r.machines, err = r.newMachines(fsHeader+glslOptionUniforms(opts), strings.TrimSuffix(genFooter(len(irmf.Materials), vec3Str), "\x00"))
+	}
+	if err != nil {
+		return err
+	}
+
+	r.planeVertices, r.camera, r.model = planeVertices, camera, model
+	return r.setProjection(projection)
+}
+
+// setProjection recomputes the position of every pixel for a new projection.
+func (r *CPURenderer) setProjection(projection mgl32.Mat4) error {
+	var err error
+	r.frag, err = planePoints(r.width, r.height, r.planeVertices, projection.Mul4(r.camera).Mul4(r.model))
+	return err
+}
+
+// newMachines compiles the model between header and footer
+// and returns a machine for each worker with the options set.
+func (r *CPURenderer) newMachines(header, footer string) ([]*shader.Machine, error) {
+	src, sm := r.irmf.assembleShader(header, footer)
+	lang := "glsl"
+	if r.wgsl {
+		lang = "wgsl"
+	}
+	prog, err := shader.Compile(lang, src)
+	if err != nil {
+		return nil, fmt.Errorf("shader.Compile: %w", &CompileError{Language: lang, Log: sm.rewrite(err.Error())})
+	}
+
+	var machines []*shader.Machine
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		m, err := prog.NewMachine()
+		if err != nil {
+			return nil, fmt.Errorf("NewMachine: %v", err)
+		}
+		for _, opt := range r.opts {
+			name := opt.Name
+			if r.wgsl {
+				name = "uniforms." + name
+			}
+			v := shader.Scalar(float32(opt.value))
+			if opt.Type == "int" {
+				v = shader.Int(int32(opt.value))
+			}
+			if err := m.Set(name, v); err != nil {
+				return nil, fmt.Errorf("option %q: %v", opt.Name, err)
+			}
+		}
+		machines = append(machines, m)
+	}
+	return machines, nil
+}
+
+// planePoints returns the model-space point on the plane of planeVertices
+// under the center of each pixel, ordered bottom row first.
+func planePoints(width, height int, planeVertices []float32, mvp mgl32.Mat4) ([]mgl32.Vec3, error) {
+	if len(planeVertices) < 15 {
+		return nil, fmt.Errorf("need at least 3 plane vertices, got %v values", len(planeVertices))
+	}
+	vertex := func(i int) mgl32.Vec3 {
+		return mgl32.Vec3{planeVertices[5*i], planeVertices[5*i+1], planeVertices[5*i+2]}
+	}
+	v0 := vertex(0)
+	normal := vertex(1).Sub(v0).Cross(vertex(2).Sub(v0))
+	if normal.Len() == 0 {
+		return nil, errors.New("degenerate plane vertices")
+	}
+	inv := mvp.Inv()
+	unproject := func(x, y, z float32) mgl32.Vec3 {
+		return mgl32.TransformCoordinate(mgl32.Vec3{x, y, z}, inv)
+	}
+
+	points := make([]mgl32.Vec3, 0, width*height)
+	for py := 0; py < height; py++ {
+		y := (float32(py)+0.5)/float32(height)*2 - 1
+		for px := 0; px < width; px++ {
+			x := (float32(px)+0.5)/float32(width)*2 - 1
+			// Intersect the ray through the pixel with the plane.
+			near, far := unproject(x, y, -1), unproject(x, y, 1)
+			dir := far.Sub(near)
+			p := near
+			if d := normal.Dot(dir); d != 0 {
+				p = near.Add(dir.Mul(normal.Dot(v0.Sub(near)) / d))
+			}
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+// Render evaluates the shader for every pixel of the slice.
+func (r *CPURenderer) Render(sliceDepth float32, materialNum int) (image.Image, error) {
+	if len(r.machines) == 0 {
+		return nil, errors.New("CPURenderer: Prepare must be called before Render")
+	}
+
+	var img image.Image
+	if r.format == RGBA8 {
+		img = image.NewRGBA(image.Rect(0, 0, r.width, r.height))
+	} else {
+		img = NewGray32f(image.Rect(0, 0, r.width, r.height))
+	}
+	err := r.forEachRow(r.machines, sliceDepth, materialNum, func(m *shader.Machine, y int) error {
+		return r.renderRow(m, img, y)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// RenderAll evaluates the model once for every pixel of the slice
+// and returns the images of all of its materials.
+func (r *CPURenderer) RenderAll(sliceDepth float32) ([]image.Image, error) {
+	if len(r.machines) == 0 {
+		return nil, errors.New("CPURenderer: Prepare must be called before RenderAll")
+	}
+	numMaterials := len(r.irmf.Materials)
+	pass := materialPass{targets: numTargets(numMaterials)}
+	if r.allMachines == nil {
+		var err error
+		if r.wgsl {
+			r.allMachines, err = r.newMachines(wgslHeader(r.opts)+wgslFSHeader, genWGSLFooterAll(numMaterials, r.vec3Str, []materialPass{pass}))
+		} else {
+			r.allMachines, err = r.newMachines(fsHeaderAll(pass.targets)+glslOptionUniforms(r.opts), genFooterAll(numMaterials, r.vec3Str, pass))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	imgs := newSliceImages(numMaterials, r.width, r.height, r.format, r.wgsl)
+	err := r.forEachRow(r.allMachines, sliceDepth, 1, func(m *shader.Machine, y int) error {
+		return r.renderAllRow(m, imgs, y)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imgs.imgs, nil
+}
+
+// forEachRow sets the uniforms of the machines and calls
+// renderRow for every image row, in parallel.
+func (r *CPURenderer) forEachRow(machines []*shader.Machine, sliceDepth float32, materialNum int, renderRow func(m *shader.Machine, y int) error) error {
+	rows := make(chan int, r.height)
+	for y := 0; y < r.height; y++ {
+		rows <- y
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(machines))
+	for i, m := range machines {
+		wg.Add(1)
+		go func(i int, m *shader.Machine) {
+			defer wg.Done()
+			if err := r.setUniforms(m, sliceDepth, materialNum); err != nil {
+				errs[i] = err
+				return
+			}
+			for y := range rows {
+				if errs[i] = renderRow(m, y); errs[i] != nil {
+					return
+				}
+			}
+		}(i, m)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *CPURenderer) setUniforms(m *shader.Machine, sliceDepth float32, materialNum int) error {
+	if r.wgsl {
+		if err := m.Set("uniforms.u_slice", shader.Scalar(sliceDepth)); err != nil {
+			return err
+		}
+		return m.Set("uniforms.u_materialNum", shader.Scalar(float32(materialNum)))
+	}
+	if err := m.Set("u_slice", shader.Scalar(sliceDepth)); err != nil {
+		return err
+	}
+	return m.Set("u_materialNum", shader.Int(int32(materialNum)))
+}
+
+// renderRow renders image row y. OpenGL reads pixels bottom-up, so
+// image row y holds the pixels of framebuffer row y.
+func (r *CPURenderer) renderRow(m *shader.Machine, img image.Image, y int) error {
+	zero := shader.Vec(0, 0, 0, 0)
+	for x := 0; x < r.width; x++ {
+		p := r.frag[y*r.width+x]
+		fragVert := shader.Vec(p[0], p[1], p[2])
+
+		var color shader.Value
+		var err error
+		if r.wgsl {
+			color, err = m.Call("fs_main", fragVert)
+		} else {
+			if err = m.Set("outputColor", zero); err != nil {
+				return err
+			}
+			if err = m.Set("fragVert", fragVert); err != nil {
+				return err
+			}
+			if _, err = m.Call("main"); err == nil {
+				color, _ = m.Get("outputColor")
+			}
+		}
+		switch {
+		case errors.Is(err, shader.ErrDiscard):
+			continue
+		case err != nil:
+			return fmt.Errorf("pixel (%v,%v): %w", x, y, err)
+		}
+
+		switch img := img.(type) {
+		case *image.RGBA:
+			off := img.PixOffset(x, y)
+			for i := 0; i < 4 && i < color.Len(); i++ {
+				img.Pix[off+i] = unorm8(color.At(i))
+			}
+		case *Gray32f:
+			img.SetGray32f(x, y, r.format.density(color.At(0)))
+		}
+	}
+	return nil
+}
+
+// renderAllRow renders image row y of every material.
+func (r *CPURenderer) renderAllRow(m *shader.Machine, imgs *sliceImages, y int) error {
+	numMaterials := len(imgs.imgs)
+	for x := 0; x < r.width; x++ {
+		p := r.frag[y*r.width+x]
+		fragVert := shader.Vec(p[0], p[1], p[2])
+
+		var colors shader.Value
+		var err error
+		if r.wgsl {
+			colors, err = m.Call("fs_all0", fragVert)
+		} else {
+			// Every element of outputColors is written by main.
+			if err = m.Set("fragVert", fragVert); err != nil {
+				return err
+			}
+			if _, err = m.Call("main"); err == nil {
+				colors, _ = m.Get("outputColors")
+			}
+		}
+		switch {
+		case errors.Is(err, shader.ErrDiscard):
+			continue
+		case err != nil:
+			return fmt.Errorf("pixel (%v,%v): %w", x, y, err)
+		}
+
+		for i := 0; i < numMaterials; i++ {
+			imgs.set(i, y*r.width+x, r.format.density(colors.Elem(i/4).At(i%4)))
+		}
+	}
+	return nil
+}
+
+// unorm8 converts a color component to an 8-bit normalized integer
+// the way the GPU writes it to an RGBA8 framebuffer.
+func unorm8(v float32) uint8 {
+	if v != v || v <= 0 { // NaN or negative
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return uint8(math.Round(float64(v) * 255))
+}
+
+// Close releases the compiled shader.
+func (r *CPURenderer) Close() {
+	r.machines = nil
+	r.allMachines = nil
+	r.frag = nil
+}
@@ -0,0 +1,111 @@
+package irmf
+
+import (
+	"image"
+	"os"
+	"testing"
+)
+
+type zSlices []image.Image
+
+func (z *zSlices) ProcessZSlice(sliceNum int, zVal, voxelRadius float32, img image.Image) error {
+	*z = append(*z, img)
+	return nil
+}
+
+func renderCPU(t *testing.T, filename string) []zSlices {
+	t.Helper()
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Init(false, 500, 500, 500)
+	s.UseCPU(true)
+	defer s.Close()
+	if err := s.NewModel(buf); err != nil {
+		t.Fatalf("NewModel: %v", err)
+	}
+	if err := s.PrepareRenderZ(); err != nil {
+		t.Fatalf("PrepareRenderZ: %v", err)
+	}
+	var result []zSlices
+	for n := 1; n <= s.NumMaterials(); n++ {
+		var slices zSlices
+		if err := s.RenderZSlices(n, &slices, MinToMax); err != nil {
+			t.Fatalf("RenderZSlices: %v", err)
+		}
+		result = append(result, slices)
+	}
+	return result
+}
+
+func TestCPURenderer(t *testing.T) {
+	tests := []struct {
+		name         string
+		numMaterials int
+	}{
+		{name: "sphere-1", numMaterials: 1},
+		{name: "sphere-2", numMaterials: 1},
+		{name: "sphere-3", numMaterials: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			glsl := renderCPU(t, "../testdata/"+tt.name+"-glsl.irmf")
+			wgsl := renderCPU(t, "../testdata/"+tt.name+"-wgsl.irmf")
+			if len(glsl) != tt.numMaterials || len(wgsl) != tt.numMaterials {
+				t.Fatalf("got %v GLSL and %v WGSL materials, want %v", len(glsl), len(wgsl), tt.numMaterials)
+			}
+			if got, want := len(glsl[0]), 20; got != want {
+				t.Fatalf("got %v slices, want %v", got, want)
+			}
+
+			mid := glsl[0][len(glsl[0])/2].(*image.RGBA)
+			b := mid.Bounds()
+			if got := mid.RGBAAt(b.Dx()/4, b.Dy()/2); got.R != 255 || got.A != 255 {
+				t.Errorf("pixel left of center = %v, want on", got)
+			}
+			if got := mid.RGBAAt(0, 0); got.R != 0 || got.A != 0 {
+				t.Errorf("corner pixel = %v, want off", got)
+			}
+
+			// The WGSL footer always writes an alpha of 1, so only compare red.
+			for m := range glsl {
+				for n := range glsl[m] {
+					g, w := glsl[m][n].(*image.RGBA), wgsl[m][n].(*image.RGBA)
+					if g.Bounds() != w.Bounds() {
+						t.Fatalf("material %v, slice %v: GLSL bounds %v, WGSL bounds %v", m+1, n, g.Bounds(), w.Bounds())
+					}
+					for i := 0; i < len(g.Pix); i += 4 {
+						if g.Pix[i] != w.Pix[i] {
+							t.Fatalf("material %v, slice %v: GLSL and WGSL renderings differ at offset %v", m+1, n, i)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCPURendererManyMaterials(t *testing.T) {
+	for _, lang := range []string{"glsl", "wgsl"} {
+		t.Run(lang, func(t *testing.T) {
+			materials := renderCPU(t, "../testdata/layers-20-"+lang+".irmf")
+			if got, want := len(materials), 20; got != want {
+				t.Fatalf("got %v materials, want %v", got, want)
+			}
+			// Each 1mm layer is two 0.5mm slices thick.
+			for m, slices := range materials {
+				for n, slice := range slices {
+					img := slice.(*image.RGBA)
+					b := img.Bounds()
+					got := img.RGBAAt(b.Dx()/2, b.Dy()/2).R != 0
+					if want := n/2 == m; got != want {
+						t.Errorf("material %v, slice %v: got %v, want %v", m+1, n, got, want)
+					}
+				}
+			}
+		})
+	}
+}
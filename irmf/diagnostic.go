@@ -0,0 +1,59 @@
+package irmf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Severity represents the severity of a Diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic represents a problem found while parsing an IRMF file.
+type Diagnostic struct {
+	Severity Severity
+	Line     int    // 1-based line in the IRMF file, or 0 if unknown
+	Column   int    // 1-based column, or 0 if unknown
+	Key      string // the JSON header key involved, if any
+	Message  string
+
+	err error // the error returned by newModel
+}
+
+// String returns the diagnostic in the usual "line:col: severity: message"
+// form, omitting any unknown position.
+func (d Diagnostic) String() string {
+	switch {
+	case d.Line > 0 && d.Column > 0:
+		return fmt.Sprintf("%v:%v: %v: %v", d.Line, d.Column, d.Severity, d.Message)
+	case d.Line > 0:
+		return fmt.Sprintf("%v: %v: %v", d.Line, d.Severity, d.Message)
+	default:
+		return fmt.Sprintf("%v: %v", d.Severity, d.Message)
+	}
+}
+
+// asError returns the error of an error diagnostic: its IncludeError,
+// or a ParseError.
+func (d Diagnostic) asError() error {
+	var ie *IncludeError
+	if errors.As(d.err, &ie) {
+		return d.err
+	}
+	return &ParseError{Line: d.Line, Column: d.Column, Key: d.Key, Err: d.err}
+}
@@ -0,0 +1,112 @@
+package irmf
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	sphere, err := os.ReadFile("../testdata/sphere-1-glsl.irmf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		src       string
+		includes  bool
+		wantModel bool
+		want      []string
+	}{
+		{
+			name:      "valid",
+			src:       string(sphere),
+			wantModel: true,
+		},
+		{
+			name: "errors and warnings together",
+			src: `/*{
+  irmf: "2.0",
+  materials: ["PLA","PLA"],
+  "colour": "red",
+  max: [5,5,5],
+  min: [6,-5,-5],
+  units: "furlongs",
+  language: "wgsl",
+  glslVersion: "300 es",
+}*/
+fn mainModel4(xyz: vec3f) -> vec4f { return vec4f(1.0); }
+`,
+			wantModel: true,
+			want: []string{
+				`4:3: warning: unknown key "colour" is ignored`,
+				`2:3: error: unsupported IRMF version: 2.0`,
+				`3:3: warning: material name "PLA" is used more than once`,
+				`7:3: error: unsupported units "furlongs": must be one of: µm, mm, cm, m, in`,
+				`5:3: error: min.x (6) must be strictly less than max.x (5)`,
+				`9:3: warning: glslVersion is ignored for WGSL shaders`,
+			},
+		},
+		{
+			name: "missing function",
+			src:  "/*{\n\"irmf\": \"1.0\",\n\"materials\": [\"PLA\"],\n\"max\": [1,1,1],\n\"min\": [-1,-1,-1],\n\"units\": \"mm\"\n}*/\nvoid main() {}\n",
+			want: []string{
+				`3:1: error: Found 1 materials, but missing 'mainModel4' function`,
+			},
+			wantModel: true,
+		},
+		{
+			name:      "more than 16 materials requires IRMF 1.1",
+			src:       "/*{\n\"irmf\": \"1.0\",\n\"materials\": [\"1\",\"2\",\"3\",\"4\",\"5\",\"6\",\"7\",\"8\",\"9\",\"10\",\"11\",\"12\",\"13\",\"14\",\"15\",\"16\",\"17\"],\n\"max\": [1,1,1],\n\"min\": [-1,-1,-1],\n\"units\": \"mm\"\n}*/\nvoid mainModel17(out float materials[17], in vec3 xyz) {}\n",
+			wantModel: true,
+			want: []string{
+				`3:1: error: IRMF 1.0 only supports up to 16 materials, found 17 (use "irmf": "1.1" for more)`,
+			},
+		},
+		{
+			name: "missing header",
+			src:  "void main() {}",
+			want: []string{`1: error: Unable to find leading "/*{"`},
+		},
+		{
+			name: "bad JSON type",
+			src:  "/*{\n\"irmf\": \"1.0\",\n\"materials\": \"PLA\"\n}*/\n",
+			want: []string{`3: error: unable to parse JSON blob: json: cannot unmarshal string into Go struct field IRMF.materials of type []string`},
+		},
+		{
+			name:      "includes are not resolved by Parse",
+			src:       "/*{\n\"irmf\": \"1.0\",\n\"materials\": [\"PLA\"],\n\"max\": [1,1,1],\n\"min\": [-1,-1,-1],\n\"units\": \"mm\"\n}*/\n#include \"missing.glsl\"\n",
+			wantModel: true,
+		},
+		{
+			name:      "includes are resolved by ParseWithIncludes",
+			src:       "/*{\n\"irmf\": \"1.0\",\n\"materials\": [\"PLA\"],\n\"max\": [1,1,1],\n\"min\": [-1,-1,-1],\n\"units\": \"mm\"\n}*/\n#include \"missing.glsl\"\n",
+			includes:  true,
+			wantModel: true,
+			want:      []string{`8: error: #include "missing.glsl": file not found`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m *IRMF
+			var diags []Diagnostic
+			if tt.includes {
+				m, diags = ParseWithIncludes([]byte(tt.src), IncludeOptions{Dir: t.TempDir()})
+			} else {
+				m, diags = Parse([]byte(tt.src))
+			}
+			if (m != nil) != tt.wantModel {
+				t.Errorf("Parse model = %v, want model %v", m, tt.wantModel)
+			}
+			var got []string
+			for _, d := range diags {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse diagnostics =\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package irmf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EncodeOptions controls how Encode writes an IRMF file.
+type EncodeOptions struct {
+	// InlineIncludes replaces the "#include" lines in the shader with the
+	// source they refer to, resolved with Includes, so that the file is
+	// self-contained. (The shader of a parsed model is already inlined.)
+	InlineIncludes bool
+	Includes       IncludeOptions
+}
+
+// Marshal returns the IRMF file for the model, encoding the shader as
+// specified by i.Encoding ("gzip", "gzip+base64" or none).
+func (i *IRMF) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := i.Encode(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes the IRMF file for the model to w: a canonical JSON header
+// (one key per line, in the order of the IRMF specification) within
+// "/*{ ... }*/" followed by the shader, encoded as specified by i.Encoding.
+// opts may be nil.
+func (i *IRMF) Encode(w io.Writer, opts *EncodeOptions) error {
+	if i.Language == "go" {
+		return errors.New("models written in Go cannot be encoded as IRMF files")
+	}
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+
+	shader := i.Shader
+	if opts.InlineIncludes {
+		var err error
+		if shader, _, err = newIncludeResolver(opts.Includes).processIncludes(shader, 1); err != nil {
+			return err
+		}
+	}
+
+	header, err := i.marshalHeader()
+	if err != nil {
+		return err
+	}
+	if lineNum, err := i.validate(header, shader); err != nil {
+		return fmt.Errorf("invalid JSON blob on line %v: %v", lineNum, err)
+	}
+
+	var body []byte
+	switch encoding := i.encoding(); encoding {
+	case "":
+		body = []byte(shader)
+	case "gzip", "gzip+base64":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(zw, shader); err != nil {
+			return fmt.Errorf("gzip: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("gzip: %v", err)
+		}
+		body = buf.Bytes()
+		if encoding == "gzip+base64" {
+			body = []byte(base64.RawStdEncoding.EncodeToString(body) + "\n")
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "/*%v*/\n", header); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+func (i *IRMF) encoding() string {
+	if i.Encoding == nil {
+		return ""
+	}
+	return *i.Encoding
+}
+
+// marshalHeader returns the JSON header with one key per line,
+// in the order of jsonKeys, and compact values.
+func (i *IRMF) marshalHeader() (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(i); err != nil {
+		return "", err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, key := range jsonKeys {
+		if v, ok := fields[key]; ok {
+			lines = append(lines, fmt.Sprintf("  %q: %s", key, v))
+		}
+	}
+	return "{\n" + strings.Join(lines, ",\n") + "\n}", nil
+}
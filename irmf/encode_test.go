@@ -0,0 +1,99 @@
+package irmf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	filenames, err := filepath.Glob("../testdata/*.irmf")
+	if err != nil || len(filenames) == 0 {
+		t.Fatalf("Glob = %v, %v", filenames, err)
+	}
+
+	for _, filename := range filenames {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, encoding := range []string{"", "gzip", "gzip+base64"} {
+			t.Run(filepath.Base(filename)+"/"+encoding, func(t *testing.T) {
+				m, err := newModel(src, IncludeOptions{})
+				if err != nil {
+					t.Fatalf("newModel: %v", err)
+				}
+				if encoding != "" {
+					m.Encoding = &encoding
+				}
+
+				buf, err := m.Marshal()
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				if encoding == "" && string(buf) != string(src) {
+					t.Errorf("Marshal =\n%s\nwant:\n%s", buf, src)
+				}
+
+				got, err := newModel(buf, IncludeOptions{})
+				if err != nil {
+					t.Fatalf("newModel(Marshal): %v", err)
+				}
+				got.lineMap, m.lineMap = nil, nil
+				if !reflect.DeepEqual(got, m) {
+					t.Errorf("newModel(Marshal(m)) = %#v, want %#v", got, m)
+				}
+			})
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "util.glsl"), "float radius() { return 5.0; }")
+	m := &IRMF{
+		IRMFVersion: "1.0",
+		Language:    "glsl",
+		Materials:   []string{"PLA <white>"},
+		Max:         []float32{5, 5, 5},
+		Min:         []float32{-5, -5, -5},
+		Units:       "mm",
+		Shader:      "#include \"util.glsl\"\nvoid mainModel4(out vec4 materials, in vec3 xyz) {\n  materials[0] = length(xyz) <= radius() ? 1.0 : 0.0;\n}\n",
+	}
+
+	var buf strings.Builder
+	if err := m.Encode(&buf, &EncodeOptions{InlineIncludes: true, Includes: IncludeOptions{Dir: dir}}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := `/*{
+  "irmf": "1.0",
+  "language": "glsl",
+  "materials": ["PLA <white>"],
+  "max": [5,5,5],
+  "min": [-5,-5,-5],
+  "units": "mm"
+}*/
+float radius() { return 5.0; }
+void mainModel4(out vec4 materials, in vec3 xyz) {
+  materials[0] = length(xyz) <= radius() ? 1.0 : 0.0;
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Encode =\n%v\nwant:\n%v", got, want)
+	}
+
+	m.Materials = nil
+	if _, err := m.Marshal(); err == nil || !strings.Contains(err.Error(), "must list at least one material name") {
+		t.Errorf("Marshal error = %v, want invalid materials", err)
+	}
+
+	goModel, err := NewGoModel(sphereMeta, sphereFunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := goModel.Marshal(); err == nil {
+		t.Error("Marshal of a Go model succeeded, want error")
+	}
+}
@@ -0,0 +1,193 @@
+package irmf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf/internal/shader"
+)
+
+// entryPoint describes the mainModel function required for a number of materials.
+type entryPoint struct {
+	name       string
+	glslOut    string // type of the GLSL "out" parameter
+	wgslResult string // WGSL return type
+}
+
+// maxMaterials is the maximum number of materials of an IRMF 1.1 model.
+const maxMaterials = 256
+
+// entryPointFor returns the entry point for the number of materials.
+// Up to 16 materials are packed into a vector or matrix (IRMF 1.0),
+// while IRMF 1.1 models with more materials return an array of exactly
+// numMaterials floats from mainModelN (e.g. mainModel24).
+func entryPointFor(numMaterials int) entryPoint {
+	switch {
+	case numMaterials <= 4:
+		return entryPoint{name: "mainModel4", glslOut: "vec4", wgslResult: "vec4<f32>"}
+	case numMaterials <= 9:
+		return entryPoint{name: "mainModel9", glslOut: "mat3", wgslResult: "mat3x3<f32>"}
+	case numMaterials <= 16:
+		return entryPoint{name: "mainModel16", glslOut: "mat4", wgslResult: "mat4x4<f32>"}
+	default:
+		return entryPoint{
+			name:       fmt.Sprintf("mainModel%v", numMaterials),
+			glslOut:    fmt.Sprintf("float[%v]", numMaterials),
+			wgslResult: fmt.Sprintf("array<f32,%v>", numMaterials),
+		}
+	}
+}
+
+// glslSignature returns the expected GLSL declaration of the entry point.
+func (e entryPoint) glslSignature() string {
+	return fmt.Sprintf("void %v(out %v materials, in vec3 xyz)", e.name, e.glslOut)
+}
+
+// wgslSignature returns the expected WGSL declaration of the entry point.
+func (e entryPoint) wgslSignature() string {
+	return fmt.Sprintf("fn %v(xyz: vec3f) -> %v", e.name, shortWGSLType(e.wgslResult))
+}
+
+// canonicalType maps the aliases of the types used by entry points
+// (such as GLSL "mat3x3" or WGSL "vec3f") to a single spelling.
+func canonicalType(t string) string {
+	switch t {
+	case "mat3x3":
+		return "mat3"
+	case "mat4x4":
+		return "mat4"
+	case "vec3f":
+		return "vec3<f32>"
+	case "vec4f":
+		return "vec4<f32>"
+	case "mat3x3f":
+		return "mat3x3<f32>"
+	case "mat4x4f":
+		return "mat4x4<f32>"
+	}
+	// WGSL array lengths may have an integer suffix, as in array<f32,24u>.
+	if rest, ok := strings.CutPrefix(t, "array<f32,"); ok {
+		return "array<f32," + strings.TrimRight(strings.TrimSuffix(rest, ">"), "iu") + ">"
+	}
+	return t
+}
+
+func shortWGSLType(t string) string {
+	return strings.NewReplacer("<f32>", "f", ",", ", ").Replace(t)
+}
+
+// checkEntryPoint verifies that the shader declares the mainModel function
+// required by the number of materials, with the right signature.
+// The returned diagnostics (other than a missing function, which is
+// reported against the "materials" key by check) point at the declaration.
+func (i *IRMF) checkEntryPoint(jsonBlobStr, shaderSrc string) []Diagnostic {
+	lang := i.Language
+	if lang == "" {
+		lang = "glsl"
+	}
+	e := entryPointFor(len(i.Materials))
+
+	// The line map only applies to the shader it was made for.
+	lineMap := i.lineMap
+	if shaderSrc != i.Shader || len(lineMap) != strings.Count(shaderSrc, "\n")+1 {
+		lineMap = nil
+	}
+	c := &entryPointChecker{e: e, lineMap: lineMap}
+
+	decls, err := shader.FindFuncs(lang, shaderSrc, e.name)
+	if err != nil {
+		var se *shader.Error
+		if errors.As(err, &se) {
+			c.errorf(se.Line, se.Col, "%v", se.Msg)
+		} else {
+			c.errorf(0, 0, "%v", err)
+		}
+		return c.diags
+	}
+
+	var defined bool
+	for _, d := range decls {
+		defined = defined || d.Body
+		if lang == "wgsl" {
+			c.checkWGSL(d)
+		} else {
+			c.checkGLSL(d)
+		}
+	}
+	diags := c.diags
+	if !defined {
+		fn := e.name
+		if lang == "wgsl" {
+			fn = "fn " + fn
+		}
+		line, col := findKey(jsonBlobStr, "materials")
+		err := fmt.Errorf("Found %v materials, but missing '%v' function", len(i.Materials), fn)
+		diags = append([]Diagnostic{{Severity: SeverityError, Line: line, Column: col, Key: "materials", Message: err.Error(), err: err}}, diags...)
+	}
+	return diags
+}
+
+// entryPointChecker collects the problems with the declarations of an entry point.
+type entryPointChecker struct {
+	e       entryPoint
+	lineMap []sourceLine // nil if unknown
+	diags   []Diagnostic
+}
+
+func (c *entryPointChecker) checkGLSL(d shader.FuncDecl) {
+	e := c.e
+	if d.Result != "void" {
+		c.errorf(d.Line, d.Col, "%v must return void, found %v; want: %v", e.name, d.Result, e.glslSignature())
+	}
+	if len(d.Params) != 2 {
+		c.errorf(d.Line, d.Col, "%v must have 2 parameters, found %v; want: %v", e.name, len(d.Params), e.glslSignature())
+		return
+	}
+
+	materials, xyz := d.Params[0], d.Params[1]
+	if canonicalType(materials.Type) != e.glslOut || !slices.Contains(materials.Qualifiers, "out") {
+		c.errorf(materials.Line, materials.Col, "first parameter of %v must be 'out %v', found '%v'", e.name, e.glslOut, materials)
+	}
+	if canonicalType(xyz.Type) != "vec3" || slices.Contains(xyz.Qualifiers, "out") || slices.Contains(xyz.Qualifiers, "inout") {
+		c.errorf(xyz.Line, xyz.Col, "second parameter of %v must be 'in vec3', found '%v'", e.name, xyz)
+	}
+}
+
+func (c *entryPointChecker) checkWGSL(d shader.FuncDecl) {
+	e := c.e
+	if len(d.Params) != 1 {
+		c.errorf(d.Line, d.Col, "%v must have 1 parameter, found %v; want: %v", e.name, len(d.Params), e.wgslSignature())
+	} else if p := d.Params[0]; canonicalType(p.Type) != "vec3<f32>" {
+		c.errorf(p.Line, p.Col, "parameter of %v must be a vec3f, found '%v: %v'", e.name, p.Name, p.Type)
+	}
+	if canonicalType(d.Result) != e.wgslResult {
+		result := shortWGSLType(d.Result)
+		if result == "" {
+			result = "nothing"
+		}
+		c.errorf(d.Line, d.Col, "%v must return %v, found %v; want: %v", e.name, shortWGSLType(e.wgslResult), result, e.wgslSignature())
+	}
+}
+
+// errorf reports an error at the given 1-based line and column of the
+// shader (after includes are expanded). Positions in the IRMF file are
+// reported in Line and Column, while positions in include files are
+// added to the message.
+func (c *entryPointChecker) errorf(line, col int, format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	d := Diagnostic{Severity: SeverityError, Message: err.Error(), err: err}
+	switch {
+	case line <= 0:
+	case c.lineMap == nil || line > len(c.lineMap):
+		d.Line, d.Column = line, col
+	case c.lineMap[line-1].included:
+		from := c.lineMap[line-1]
+		d.Message = fmt.Sprintf("%v:%v:%v: %v", from.file, from.line, col, d.Message)
+		d.err = errors.New(d.Message)
+	default:
+		d.Line, d.Column = c.lineMap[line-1].line, col
+	}
+	c.diags = append(c.diags, d)
+}
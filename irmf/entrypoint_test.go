@@ -0,0 +1,173 @@
+package irmf
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// entryPointModel returns an IRMF file with the given language, number of
+// materials and shader. The shader starts on line 9.
+func entryPointModel(lang string, numMaterials int, shader string) string {
+	version := "1.0"
+	if numMaterials > 16 {
+		version = "1.1"
+	}
+	var materials []string
+	for n := range numMaterials {
+		materials = append(materials, fmt.Sprintf(`"PLA %v"`, n+1))
+	}
+	return fmt.Sprintf(`/*{
+"irmf": %q,
+"language": %q,
+"materials": [%v],
+"max": [1,1,1],
+"min": [-1,-1,-1],
+"units": "mm"
+}*/
+%v`, version, lang, strings.Join(materials, ","), shader)
+}
+
+func TestCheckEntryPoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		lang         string
+		numMaterials int
+		shader       string
+		want         []string
+	}{
+		{
+			name:   "glsl",
+			lang:   "glsl",
+			shader: "#define R 1.0\nvoid mainModel4(out vec4 materials, in vec3 xyz);\nvoid mainModel4(out highp vec4 m, vec3 p) {\n  m[0] = R;\n}\n",
+		},
+		{
+			name:   "glsl name in a comment",
+			lang:   "glsl",
+			shader: "// void mainModel4(out vec4 materials, in vec3 xyz)\n/* mainModel4 */\nvoid main() {}\n",
+			want:   []string{`4:1: error: Found 1 materials, but missing 'mainModel4' function`},
+		},
+		{
+			name:   "glsl prototype only",
+			lang:   "glsl",
+			shader: "void mainModel4(out vec4 materials, in vec3 xyz);\n",
+			want:   []string{`4:1: error: Found 1 materials, but missing 'mainModel4' function`},
+		},
+		{
+			name:   "glsl wrong parameter types",
+			lang:   "glsl",
+			shader: "\nvoid mainModel4(out vec3 materials,\n                out vec3 xyz) {}\n",
+			want: []string{
+				`10:17: error: first parameter of mainModel4 must be 'out vec4', found 'out vec3 materials'`,
+				`11:17: error: second parameter of mainModel4 must be 'in vec3', found 'out vec3 xyz'`,
+			},
+		},
+		{
+			name:   "glsl missing out",
+			lang:   "glsl",
+			shader: "void mainModel4(vec4 materials, in vec3 xyz) {}\n",
+			want:   []string{`9:17: error: first parameter of mainModel4 must be 'out vec4', found 'vec4 materials'`},
+		},
+		{
+			name:   "glsl return type and parameter count",
+			lang:   "glsl",
+			shader: "float mainModel4(in vec3 xyz) { return 1.0; }\n",
+			want: []string{
+				`9:7: error: mainModel4 must return void, found float; want: void mainModel4(out vec4 materials, in vec3 xyz)`,
+				`9:7: error: mainModel4 must have 2 parameters, found 1; want: void mainModel4(out vec4 materials, in vec3 xyz)`,
+			},
+		},
+		{
+			name:         "glsl wrong entry point for materials",
+			lang:         "glsl",
+			numMaterials: 5,
+			shader:       "void mainModel4(out vec4 materials, in vec3 xyz) {}\n",
+			want:         []string{`4:1: error: Found 5 materials, but missing 'mainModel9' function`},
+		},
+		{
+			name:         "glsl mat3x3",
+			lang:         "glsl",
+			numMaterials: 9,
+			shader:       "void mainModel9(out mat3x3 materials, in vec3 xyz) {}\n",
+		},
+		{
+			name:         "glsl more than 16 materials",
+			lang:         "glsl",
+			numMaterials: 24,
+			shader:       "void mainModel24(out float materials[24], in vec3 xyz) {}\n",
+		},
+		{
+			name:         "glsl wrong array size",
+			lang:         "glsl",
+			numMaterials: 24,
+			shader:       "void mainModel24(out float[16] materials, in vec3 xyz) {}\n",
+			want:         []string{`9:18: error: first parameter of mainModel24 must be 'out float[24]', found 'out float[16] materials'`},
+		},
+		{
+			name:   "wgsl",
+			lang:   "wgsl",
+			shader: "fn mainModel4(xyz: vec3<f32>) -> vec4<f32> {\n  return vec4f(1.0);\n}\n",
+		},
+		{
+			name:         "wgsl wrong return type",
+			lang:         "wgsl",
+			numMaterials: 16,
+			shader:       "fn mainModel16(xyz: vec3f) -> mat3x3f {\n  return mat3x3f();\n}\n",
+			want:         []string{`9:4: error: mainModel16 must return mat4x4f, found mat3x3f; want: fn mainModel16(xyz: vec3f) -> mat4x4f`},
+		},
+		{
+			name:         "wgsl more than 16 materials",
+			lang:         "wgsl",
+			numMaterials: 17,
+			shader:       "fn mainModel17(xyz: vec3f) -> array<f32, 17u> {\n  return array<f32, 17>();\n}\n",
+		},
+		{
+			name:         "wgsl wrong array size",
+			lang:         "wgsl",
+			numMaterials: 17,
+			shader:       "fn mainModel17(xyz: vec3f) -> array<f32, 16> {\n  return array<f32, 16>();\n}\n",
+			want:         []string{`9:4: error: mainModel17 must return array<f32, 17>, found array<f32, 16>; want: fn mainModel17(xyz: vec3f) -> array<f32, 17>`},
+		},
+		{
+			name:   "wgsl wrong parameters",
+			lang:   "wgsl",
+			shader: "fn mainModel4(x: f32, y: f32) -> vec4f { return vec4f(x); }\nfn mainModel4(@location(0) p: vec2f) {}\n",
+			want: []string{
+				`9:4: error: mainModel4 must have 1 parameter, found 2; want: fn mainModel4(xyz: vec3f) -> vec4f`,
+				`10:28: error: parameter of mainModel4 must be a vec3f, found 'p: vec2f'`,
+				`10:4: error: mainModel4 must return vec4f, found nothing; want: fn mainModel4(xyz: vec3f) -> vec4f`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			numMaterials := tt.numMaterials
+			if numMaterials == 0 {
+				numMaterials = 1
+			}
+			_, diags := Parse([]byte(entryPointModel(tt.lang, numMaterials, tt.shader)))
+			var got []string
+			for _, d := range diags {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse diagnostics =\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckEntryPointInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "model.glsl"), "// model\nvoid mainModel4(out float materials, in vec3 xyz) {}\n")
+	src := entryPointModel("glsl", 1, "#include \"model.glsl\"\n")
+
+	_, err := newModel([]byte(src), IncludeOptions{Dir: dir})
+	want := `model.glsl:2:17: first parameter of mainModel4 must be 'out vec4', found 'out float materials'`
+	if err == nil || err.Error() != want {
+		t.Errorf("newModel error = %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,91 @@
+package irmf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors returned by this package (and by the output writers) wrap
+// the following errors and error types where they apply, so callers can
+// find them with errors.Is and errors.As and decide whether to retry a job,
+// skip a model or abort.
+
+var (
+	// ErrIncludeNotFound is returned (within an IncludeError) for an
+	// "#include" file that exists neither locally nor at a known URL.
+	ErrIncludeNotFound = errors.New("file not found")
+
+	// ErrOffline is returned (within an IncludeError) for a remote
+	// "#include" file that is not cached when IncludeOptions.Offline is set.
+	ErrOffline = errors.New("network access is disabled")
+)
+
+// ParseError is a problem with an IRMF model found while parsing or
+// validating it, such as malformed JSON, a missing key or an invalid
+// entry point. It is the error of the first error Diagnostic of the model.
+type ParseError struct {
+	Line   int    // 1-based line in the IRMF file, or 0 if unknown
+	Column int    // 1-based column, or 0 if unknown
+	Key    string // the JSON header key involved, if any
+	Err    error  // the problem, whose message includes its position
+}
+
+func (e *ParseError) Error() string { return e.Err.Error() }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// IncludeError is a failure to resolve an "#include" line. The errors of
+// nested "#include" lines are wrapped in the IncludeError of the line that
+// includes them.
+type IncludeError struct {
+	Line    int    // the line of the "#include" in the including file
+	Include string // the file name or URL being included
+	Err     error
+}
+
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("line %v: #include %q: %v", e.Line, e.Include, e.Err)
+}
+func (e *IncludeError) Unwrap() error { return e.Err }
+
+// FetchError is a failure to download a remote "#include" file. It may be
+// worth retrying if the network or the server is temporarily unavailable.
+type FetchError struct {
+	URL        string
+	StatusCode int // the HTTP status code, or 0 if no response was received
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("unable to download source from %v: %v", e.URL, e.Err)
+}
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// CompileError is a failure to compile the shader of a model.
+type CompileError struct {
+	Language string // "glsl" or "wgsl"
+	Log      string // the compiler messages, with the lines of the IRMF file
+}
+
+func (e *CompileError) Error() string { return "failed to compile shader:\n" + e.Log }
+
+// DeviceError is a failure to initialize a GPU renderer, such as no
+// display, no OpenGL context or no WebGPU adapter being available.
+// The Slicer falls back to the CPURenderer after a DeviceError
+// (see SetFallback).
+type DeviceError struct {
+	Renderer string // "OpenGL" or "WebGPU"
+	Err      error
+}
+
+func (e *DeviceError) Error() string { return fmt.Sprintf("%v: %v", e.Renderer, e.Err) }
+func (e *DeviceError) Unwrap() error { return e.Err }
+
+// WriteError is a failure to write an output file, returned by
+// the output writers (such as zipper.Slice).
+type WriteError struct {
+	Filename string
+	Err      error
+}
+
+func (e *WriteError) Error() string { return fmt.Sprintf("%v: %v", e.Filename, e.Err) }
+func (e *WriteError) Unwrap() error { return e.Err }
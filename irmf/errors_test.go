@@ -0,0 +1,167 @@
+package irmf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	mainModel := "void mainModel4(out vec4 materials, in vec3 xyz) {\n  materials[0] = 1.0;\n}\n"
+	furlongs := `/*{
+"irmf": "1.0",
+"language": "glsl",
+"materials": ["PLA"],
+"max": [1,1,1],
+"min": [-1,-1,-1],
+"units": "furlongs"
+}*/
+` + mainModel
+
+	tests := []struct {
+		name  string
+		src   string
+		opts  IncludeOptions
+		check func(t *testing.T, err error)
+	}{
+		{
+			name: "no JSON blob",
+			src:  mainModel,
+			check: func(t *testing.T, err error) {
+				var pe *ParseError
+				if !errors.As(err, &pe) || pe.Line != 1 {
+					t.Errorf("newModel error = %#v, want a *ParseError on line 1", err)
+				}
+			},
+		},
+		{
+			name: "invalid key",
+			src:  furlongs,
+			check: func(t *testing.T, err error) {
+				var pe *ParseError
+				if !errors.As(err, &pe) || pe.Line != 7 || pe.Key != "units" {
+					t.Errorf("newModel error = %#v, want a *ParseError of the units on line 7", err)
+				}
+			},
+		},
+		{
+			name: "include not found",
+			src:  entryPointModel("glsl", 1, "#include \"missing.glsl\"\n"+mainModel),
+			opts: IncludeOptions{Dir: t.TempDir(), Offline: true},
+			check: func(t *testing.T, err error) {
+				var ie *IncludeError
+				if !errors.As(err, &ie) || ie.Line != 9 || ie.Include != "missing.glsl" || !errors.Is(err, ErrIncludeNotFound) {
+					t.Errorf("newModel error = %#v, want an *IncludeError of missing.glsl on line 9 wrapping ErrIncludeNotFound", err)
+				}
+			},
+		},
+		{
+			name: "offline",
+			src:  entryPointModel("glsl", 1, "#include \"lygia/math/const.glsl\"\n"+mainModel),
+			opts: IncludeOptions{CacheDir: t.TempDir(), Offline: true},
+			check: func(t *testing.T, err error) {
+				var ie *IncludeError
+				if !errors.As(err, &ie) || !errors.Is(err, ErrOffline) {
+					t.Errorf("newModel error = %#v, want an *IncludeError wrapping ErrOffline", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newModel([]byte(tt.src), tt.opts)
+			if err == nil {
+				t.Fatal("newModel: want an error")
+			}
+			tt.check(t, err)
+		})
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again later", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := curl(srv.URL)
+	var fe *FetchError
+	if !errors.As(err, &fe) || fe.URL != srv.URL || fe.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("curl error = %#v, want a *FetchError with status %v", err, http.StatusServiceUnavailable)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	s := Init(false, 500, 500, 500)
+	s.UseCPU(true)
+	defer s.Close()
+	if err := s.NewModel([]byte(entryPointModel("glsl", 1, "void mainModel4(out vec4 materials, in vec3 xyz) {\n  materials[0] = ;\n}\n"))); err != nil {
+		t.Fatalf("NewModel: %v", err)
+	}
+	err := s.PrepareRenderZ()
+	var ce *CompileError
+	if !errors.As(err, &ce) || ce.Language != "glsl" {
+		t.Errorf("PrepareRenderZ error = %#v, want a GLSL *CompileError", err)
+	}
+}
+
+func TestSetModelBadMBB(t *testing.T) {
+	meta := sphereMeta
+	meta.Min = []float32{-5, -5}
+	s := Init(false, 500, 500, 500)
+	defer s.Close()
+	err := s.SetModel(&meta)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Errorf("SetModel error = %#v, want a *ParseError", err)
+	}
+	if min, max := s.MBB(); min != [3]float32{} || max != [3]float32{} {
+		t.Errorf("MBB = %v, %v without a model, want zeros", min, max)
+	}
+}
+
+// initErrorRenderer is a CPURenderer whose Init fails with err.
+type initErrorRenderer struct {
+	CPURenderer
+	err error
+}
+
+func (r *initErrorRenderer) Init(width, height int, view bool) error { return r.err }
+
+func TestRendererFallback(t *testing.T) {
+	errInit := errors.New("out of memory")
+	tests := []struct {
+		name         string
+		err          error
+		wantErr      error
+		wantFallback bool
+	}{
+		{name: "device error", err: &DeviceError{Renderer: "OpenGL", Err: errInit}, wantFallback: true},
+		{name: "other error", err: errInit, wantErr: errInit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Init(false, 500, 500, 500)
+			s.UseCPU(true)
+			defer s.Close()
+			if err := s.NewModel([]byte(entryPointModel("glsl", 1, "void mainModel4(out vec4 materials, in vec3 xyz) {\n  materials[0] = 1.0;\n}\n"))); err != nil {
+				t.Fatalf("NewModel: %v", err)
+			}
+			var fallbackErr *DeviceError
+			s.SetFallback(func(err *DeviceError) { fallbackErr = err })
+			s.setRenderer(&initErrorRenderer{err: tt.err})
+			if err := s.PrepareRenderZ(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("PrepareRenderZ error = %v, want %v", err, tt.wantErr)
+			}
+			if _, ok := s.renderer.(*CPURenderer); ok != tt.wantFallback {
+				t.Errorf("renderer = %T, want a fallback to the CPURenderer: %v", s.renderer, tt.wantFallback)
+			}
+			if (fallbackErr != nil) != tt.wantFallback || (fallbackErr != nil && fallbackErr != tt.err) {
+				t.Errorf("fallback called with %v, want a call: %v", fallbackErr, tt.wantFallback)
+			}
+		})
+	}
+}
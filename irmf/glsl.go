@@ -1,10 +1,12 @@
 package irmf
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"log"
 	"strings"
+	"unsafe"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
@@ -12,51 +14,97 @@ import (
 )
 
 // OpenGLRenderer is a renderer implementation using OpenGL.
+//
+// It uses the context of a (possibly hidden) glfw window when a display is
+// available. Otherwise, unless the slicing is to be viewed, it uses a
+// headless context from EGL or OSMesa so that GLSL models can be sliced
+// on servers and in containers (e.g. with Mesa's llvmpipe). The slices are
+// rendered into a framebuffer object, so their size is limited by the
+// maximum renderbuffer size rather than by the window or headless buffer.
 type OpenGLRenderer struct {
-	window *glfw.Window
+	ctx    glContext
 	width  int
 	height int
 	view   bool
+	format PixelFormat
+
+	// fbo is the framebuffer object that the slices are rendered into.
+	fbo           uint32
+	renderbuffers [2]uint32 // color and depth
 
 	program             uint32
 	vao                 uint32
 	modelUniform        int32
 	uMaterialNumUniform int32
 	uSliceUniform       int32
+
+	// The state needed to compile the programs used by RenderAll.
+	irmf                      *IRMF
+	opts                      []optionValue
+	vec3Str                   string
+	projection, camera, model mgl32.Mat4
+
+	// allPrograms render the materials of allPasses into the
+	// render targets of allFBO, or are nil until RenderAll is called.
+	allPasses        []materialPass
+	allPrograms      []uint32
+	allSliceUniforms []int32
+	allFBO           uint32
+	allRenderbuffers []uint32
+}
+
+// glContext represents the current OpenGL context of an OpenGLRenderer.
+type glContext interface {
+	swapBuffers()
+	destroy()
+}
+
+// headlessContext is an offscreen glContext that needs no window system.
+type headlessContext interface {
+	glContext
+	fmt.Stringer // the name of the API, e.g. "EGL"
+	procAddress(name string) unsafe.Pointer
 }
 
+// glfwContext is the OpenGL context of a glfw window.
+type glfwContext struct {
+	window *glfw.Window
+}
+
+func (c *glfwContext) swapBuffers() {
+	c.window.SwapBuffers()
+	glfw.PollEvents()
+}
+
+func (c *glfwContext) destroy() { glfw.Terminate() }
+
+// surfaceSize is the width and height of the window or headless buffer
+// of the context when the slices are not viewed, since they are rendered
+// into a framebuffer object rather than into the window or buffer.
+const surfaceSize = 64
+
 func (r *OpenGLRenderer) Init(width, height int, view bool) error {
-	if r.window != nil && (r.width != width || r.height != height) {
-		glfw.Terminate()
-		r.window = nil
+	if r.ctx != nil && r.view && (r.width != width || r.height != height) {
+		// The window shows the slices at their size.
+		r.Close()
 	}
 	r.width = width
 	r.height = height
 	r.view = view
 
-	if r.window == nil {
-		err := glfw.Init()
-		if err != nil {
-			return fmt.Errorf("glfw.Init: %v", err)
+	if r.ctx == nil {
+		surfaceWidth, surfaceHeight := surfaceSize, surfaceSize
+		if r.view {
+			surfaceWidth, surfaceHeight = width, height
 		}
-
-		glfw.WindowHint(glfw.Resizable, glfw.False)
-		glfw.WindowHint(glfw.ContextVersionMajor, 4)
-		glfw.WindowHint(glfw.ContextVersionMinor, 1)
-		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
-		glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
-		if !r.view {
-			glfw.WindowHint(glfw.Visible, glfw.False)
+		var err error
+		if !r.view && !haveDisplay() {
+			err = r.initHeadless(surfaceWidth, surfaceHeight, errors.New("no display is available"))
+		} else if err = r.initWindow(surfaceWidth, surfaceHeight); err != nil && !r.view {
+			err = r.initHeadless(surfaceWidth, surfaceHeight, err)
 		}
-		r.window, err = glfw.CreateWindow(width, height, "IRMF Slicer", nil, nil)
-		if err != nil {
-			return fmt.Errorf("CreateWindow(%v,%v): %v", width, height, err)
-		}
-		r.window.MakeContextCurrent()
-
-		err = gl.Init()
 		if err != nil {
-			return fmt.Errorf("gl.Init: %v", err)
+			return &DeviceError{Renderer: "OpenGL", Err: err}
 		}
 
 		version := gl.GoStr(gl.GetString(gl.VERSION))
@@ -65,23 +113,72 @@ func (r *OpenGLRenderer) Init(width, height int, view bool) error {
 	return nil
 }
 
-func (r *OpenGLRenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []float32, projection, camera, model mgl32.Mat4) error {
-	// Configure the vertex and fragment shaders
-	var err error
-	if r.program, err = newProgram(vertexShader, fsHeader+irmf.Shader+genFooter(len(irmf.Materials), vec3Str)); err != nil {
-		return fmt.Errorf("newProgram: %v", err)
+// initWindow creates the glfw window whose context is used for rendering.
+func (r *OpenGLRenderer) initWindow(width, height int) error {
+	err := glfw.Init()
+	if err != nil {
+		return fmt.Errorf("glfw.Init: %v", err)
 	}
 
-	gl.UseProgram(r.program)
+	glfw.WindowHint(glfw.Resizable, glfw.False)
+	glfw.WindowHint(glfw.ContextVersionMajor, 4)
+	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	if !r.view {
+		glfw.WindowHint(glfw.Visible, glfw.False)
+	}
+	window, err := glfw.CreateWindow(width, height, "IRMF Slicer", nil, nil)
+	if err != nil {
+		glfw.Terminate()
+		return fmt.Errorf("CreateWindow(%v,%v): %v", width, height, err)
+	}
+	window.MakeContextCurrent()
+	r.ctx = &glfwContext{window: window}
+
+	if err := gl.Init(); err != nil {
+		r.Close()
+		return fmt.Errorf("gl.Init: %v", err)
+	}
+	return nil
+}
+
+// initHeadless creates an offscreen context after the window
+// could not be created because of windowErr.
+func (r *OpenGLRenderer) initHeadless(width, height int, windowErr error) error {
+	ctx, err := newHeadlessContext(width, height)
+	if err != nil {
+		return fmt.Errorf("%v, and unable to create a headless OpenGL context: %v", windowErr, err)
+	}
+	r.ctx = ctx
+	log.Printf("Using a headless %v OpenGL context (%v)", ctx, windowErr)
 
-	projectionUniform := gl.GetUniformLocation(r.program, gl.Str("projection\x00"))
-	gl.UniformMatrix4fv(projectionUniform, 1, false, &projection[0])
+	if err := gl.InitWithProcAddrFunc(ctx.procAddress); err != nil {
+		r.Close()
+		return fmt.Errorf("gl.Init: %v", err)
+	}
+	return nil
+}
 
-	cameraUniform := gl.GetUniformLocation(r.program, gl.Str("camera\x00"))
-	gl.UniformMatrix4fv(cameraUniform, 1, false, &camera[0])
+func (r *OpenGLRenderer) setPixelFormat(f PixelFormat) { r.format = f }
 
+func (r *OpenGLRenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []float32, projection, camera, model mgl32.Mat4) error {
+	// Configure the vertex and fragment shaders
+	opts, err := irmf.uniformOptions()
+	if err != nil {
+		return err
+	}
+	fragmentShader, sm := irmf.assembleShader(fsHeader+glslOptionUniforms(opts), genFooter(len(irmf.Materials), vec3Str))
+	if r.program, err = newProgram(vertexShader, fragmentShader, sm); err != nil {
+		return fmt.Errorf("newProgram: %w", err)
+	}
+	r.irmf, r.opts, r.vec3Str = irmf, opts, vec3Str
+	r.projection, r.camera, r.model = projection, camera, model
+	r.closeAll()
+
+	gl.UseProgram(r.program)
+	r.setProgramUniforms(r.program)
 	r.modelUniform = gl.GetUniformLocation(r.program, gl.Str("model\x00"))
-	gl.UniformMatrix4fv(r.modelUniform, 1, false, &model[0])
 
 	// Set up uniforms needed by shaders:
 	uSlice := float32(0)
@@ -106,6 +203,10 @@ func (r *OpenGLRenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 	gl.EnableVertexAttribArray(vertAttrib)
 	gl.VertexAttribPointer(vertAttrib, 3, gl.FLOAT, false, 5*4, gl.PtrOffset(0))
 
+	if err := r.initFramebuffer(); err != nil {
+		return err
+	}
+
 	// Configure global settings
 	gl.Enable(gl.DEPTH_TEST)
 	gl.DepthFunc(gl.LESS)
@@ -114,6 +215,85 @@ func (r *OpenGLRenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 	return nil
 }
 
+// setProgramUniforms sets the options and matrices of the current program.
+func (r *OpenGLRenderer) setProgramUniforms(program uint32) {
+	for _, opt := range r.opts {
+		loc := gl.GetUniformLocation(program, gl.Str(opt.Name+"\x00"))
+		if opt.Type == "int" {
+			gl.Uniform1i(loc, int32(opt.value))
+		} else {
+			gl.Uniform1f(loc, float32(opt.value))
+		}
+	}
+
+	projectionUniform := gl.GetUniformLocation(program, gl.Str("projection\x00"))
+	gl.UniformMatrix4fv(projectionUniform, 1, false, &r.projection[0])
+
+	cameraUniform := gl.GetUniformLocation(program, gl.Str("camera\x00"))
+	gl.UniformMatrix4fv(cameraUniform, 1, false, &r.camera[0])
+
+	modelUniform := gl.GetUniformLocation(program, gl.Str("model\x00"))
+	gl.UniformMatrix4fv(modelUniform, 1, false, &r.model[0])
+}
+
+// maxImageSize returns the maximum renderbuffer and viewport size,
+// or 0 if it is unknown because the context is not created yet.
+func (r *OpenGLRenderer) maxImageSize() int {
+	if r.ctx == nil {
+		return 0
+	}
+	var renderbuffer int32
+	var viewport [2]int32
+	gl.GetIntegerv(gl.MAX_RENDERBUFFER_SIZE, &renderbuffer)
+	gl.GetIntegerv(gl.MAX_VIEWPORT_DIMS, &viewport[0])
+	return int(min(renderbuffer, viewport[0], viewport[1]))
+}
+
+// setProjection replaces the projection matrix set by Prepare.
+func (r *OpenGLRenderer) setProjection(projection mgl32.Mat4) error {
+	r.projection = projection
+	for _, program := range append([]uint32{r.program}, r.allPrograms...) {
+		gl.UseProgram(program)
+		projectionUniform := gl.GetUniformLocation(program, gl.Str("projection\x00"))
+		gl.UniformMatrix4fv(projectionUniform, 1, false, &projection[0])
+	}
+	return nil
+}
+
+// initFramebuffer creates the framebuffer object that the slices
+// are rendered into, with a color renderbuffer of the PixelFormat.
+func (r *OpenGLRenderer) initFramebuffer() error {
+	if r.fbo != 0 {
+		gl.DeleteFramebuffers(1, &r.fbo)
+		gl.DeleteRenderbuffers(2, &r.renderbuffers[0])
+		r.fbo = 0
+	}
+	internalFormat := uint32(gl.R32F)
+	switch r.format {
+	case RGBA8:
+		internalFormat = gl.RGBA8
+	case R16F:
+		internalFormat = gl.R16F
+	}
+
+	gl.GenRenderbuffers(2, &r.renderbuffers[0])
+	color, depth := r.renderbuffers[0], r.renderbuffers[1]
+	gl.BindRenderbuffer(gl.RENDERBUFFER, color)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, internalFormat, int32(r.width), int32(r.height))
+	gl.BindRenderbuffer(gl.RENDERBUFFER, depth)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, int32(r.width), int32(r.height))
+
+	gl.GenFramebuffers(1, &r.fbo)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.fbo)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.RENDERBUFFER, color)
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, depth)
+	if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		return fmt.Errorf("unable to render %v pixels: framebuffer status 0x%x", r.format, status)
+	}
+	gl.Viewport(0, 0, int32(r.width), int32(r.height))
+	return nil
+}
+
 func (r *OpenGLRenderer) Render(sliceDepth float32, materialNum int) (image.Image, error) {
 	if e := gl.GetError(); e != gl.NO_ERROR {
 		fmt.Printf("renderSlice, before gl.Clear: GL ERROR: %v\n", e)
@@ -135,39 +315,205 @@ func (r *OpenGLRenderer) Render(sliceDepth float32, materialNum int) (image.Imag
 		fmt.Printf("renderSlice, after gl.DrawArrays: GL ERROR: %v\n", e)
 	}
 
-	width, height := r.window.GetFramebufferSize()
-	rgba := &image.RGBA{
-		Pix:    make([]uint8, width*height*4),
-		Stride: width * 4,
-		Rect:   image.Rect(0, 0, width, height),
+	var img image.Image
+	if r.format == RGBA8 {
+		rgba := image.NewRGBA(image.Rect(0, 0, r.width, r.height))
+		gl.ReadPixels(0, 0, int32(r.width), int32(r.height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&rgba.Pix[0]))
+		img = rgba
+	} else {
+		gray := NewGray32f(image.Rect(0, 0, r.width, r.height))
+		gl.ReadPixels(0, 0, int32(r.width), int32(r.height), gl.RED, gl.FLOAT, gl.Ptr(&gray.Pix[0]))
+		img = gray
+	}
+	if r.view {
+		// Show the slice in the window, too.
+		gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, 0)
+		gl.BlitFramebuffer(0, 0, int32(r.width), int32(r.height), 0, 0, int32(r.width), int32(r.height), gl.COLOR_BUFFER_BIT, gl.NEAREST)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, r.fbo)
 	}
-	gl.ReadPixels(0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&rgba.Pix[0]))
 
 	if e := gl.GetError(); e != gl.NO_ERROR {
 		fmt.Printf("renderSlice, after gl.ReadPixels: GL ERROR: %v\n", e)
 	}
 
 	// Maintenance
-	r.window.SwapBuffers()
-	glfw.PollEvents()
+	r.ctx.swapBuffers()
 
-	return rgba, nil
+	return img, nil
+}
+
+// RenderAll renders every material of the slice into multiple render
+// targets, four materials per RGBA target, so the model is evaluated only
+// once per pixel unless it has more materials than fit in GL_MAX_DRAW_BUFFERS
+// targets. It returns the same images as calling Render for each material.
+func (r *OpenGLRenderer) RenderAll(sliceDepth float32) ([]image.Image, error) {
+	if r.program == 0 {
+		return nil, errors.New("OpenGLRenderer: Prepare must be called before RenderAll")
+	}
+	if r.allPrograms == nil {
+		if err := r.initAll(); err != nil {
+			return nil, err
+		}
+	}
+
+	var viewport [4]int32
+	gl.GetIntegerv(gl.VIEWPORT, &viewport[0])
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.allFBO)
+	gl.Viewport(0, 0, int32(r.width), int32(r.height))
+
+	numMaterials := len(r.irmf.Materials)
+	imgs := newSliceImages(numMaterials, r.width, r.height, r.format, false)
+	var pix []uint8
+	var floats []float32
+	if r.format == RGBA8 {
+		pix = make([]uint8, 4*r.width*r.height)
+	} else {
+		floats = make([]float32, 4*r.width*r.height)
+	}
+	for i, p := range r.allPasses {
+		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		gl.UseProgram(r.allPrograms[i])
+		gl.Uniform1f(r.allSliceUniforms[i], sliceDepth)
+		gl.BindVertexArray(r.vao)
+		gl.DrawArrays(gl.TRIANGLES, 0, 2*3)
+
+		for j := 0; j < p.targets; j++ {
+			gl.ReadBuffer(gl.COLOR_ATTACHMENT0 + uint32(j))
+			if pix != nil {
+				gl.ReadPixels(0, 0, int32(r.width), int32(r.height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&pix[0]))
+			} else {
+				gl.ReadPixels(0, 0, int32(r.width), int32(r.height), gl.RGBA, gl.FLOAT, gl.Ptr(&floats[0]))
+			}
+			for c := 0; c < 4; c++ {
+				n := p.first + 4*j + c
+				if n >= numMaterials {
+					break
+				}
+				for k := 0; k < r.width*r.height; k++ {
+					if pix != nil {
+						imgs.setUnorm8(n, k, pix[4*k+c])
+					} else {
+						imgs.set(n, k, floats[4*k+c])
+					}
+				}
+			}
+		}
+	}
+
+	if e := gl.GetError(); e != gl.NO_ERROR {
+		fmt.Printf("renderAll, after gl.ReadPixels: GL ERROR: %v\n", e)
+	}
+
+	if r.view {
+		// Show the first four materials in the window.
+		gl.ReadBuffer(gl.COLOR_ATTACHMENT0)
+		gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, 0)
+		gl.BlitFramebuffer(0, 0, int32(r.width), int32(r.height), 0, 0, int32(r.width), int32(r.height), gl.COLOR_BUFFER_BIT, gl.NEAREST)
+	}
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.fbo)
+	gl.Viewport(viewport[0], viewport[1], viewport[2], viewport[3])
+
+	// Maintenance
+	r.ctx.swapBuffers()
+
+	return imgs.imgs, nil
+}
+
+// initAll compiles the programs used by RenderAll and creates
+// the framebuffer object holding their render targets.
+func (r *OpenGLRenderer) initAll() error {
+	var maxDrawBuffers, maxAttachments int32
+	gl.GetIntegerv(gl.MAX_DRAW_BUFFERS, &maxDrawBuffers)
+	gl.GetIntegerv(gl.MAX_COLOR_ATTACHMENTS, &maxAttachments)
+	numMaterials := len(r.irmf.Materials)
+	maxTargets := min(int(maxDrawBuffers), int(maxAttachments), numTargets(numMaterials))
+	if maxTargets < 1 {
+		return fmt.Errorf("unable to render to multiple targets: GL_MAX_DRAW_BUFFERS=%v", maxDrawBuffers)
+	}
+
+	r.allPasses = materialPasses(numMaterials, maxTargets)
+	for _, p := range r.allPasses {
+		fragmentShader, sm := r.irmf.assembleShader(fsHeaderAll(p.targets)+glslOptionUniforms(r.opts), genFooterAll(numMaterials, r.vec3Str, p)+"\x00")
+		program, err := newProgram(vertexShader, fragmentShader, sm)
+		if err != nil {
+			r.closeAll()
+			return fmt.Errorf("newProgram: %w", err)
+		}
+		gl.UseProgram(program)
+		r.setProgramUniforms(program)
+		r.allPrograms = append(r.allPrograms, program)
+		r.allSliceUniforms = append(r.allSliceUniforms, gl.GetUniformLocation(program, gl.Str("u_slice\x00")))
+	}
+
+	internalFormat := uint32(gl.RGBA8)
+	switch r.format {
+	case R16F:
+		internalFormat = gl.RGBA16F
+	case R32F:
+		internalFormat = gl.RGBA32F
+	}
+	r.allRenderbuffers = make([]uint32, maxTargets+1)
+	gl.GenRenderbuffers(int32(len(r.allRenderbuffers)), &r.allRenderbuffers[0])
+	gl.GenFramebuffers(1, &r.allFBO)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.allFBO)
+	var drawBuffers []uint32
+	for j := 0; j < maxTargets; j++ {
+		gl.BindRenderbuffer(gl.RENDERBUFFER, r.allRenderbuffers[j])
+		gl.RenderbufferStorage(gl.RENDERBUFFER, internalFormat, int32(r.width), int32(r.height))
+		gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0+uint32(j), gl.RENDERBUFFER, r.allRenderbuffers[j])
+		drawBuffers = append(drawBuffers, gl.COLOR_ATTACHMENT0+uint32(j))
+	}
+	depth := r.allRenderbuffers[maxTargets]
+	gl.BindRenderbuffer(gl.RENDERBUFFER, depth)
+	gl.RenderbufferStorage(gl.RENDERBUFFER, gl.DEPTH_COMPONENT24, int32(r.width), int32(r.height))
+	gl.FramebufferRenderbuffer(gl.FRAMEBUFFER, gl.DEPTH_ATTACHMENT, gl.RENDERBUFFER, depth)
+	gl.DrawBuffers(int32(len(drawBuffers)), &drawBuffers[0])
+	status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, r.fbo)
+	if status != gl.FRAMEBUFFER_COMPLETE {
+		r.closeAll()
+		return fmt.Errorf("unable to render %v materials to %v targets: framebuffer status 0x%x", numMaterials, maxTargets, status)
+	}
+	return nil
+}
+
+// closeAll releases the programs and framebuffer object used by RenderAll.
+func (r *OpenGLRenderer) closeAll() {
+	if r.ctx == nil {
+		return
+	}
+	for _, program := range r.allPrograms {
+		gl.DeleteProgram(program)
+	}
+	if r.allFBO != 0 {
+		gl.DeleteFramebuffers(1, &r.allFBO)
+	}
+	if len(r.allRenderbuffers) > 0 {
+		gl.DeleteRenderbuffers(int32(len(r.allRenderbuffers)), &r.allRenderbuffers[0])
+	}
+	r.allPasses, r.allPrograms, r.allSliceUniforms = nil, nil, nil
+	r.allFBO, r.allRenderbuffers = 0, nil
 }
 
 func (r *OpenGLRenderer) Close() {
-	if r.window != nil {
-		glfw.Terminate()
-		r.window = nil
+	if r.ctx != nil {
+		r.closeAll()
+		r.ctx.destroy()
+		r.ctx = nil
 	}
+	r.fbo = 0
+	r.program = 0
 }
 
-func newProgram(vertexShaderSource, fragmentShaderSource string) (uint32, error) {
-	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
+// newProgram compiles and links the shaders. Compile errors in the
+// fragment shader are reported in terms of the original source using fsMap.
+func newProgram(vertexShaderSource, fragmentShaderSource string, fsMap *sourceMap) (uint32, error) {
+	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER, nil)
 	if err != nil {
 		return 0, err
 	}
 
-	fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
+	fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER, fsMap)
 	if err != nil {
 		return 0, err
 	}
@@ -196,7 +542,7 @@ func newProgram(vertexShaderSource, fragmentShaderSource string) (uint32, error)
 	return program, nil
 }
 
-func compileShader(source string, shaderType uint32) (uint32, error) {
+func compileShader(source string, shaderType uint32, sm *sourceMap) (uint32, error) {
 	shader := gl.CreateShader(shaderType)
 
 	csources, free := gl.Strs(source)
@@ -213,25 +559,30 @@ func compileShader(source string, shaderType uint32) (uint32, error) {
 		log := strings.Repeat("\x00", int(logLength+1))
 		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
 
+		if sm != nil {
+			return 0, &CompileError{Language: "glsl", Log: sm.rewrite(log)}
+		}
 		return 0, fmt.Errorf("failed to compile %v: %v", source, log)
 	}
 
 	return shader, nil
 }
 
-const vertexShader = "#version 330\nuniform mat4 projection;\nuniform mat4 camera;\nuniform mat4 model;\nin vec3 vert;\nout vec3 fragVert;\nvoid main() {\n\tgl_Position = projection * camera * model * vec4(vert, 1);\n\tfragVert = vert;\n}"
+const vertexShader = "#version 330\nuniform mat4 projection;\nuniform mat4 camera;\nuniform mat4 model;\nlayout(location = 0) in vec3 vert;\nout vec3 fragVert;\nvoid main() {\n\tgl_Position = projection * camera * model * vec4(vert, 1);\n\tfragVert = vert;\n}\x00"
 
-const fsHeader = "#version 330\nprecision highp float;\nprecision highp int;\nin vec3 fragVert;\nout vec4 outputColor;\nuniform float u_slice;\nuniform int u_materialNum;"
+const fsHeader = "#version 330\nprecision highp float;\nprecision highp int;\nin vec3 fragVert;\nout vec4 outputColor;\nuniform float u_slice;\nuniform int u_materialNum;\n"
 
 func genFooter(numMaterials int, vec3Str string) string {
 	switch numMaterials {
-	default:
-		return fmt.Sprintf(fsFooterFmt4, vec3Str) + "\x00"
 	case 5, 6, 7, 8, 9:
 		return fmt.Sprintf(fsFooterFmt9, vec3Str) + "\x00"
 	case 10, 11, 12, 13, 14, 15, 16:
 		return fmt.Sprintf(fsFooterFmt16, vec3Str) + "\x00"
 	}
+	if numMaterials > 16 {
+		return fmt.Sprintf(fsFooterFmtN, numMaterials, numMaterials, vec3Str) + "\x00"
+	}
+	return fmt.Sprintf(fsFooterFmt4, vec3Str) + "\x00"
 }
 
 const fsFooterFmt4 = "\nvoid main() {\n  vec4 m;\n  mainModel4(m, vec3(%v));\n  switch(u_materialNum) {\n  case 1:\n    outputColor = vec4(m.x);\n    break;\n  case 2:\n    outputColor = vec4(m.y);\n    break;\n  case 3:\n    outputColor = vec4(m.z);\n    break;\n  case 4:\n    outputColor = vec4(m.w);\n    break;\n  }\n}"
@@ -239,3 +590,5 @@ const fsFooterFmt4 = "\nvoid main() {\n  vec4 m;\n  mainModel4(m, vec3(%v));\n
 const fsFooterFmt9 = "\nvoid main() {\n  mat3 m;\n  mainModel9(m, vec3(%v));\n  switch(u_materialNum) {\n  case 1:\n    outputColor = vec4(m[0][0]);\n    break;\n  case 2:\n    outputColor = vec4(m[0][1]);\n    break;\n  case 3:\n    outputColor = vec4(m[0][2]);\n    break;\n  case 4:\n    outputColor = vec4(m[1][0]);\n    break;\n  case 5:\n    outputColor = vec4(m[1][1]);\n    break;\n  case 6:\n    outputColor = vec4(m[1][2]);\n    break;\n  case 7:\n    outputColor = vec4(m[2][0]);\n    break;\n  case 8:\n    outputColor = vec4(m[2][1]);\n    break;\n  case 9:\n    outputColor = vec4(m[2][2]);\n    break;\n  }\n}"
 
 const fsFooterFmt16 = "\nvoid main() {\n  mat4 m;\n  mainModel16(m, vec3(%v));\n  switch(u_materialNum) {\n  case 1:\n    outputColor = vec4(m[0][0]);\n    break;\n  case 2:\n    outputColor = vec4(m[0][1]);\n    break;\n  case 3:\n    outputColor = vec4(m[0][2]);\n    break;\n  case 4:\n    outputColor = vec4(m[0][3]);\n    break;\n  case 5:\n    outputColor = vec4(m[1][0]);\n    break;\n  case 6:\n    outputColor = vec4(m[1][1]);\n    break;\n  case 7:\n    outputColor = vec4(m[1][2]);\n    break;\n  case 8:\n    outputColor = vec4(m[1][3]);\n    break;\n  case 9:\n    outputColor = vec4(m[2][0]);\n    break;\n  case 10:\n    outputColor = vec4(m[2][1]);\n    break;\n  case 11:\n    outputColor = vec4(m[2][2]);\n    break;\n  case 12:\n    outputColor = vec4(m[2][3]);\n    break;\n  case 13:\n    outputColor = vec4(m[3][0]);\n    break;\n  case 14:\n    outputColor = vec4(m[3][1]);\n    break;\n  case 15:\n    outputColor = vec4(m[3][2]);\n    break;\n  case 16:\n    outputColor = vec4(m[3][3]);\n    break;\n  }\n}"
+
+const fsFooterFmtN = "\nvoid main() {\n  float m[%v];\n  mainModel%v(m, vec3(%v));\n  outputColor = vec4(m[u_materialNum - 1]);\n}"
@@ -0,0 +1,74 @@
+package irmf
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestOpenGLRendererMatchesCPU(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	buf, err := os.ReadFile("../testdata/sphere-1-glsl.irmf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The slices at 100 microns are larger than the surfaceSize
+	// of the context, so they are only whole if they are rendered
+	// into the framebuffer object.
+	for _, res := range []float32{500, 100} {
+		t.Run(fmt.Sprint(res), func(t *testing.T) { testOpenGLRendererMatchesCPU(t, buf, res) })
+	}
+}
+
+func testOpenGLRendererMatchesCPU(t *testing.T, buf []byte, res float32) {
+	render := func(cpu bool) []zSlices {
+		s := Init(false, res, res, res)
+		s.UseCPU(cpu)
+		defer s.Close()
+		if err := s.NewModel(buf); err != nil {
+			t.Fatalf("NewModel: %v", err)
+		}
+		if err := s.PrepareRenderZ(); err != nil {
+			t.Fatalf("PrepareRenderZ: %v", err)
+		}
+		if _, ok := s.renderer.(*OpenGLRenderer); !cpu && !ok {
+			t.Skip("no OpenGL context is available")
+		}
+		if limit := s.tileLimit(); !cpu && limit <= 0 {
+			t.Errorf("tileLimit = %v after PrepareRenderZ, want the limit of the OpenGL context", limit)
+		}
+		var result []zSlices
+		for n := 1; n <= s.NumMaterials(); n++ {
+			var slices zSlices
+			if err := s.RenderZSlices(n, &slices, MinToMax); err != nil {
+				t.Fatalf("RenderZSlices: %v", err)
+			}
+			result = append(result, slices)
+		}
+		return result
+	}
+
+	gpu, cpu := render(false), render(true)
+	for m := range cpu {
+		for n := range cpu[m] {
+			g, c := gpu[m][n].(*image.RGBA), cpu[m][n].(*image.RGBA)
+			if g.Bounds() != c.Bounds() {
+				t.Fatalf("material %v, slice %v: OpenGL bounds %v, CPU bounds %v", m+1, n, g.Bounds(), c.Bounds())
+			}
+			var diffs int
+			for i := 0; i < len(g.Pix); i += 4 {
+				if g.Pix[i] != c.Pix[i] {
+					diffs++
+				}
+			}
+			// Allow for rounding differences on the surface of the sphere.
+			if diffs > len(g.Pix)/4/100 {
+				t.Errorf("material %v, slice %v: OpenGL and CPU renderings differ in %v pixels", m+1, n, diffs)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package irmf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// ModelFunc represents an IRMF model written in Go. It returns the
+// value (0 to 1) of each of up to 16 materials at the given point.
+type ModelFunc func(x, y, z float32) [16]float32
+
+// NewGoModel returns a new IRMF model whose materials are computed by fn
+// instead of by a shader. The metadata (such as Materials, Min, Max and
+// Units) is copied from meta. The returned model can be sliced with
+// Slicer.SetModel.
+func NewGoModel(meta IRMF, fn ModelFunc) (*IRMF, error) {
+	if fn == nil {
+		return nil, errors.New("missing model function")
+	}
+	m := meta
+	m.Materials = append([]string(nil), meta.Materials...)
+	m.Min = append([]float32(nil), meta.Min...)
+	m.Max = append([]float32(nil), meta.Max...)
+	m.Language = "go"
+	m.Shader = ""
+	m.Encoding = nil
+	if m.IRMFVersion == "" {
+		m.IRMFVersion = "1.0"
+	}
+	m.goFunc = fn
+	if _, err := m.validate("", ""); err != nil {
+		return nil, err
+	}
+	if len(m.Materials) > 16 {
+		return nil, fmt.Errorf("Go models only support up to 16 materials, found %v", len(m.Materials))
+	}
+	return &m, nil
+}
+
+// GoFuncRenderer is a Renderer that evaluates a ModelFunc directly
+// on the CPU without any shader. Its output matches the OpenGL renderer.
+type GoFuncRenderer struct {
+	width  int
+	height int
+	format PixelFormat
+
+	fn           ModelFunc
+	numMaterials int
+	coords       vec3Map      // model coordinates of each pixel, set by the Slicer
+	frag         []mgl32.Vec3 // fragVert for each pixel
+
+	planeVertices []float32
+	camera, model mgl32.Mat4
+}
+
+var _ Renderer = &GoFuncRenderer{}
+
+// Init sets the size of the rendered images. view is ignored.
+func (r *GoFuncRenderer) Init(width, height int, view bool) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid image size %vx%v", width, height)
+	}
+	r.width = width
+	r.height = height
+	return nil
+}
+
+func (r *GoFuncRenderer) setPixelFormat(f PixelFormat) { r.format = f }
+
+func (r *GoFuncRenderer) setCoords(coords vec3Map) { r.coords = coords }
+
+// Prepare computes the model-space position of every pixel on the slicing
+// plane. The model coordinates are set by the Slicer; vec3Str is ignored.
+func (r *GoFuncRenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []float32, projection, camera, model mgl32.Mat4) error {
+	if irmf.goFunc == nil {
+		return errors.New("GoFuncRenderer: model has no Go function; use NewGoModel")
+	}
+	r.fn = irmf.goFunc
+	r.numMaterials = len(irmf.Materials)
+
+	r.planeVertices, r.camera, r.model = planeVertices, camera, model
+	return r.setProjection(projection)
+}
+
+// setProjection recomputes the position of every pixel for a new projection.
+func (r *GoFuncRenderer) setProjection(projection mgl32.Mat4) error {
+	var err error
+	r.frag, err = planePoints(r.width, r.height, r.planeVertices, projection.Mul4(r.camera).Mul4(r.model))
+	return err
+}
+
+// vec3Map is the affine map from the fragment position and the slice depth
+// to the model coordinates, which the shaders compute with a vec3Str.
+// Row i holds the coefficients of fragVert.x, fragVert.y, fragVert.z and
+// u_slice, followed by the constant term, of model coordinate i.
+type vec3Map [3][5]float32
+
+// vec3Sources are the variables of a vec3Str, in the order of the vec3Map columns.
+var vec3Sources = []string{"fragVert.x", "fragVert.y", "fragVert.z", "u_slice"}
+
+// apply returns the model coordinates of the fragment at frag in the slice at sliceDepth.
+func (m *vec3Map) apply(frag mgl32.Vec3, sliceDepth float32) (xyz [3]float32) {
+	for i, row := range m {
+		xyz[i] = row[0]*frag[0] + row[1]*frag[1] + row[2]*frag[2] + row[3]*sliceDepth + row[4]
+	}
+	return xyz
+}
+
+// affineVec3Map returns the vec3Map whose coordinate i is
+// m[i,0]*a + m[i,1]*b + m[i,2]*c + m[i,3] for the vec3Sources a, b and c,
+// like the shader expression of affineVec3Str.
+func affineVec3Map(m mgl32.Mat4, a, b, c string) vec3Map {
+	var coords vec3Map
+	for i := range coords {
+		for j, name := range []string{a, b, c} {
+			coords[i][slices.Index(vec3Sources, name)] += m.At(i, j)
+		}
+		coords[i][4] = m.At(i, 3)
+	}
+	return coords
+}
+
+// coordsSetter is implemented by the renderers that compute the model
+// coordinates themselves rather than with the vec3Str shader expression.
+// setCoords is called before Prepare.
+type coordsSetter interface {
+	setCoords(coords vec3Map)
+}
+
+// Render evaluates the model function for every pixel of the slice.
+func (r *GoFuncRenderer) Render(sliceDepth float32, materialNum int) (image.Image, error) {
+	if r.fn == nil {
+		return nil, errors.New("GoFuncRenderer: Prepare must be called before Render")
+	}
+	if materialNum < 1 || materialNum > 16 {
+		return nil, fmt.Errorf("materialNum must be between 1 and 16, got %v", materialNum)
+	}
+
+	var rgba *image.RGBA
+	var gray *Gray32f
+	if r.format == RGBA8 {
+		rgba = image.NewRGBA(image.Rect(0, 0, r.width, r.height))
+	} else {
+		gray = NewGray32f(image.Rect(0, 0, r.width, r.height))
+	}
+	for i, p := range r.frag {
+		xyz := r.coords.apply(p, sliceDepth)
+		d := r.fn(xyz[0], xyz[1], xyz[2])[materialNum-1]
+		if gray != nil {
+			gray.Pix[i] = r.format.density(d)
+			continue
+		}
+		v := unorm8(d)
+		rgba.Pix[4*i], rgba.Pix[4*i+1], rgba.Pix[4*i+2], rgba.Pix[4*i+3] = v, v, v, v
+	}
+	if gray != nil {
+		return gray, nil
+	}
+	return rgba, nil
+}
+
+// RenderAll evaluates the model function once for every pixel of the slice
+// and returns the images of all of its materials.
+func (r *GoFuncRenderer) RenderAll(sliceDepth float32) ([]image.Image, error) {
+	if r.fn == nil {
+		return nil, errors.New("GoFuncRenderer: Prepare must be called before RenderAll")
+	}
+
+	imgs := newSliceImages(r.numMaterials, r.width, r.height, r.format, false)
+	for i, p := range r.frag {
+		xyz := r.coords.apply(p, sliceDepth)
+		m := r.fn(xyz[0], xyz[1], xyz[2])
+		for n := 0; n < r.numMaterials; n++ {
+			imgs.set(n, i, r.format.density(m[n]))
+		}
+	}
+	return imgs.imgs, nil
+}
+
+// Close releases the renderer resources.
+func (r *GoFuncRenderer) Close() {
+	r.fn = nil
+	r.frag = nil
+}
@@ -0,0 +1,130 @@
+package irmf
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func sphereFunc(x, y, z float32) (m [16]float32) {
+	if math.Sqrt(float64(x*x+y*y+z*z)) <= 5 {
+		m[0] = 1
+	}
+	return m
+}
+
+var sphereMeta = IRMF{
+	Materials: []string{"AISI 1018 steel"},
+	Min:       []float32{-5, -5, -5},
+	Max:       []float32{5, 5, 5},
+	Units:     "mm",
+}
+
+func TestNewGoModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    IRMF
+		fn      ModelFunc
+		wantErr bool
+	}{
+		{name: "sphere", meta: sphereMeta, fn: sphereFunc},
+		{name: "missing func", meta: sphereMeta, wantErr: true},
+		{name: "missing materials", meta: IRMF{Min: sphereMeta.Min, Max: sphereMeta.Max, Units: "mm"}, fn: sphereFunc, wantErr: true},
+		{name: "bad mbb", meta: IRMF{Materials: sphereMeta.Materials, Min: sphereMeta.Max, Max: sphereMeta.Min, Units: "mm"}, fn: sphereFunc, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewGoModel(tt.meta, tt.fn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewGoModel error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Language != "go" || got.IRMFVersion != "1.0") {
+				t.Errorf("NewGoModel = %+v, want language go and IRMF version 1.0", got)
+			}
+		})
+	}
+}
+
+func TestGoFuncRenderer(t *testing.T) {
+	model, err := NewGoModel(sphereMeta, sphereFunc)
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+	s := Init(false, 500, 500, 500)
+	defer s.Close()
+	if err := s.SetModel(model); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	if err := s.PrepareRenderZ(); err != nil {
+		t.Fatalf("PrepareRenderZ: %v", err)
+	}
+	var got zSlices
+	if err := s.RenderZSlices(1, &got, MinToMax); err != nil {
+		t.Fatalf("RenderZSlices: %v", err)
+	}
+
+	want := renderCPU(t, "../testdata/sphere-1-glsl.irmf")[0]
+	if len(got) != len(want) {
+		t.Fatalf("got %v slices, want %v", len(got), len(want))
+	}
+	for n := range got {
+		g, w := got[n].(*image.RGBA), want[n].(*image.RGBA)
+		if g.Bounds() != w.Bounds() {
+			t.Fatalf("slice %v: bounds %v, want %v", n, g.Bounds(), w.Bounds())
+		}
+		for i := range g.Pix {
+			if g.Pix[i] != w.Pix[i] {
+				t.Fatalf("slice %v: Go and GLSL renderings differ at offset %v", n, i)
+			}
+		}
+	}
+}
+
+func TestAffineVec3Map(t *testing.T) {
+	var (
+		x     = [5]float32{1, 0, 0, 0, 0}
+		y     = [5]float32{0, 1, 0, 0, 0}
+		z     = [5]float32{0, 0, 1, 0, 0}
+		slice = [5]float32{0, 0, 0, 1, 0}
+	)
+	// The maps of PrepareRenderX, Y and Z, whose shaders use the vec3Str
+	// "u_slice,fragVert.yz", "fragVert.x,u_slice,fragVert.z" and
+	// "fragVert.xy,u_slice", and of a placed model.
+	tests := []struct {
+		name string
+		m    mgl32.Mat4
+		vars [3]string // a, b and c
+		want vec3Map
+	}{
+		{
+			name: "x",
+			m:    mgl32.Mat4FromRows(mgl32.Vec4{0, 0, 1, 0}, mgl32.Vec4{1, 0, 0, 0}, mgl32.Vec4{0, 1, 0, 0}, mgl32.Vec4{0, 0, 0, 1}),
+			vars: [3]string{"fragVert.y", "fragVert.z", "u_slice"},
+			want: vec3Map{slice, y, z},
+		},
+		{
+			name: "y",
+			m:    mgl32.Mat4FromRows(mgl32.Vec4{1, 0, 0, 0}, mgl32.Vec4{0, 0, 1, 0}, mgl32.Vec4{0, 1, 0, 0}, mgl32.Vec4{0, 0, 0, 1}),
+			vars: [3]string{"fragVert.x", "fragVert.z", "u_slice"},
+			want: vec3Map{x, slice, z},
+		},
+		{name: "z", m: mgl32.Ident4(), vars: [3]string{"fragVert.x", "fragVert.y", "u_slice"}, want: vec3Map{x, y, slice}},
+		{
+			name: "affine",
+			m:    mgl32.Mat4FromRows(mgl32.Vec4{0.5, 0, -0.25, 2}, mgl32.Vec4{0, 1, 0, 0}, mgl32.Vec4{0, 0, 0, 0}, mgl32.Vec4{0, 0, 0, 1}),
+			vars: [3]string{"fragVert.x", "fragVert.y", "u_slice"},
+			want: vec3Map{{0.5, 0, 0, -0.25, 2}, y},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := affineVec3Map(tt.m, tt.vars[0], tt.vars[1], tt.vars[2]); got != tt.want {
+				t.Errorf("affineVec3Map = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
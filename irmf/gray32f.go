@@ -0,0 +1,175 @@
+package irmf
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// PixelFormat is the format of the images returned by the renderers.
+type PixelFormat int
+
+const (
+	// RGBA8 renders *image.RGBA slices with the material density,
+	// quantized to 256 levels, copied into all four channels.
+	RGBA8 PixelFormat = iota
+	// R16F renders *Gray32f slices from a half-precision float target.
+	R16F
+	// R32F renders *Gray32f slices from a single-precision float target.
+	R32F
+)
+
+func (f PixelFormat) String() string {
+	switch f {
+	case RGBA8:
+		return "rgba8"
+	case R16F:
+		return "r16f"
+	case R32F:
+		return "r32f"
+	}
+	return fmt.Sprintf("PixelFormat(%d)", int(f))
+}
+
+// ParsePixelFormat returns the PixelFormat with the given name
+// ("rgba8", "r16f" or "r32f").
+func ParsePixelFormat(name string) (PixelFormat, error) {
+	for _, f := range []PixelFormat{RGBA8, R16F, R32F} {
+		if name == f.String() {
+			return f, nil
+		}
+	}
+	return RGBA8, fmt.Errorf("unknown pixel format %q: must be one of: rgba8, r16f, r32f", name)
+}
+
+// pixelFormatSetter is implemented by the renderers that support PixelFormats
+// other than RGBA8. setPixelFormat is called before Init.
+type pixelFormatSetter interface {
+	setPixelFormat(f PixelFormat)
+}
+
+// Gray32f is an in-memory image whose pixels are float32 densities.
+// Unlike the RGBA8 slices, the values are not clamped to [0,1].
+// Its color model is color.Gray16Model, so it can be encoded
+// as a 16-bit grayscale PNG.
+type Gray32f struct {
+	// Pix holds the image's pixels. The pixel at (x, y) is at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)].
+	Pix []float32
+	// Stride is the Pix stride (in pixels) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+var _ draw.Image = &Gray32f{}
+
+// NewGray32f returns a new Gray32f image with the given bounds.
+func NewGray32f(r image.Rectangle) *Gray32f {
+	return &Gray32f{Pix: make([]float32, r.Dx()*r.Dy()), Stride: r.Dx(), Rect: r}
+}
+
+func (p *Gray32f) ColorModel() color.Model { return color.Gray16Model }
+
+func (p *Gray32f) Bounds() image.Rectangle { return p.Rect }
+
+// At returns the density at (x, y), clamped to [0,1], as a color.Gray16.
+func (p *Gray32f) At(x, y int) color.Color {
+	v := p.Gray32fAt(x, y)
+	switch {
+	case v != v || v <= 0: // NaN or negative
+		return color.Gray16{}
+	case v >= 1:
+		return color.Gray16{Y: 0xffff}
+	}
+	return color.Gray16{Y: uint16(math.Round(float64(v) * 0xffff))}
+}
+
+// Gray32fAt returns the density at (x, y), or 0 outside of the image.
+func (p *Gray32f) Gray32fAt(x, y int) float32 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	return p.Pix[p.PixOffset(x, y)]
+}
+
+// SetGray32f sets the density at (x, y).
+func (p *Gray32f) SetGray32f(x, y int, v float32) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = v
+}
+
+// Set sets the density at (x, y) to the gray level of c.
+func (p *Gray32f) Set(x, y int, c color.Color) {
+	p.SetGray32f(x, y, float32(color.Gray16Model.Convert(c).(color.Gray16).Y)/0xffff)
+}
+
+// PixOffset returns the index of the pixel at (x, y) in Pix.
+func (p *Gray32f) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x - p.Rect.Min.X)
+}
+
+// float32ToHalf converts v to an IEEE 754 half-precision float,
+// rounding to the nearest even value like the GPU.
+func float32ToHalf(v float32) uint16 {
+	bits := math.Float32bits(v)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23&0xff) - 127 + 15
+	mant := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff > 0x7f800000: // NaN
+		return sign | 0x7e00
+	case exp >= 0x1f: // too large (or infinite)
+		return sign | 0x7c00
+	case exp <= 0: // subnormal or zero
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		half := mant >> shift
+		rem := mant & (1<<shift - 1)
+		if mid := uint32(1) << (shift - 1); rem > mid || (rem == mid && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(exp)<<10 | mant>>13
+	if rem := mant & 0x1fff; rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++ // may carry into the exponent, which is still correct
+	}
+	return sign | uint16(half)
+}
+
+// halfToFloat32 converts an IEEE 754 half-precision float to a float32.
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch {
+	case exp == 0x1f: // infinity or NaN
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		// Zero or subnormal: mant * 2^-24.
+		v := float32(mant) / (1 << 24)
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// density converts the value written by a fragment shader
+// to the value stored in a target of the given format.
+func (f PixelFormat) density(v float32) float32 {
+	if f == R16F {
+		return halfToFloat32(float32ToHalf(v))
+	}
+	return v
+}
@@ -0,0 +1,164 @@
+package irmf
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestHalf(t *testing.T) {
+	tests := []struct {
+		v    float32
+		want uint16
+	}{
+		{v: 0, want: 0x0000},
+		{v: float32(math.Copysign(0, -1)), want: 0x8000},
+		{v: 1, want: 0x3c00},
+		{v: -2, want: 0xc000},
+		{v: 0.5, want: 0x3800},
+		{v: 0.1, want: 0x2e66},
+		{v: 65504, want: 0x7bff},
+		{v: 65520, want: 0x7c00}, // rounds up to infinity
+		{v: 1e10, want: 0x7c00},
+		{v: float32(math.Inf(-1)), want: 0xfc00},
+		{v: 1.0 / (1 << 24), want: 0x0001}, // smallest subnormal
+		{v: 1.0 / (1 << 25), want: 0x0000}, // ties to even
+		{v: 3.0 / (1 << 25), want: 0x0002},
+		{v: 1 + 1.0/2048, want: 0x3c00}, // ties to even
+		{v: 1 + 3.0/2048, want: 0x3c02},
+	}
+	for _, tt := range tests {
+		if got := float32ToHalf(tt.v); got != tt.want {
+			t.Errorf("float32ToHalf(%v) = %#04x, want %#04x", tt.v, got, tt.want)
+		}
+	}
+
+	for h := range 1 << 16 {
+		v := halfToFloat32(uint16(h))
+		if v != v {
+			continue
+		}
+		if got := float32ToHalf(v); got != uint16(h) {
+			t.Fatalf("float32ToHalf(halfToFloat32(%#04x) = %v) = %#04x", h, v, got)
+		}
+	}
+}
+
+func TestGray32f(t *testing.T) {
+	img := NewGray32f(image.Rect(1, 2, 4, 4))
+	img.SetGray32f(3, 3, 0.5)
+	img.SetGray32f(1, 2, 1.5)
+	img.SetGray32f(0, 0, 1) // out of bounds
+	img.Set(2, 3, color.Gray{Y: 0xff})
+
+	tests := []struct {
+		x, y  int
+		value float32
+		color color.Gray16
+	}{
+		{x: 3, y: 3, value: 0.5, color: color.Gray16{Y: 0x8000}},
+		{x: 1, y: 2, value: 1.5, color: color.Gray16{Y: 0xffff}},
+		{x: 2, y: 2, value: 0, color: color.Gray16{}},
+		{x: 2, y: 3, value: 1, color: color.Gray16{Y: 0xffff}},
+		{x: 0, y: 0, value: 0, color: color.Gray16{}},
+	}
+	for _, tt := range tests {
+		if got := img.Gray32fAt(tt.x, tt.y); got != tt.value {
+			t.Errorf("Gray32fAt(%v,%v) = %v, want %v", tt.x, tt.y, got, tt.value)
+		}
+		if got := img.At(tt.x, tt.y); got != tt.color {
+			t.Errorf("At(%v,%v) = %v, want %v", tt.x, tt.y, got, tt.color)
+		}
+	}
+}
+
+// rampGLSL has a density that increases from -1 to 2 along x.
+const rampGLSL = `/*{
+  "irmf": "1.0",
+  "materials": ["PLA"],
+  "max": [3,1,1],
+  "min": [0,0,0],
+  "units": "mm"
+}*/
+void mainModel4(out vec4 materials, in vec3 xyz) {
+  materials[0] = xyz.x - 1.0 + 0.001;
+}
+`
+
+// renderRamp returns the middle slice of rampGLSL rendered in the given format.
+func renderRamp(t *testing.T, format PixelFormat, cpu bool) image.Image {
+	t.Helper()
+	s := Init(false, 100, 100, 100)
+	s.UseCPU(cpu)
+	s.SetPixelFormat(format)
+	defer s.Close()
+	if err := s.NewModel([]byte(rampGLSL)); err != nil {
+		t.Fatalf("NewModel: %v", err)
+	}
+	if err := s.PrepareRenderZ(); err != nil {
+		t.Fatalf("PrepareRenderZ: %v", err)
+	}
+	if _, ok := s.renderer.(*OpenGLRenderer); !cpu && !ok {
+		t.Skip("no OpenGL context is available")
+	}
+	var slices zSlices
+	if err := s.RenderZSlices(1, &slices, MinToMax); err != nil {
+		t.Fatalf("RenderZSlices: %v", err)
+	}
+	return slices[len(slices)/2]
+}
+
+func TestPixelFormatsCPU(t *testing.T) {
+	rgba := renderRamp(t, RGBA8, true).(*image.RGBA)
+	r32f := renderRamp(t, R32F, true).(*Gray32f)
+	r16f := renderRamp(t, R16F, true).(*Gray32f)
+	if rgba.Bounds() != r32f.Bounds() || rgba.Bounds() != r16f.Bounds() {
+		t.Fatalf("bounds = %v, %v and %v, want all the same", rgba.Bounds(), r32f.Bounds(), r16f.Bounds())
+	}
+
+	// Pixel centers are at x = 0.05, 0.15, ...
+	for x := 0; x < rgba.Bounds().Dx(); x++ {
+		want := float32(x)/10 + 0.05 - 1 + 0.001
+		if got := r32f.Gray32fAt(x, 5); math.Abs(float64(got-want)) > 1e-5 {
+			t.Errorf("R32F pixel %v = %v, want %v", x, got, want)
+		}
+		if got := r16f.Gray32fAt(x, 5); got != halfToFloat32(float32ToHalf(r32f.Gray32fAt(x, 5))) {
+			t.Errorf("R16F pixel %v = %v, want %v rounded to half precision", x, got, r32f.Gray32fAt(x, 5))
+		}
+		if got, want := rgba.RGBAAt(x, 5).R, unorm8(r32f.Gray32fAt(x, 5)); got != want {
+			t.Errorf("RGBA8 pixel %v = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestPixelFormatsOpenGL(t *testing.T) {
+	for _, format := range []PixelFormat{R16F, R32F} {
+		t.Run(format.String(), func(t *testing.T) {
+			gpu, ok := renderRamp(t, format, false).(*Gray32f)
+			if !ok {
+				t.Fatalf("OpenGL rendered a %T, want a *Gray32f", gpu)
+			}
+			cpu := renderRamp(t, format, true).(*Gray32f)
+			if gpu.Bounds() != cpu.Bounds() {
+				t.Fatalf("OpenGL bounds %v, CPU bounds %v", gpu.Bounds(), cpu.Bounds())
+			}
+			for i := range cpu.Pix {
+				if math.Abs(float64(gpu.Pix[i]-cpu.Pix[i])) > 1e-3 {
+					t.Fatalf("pixel %v: OpenGL = %v, CPU = %v", i, gpu.Pix[i], cpu.Pix[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePixelFormat(t *testing.T) {
+	for _, f := range []PixelFormat{RGBA8, R16F, R32F} {
+		if got, err := ParsePixelFormat(f.String()); err != nil || got != f {
+			t.Errorf("ParsePixelFormat(%q) = %v, %v, want %v", f, got, err, f)
+		}
+	}
+	if _, err := ParsePixelFormat("rgb565"); err == nil {
+		t.Error("ParsePixelFormat(rgb565) succeeded, want an error")
+	}
+}
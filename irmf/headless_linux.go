@@ -0,0 +1,344 @@
+package irmf
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+// EGL and OSMesa are loaded at run time so that neither their headers nor
+// their libraries are needed to build the slicer. The few types and
+// constants used below are copied from EGL/egl.h, EGL/eglext.h and
+// GL/osmesa.h.
+
+typedef void *EGLDisplay;
+typedef void *EGLConfig;
+typedef void *EGLContext;
+typedef void *EGLSurface;
+typedef void *EGLDeviceEXT;
+typedef int32_t EGLint;
+typedef unsigned int EGLBoolean;
+typedef unsigned int EGLenum;
+
+#define EGL_NONE                            0x3038
+#define EGL_SURFACE_TYPE                    0x3033
+#define EGL_PBUFFER_BIT                     0x0001
+#define EGL_RENDERABLE_TYPE                 0x3040
+#define EGL_OPENGL_BIT                      0x0008
+#define EGL_RED_SIZE                        0x3024
+#define EGL_GREEN_SIZE                      0x3023
+#define EGL_BLUE_SIZE                       0x3022
+#define EGL_ALPHA_SIZE                      0x3021
+#define EGL_DEPTH_SIZE                      0x3025
+#define EGL_WIDTH                           0x3057
+#define EGL_HEIGHT                          0x3056
+#define EGL_EXTENSIONS                      0x3055
+#define EGL_OPENGL_API                      0x30A2
+#define EGL_CONTEXT_MAJOR_VERSION           0x3098
+#define EGL_CONTEXT_MINOR_VERSION           0x30FB
+#define EGL_CONTEXT_OPENGL_PROFILE_MASK     0x30FD
+#define EGL_CONTEXT_OPENGL_CORE_PROFILE_BIT 0x0001
+#define EGL_PLATFORM_DEVICE_EXT             0x313F
+#define EGL_PLATFORM_SURFACELESS_MESA       0x31DD
+
+typedef struct {
+	void *lib;
+	void *(*getProcAddress)(const char *);
+	EGLBoolean (*terminate)(EGLDisplay);
+	EGLBoolean (*makeCurrent)(EGLDisplay, EGLSurface, EGLSurface, EGLContext);
+	EGLBoolean (*destroySurface)(EGLDisplay, EGLSurface);
+	EGLBoolean (*destroyContext)(EGLDisplay, EGLContext);
+	EGLDisplay display;
+	EGLSurface surface;
+	EGLContext context;
+} irmf_egl;
+
+static int irmf_egl_has_extension(const char *extensions, const char *name) {
+	size_t n = strlen(name);
+	const char *p = extensions;
+	while (p && (p = strstr(p, name)) != NULL) {
+		if ((p == extensions || p[-1] == ' ') && (p[n] == ' ' || p[n] == '\0')) {
+			return 1;
+		}
+		p += n;
+	}
+	return 0;
+}
+
+// irmf_egl_try_display creates a pbuffer surface and an OpenGL 4.1 core
+// context on display, and makes them current.
+static int irmf_egl_try_display(irmf_egl *e, EGLDisplay display, int width, int height) {
+	EGLBoolean (*initialize)(EGLDisplay, EGLint *, EGLint *) = dlsym(e->lib, "eglInitialize");
+	EGLBoolean (*bindAPI)(EGLenum) = dlsym(e->lib, "eglBindAPI");
+	EGLBoolean (*chooseConfig)(EGLDisplay, const EGLint *, EGLConfig *, EGLint, EGLint *) = dlsym(e->lib, "eglChooseConfig");
+	EGLSurface (*createPbufferSurface)(EGLDisplay, EGLConfig, const EGLint *) = dlsym(e->lib, "eglCreatePbufferSurface");
+	EGLContext (*createContext)(EGLDisplay, EGLConfig, EGLContext, const EGLint *) = dlsym(e->lib, "eglCreateContext");
+	if (!display || !initialize || !bindAPI || !chooseConfig || !createPbufferSurface || !createContext) {
+		return 0;
+	}
+
+	EGLint major, minor;
+	if (!initialize(display, &major, &minor)) {
+		return 0;
+	}
+	const EGLint configAttribs[] = {
+		EGL_SURFACE_TYPE, EGL_PBUFFER_BIT,
+		EGL_RENDERABLE_TYPE, EGL_OPENGL_BIT,
+		EGL_RED_SIZE, 8,
+		EGL_GREEN_SIZE, 8,
+		EGL_BLUE_SIZE, 8,
+		EGL_ALPHA_SIZE, 8,
+		EGL_DEPTH_SIZE, 24,
+		EGL_NONE,
+	};
+	const EGLint surfaceAttribs[] = {EGL_WIDTH, width, EGL_HEIGHT, height, EGL_NONE};
+	const EGLint contextAttribs[] = {
+		EGL_CONTEXT_MAJOR_VERSION, 4,
+		EGL_CONTEXT_MINOR_VERSION, 1,
+		EGL_CONTEXT_OPENGL_PROFILE_MASK, EGL_CONTEXT_OPENGL_CORE_PROFILE_BIT,
+		EGL_NONE,
+	};
+	EGLConfig config;
+	EGLint numConfigs = 0;
+	if (!bindAPI(EGL_OPENGL_API) || !chooseConfig(display, configAttribs, &config, 1, &numConfigs) || numConfigs < 1) {
+		e->terminate(display);
+		return 0;
+	}
+	EGLSurface surface = createPbufferSurface(display, config, surfaceAttribs);
+	if (!surface) {
+		e->terminate(display);
+		return 0;
+	}
+	EGLContext context = createContext(display, config, NULL, contextAttribs);
+	if (!context) {
+		e->destroySurface(display, surface);
+		e->terminate(display);
+		return 0;
+	}
+	if (!e->makeCurrent(display, surface, surface, context)) {
+		e->destroyContext(display, context);
+		e->destroySurface(display, surface);
+		e->terminate(display);
+		return 0;
+	}
+	e->display = display;
+	e->surface = surface;
+	e->context = context;
+	return 1;
+}
+
+// irmf_egl_create tries the EGL devices (such as headless GPUs), then the
+// Mesa surfaceless platform, then the default display.
+static const char *irmf_egl_create(irmf_egl *e, int width, int height) {
+	memset(e, 0, sizeof(*e));
+	e->lib = dlopen("libEGL.so.1", RTLD_NOW | RTLD_GLOBAL);
+	if (!e->lib) {
+		return "unable to load libEGL.so.1";
+	}
+	e->getProcAddress = dlsym(e->lib, "eglGetProcAddress");
+	e->terminate = dlsym(e->lib, "eglTerminate");
+	e->makeCurrent = dlsym(e->lib, "eglMakeCurrent");
+	e->destroySurface = dlsym(e->lib, "eglDestroySurface");
+	e->destroyContext = dlsym(e->lib, "eglDestroyContext");
+	EGLDisplay (*getDisplay)(void *) = dlsym(e->lib, "eglGetDisplay");
+	const char *(*queryString)(EGLDisplay, EGLint) = dlsym(e->lib, "eglQueryString");
+	if (!e->getProcAddress || !e->terminate || !e->makeCurrent || !e->destroySurface || !e->destroyContext || !getDisplay || !queryString) {
+		dlclose(e->lib);
+		return "libEGL.so.1 is missing required functions";
+	}
+
+	const char *extensions = queryString(NULL, EGL_EXTENSIONS);
+	EGLDisplay (*getPlatformDisplay)(EGLenum, void *, const EGLint *) = e->getProcAddress("eglGetPlatformDisplayEXT");
+	EGLBoolean (*queryDevices)(EGLint, EGLDeviceEXT *, EGLint *) = e->getProcAddress("eglQueryDevicesEXT");
+	if (getPlatformDisplay && queryDevices && irmf_egl_has_extension(extensions, "EGL_EXT_platform_device")) {
+		EGLDeviceEXT devices[8];
+		EGLint numDevices = 0;
+		if (queryDevices(8, devices, &numDevices)) {
+			for (EGLint i = 0; i < numDevices; i++) {
+				if (irmf_egl_try_display(e, getPlatformDisplay(EGL_PLATFORM_DEVICE_EXT, devices[i], NULL), width, height)) {
+					return NULL;
+				}
+			}
+		}
+	}
+	if (getPlatformDisplay && irmf_egl_has_extension(extensions, "EGL_MESA_platform_surfaceless")) {
+		if (irmf_egl_try_display(e, getPlatformDisplay(EGL_PLATFORM_SURFACELESS_MESA, NULL, NULL), width, height)) {
+			return NULL;
+		}
+	}
+	if (irmf_egl_try_display(e, getDisplay(NULL), width, height)) {
+		return NULL;
+	}
+	dlclose(e->lib);
+	return "no EGL display supports an OpenGL 4.1 core profile pbuffer";
+}
+
+static void *irmf_egl_proc(irmf_egl *e, const char *name) {
+	return e->getProcAddress(name);
+}
+
+static void irmf_egl_destroy(irmf_egl *e) {
+	e->makeCurrent(e->display, NULL, NULL, NULL);
+	e->destroyContext(e->display, e->context);
+	e->destroySurface(e->display, e->surface);
+	e->terminate(e->display);
+	dlclose(e->lib);
+}
+
+#define OSMESA_RGBA                  0x1908
+#define OSMESA_UNSIGNED_BYTE         0x1401
+#define OSMESA_FORMAT                0x22
+#define OSMESA_DEPTH_BITS            0x30
+#define OSMESA_PROFILE               0x33
+#define OSMESA_CORE_PROFILE          0x34
+#define OSMESA_CONTEXT_MAJOR_VERSION 0x36
+#define OSMESA_CONTEXT_MINOR_VERSION 0x37
+
+typedef struct {
+	void *lib;
+	void *context;
+	void *buffer;
+	void *(*getProcAddress)(const char *);
+	void (*destroyContext)(void *);
+} irmf_osmesa;
+
+// irmf_osmesa_create creates an OpenGL 4.1 core context that renders
+// into a buffer in memory, entirely in software.
+static const char *irmf_osmesa_create(irmf_osmesa *o, int width, int height) {
+	memset(o, 0, sizeof(*o));
+	o->lib = dlopen("libOSMesa.so.8", RTLD_NOW | RTLD_GLOBAL);
+	if (!o->lib) {
+		o->lib = dlopen("libOSMesa.so", RTLD_NOW | RTLD_GLOBAL);
+	}
+	if (!o->lib) {
+		return "unable to load libOSMesa.so.8";
+	}
+	void *(*createContextAttribs)(const int *, void *) = dlsym(o->lib, "OSMesaCreateContextAttribs");
+	unsigned char (*makeCurrent)(void *, void *, unsigned int, int, int) = dlsym(o->lib, "OSMesaMakeCurrent");
+	o->getProcAddress = dlsym(o->lib, "OSMesaGetProcAddress");
+	o->destroyContext = dlsym(o->lib, "OSMesaDestroyContext");
+	if (!createContextAttribs || !makeCurrent || !o->getProcAddress || !o->destroyContext) {
+		dlclose(o->lib);
+		return "libOSMesa is missing required functions";
+	}
+
+	const int attribs[] = {
+		OSMESA_FORMAT, OSMESA_RGBA,
+		OSMESA_DEPTH_BITS, 24,
+		OSMESA_PROFILE, OSMESA_CORE_PROFILE,
+		OSMESA_CONTEXT_MAJOR_VERSION, 4,
+		OSMESA_CONTEXT_MINOR_VERSION, 1,
+		0,
+	};
+	o->context = createContextAttribs(attribs, NULL);
+	if (!o->context) {
+		dlclose(o->lib);
+		return "OSMesaCreateContextAttribs failed";
+	}
+	o->buffer = malloc((size_t)width * height * 4);
+	if (!o->buffer || !makeCurrent(o->context, o->buffer, OSMESA_UNSIGNED_BYTE, width, height)) {
+		free(o->buffer);
+		o->destroyContext(o->context);
+		dlclose(o->lib);
+		return "OSMesaMakeCurrent failed";
+	}
+	return NULL;
+}
+
+static void *irmf_osmesa_proc(irmf_osmesa *o, const char *name) {
+	return o->getProcAddress(name);
+}
+
+static void irmf_osmesa_destroy(irmf_osmesa *o) {
+	o->destroyContext(o->context);
+	free(o->buffer);
+	dlclose(o->lib);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// haveDisplay reports whether a window system is available to glfw.
+func haveDisplay() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// newHeadlessContext creates an offscreen OpenGL context of the given size
+// without a window system, using EGL (for GPUs or Mesa llvmpipe)
+// or else OSMesa (pure software), and makes it current.
+func newHeadlessContext(width, height int) (headlessContext, error) {
+	egl, eglErr := newEGLContext(width, height)
+	if eglErr == nil {
+		return egl, nil
+	}
+	osmesa, osmesaErr := newOSMesaContext(width, height)
+	if osmesaErr == nil {
+		return osmesa, nil
+	}
+	return nil, fmt.Errorf("EGL: %v; OSMesa: %v", eglErr, osmesaErr)
+}
+
+// eglContext is an EGL pbuffer context.
+type eglContext struct {
+	e *C.irmf_egl
+}
+
+func newEGLContext(width, height int) (*eglContext, error) {
+	e := (*C.irmf_egl)(C.malloc(C.sizeof_irmf_egl))
+	if msg := C.irmf_egl_create(e, C.int(width), C.int(height)); msg != nil {
+		C.free(unsafe.Pointer(e))
+		return nil, errors.New(C.GoString(msg))
+	}
+	return &eglContext{e: e}, nil
+}
+
+func (c *eglContext) String() string { return "EGL" }
+
+func (c *eglContext) swapBuffers() {}
+
+func (c *eglContext) procAddress(name string) unsafe.Pointer {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.irmf_egl_proc(c.e, cname)
+}
+
+func (c *eglContext) destroy() {
+	C.irmf_egl_destroy(c.e)
+	C.free(unsafe.Pointer(c.e))
+}
+
+// osmesaContext is an OSMesa context that renders into memory.
+type osmesaContext struct {
+	o *C.irmf_osmesa
+}
+
+func newOSMesaContext(width, height int) (*osmesaContext, error) {
+	o := (*C.irmf_osmesa)(C.malloc(C.sizeof_irmf_osmesa))
+	if msg := C.irmf_osmesa_create(o, C.int(width), C.int(height)); msg != nil {
+		C.free(unsafe.Pointer(o))
+		return nil, errors.New(C.GoString(msg))
+	}
+	return &osmesaContext{o: o}, nil
+}
+
+func (c *osmesaContext) String() string { return "OSMesa" }
+
+func (c *osmesaContext) swapBuffers() {}
+
+func (c *osmesaContext) procAddress(name string) unsafe.Pointer {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.irmf_osmesa_proc(c.o, cname)
+}
+
+func (c *osmesaContext) destroy() {
+	C.irmf_osmesa_destroy(c.o)
+	C.free(unsafe.Pointer(c.o))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package irmf
+
+import "errors"
+
+// haveDisplay reports whether a window system is available to glfw.
+func haveDisplay() bool { return true }
+
+// newHeadlessContext is only supported on Linux.
+func newHeadlessContext(width, height int) (headlessContext, error) {
+	return nil, errors.New("headless OpenGL contexts are only supported on Linux")
+}
@@ -0,0 +1,367 @@
+package irmf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IncludeOptions controls how "#include" lines are resolved.
+//
+// Each include is resolved in this order:
+//  1. relative to Dir (the directory containing the IRMF file),
+//  2. relative to each directory in SearchPath (e.g. a vendor directory),
+//  3. from the content-addressed cache in CacheDir,
+//  4. from the network (lygia.xyz or GitHub), unless Offline is set.
+type IncludeOptions struct {
+	Dir        string
+	Filename   string // name of the IRMF file, reported in shader errors
+	SearchPath []string
+	CacheDir   string // empty disables the cache
+	Offline    bool
+
+	// LockFile is the path of the lock file (normally LockFileName next
+	// to the IRMF file). If it exists, the SHA-256 hash of every include
+	// file must match the one recorded in it.
+	LockFile string
+	// UpdateLock re-downloads remote include files (instead of using the
+	// cache) and records the hashes of all include files in LockFile.
+	UpdateLock bool
+}
+
+// DefaultIncludeCacheDir returns the default include cache directory
+// within the user's cache directory, or "" if there is none.
+func DefaultIncludeCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "irmf-slicer", "includes")
+}
+
+// includeResolver resolves "#include" lines.
+type includeResolver struct {
+	opts  IncludeOptions
+	fetch func(url string) ([]byte, error)
+
+	seen  map[string]bool   // keys of the files already included
+	stack []*includeFile    // files currently being expanded
+	lock  map[string]string // lock file hashes by lock key; nil if unused
+}
+
+// includeFile represents a resolved include file.
+type includeFile struct {
+	name string // include name, e.g. "lygia/math/const.glsl"
+	key  string // absolute filename or URL; "" if unknown for the IRMF file
+	dir  string // directory containing the file, or "" if downloaded
+	root bool   // the IRMF file itself
+}
+
+func newIncludeResolver(opts IncludeOptions) *includeResolver {
+	return &includeResolver{opts: opts, fetch: curl, seen: map[string]bool{}}
+}
+
+// processIncludes recursively replaces "#include" lines with the source
+// they refer to. Each file is only included once, like an include guard.
+// "#include" lines within "/* ... */" comments are ignored.
+// firstLine is the line number of the start of source in the IRMF file
+// and is used for error messages. The returned line map records the
+// origin of each line of the result.
+func (r *includeResolver) processIncludes(source string, firstLine int) (string, []sourceLine, error) {
+	if err := r.readLock(); err != nil {
+		return "", nil, err
+	}
+
+	root := &includeFile{name: r.opts.Filename, dir: r.opts.Dir, root: true}
+	if root.name == "" {
+		root.name = "<shader>"
+	} else {
+		// The IRMF file is on the stack so that including it is a cycle.
+		filename := r.opts.Filename
+		if r.opts.Dir != "" {
+			filename = filepath.Join(r.opts.Dir, filepath.Base(filename))
+		}
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return "", nil, err
+		}
+		root.key = abs
+	}
+	r.stack = []*includeFile{root}
+	lines, lineMap, err := r.expand(source, firstLine, root)
+	r.stack = nil
+	if err != nil {
+		return "", nil, err
+	}
+
+	if r.opts.UpdateLock && r.lock != nil {
+		if err := r.writeLock(); err != nil {
+			return "", nil, err
+		}
+	}
+	return strings.Join(lines, "\n"), lineMap, nil
+}
+
+func (r *includeResolver) expand(source string, firstLine int, parent *includeFile) ([]string, []sourceLine, error) {
+	lines := strings.Split(source, "\n")
+	var result []string
+	var lineMap []sourceLine
+	var inComment bool
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		m := includeRE.FindStringSubmatch(trimmed)
+		if inComment || len(m) < 2 {
+			inComment = scanComments(line, inComment)
+			result = append(result, line)
+			lineMap = append(lineMap, sourceLine{file: parent.name, line: firstLine + i, included: !parent.root})
+			continue
+		}
+
+		lineErr := func(err error) error {
+			return &IncludeError{Line: firstLine + i, Include: m[1], Err: err}
+		}
+		var annotated string
+		if h := includeHashRE.FindStringSubmatch(trimmed[len(m[0]):]); h != nil {
+			annotated = strings.ToLower(h[1])
+		}
+		f, buf, err := r.resolve(m[1], parent, annotated)
+		if err != nil {
+			return nil, nil, lineErr(err)
+		}
+		if err := r.verify(f, buf, annotated); err != nil {
+			return nil, nil, lineErr(err)
+		}
+		for j, g := range r.stack {
+			if g.key != "" && g.key == f.key {
+				var chain []string
+				for _, g := range r.stack[j:] {
+					chain = append(chain, g.name)
+				}
+				return nil, nil, lineErr(fmt.Errorf("include cycle: %v -> %v", strings.Join(chain, " -> "), f.name))
+			}
+		}
+		if r.seen[f.key] {
+			continue
+		}
+		r.seen[f.key] = true
+
+		r.stack = append(r.stack, f)
+		src, srcMap, err := r.expand(string(buf), 1, f)
+		r.stack = r.stack[:len(r.stack)-1]
+		if err != nil {
+			return nil, nil, lineErr(err)
+		}
+		result = append(result, src...)
+		lineMap = append(lineMap, srcMap...)
+	}
+
+	return result, lineMap, nil
+}
+
+// scanComments reports whether a "/* ... */" comment is still open at
+// the end of line, given whether one was open at its start.
+func scanComments(line string, inComment bool) bool {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case inComment:
+			if strings.HasPrefix(line[i:], "*/") {
+				inComment = false
+				i++
+			}
+		case strings.HasPrefix(line[i:], "//"):
+			return false
+		case strings.HasPrefix(line[i:], "/*"):
+			inComment = true
+			i++
+		}
+	}
+	return inComment
+}
+
+// resolve returns the include file inc (included from parent) and its contents.
+// If hash is not empty, a cached copy of a remote file with that hash is
+// preferred over whatever the URL currently refers to.
+func (r *includeResolver) resolve(inc string, parent *includeFile, hash string) (*includeFile, []byte, error) {
+	// Paths starting with "./" or "../" are relative to the including file.
+	// Other paths are first looked up next to the including file, then
+	// relative to the IRMF file and the search path.
+	relName := inc
+	if !parent.root {
+		relName = path.Clean(path.Join(path.Dir(parent.name), inc))
+	}
+	name := inc
+	if strings.HasPrefix(inc, "./") || strings.HasPrefix(inc, "../") {
+		name = relName
+	}
+
+	type candidate struct{ name, filename string }
+	var candidates []candidate
+	if filepath.IsAbs(inc) {
+		candidates = []candidate{{name, inc}}
+	} else {
+		if parent.dir != "" {
+			candidates = append(candidates, candidate{relName, filepath.Join(parent.dir, filepath.FromSlash(inc))})
+		}
+		for _, dir := range append([]string{r.opts.Dir}, r.opts.SearchPath...) {
+			candidates = append(candidates, candidate{name, filepath.Join(dir, filepath.FromSlash(name))})
+		}
+	}
+	for _, c := range candidates {
+		buf, err := os.ReadFile(c.filename)
+		if err == nil {
+			abs, err := filepath.Abs(c.filename)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &includeFile{name: c.name, key: abs, dir: filepath.Dir(abs)}, buf, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+
+	url := includeURL(name)
+	if url == "" {
+		return nil, nil, ErrIncludeNotFound
+	}
+	f := &includeFile{name: name, key: url}
+
+	if hash == "" && r.lock != nil && !r.opts.UpdateLock {
+		hash = r.lock[url]
+	}
+	if hash != "" {
+		if buf, err := r.readContent(hash); err != nil {
+			return nil, nil, err
+		} else if buf != nil {
+			return f, buf, nil
+		}
+	}
+	if !r.opts.UpdateLock {
+		if buf, err := r.readCache(url); err != nil {
+			return nil, nil, err
+		} else if buf != nil {
+			return f, buf, nil
+		}
+	}
+
+	if r.opts.Offline {
+		return nil, nil, fmt.Errorf("not found locally or in the cache, and %w (%v)", ErrOffline, url)
+	}
+	buf, err := r.fetch(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r.writeCache(url, buf); err != nil {
+		return nil, nil, err
+	}
+	return f, buf, nil
+}
+
+// The cache stores each file under its SHA-256 hash in "sha256/" and
+// maps each URL (by the SHA-256 of the URL) to its content hash in "url/".
+func (r *includeResolver) urlKeyPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(r.opts.CacheDir, "url", hex.EncodeToString(sum[:]))
+}
+
+func (r *includeResolver) contentPath(hash string) string {
+	return filepath.Join(r.opts.CacheDir, "sha256", hash)
+}
+
+// readCache returns the cached contents of url, or nil if it is not cached.
+func (r *includeResolver) readCache(url string) ([]byte, error) {
+	if r.opts.CacheDir == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(r.urlKeyPath(url))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.readContent(strings.TrimSpace(string(key)))
+}
+
+// readContent returns the cached file with the given SHA-256 hash,
+// or nil if it is not cached.
+func (r *includeResolver) readContent(hash string) ([]byte, error) {
+	if r.opts.CacheDir == "" {
+		return nil, nil
+	}
+	buf, err := os.ReadFile(r.contentPath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sha256Hex(buf) != hash {
+		log.Printf("Ignoring corrupt cache entry %v", hash)
+		return nil, nil
+	}
+	return buf, nil
+}
+
+// writeCache adds the contents of url to the cache.
+func (r *includeResolver) writeCache(url string, buf []byte) error {
+	if r.opts.CacheDir == "" {
+		return nil
+	}
+	hash := sha256Hex(buf)
+	if err := writeFileAtomic(r.contentPath(hash), buf); err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+	if err := writeFileAtomic(r.urlKeyPath(url), []byte(hash+"\n")); err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file so that readers never see partial contents.
+func writeFileAtomic(filename string, buf []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+func curl(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, &FetchError{URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &FetchError{URL: url, StatusCode: resp.StatusCode, Err: errors.New(resp.Status)}
+	}
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &FetchError{URL: url, StatusCode: resp.StatusCode, Err: fmt.Errorf("unable to read response body: %v", err)}
+	}
+	log.Printf("Read %v bytes from %v", len(buf), url)
+
+	return buf, nil
+}
@@ -0,0 +1,361 @@
+package irmf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, filename, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessIncludes(t *testing.T) {
+	const lygiaURL = "https://lygia.xyz/math/const.glsl"
+
+	tmp := t.TempDir()
+	modelDir := filepath.Join(tmp, "model")
+	vendorDir := filepath.Join(tmp, "vendor")
+	writeFile(t, filepath.Join(modelDir, "local.glsl"), "// local")
+	writeFile(t, filepath.Join(modelDir, "lygia", "math", "local.glsl"), "// local lygia")
+	writeFile(t, filepath.Join(vendorDir, "lygia", "math", "local.glsl"), "// vendored lygia (shadowed)")
+	writeFile(t, filepath.Join(vendorDir, "lygia", "math", "vendored.glsl"), "// vendored lygia")
+	writeFile(t, filepath.Join(vendorDir, "lygia", "sdf", "sphere.glsl"), "#include \"../math/vendored.glsl\"\n#include \"../math/const.glsl\"\n// sphere")
+	writeFile(t, filepath.Join(modelDir, "a.glsl"), "#include \"sub/b.glsl\"\n// a")
+	writeFile(t, filepath.Join(modelDir, "sub", "b.glsl"), "#include \"c.glsl\"\n// b")
+	writeFile(t, filepath.Join(modelDir, "sub", "c.glsl"), "#include \"../a.glsl\"\n// c")
+	writeFile(t, filepath.Join(modelDir, "model.irmf"), "// model\n#include \"self.glsl\"")
+	writeFile(t, filepath.Join(modelDir, "self.glsl"), "#include \"model.irmf\"\n// self")
+
+	tests := []struct {
+		name      string
+		filename  string
+		src       string
+		offline   bool
+		noCache   bool
+		network   map[string]string
+		want      string
+		wantErr   string
+		wantFetch int
+	}{
+		{
+			name: "no includes",
+			src:  "void main() {}",
+			want: "void main() {}",
+		},
+		{
+			name: "local relative path",
+			src:  `#include "local.glsl"`,
+			want: "// local",
+		},
+		{
+			name: "local takes priority over search path",
+			src:  `#include "lygia/math/local.glsl"`,
+			want: "// local lygia",
+		},
+		{
+			name: "search path",
+			src:  `#include "lygia/math/vendored.glsl"`,
+			want: "// vendored lygia",
+		},
+		{
+			name:      "network then cache",
+			src:       "#include \"lygia/math/const.glsl\"\n#include \"lygia.xyz/math/const.glsl\"",
+			network:   map[string]string{lygiaURL: "// const"},
+			want:      "// const",
+			wantFetch: 1,
+		},
+		{
+			name:      "recursive relative includes",
+			src:       "#include \"lygia/sdf/sphere.glsl\"\n// model",
+			network:   map[string]string{lygiaURL: "#include \"../math/vendored.glsl\"\n// const"},
+			want:      "// vendored lygia\n// const\n// sphere\n// model",
+			wantFetch: 1,
+		},
+		{
+			name: "include once",
+			src:  "#include \"local.glsl\"\n#include \"./local.glsl\"\n#include \"local.glsl\"",
+			want: "// local",
+		},
+		{
+			name:    "include cycle",
+			src:     "// model\n#include \"a.glsl\"",
+			wantErr: `line 11: #include "a.glsl": line 1: #include "sub/b.glsl": line 1: #include "c.glsl": line 1: #include "../a.glsl": include cycle: a.glsl -> sub/b.glsl -> sub/c.glsl -> a.glsl`,
+		},
+		{
+			name:     "include of the IRMF file",
+			filename: "model.irmf",
+			src:      "// model\n#include \"self.glsl\"",
+			wantErr:  `line 11: #include "self.glsl": line 1: #include "model.irmf": include cycle: model.irmf -> self.glsl -> model.irmf`,
+		},
+		{
+			name:     "IRMF file including itself",
+			filename: "model.irmf",
+			src:      "// model\n#include \"./model.irmf\"",
+			wantErr:  `line 11: #include "./model.irmf": include cycle: model.irmf -> ./model.irmf`,
+		},
+		{
+			name: "commented-out includes",
+			src:  "/* #include \"missing.glsl\"\n#include \"missing.glsl\"\n*/ // /*\n#include \"local.glsl\"",
+			want: "/* #include \"missing.glsl\"\n#include \"missing.glsl\"\n*/ // /*\n// local",
+		},
+		{
+			name:    "offline without cache",
+			src:     "// comment\n#include \"lygia/math/const.glsl\"",
+			offline: true,
+			noCache: true,
+			wantErr: `line 11: #include "lygia/math/const.glsl": not found locally or in the cache, and network access is disabled (https://lygia.xyz/math/const.glsl)`,
+		},
+		{
+			name:    "missing local file",
+			src:     `#include "bad/include.h"`,
+			wantErr: `line 10: #include "bad/include.h": file not found`,
+		},
+		{
+			name:    "download failure",
+			src:     `#include "lygia/math/missing.glsl"`,
+			wantErr: `line 10: #include "lygia/math/missing.glsl": 404 Not Found`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := IncludeOptions{
+				Dir:        modelDir,
+				Filename:   tt.filename,
+				SearchPath: []string{vendorDir},
+				CacheDir:   filepath.Join(t.TempDir(), "cache"),
+				Offline:    tt.offline,
+			}
+			if tt.noCache {
+				opts.CacheDir = ""
+			}
+			r := newIncludeResolver(opts)
+			var fetches int
+			r.fetch = func(url string) ([]byte, error) {
+				fetches++
+				if s, ok := tt.network[url]; ok {
+					return []byte(s), nil
+				}
+				return nil, errors.New("404 Not Found")
+			}
+
+			got, _, err := r.processIncludes(tt.src, 10)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("processIncludes error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("processIncludes: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("processIncludes = %q, want %q", got, tt.want)
+			}
+			if fetches != tt.wantFetch {
+				t.Errorf("got %v network fetches, want %v", fetches, tt.wantFetch)
+			}
+
+			// Everything downloaded must now be available offline from the cache.
+			if len(tt.network) > 0 {
+				opts.Offline = true
+				r := newIncludeResolver(opts)
+				if got, _, err := r.processIncludes(tt.src, 10); err != nil || got != tt.want {
+					t.Errorf("offline processIncludes = (%q, %v), want %q", got, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCorruptCacheEntry(t *testing.T) {
+	const url = "https://lygia.xyz/math/const.glsl"
+	r := newIncludeResolver(IncludeOptions{CacheDir: t.TempDir(), Offline: true})
+	if err := r.writeCache(url, []byte("// const")); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := filepath.Glob(filepath.Join(r.opts.CacheDir, "sha256", "*"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("Glob = %v, %v", matches, err)
+	}
+	writeFile(t, matches[0], "// tampered")
+
+	if _, _, err := r.resolve("lygia/math/const.glsl", &includeFile{}, ""); err == nil || !strings.Contains(err.Error(), "network access is disabled") {
+		t.Errorf("resolve error = %v, want cache miss", err)
+	}
+}
+
+func TestIncludeLock(t *testing.T) {
+	const (
+		lygiaURL = "https://lygia.xyz/math/const.glsl"
+		src      = "#include \"local.glsl\"\n#include \"lygia/math/const.glsl\""
+	)
+	modelDir := t.TempDir()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	lockFile := filepath.Join(modelDir, LockFileName)
+	writeFile(t, filepath.Join(modelDir, "local.glsl"), "// local")
+
+	network := map[string]string{lygiaURL: "// const v1"}
+	process := func(src string, opts IncludeOptions) (string, error) {
+		r := newIncludeResolver(opts)
+		r.fetch = func(url string) ([]byte, error) {
+			if s, ok := network[url]; ok {
+				return []byte(s), nil
+			}
+			return nil, errors.New("404 Not Found")
+		}
+		got, _, err := r.processIncludes(src, 1)
+		return got, err
+	}
+	opts := IncludeOptions{Dir: modelDir, CacheDir: cacheDir, LockFile: lockFile}
+
+	// Without a lock file, nothing is checked.
+	if _, err := process(src, opts); err != nil {
+		t.Fatalf("processIncludes without lock: %v", err)
+	}
+
+	// A changed remote file is re-downloaded when updating the lock.
+	network[lygiaURL] = "// const v2"
+	updateOpts := opts
+	updateOpts.UpdateLock = true
+	if got, err := process(src, updateOpts); err != nil || got != "// local\n// const v2" {
+		t.Fatalf("processIncludes(UpdateLock) = (%q, %v)", got, err)
+	}
+	buf, err := os.ReadFile(lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"local.glsl sha256:" + sha256Hex([]byte("// local")) + "\n",
+		lygiaURL + " sha256:" + sha256Hex([]byte("// const v2")) + "\n",
+	} {
+		if !strings.Contains(string(buf), want) {
+			t.Errorf("lock file = %q, missing %q", buf, want)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		network string
+		local   string
+		noCache bool
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "locked",
+			src:     src,
+			network: "// const v2",
+			want:    "// local\n// const v2",
+		},
+		{
+			name:    "remote file changed",
+			src:     src,
+			network: "// const v3",
+			noCache: true,
+			wantErr: `line 2: #include "lygia/math/const.glsl": SHA-256 mismatch: got ` + sha256Hex([]byte("// const v3")) + ", want " + sha256Hex([]byte("// const v2")) + " from " + lockFile,
+		},
+		{
+			name:    "locked copy found in cache",
+			src:     src,
+			network: "// const v3",
+			want:    "// local\n// const v2",
+		},
+		{
+			name:    "local file changed",
+			src:     src,
+			network: "// const v2",
+			local:   "// changed",
+			wantErr: `line 1: #include "local.glsl": SHA-256 mismatch: got ` + sha256Hex([]byte("// changed")) + ", want " + sha256Hex([]byte("// local")) + " from " + lockFile,
+		},
+		{
+			name:    "not in lock file",
+			src:     "#include \"lygia/math/other.glsl\"",
+			wantErr: `line 1: #include "lygia/math/other.glsl": missing from ` + lockFile + " (use -update-lock to add it)",
+		},
+		{
+			name:    "annotation",
+			src:     "#include \"local.glsl\" // sha256:" + sha256Hex([]byte("// local")),
+			want:    "// local",
+			network: "// const v2",
+		},
+		{
+			name:    "annotation mismatch",
+			src:     "#include \"local.glsl\" // sha256:" + sha256Hex([]byte("// other")),
+			wantErr: `line 1: #include "local.glsl": SHA-256 mismatch: got ` + sha256Hex([]byte("// local")) + ", want " + sha256Hex([]byte("// other")) + " from the #include line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network = map[string]string{lygiaURL: tt.network, "https://lygia.xyz/math/other.glsl": "// other"}
+			local := tt.local
+			if local == "" {
+				local = "// local"
+			}
+			writeFile(t, filepath.Join(modelDir, "local.glsl"), local)
+			opts := opts
+			if tt.noCache {
+				opts.CacheDir = ""
+			}
+
+			got, err := process(tt.src, opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("processIncludes error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("processIncludes = (%q, %v), want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLock(t *testing.T) {
+	hash := sha256Hex([]byte("// local"))
+	tests := []struct {
+		name    string
+		lock    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", want: map[string]string{}},
+		{
+			name: "entries and comments",
+			lock: "# comment\n\nlocal.glsl sha256:" + strings.ToUpper(hash) + "\n",
+			want: map[string]string{"local.glsl": hash},
+		},
+		{name: "missing prefix", lock: "local.glsl " + hash, wantErr: true},
+		{name: "short hash", lock: "local.glsl sha256:" + hash[1:], wantErr: true},
+		{name: "extra field", lock: "local.glsl sha256:" + hash + " x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLock([]byte(tt.lock))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLock error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(got) != len(tt.want) {
+				t.Fatalf("parseLock = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("parseLock[%q] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
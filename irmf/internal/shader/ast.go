@@ -0,0 +1,217 @@
+package shader
+
+import "fmt"
+
+// typeSpec represents a declared type.
+type typeSpec struct {
+	k    kind
+	r, c uint8 // rows and columns for numeric types
+
+	structName string
+	st         *structType // resolved by the compiler
+
+	elem    *typeSpec // array element type
+	length  int       // array length; 0 means sized by the initializer
+	lenExpr expr      // array length expression, if not a literal
+}
+
+// structType represents a declared struct type.
+type structType struct {
+	name   string
+	fields []field
+	index  map[string]int
+}
+
+type field struct {
+	name string
+	typ  *typeSpec
+}
+
+// expr represents an expression node.
+type expr interface {
+	tok() token
+}
+
+type (
+	litExpr struct {
+		t token
+		v Value
+	}
+	identExpr struct {
+		t    token
+		name string
+	}
+	unaryExpr struct {
+		t  token
+		op string // "-", "+", "!", "~", "++", "--"
+		x  expr
+	}
+	postfixExpr struct {
+		t  token
+		op string // "++", "--"
+		x  expr
+	}
+	binaryExpr struct {
+		t    token
+		op   string
+		x, y expr
+	}
+	assignExpr struct {
+		t        token
+		op       string // "=", "+=", ...
+		lhs, rhs expr
+	}
+	condExpr struct {
+		t             token
+		cond, yes, no expr
+	}
+	callExpr struct {
+		t    token
+		name string
+		typ  *typeSpec // explicit constructor type, e.g. "vec3<f32>" or "float[3]"
+		args []expr
+	}
+	memberExpr struct {
+		t    token
+		x    expr
+		name string
+	}
+	indexExpr struct {
+		t     token
+		x, ix expr
+	}
+	seqExpr struct {
+		t     token
+		exprs []expr
+	}
+)
+
+func (e *litExpr) tok() token     { return e.t }
+func (e *identExpr) tok() token   { return e.t }
+func (e *unaryExpr) tok() token   { return e.t }
+func (e *postfixExpr) tok() token { return e.t }
+func (e *binaryExpr) tok() token  { return e.t }
+func (e *assignExpr) tok() token  { return e.t }
+func (e *condExpr) tok() token    { return e.t }
+func (e *callExpr) tok() token    { return e.t }
+func (e *memberExpr) tok() token  { return e.t }
+func (e *indexExpr) tok() token   { return e.t }
+func (e *seqExpr) tok() token     { return e.t }
+
+// stmt represents a statement node.
+type stmt interface{}
+
+type (
+	varDecl struct {
+		t     token
+		name  string
+		typ   *typeSpec // nil for inferred WGSL declarations
+		init  expr
+		konst bool
+	}
+	declStmt struct {
+		decls []*varDecl
+	}
+	exprStmt struct {
+		x expr
+	}
+	blockStmt struct {
+		stmts []stmt
+	}
+	ifStmt struct {
+		t    token
+		cond expr
+		then stmt
+		els  stmt
+	}
+	forStmt struct {
+		t    token
+		init stmt
+		cond expr
+		post expr
+		body stmt
+	}
+	whileStmt struct {
+		t    token
+		cond expr
+		body stmt
+		do   bool
+	}
+	loopStmt struct {
+		t          token
+		body       *blockStmt
+		continuing *blockStmt
+	}
+	returnStmt struct {
+		t token
+		x expr
+	}
+	breakStmt struct {
+		t    token
+		cond expr // WGSL "break if"
+	}
+	continueStmt struct {
+		t token
+	}
+	discardStmt struct {
+		t token
+	}
+	switchStmt struct {
+		t     token
+		tag   expr
+		cases []*caseClause
+	}
+	caseClause struct {
+		t         token
+		values    []expr
+		isDefault bool
+		body      []stmt
+		wgsl      bool // WGSL case bodies never fall through
+	}
+)
+
+// param represents a function parameter.
+type param struct {
+	t    token
+	name string
+	typ  *typeSpec
+	out  bool // "out" or "inout"
+	in   bool // "in" or "inout" (or no qualifier)
+}
+
+// funcDecl represents a function declaration or prototype.
+type funcDecl struct {
+	t      token
+	name   string
+	params []*param
+	ret    *typeSpec
+	body   *blockStmt // nil for prototypes
+}
+
+// file represents a parsed shader.
+type file struct {
+	structs []*structType
+	globals []*varDecl
+	funcs   []*funcDecl
+
+	// structTypes maps struct names to unresolved field type specs.
+	structTok map[string]token
+}
+
+func (t *typeSpec) String() string {
+	switch t.k {
+	case kStruct:
+		return t.structName
+	case kArray:
+		return fmt.Sprintf("%v[%v]", t.elem, t.length)
+	case kVoid:
+		return "void"
+	}
+	switch {
+	case t.c > 1:
+		return fmt.Sprintf("mat%vx%v", t.c, t.r)
+	case t.r > 1:
+		return fmt.Sprintf("%v%v", t.k, t.r)
+	}
+	return t.k.String()
+}
@@ -0,0 +1,430 @@
+package shader
+
+import (
+	"fmt"
+	"math"
+)
+
+// builtin represents a built-in function with up to three overloads
+// selected by the number of arguments.
+type builtin struct {
+	f1 func(a Value) (Value, error)
+	f2 func(a, b Value) (Value, error)
+	f3 func(a, b, c Value) (Value, error)
+}
+
+// builtins contains the GLSL and WGSL built-in functions.
+var builtins = map[string]*builtin{}
+
+func init() {
+	floatFuncs := map[string]func(float64) float64{
+		"radians":     func(x float64) float64 { return x * math.Pi / 180 },
+		"degrees":     func(x float64) float64 { return x * 180 / math.Pi },
+		"sin":         math.Sin,
+		"cos":         math.Cos,
+		"tan":         math.Tan,
+		"asin":        math.Asin,
+		"acos":        math.Acos,
+		"sinh":        math.Sinh,
+		"cosh":        math.Cosh,
+		"tanh":        math.Tanh,
+		"asinh":       math.Asinh,
+		"acosh":       math.Acosh,
+		"atanh":       math.Atanh,
+		"exp":         math.Exp,
+		"log":         math.Log,
+		"exp2":        math.Exp2,
+		"log2":        math.Log2,
+		"sqrt":        math.Sqrt,
+		"inversesqrt": func(x float64) float64 { return 1 / math.Sqrt(x) },
+		"inverseSqrt": func(x float64) float64 { return 1 / math.Sqrt(x) },
+		"floor":       math.Floor,
+		"ceil":        math.Ceil,
+		"fract":       func(x float64) float64 { return x - math.Floor(x) },
+		"trunc":       math.Trunc,
+		"round":       math.RoundToEven,
+		"roundEven":   math.RoundToEven,
+		"saturate":    func(x float64) float64 { return math.Max(0, math.Min(1, x)) },
+	}
+	for name, fn := range floatFuncs {
+		fn := fn
+		builtins[name] = &builtin{f1: func(a Value) (Value, error) { return map1(a, kFloat, fn) }}
+	}
+
+	for _, name := range []string{"dFdx", "dFdy", "fwidth", "dpdx", "dpdy", "dpdxCoarse", "dpdyCoarse", "dpdxFine", "dpdyFine", "fwidthCoarse", "fwidthFine"} {
+		// There are no neighboring fragments, so derivatives are zero.
+		builtins[name] = &builtin{f1: func(a Value) (Value, error) {
+			return map1(a, kFloat, func(float64) float64 { return 0 })
+		}}
+	}
+
+	builtins["abs"] = &builtin{f1: func(a Value) (Value, error) { return map1(a, a.k, math.Abs) }}
+	builtins["sign"] = &builtin{f1: func(a Value) (Value, error) {
+		return map1(a, a.k, func(x float64) float64 {
+			switch {
+			case x > 0:
+				return 1
+			case x < 0:
+				return -1
+			}
+			return 0
+		})
+	}}
+	builtins["atan"] = &builtin{
+		f1: func(a Value) (Value, error) { return map1(a, kFloat, math.Atan) },
+		f2: func(y, x Value) (Value, error) { return map2(y, x, kFloat, math.Atan2) },
+	}
+	builtins["atan2"] = &builtin{f2: func(y, x Value) (Value, error) { return map2(y, x, kFloat, math.Atan2) }}
+	builtins["pow"] = &builtin{f2: func(a, b Value) (Value, error) { return map2(a, b, kFloat, math.Pow) }}
+	builtins["mod"] = &builtin{f2: func(a, b Value) (Value, error) {
+		return map2(a, b, kFloat, func(x, y float64) float64 { return x - y*math.Floor(x/y) })
+	}}
+	builtins["step"] = &builtin{f2: func(edge, x Value) (Value, error) {
+		return map2(edge, x, kFloat, func(e, x float64) float64 {
+			if x < e {
+				return 0
+			}
+			return 1
+		})
+	}}
+	builtins["min"] = &builtin{f2: func(a, b Value) (Value, error) { return map2(a, b, promote(a.k, b.k), math.Min) }}
+	builtins["max"] = &builtin{f2: func(a, b Value) (Value, error) { return map2(a, b, promote(a.k, b.k), math.Max) }}
+	builtins["clamp"] = &builtin{f3: func(x, lo, hi Value) (Value, error) {
+		return map3(x, lo, hi, promote(x.k, promote(lo.k, hi.k)), func(x, lo, hi float64) float64 {
+			return math.Min(math.Max(x, lo), hi)
+		})
+	}}
+	builtins["mix"] = &builtin{f3: func(x, y, a Value) (Value, error) {
+		if a.k == kBool {
+			return selectValue(x, y, a)
+		}
+		return map3(x, y, a, kFloat, func(x, y, a float64) float64 { return x*(1-a) + y*a })
+	}}
+	builtins["select"] = &builtin{f3: selectValue}
+	builtins["smoothstep"] = &builtin{f3: func(e0, e1, x Value) (Value, error) {
+		return map3(e0, e1, x, kFloat, func(e0, e1, x float64) float64 {
+			t := math.Max(0, math.Min(1, (x-e0)/(e1-e0)))
+			return t * t * (3 - 2*t)
+		})
+	}}
+	builtins["fma"] = &builtin{f3: func(a, b, c Value) (Value, error) {
+		return map3(a, b, c, kFloat, func(a, b, c float64) float64 { return a*b + c })
+	}}
+
+	builtins["length"] = &builtin{f1: func(a Value) (Value, error) {
+		d, err := dot(a, a)
+		return Scalar(float32(math.Sqrt(d))), err
+	}}
+	builtins["distance"] = &builtin{f2: func(a, b Value) (Value, error) {
+		d, err := binop(opSub, a, b, false)
+		if err != nil {
+			return Value{}, err
+		}
+		n, err := dot(d, d)
+		return Scalar(float32(math.Sqrt(n))), err
+	}}
+	builtins["dot"] = &builtin{f2: func(a, b Value) (Value, error) {
+		d, err := dot(a, b)
+		if a.k == kFloat || b.k == kFloat {
+			return Scalar(float32(d)), err
+		}
+		out := Value{k: promote(a.k, b.k), r: 1, c: 1}
+		out.setNum(0, d)
+		return out, err
+	}}
+	builtins["normalize"] = &builtin{f1: func(a Value) (Value, error) {
+		d, err := dot(a, a)
+		if err != nil {
+			return Value{}, err
+		}
+		n := math.Sqrt(d)
+		return map1(a, kFloat, func(x float64) float64 { return x / n })
+	}}
+	builtins["cross"] = &builtin{f2: func(a, b Value) (Value, error) {
+		if a.r != 3 || b.r != 3 || a.c != 1 || b.c != 1 {
+			return Value{}, fmt.Errorf("cross requires two 3-component vectors")
+		}
+		return Vec(
+			float32(a.num(1)*b.num(2)-a.num(2)*b.num(1)),
+			float32(a.num(2)*b.num(0)-a.num(0)*b.num(2)),
+			float32(a.num(0)*b.num(1)-a.num(1)*b.num(0)),
+		), nil
+	}}
+	builtins["reflect"] = &builtin{f2: func(i, n Value) (Value, error) {
+		d, err := dot(n, i)
+		if err != nil {
+			return Value{}, err
+		}
+		return map2(i, n, kFloat, func(i, n float64) float64 { return i - 2*d*n })
+	}}
+	builtins["refract"] = &builtin{f3: func(i, n, eta Value) (Value, error) {
+		d, err := dot(n, i)
+		if err != nil {
+			return Value{}, err
+		}
+		e := eta.num(0)
+		k := 1 - e*e*(1-d*d)
+		if k < 0 {
+			return map1(i, kFloat, func(float64) float64 { return 0 })
+		}
+		return map2(i, n, kFloat, func(i, n float64) float64 { return e*i - (e*d+math.Sqrt(k))*n })
+	}}
+	faceForward := &builtin{f3: func(n, i, nref Value) (Value, error) {
+		d, err := dot(nref, i)
+		if err != nil {
+			return Value{}, err
+		}
+		if d < 0 {
+			return n.convert(kFloat), nil
+		}
+		return negate(n.convert(kFloat))
+	}}
+	builtins["faceforward"] = faceForward
+	builtins["faceForward"] = faceForward
+
+	compares := map[string]opcode{
+		"lessThan":         opLt,
+		"lessThanEqual":    opLe,
+		"greaterThan":      opGt,
+		"greaterThanEqual": opGe,
+		"equal":            opEq,
+		"notEqual":         opNe,
+	}
+	for name, op := range compares {
+		op := op
+		builtins[name] = &builtin{f2: func(a, b Value) (Value, error) { return compare(op, a, b, true) }}
+	}
+	builtins["all"] = &builtin{f1: func(a Value) (Value, error) {
+		for i := 0; i < a.Len(); i++ {
+			if a.v[i] == 0 {
+				return boolValue(false), nil
+			}
+		}
+		return boolValue(true), nil
+	}}
+	builtins["any"] = &builtin{f1: func(a Value) (Value, error) {
+		for i := 0; i < a.Len(); i++ {
+			if a.v[i] != 0 {
+				return boolValue(true), nil
+			}
+		}
+		return boolValue(false), nil
+	}}
+	builtins["not"] = &builtin{f1: not}
+
+	builtins["matrixCompMult"] = &builtin{f2: func(a, b Value) (Value, error) {
+		return map2(a, b, kFloat, func(x, y float64) float64 { return x * y })
+	}}
+	builtins["transpose"] = &builtin{f1: func(a Value) (Value, error) {
+		if !a.isMatrix() {
+			return Value{}, fmt.Errorf("transpose requires a matrix")
+		}
+		out := Value{k: a.k, r: a.c, c: a.r}
+		for j := 0; j < int(a.c); j++ {
+			for i := 0; i < int(a.r); i++ {
+				out.v[i*int(out.r)+j] = a.v[j*int(a.r)+i]
+			}
+		}
+		return out, nil
+	}}
+	builtins["determinant"] = &builtin{f1: func(a Value) (Value, error) {
+		if !a.isMatrix() || a.r != a.c {
+			return Value{}, fmt.Errorf("determinant requires a square matrix")
+		}
+		m := toRows(a)
+		d, _ := gaussJordan(m, false)
+		return Scalar(float32(d)), nil
+	}}
+	builtins["inverse"] = &builtin{f1: func(a Value) (Value, error) {
+		if !a.isMatrix() || a.r != a.c {
+			return Value{}, fmt.Errorf("inverse requires a square matrix")
+		}
+		_, inv := gaussJordan(toRows(a), true)
+		out := Value{k: kFloat, r: a.r, c: a.c}
+		n := int(a.r)
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				out.setNum(j*n+i, inv[i][j])
+			}
+		}
+		return out, nil
+	}}
+
+	bitcasts := map[string][2]kind{
+		"floatBitsToInt":  {kFloat, kInt},
+		"floatBitsToUint": {kFloat, kUint},
+		"intBitsToFloat":  {kInt, kFloat},
+		"uintBitsToFloat": {kUint, kFloat},
+	}
+	for name, kinds := range bitcasts {
+		name, from, to := name, kinds[0], kinds[1]
+		builtins[name] = &builtin{f1: func(a Value) (Value, error) {
+			if a.k != from {
+				return Value{}, fmt.Errorf("%v requires %v argument, found %v", name, from, a.k)
+			}
+			a.k = to
+			return a, nil
+		}}
+	}
+}
+
+// map1 applies fn to each component of a, producing a result of kind k.
+func map1(a Value, k kind, fn func(float64) float64) (Value, error) {
+	if a.k >= kStruct || k == kBool {
+		return Value{}, fmt.Errorf("invalid argument %v", a.k)
+	}
+	out := Value{k: k, r: a.r, c: a.c}
+	for i := 0; i < a.Len(); i++ {
+		out.setNum(i, fn(a.num(i)))
+	}
+	return out, nil
+}
+
+// map2 applies fn component-wise to a and b, broadcasting scalars.
+func map2(a, b Value, k kind, fn func(x, y float64) float64) (Value, error) {
+	if a.k >= kStruct || b.k >= kStruct || k == kBool {
+		return Value{}, fmt.Errorf("invalid arguments %v and %v", a.k, b.k)
+	}
+	r, c, ok := broadcast(&a, &b)
+	if !ok {
+		return Value{}, fmt.Errorf("mismatched argument shapes %vx%v and %vx%v", a.c, a.r, b.c, b.r)
+	}
+	out := Value{k: k, r: r, c: c}
+	ai, bi := stride(&a), stride(&b)
+	for i := 0; i < out.Len(); i++ {
+		out.setNum(i, fn(a.num(i*ai), b.num(i*bi)))
+	}
+	return out, nil
+}
+
+// map3 applies fn component-wise to a, b and c, broadcasting scalars.
+func map3(a, b, c Value, k kind, fn func(x, y, z float64) float64) (Value, error) {
+	if a.k >= kStruct || b.k >= kStruct || c.k >= kStruct || k == kBool {
+		return Value{}, fmt.Errorf("invalid arguments %v, %v and %v", a.k, b.k, c.k)
+	}
+	r, cols, ok := broadcast(&a, &b)
+	if ok {
+		shape := Value{r: r, c: cols}
+		r, cols, ok = broadcast(&shape, &c)
+	}
+	if !ok {
+		return Value{}, fmt.Errorf("mismatched argument shapes")
+	}
+	out := Value{k: k, r: r, c: cols}
+	ai, bi, ci := stride(&a), stride(&b), stride(&c)
+	for i := 0; i < out.Len(); i++ {
+		out.setNum(i, fn(a.num(i*ai), b.num(i*bi), c.num(i*ci)))
+	}
+	return out, nil
+}
+
+// stride returns 0 for scalars (which broadcast) and 1 otherwise.
+func stride(v *Value) int {
+	if v.isScalar() {
+		return 0
+	}
+	return 1
+}
+
+// selectValue returns t where cond is true and f elsewhere.
+// This implements both WGSL select(f, t, cond) and GLSL mix(x, y, bvec).
+func selectValue(f, t, cond Value) (Value, error) {
+	if cond.k != kBool {
+		return Value{}, fmt.Errorf("selector must be bool, found %v", cond.k)
+	}
+	k := promote(f.k, t.k)
+	f, t = f.convert(k), t.convert(k)
+	if cond.isScalar() {
+		if cond.truth() {
+			return t, nil
+		}
+		return f, nil
+	}
+	if !sameShape(&f, &t) || f.r != cond.r || f.c != 1 {
+		return Value{}, fmt.Errorf("mismatched argument shapes")
+	}
+	out := f
+	for i := 0; i < int(cond.r); i++ {
+		if cond.v[i] != 0 {
+			out.v[i] = t.v[i]
+		}
+	}
+	return out, nil
+}
+
+func dot(a, b Value) (float64, error) {
+	if a.k >= kStruct || b.k >= kStruct || a.c != 1 || b.c != 1 || a.r != b.r {
+		return 0, fmt.Errorf("mismatched vector arguments")
+	}
+	var sum float64
+	for i := 0; i < int(a.r); i++ {
+		sum += a.num(i) * b.num(i)
+	}
+	return sum, nil
+}
+
+// toRows returns a square matrix as row-major float64 slices.
+func toRows(a Value) [][]float64 {
+	n := int(a.r)
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n)
+		for j := range m[i] {
+			m[i][j] = a.num(j*n + i)
+		}
+	}
+	return m
+}
+
+// gaussJordan returns the determinant of m and, if wantInverse is set,
+// its inverse. A singular matrix has a zero determinant and an inverse
+// of all zeros. m is modified.
+func gaussJordan(m [][]float64, wantInverse bool) (float64, [][]float64) {
+	n := len(m)
+	inv := make([][]float64, n)
+	for i := range inv {
+		inv[i] = make([]float64, n)
+		inv[i][i] = 1
+	}
+	det := 1.0
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if m[pivot][col] == 0 {
+			zero := make([][]float64, n)
+			for i := range zero {
+				zero[i] = make([]float64, n)
+			}
+			return 0, zero
+		}
+		if pivot != col {
+			m[pivot], m[col] = m[col], m[pivot]
+			inv[pivot], inv[col] = inv[col], inv[pivot]
+			det = -det
+		}
+		p := m[col][col]
+		det *= p
+		for j := 0; j < n; j++ {
+			m[col][j] /= p
+			inv[col][j] /= p
+		}
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+			f := m[row][col]
+			for j := 0; j < n; j++ {
+				m[row][j] -= f * m[col][j]
+				inv[row][j] -= f * inv[col][j]
+			}
+		}
+	}
+	if !wantInverse {
+		return det, nil
+	}
+	return det, inv
+}
@@ -0,0 +1,784 @@
+package shader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// evalFn evaluates an expression in the frame starting at stack index b.
+type evalFn func(m *Machine, b int) Value
+
+// execFn executes a statement in the frame starting at stack index b.
+type execFn func(m *Machine, b int) ctrl
+
+// ctrl represents the control flow outcome of a statement.
+type ctrl uint8
+
+const (
+	ctrlNone ctrl = iota
+	ctrlBreak
+	ctrlContinue
+	ctrlReturn
+)
+
+// discardSignal is raised (via panic) by the "discard" statement.
+type discardSignal struct{}
+
+// function represents a compiled user-defined function.
+type function struct {
+	name    string
+	params  []*param
+	ret     *typeSpec
+	nlocals int
+	body    execFn
+	decl    *funcDecl
+}
+
+type globalVar struct {
+	slot  int
+	typ   *typeSpec
+	konst bool
+}
+
+type localVar struct {
+	slot  int
+	typ   *typeSpec
+	konst bool
+}
+
+// lvalue represents an assignable expression.
+type lvalue struct {
+	get evalFn
+	set func(m *Machine, b int, v Value)
+}
+
+type compiler struct {
+	wgsl    bool
+	f       *file
+	structs map[string]*structType
+	funcs   map[string][]*function
+	globals map[string]*globalVar
+	gdecls  map[string]*varDecl
+	calls   map[*function][]call // the call graph, to reject recursion
+
+	// Per-function state.
+	fn      *function
+	scopes  []map[string]*localVar
+	nlocals int
+}
+
+func compileFile(f *file, wgsl bool) (*Program, error) {
+	c := &compiler{
+		wgsl:    wgsl,
+		f:       f,
+		structs: map[string]*structType{},
+		funcs:   map[string][]*function{},
+		globals: map[string]*globalVar{},
+		gdecls:  map[string]*varDecl{},
+		calls:   map[*function][]call{},
+	}
+	for _, st := range f.structs {
+		c.structs[st.name] = st
+	}
+	for _, st := range f.structs {
+		for _, fd := range st.fields {
+			if err := c.resolveType(fd.typ, f.structTok[st.name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	prog := &Program{wgsl: wgsl, funcs: c.funcs, globals: map[string]int{}}
+
+	// Declare all globals and functions before compiling any bodies
+	// so that WGSL module-scope declarations may appear in any order.
+	for i, d := range f.globals {
+		if _, ok := c.globals[d.name]; ok {
+			return nil, errorf(d.t, "redeclaration of %q", d.name)
+		}
+		if d.typ != nil {
+			if err := c.resolveType(d.typ, d.t); err != nil {
+				return nil, err
+			}
+		}
+		c.globals[d.name] = &globalVar{slot: i, typ: d.typ, konst: d.konst}
+		c.gdecls[d.name] = d
+		prog.globals[d.name] = i
+	}
+	prog.nglobals = len(f.globals)
+
+	var all []*function
+	for _, fd := range f.funcs {
+		for _, p := range fd.params {
+			if err := c.resolveType(p.typ, fd.t); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.resolveType(fd.ret, fd.t); err != nil {
+			return nil, err
+		}
+		for _, other := range c.funcs[fd.name] {
+			if sameSignature(other.params, fd.params) {
+				return nil, errorf(fd.t, "redefinition of function %q", fd.name)
+			}
+		}
+		fn := &function{name: fd.name, params: fd.params, ret: fd.ret, decl: fd}
+		c.funcs[fd.name] = append(c.funcs[fd.name], fn)
+		all = append(all, fn)
+	}
+
+	for _, fn := range all {
+		if err := c.compileFunc(fn); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkRecursion(all); err != nil {
+		return nil, err
+	}
+
+	c.fn = &function{ret: &typeSpec{k: kVoid}}
+	for i, d := range f.globals {
+		slot := i
+		typ := d.typ
+		if d.init == nil {
+			z, err := zeroValue(typ, d.t)
+			if err != nil {
+				return nil, err
+			}
+			prog.inits = append(prog.inits, func(m *Machine) { m.globals[slot] = z.clone() })
+			continue
+		}
+		c.scopes = []map[string]*localVar{{}}
+		init, err := c.expr(d.init)
+		if err != nil {
+			return nil, err
+		}
+		t := d.t
+		prog.inits = append(prog.inits, func(m *Machine) {
+			v := init(m, m.sp)
+			v = coerce(v, typ, t)
+			m.globals[slot] = v.clone()
+		})
+	}
+
+	return prog, nil
+}
+
+func sameSignature(a, b []*param) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameType(a[i].typ, b[i].typ) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameType(a, b *typeSpec) bool {
+	if a.k != b.k || a.r != b.r || a.c != b.c || a.st != b.st || a.length != b.length {
+		return false
+	}
+	if a.elem != nil && b.elem != nil {
+		return sameType(a.elem, b.elem)
+	}
+	return a.elem == nil && b.elem == nil
+}
+
+// resolveType resolves struct names and array lengths in t.
+func (c *compiler) resolveType(t *typeSpec, at token) error {
+	if t == nil {
+		return nil
+	}
+	switch t.k {
+	case kStruct:
+		if t.st != nil {
+			return nil
+		}
+		st, ok := c.structs[t.structName]
+		if !ok {
+			return errorf(at, "unknown struct %q", t.structName)
+		}
+		t.st = st
+	case kArray:
+		if err := c.resolveType(t.elem, at); err != nil {
+			return err
+		}
+		if t.lenExpr != nil && t.length == 0 {
+			n, err := c.constInt(t.lenExpr)
+			if err != nil {
+				return err
+			}
+			t.length = n
+		}
+	}
+	return nil
+}
+
+// constInt evaluates a constant integer expression such as an array length.
+func (c *compiler) constInt(x expr) (int, error) {
+	switch e := x.(type) {
+	case *litExpr:
+		if e.v.k == kInt || e.v.k == kUint {
+			return int(e.v.v[0]), nil
+		}
+	case *identExpr:
+		if d, ok := c.gdecls[e.name]; ok && d.konst && d.init != nil {
+			return c.constInt(d.init)
+		}
+	case *binaryExpr:
+		x, err := c.constInt(e.x)
+		if err != nil {
+			return 0, err
+		}
+		y, err := c.constInt(e.y)
+		if err != nil {
+			return 0, err
+		}
+		switch e.op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		case "/":
+			if y != 0 {
+				return x / y, nil
+			}
+		}
+	}
+	return 0, errorf(x.tok(), "array length must be a constant integer expression")
+}
+
+func (c *compiler) pushScope() { c.scopes = append(c.scopes, map[string]*localVar{}) }
+func (c *compiler) popScope()  { c.scopes = c.scopes[:len(c.scopes)-1] }
+
+func (c *compiler) declareLocal(t token, name string, typ *typeSpec, konst bool) (*localVar, error) {
+	scope := c.scopes[len(c.scopes)-1]
+	if _, ok := scope[name]; ok {
+		return nil, errorf(t, "redeclaration of %q", name)
+	}
+	lv := &localVar{slot: c.nlocals, typ: typ, konst: konst}
+	c.nlocals++
+	scope[name] = lv
+	return lv, nil
+}
+
+func (c *compiler) lookupLocal(name string) *localVar {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if lv, ok := c.scopes[i][name]; ok {
+			return lv
+		}
+	}
+	return nil
+}
+
+func (c *compiler) compileFunc(fn *function) error {
+	c.fn = fn
+	c.scopes = []map[string]*localVar{{}}
+	c.nlocals = 0
+	for i, p := range fn.params {
+		name := p.name
+		if name == "" {
+			name = fmt.Sprintf("#%v", i) // unnamed parameter
+		}
+		if _, err := c.declareLocal(p.t, name, p.typ, false); err != nil {
+			return err
+		}
+	}
+	body, err := c.block(fn.decl.body.stmts, false)
+	if err != nil {
+		return err
+	}
+	fn.body = body
+	fn.nlocals = c.nlocals
+	return nil
+}
+
+// block compiles a list of statements, optionally in a new scope.
+func (c *compiler) block(stmts []stmt, scoped bool) (execFn, error) {
+	if scoped {
+		c.pushScope()
+		defer c.popScope()
+	}
+	var fns []execFn
+	for _, s := range stmts {
+		fn, err := c.stmt(s)
+		if err != nil {
+			return nil, err
+		}
+		if fn != nil {
+			fns = append(fns, fn)
+		}
+	}
+	switch len(fns) {
+	case 0:
+		return func(m *Machine, b int) ctrl { return ctrlNone }, nil
+	case 1:
+		return fns[0], nil
+	}
+	return func(m *Machine, b int) ctrl {
+		for _, fn := range fns {
+			if r := fn(m, b); r != ctrlNone {
+				return r
+			}
+		}
+		return ctrlNone
+	}, nil
+}
+
+func (c *compiler) stmt(s stmt) (execFn, error) {
+	switch s := s.(type) {
+	case nil:
+		return nil, nil
+	case *blockStmt:
+		return c.block(s.stmts, true)
+	case *declStmt:
+		return c.declStmt(s)
+	case *exprStmt:
+		x, err := c.expr(s.x)
+		if err != nil {
+			return nil, err
+		}
+		return func(m *Machine, b int) ctrl {
+			x(m, b)
+			return ctrlNone
+		}, nil
+	case *ifStmt:
+		return c.ifStmt(s)
+	case *forStmt:
+		return c.forStmt(s)
+	case *whileStmt:
+		return c.whileStmt(s)
+	case *loopStmt:
+		return c.loopStmt(s)
+	case *switchStmt:
+		return c.switchStmt(s)
+	case *returnStmt:
+		return c.returnStmt(s)
+	case *breakStmt:
+		if s.cond != nil {
+			cond, err := c.expr(s.cond)
+			if err != nil {
+				return nil, err
+			}
+			return func(m *Machine, b int) ctrl {
+				if v := cond(m, b); v.truth() {
+					return ctrlBreak
+				}
+				return ctrlNone
+			}, nil
+		}
+		return func(m *Machine, b int) ctrl { return ctrlBreak }, nil
+	case *continueStmt:
+		return func(m *Machine, b int) ctrl { return ctrlContinue }, nil
+	case *discardStmt:
+		return func(m *Machine, b int) ctrl { panic(discardSignal{}) }, nil
+	}
+	return nil, fmt.Errorf("unsupported statement %T", s)
+}
+
+func (c *compiler) declStmt(s *declStmt) (execFn, error) {
+	var fns []execFn
+	for _, d := range s.decls {
+		typ := d.typ
+		if typ != nil {
+			if err := c.resolveType(typ, d.t); err != nil {
+				return nil, err
+			}
+		}
+		var init evalFn
+		if d.init != nil {
+			var err error
+			if init, err = c.expr(d.init); err != nil {
+				return nil, err
+			}
+		}
+		// Declare after compiling the initializer so that
+		// "float x = x;" refers to any outer x.
+		lv, err := c.declareLocal(d.t, d.name, typ, d.konst)
+		if err != nil {
+			return nil, err
+		}
+		slot, t := lv.slot, d.t
+		if init == nil {
+			z, err := zeroValue(typ, d.t)
+			if err != nil {
+				return nil, err
+			}
+			fns = append(fns, func(m *Machine, b int) ctrl {
+				m.stack[b+slot] = z.clone()
+				return ctrlNone
+			})
+			continue
+		}
+		fns = append(fns, func(m *Machine, b int) ctrl {
+			v := coerce(init(m, b), typ, t)
+			m.stack[b+slot] = v
+			return ctrlNone
+		})
+	}
+	if len(fns) == 1 {
+		return fns[0], nil
+	}
+	return func(m *Machine, b int) ctrl {
+		for _, fn := range fns {
+			fn(m, b)
+		}
+		return ctrlNone
+	}, nil
+}
+
+func (c *compiler) ifStmt(s *ifStmt) (execFn, error) {
+	cond, err := c.expr(s.cond)
+	if err != nil {
+		return nil, err
+	}
+	then, err := c.scopedStmt(s.then)
+	if err != nil {
+		return nil, err
+	}
+	if s.els == nil {
+		return func(m *Machine, b int) ctrl {
+			if v := cond(m, b); v.truth() {
+				return then(m, b)
+			}
+			return ctrlNone
+		}, nil
+	}
+	els, err := c.scopedStmt(s.els)
+	if err != nil {
+		return nil, err
+	}
+	return func(m *Machine, b int) ctrl {
+		if v := cond(m, b); v.truth() {
+			return then(m, b)
+		}
+		return els(m, b)
+	}, nil
+}
+
+// scopedStmt compiles a statement in its own scope.
+func (c *compiler) scopedStmt(s stmt) (execFn, error) {
+	c.pushScope()
+	defer c.popScope()
+	fn, err := c.stmt(s)
+	if err != nil || fn != nil {
+		return fn, err
+	}
+	return func(m *Machine, b int) ctrl { return ctrlNone }, nil
+}
+
+func alwaysTrue(m *Machine, b int) Value { return boolValue(true) }
+
+func (c *compiler) forStmt(s *forStmt) (execFn, error) {
+	c.pushScope()
+	defer c.popScope()
+	init, err := c.stmt(s.init)
+	if err != nil {
+		return nil, err
+	}
+	cond := evalFn(alwaysTrue)
+	if s.cond != nil {
+		if cond, err = c.expr(s.cond); err != nil {
+			return nil, err
+		}
+	}
+	var post evalFn
+	if s.post != nil {
+		if post, err = c.expr(s.post); err != nil {
+			return nil, err
+		}
+	}
+	body, err := c.scopedStmt(s.body)
+	if err != nil {
+		return nil, err
+	}
+	return func(m *Machine, b int) ctrl {
+		if init != nil {
+			init(m, b)
+		}
+		for {
+			if v := cond(m, b); !v.truth() {
+				return ctrlNone
+			}
+			switch body(m, b) {
+			case ctrlBreak:
+				return ctrlNone
+			case ctrlReturn:
+				return ctrlReturn
+			}
+			if post != nil {
+				post(m, b)
+			}
+		}
+	}, nil
+}
+
+func (c *compiler) whileStmt(s *whileStmt) (execFn, error) {
+	cond, err := c.expr(s.cond)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.scopedStmt(s.body)
+	if err != nil {
+		return nil, err
+	}
+	do := s.do
+	return func(m *Machine, b int) ctrl {
+		for first := true; ; first = false {
+			if !(do && first) {
+				if v := cond(m, b); !v.truth() {
+					return ctrlNone
+				}
+			}
+			switch body(m, b) {
+			case ctrlBreak:
+				return ctrlNone
+			case ctrlReturn:
+				return ctrlReturn
+			}
+		}
+	}, nil
+}
+
+func (c *compiler) loopStmt(s *loopStmt) (execFn, error) {
+	c.pushScope()
+	defer c.popScope()
+	body, err := c.block(s.body.stmts, false)
+	if err != nil {
+		return nil, err
+	}
+	continuing := func(m *Machine, b int) ctrl { return ctrlNone }
+	if s.continuing != nil {
+		if continuing, err = c.block(s.continuing.stmts, true); err != nil {
+			return nil, err
+		}
+	}
+	return func(m *Machine, b int) ctrl {
+		for {
+			switch body(m, b) {
+			case ctrlBreak:
+				return ctrlNone
+			case ctrlReturn:
+				return ctrlReturn
+			}
+			switch continuing(m, b) {
+			case ctrlBreak:
+				return ctrlNone
+			case ctrlReturn:
+				return ctrlReturn
+			}
+		}
+	}, nil
+}
+
+func (c *compiler) switchStmt(s *switchStmt) (execFn, error) {
+	tag, err := c.expr(s.tag)
+	if err != nil {
+		return nil, err
+	}
+	type compiledCase struct {
+		values    []evalFn
+		isDefault bool
+		body      execFn
+		wgsl      bool
+	}
+	var cases []compiledCase
+	c.pushScope()
+	defer c.popScope()
+	for _, cc := range s.cases {
+		cs := compiledCase{isDefault: cc.isDefault, wgsl: cc.wgsl}
+		for _, v := range cc.values {
+			fn, err := c.expr(v)
+			if err != nil {
+				return nil, err
+			}
+			cs.values = append(cs.values, fn)
+		}
+		if cs.body, err = c.block(cc.body, cc.wgsl); err != nil {
+			return nil, err
+		}
+		cases = append(cases, cs)
+	}
+	return func(m *Machine, b int) ctrl {
+		t := tag(m, b)
+		start := -1
+	search:
+		for i, cs := range cases {
+			for _, v := range cs.values {
+				cv := v(m, b)
+				if cv.num(0) == t.num(0) {
+					start = i
+					break search
+				}
+			}
+		}
+		if start < 0 {
+			for i, cs := range cases {
+				if cs.isDefault {
+					start = i
+					break
+				}
+			}
+		}
+		if start < 0 {
+			return ctrlNone
+		}
+		for i := start; i < len(cases); i++ {
+			switch r := cases[i].body(m, b); r {
+			case ctrlBreak:
+				return ctrlNone
+			case ctrlNone:
+				if cases[i].wgsl {
+					return ctrlNone
+				}
+			default:
+				return r
+			}
+		}
+		return ctrlNone
+	}, nil
+}
+
+func (c *compiler) returnStmt(s *returnStmt) (execFn, error) {
+	ret := c.fn.ret
+	if s.x == nil {
+		if ret != nil && ret.k != kVoid {
+			return nil, errorf(s.t, "missing return value")
+		}
+		return func(m *Machine, b int) ctrl {
+			m.ret = Value{k: kVoid}
+			return ctrlReturn
+		}, nil
+	}
+	if ret != nil && ret.k == kVoid {
+		return nil, errorf(s.t, "void function cannot return a value")
+	}
+	x, err := c.expr(s.x)
+	if err != nil {
+		return nil, err
+	}
+	t := s.t
+	return func(m *Machine, b int) ctrl {
+		v := coerce(x(m, b), ret, t)
+		m.ret = v
+		return ctrlReturn
+	}, nil
+}
+
+// coerce converts v to the declared type typ. Only numeric component
+// kinds are converted; a shape mismatch is a runtime error.
+func coerce(v Value, typ *typeSpec, at token) Value {
+	if typ == nil {
+		return v
+	}
+	switch typ.k {
+	case kStruct:
+		if v.k != kStruct || v.st != typ.st {
+			panic(errorf(at, "cannot use %v as struct %v", v.k, typ.structName))
+		}
+		return v
+	case kArray:
+		if v.k != kArray || (typ.length > 0 && len(v.s) != typ.length) {
+			panic(errorf(at, "cannot use %v as array of length %v", v, typ.length))
+		}
+		return v
+	case kVoid:
+		return v
+	}
+	if v.k >= kStruct || v.r != typ.r || v.c != typ.c {
+		panic(errorf(at, "cannot use %v as %v", v, typ))
+	}
+	return v.convert(typ.k)
+}
+
+// zeroValue returns the zero value of typ.
+func zeroValue(typ *typeSpec, at token) (Value, error) {
+	if typ == nil {
+		return Value{}, errorf(at, "missing type")
+	}
+	switch typ.k {
+	case kStruct:
+		v := Value{k: kStruct, st: typ.st, s: make([]Value, len(typ.st.fields))}
+		for i, f := range typ.st.fields {
+			z, err := zeroValue(f.typ, at)
+			if err != nil {
+				return Value{}, err
+			}
+			v.s[i] = z
+		}
+		return v, nil
+	case kArray:
+		if typ.length <= 0 {
+			return Value{}, errorf(at, "unsized array requires an initializer")
+		}
+		v := Value{k: kArray, s: make([]Value, typ.length)}
+		for i := range v.s {
+			z, err := zeroValue(typ.elem, at)
+			if err != nil {
+				return Value{}, err
+			}
+			v.s[i] = z
+		}
+		return v, nil
+	case kVoid:
+		return Value{k: kVoid}, nil
+	case kInfer:
+		return Value{k: kFloat, r: typ.r, c: typ.c}, nil
+	}
+	return Value{k: typ.k, r: typ.r, c: typ.c}, nil
+}
+
+// call is a call from one function to another in the call graph.
+type call struct {
+	t  token
+	fn *function
+}
+
+// checkRecursion returns an error for the first cycle in the call graph,
+// since neither GLSL nor WGSL allow recursion.
+func (c *compiler) checkRecursion(all []*function) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[*function]int{}
+	var path []*function
+	var visit func(fn *function) error
+	visit = func(fn *function) error {
+		state[fn] = visiting
+		path = append(path, fn)
+		for _, cl := range c.calls[fn] {
+			switch state[cl.fn] {
+			case visiting:
+				var chain []string
+				for i := len(path) - 1; i >= 0; i-- {
+					chain = append([]string{path[i].name}, chain...)
+					if path[i] == cl.fn {
+						break
+					}
+				}
+				return errorf(cl.t, "recursive call of %q is not allowed: %v -> %v", cl.fn.name, strings.Join(chain, " -> "), cl.fn.name)
+			case unvisited:
+				if err := visit(cl.fn); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[fn] = done
+		return nil
+	}
+	for _, fn := range all {
+		if state[fn] == unvisited {
+			if err := visit(fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
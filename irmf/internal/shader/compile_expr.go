@@ -0,0 +1,724 @@
+package shader
+
+import "fmt"
+
+// fail raises a runtime error at token t.
+func fail(t token, err error) {
+	panic(errorf(t, "%v", err))
+}
+
+func (c *compiler) expr(x expr) (evalFn, error) {
+	switch e := x.(type) {
+	case *litExpr:
+		v := e.v
+		return func(m *Machine, b int) Value { return v }, nil
+	case *identExpr:
+		lv, err := c.lvalue(e, false)
+		if err != nil {
+			return nil, err
+		}
+		return lv.get, nil
+	case *unaryExpr:
+		return c.unaryExpr(e)
+	case *postfixExpr:
+		return c.incDec(e.t, e.op, e.x, true)
+	case *binaryExpr:
+		return c.binaryExpr(e)
+	case *assignExpr:
+		return c.assignExpr(e)
+	case *condExpr:
+		cond, err := c.expr(e.cond)
+		if err != nil {
+			return nil, err
+		}
+		yes, err := c.expr(e.yes)
+		if err != nil {
+			return nil, err
+		}
+		no, err := c.expr(e.no)
+		if err != nil {
+			return nil, err
+		}
+		return func(m *Machine, b int) Value {
+			if v := cond(m, b); v.truth() {
+				return yes(m, b)
+			}
+			return no(m, b)
+		}, nil
+	case *callExpr:
+		return c.callExpr(e)
+	case *memberExpr:
+		base, err := c.expr(e.x)
+		if err != nil {
+			return nil, err
+		}
+		return c.member(e, base)
+	case *indexExpr:
+		base, err := c.expr(e.x)
+		if err != nil {
+			return nil, err
+		}
+		ix, err := c.expr(e.ix)
+		if err != nil {
+			return nil, err
+		}
+		t := e.t
+		return func(m *Machine, b int) Value {
+			v := base(m, b)
+			i := intIndex(ix(m, b))
+			out, err := index(v, i)
+			if err != nil {
+				fail(t, err)
+			}
+			return out
+		}, nil
+	case *seqExpr:
+		var fns []evalFn
+		for _, x := range e.exprs {
+			fn, err := c.expr(x)
+			if err != nil {
+				return nil, err
+			}
+			fns = append(fns, fn)
+		}
+		return func(m *Machine, b int) Value {
+			var v Value
+			for _, fn := range fns {
+				v = fn(m, b)
+			}
+			return v
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported expression %T", x)
+}
+
+// member compiles a swizzle or struct field access on base.
+func (c *compiler) member(e *memberExpr, base evalFn) (evalFn, error) {
+	name, t := e.name, e.t
+	swz, isSwizzle := swizzleIndices(name)
+	return func(m *Machine, b int) Value {
+		v := base(m, b)
+		if v.k == kStruct {
+			i, ok := v.st.index[name]
+			if !ok {
+				fail(t, fmt.Errorf("struct %v has no field %q", v.st.name, name))
+			}
+			return v.s[i]
+		}
+		if !isSwizzle || v.k >= kStruct || v.c > 1 {
+			fail(t, fmt.Errorf("invalid field selection %q on %v", name, v))
+		}
+		out := Value{k: v.k, r: uint8(len(swz)), c: 1}
+		for i, j := range swz {
+			if j >= int(v.r) {
+				fail(t, fmt.Errorf("swizzle %q out of range for vector of size %v", name, v.r))
+			}
+			out.v[i] = v.v[j]
+		}
+		return out
+	}, nil
+}
+
+// lvalue compiles an expression that can be read and written.
+// When write is true, the expression must be assignable.
+func (c *compiler) lvalue(x expr, write bool) (*lvalue, error) {
+	switch e := x.(type) {
+	case *identExpr:
+		if lv := c.lookupLocal(e.name); lv != nil {
+			if write && lv.konst {
+				return nil, errorf(e.t, "cannot assign to constant %q", e.name)
+			}
+			slot := lv.slot
+			return &lvalue{
+				get: func(m *Machine, b int) Value { return m.stack[b+slot] },
+				set: func(m *Machine, b int, v Value) { m.stack[b+slot] = v },
+			}, nil
+		}
+		if g, ok := c.globals[e.name]; ok {
+			if write && g.konst {
+				return nil, errorf(e.t, "cannot assign to constant %q", e.name)
+			}
+			slot := g.slot
+			return &lvalue{
+				get: func(m *Machine, b int) Value { return m.globals[slot] },
+				set: func(m *Machine, b int, v Value) { m.globals[slot] = v },
+			}, nil
+		}
+		return nil, errorf(e.t, "undeclared identifier %q", e.name)
+	case *memberExpr:
+		base, err := c.lvalue(e.x, write)
+		if err != nil {
+			return nil, err
+		}
+		get, err := c.member(e, base.get)
+		if err != nil {
+			return nil, err
+		}
+		name, t := e.name, e.t
+		swz, isSwizzle := swizzleIndices(name)
+		return &lvalue{
+			get: get,
+			set: func(m *Machine, b int, v Value) {
+				bv := base.get(m, b)
+				if bv.k == kStruct {
+					i, ok := bv.st.index[name]
+					if !ok {
+						fail(t, fmt.Errorf("struct %v has no field %q", bv.st.name, name))
+					}
+					s := make([]Value, len(bv.s))
+					copy(s, bv.s)
+					s[i] = v.clone()
+					bv.s = s
+					base.set(m, b, bv)
+					return
+				}
+				if !isSwizzle || bv.k >= kStruct || bv.c > 1 {
+					fail(t, fmt.Errorf("invalid field selection %q on %v", name, bv))
+				}
+				if v.isScalar() && len(swz) > 1 {
+					fail(t, fmt.Errorf("cannot assign scalar to swizzle %q", name))
+				}
+				if v.Len() != len(swz) {
+					fail(t, fmt.Errorf("cannot assign %v to swizzle %q", v, name))
+				}
+				v = v.convert(bv.k)
+				for i, j := range swz {
+					if j >= int(bv.r) {
+						fail(t, fmt.Errorf("swizzle %q out of range for vector of size %v", name, bv.r))
+					}
+					bv.v[j] = v.v[i]
+				}
+				base.set(m, b, bv)
+			},
+		}, nil
+	case *indexExpr:
+		base, err := c.lvalue(e.x, write)
+		if err != nil {
+			return nil, err
+		}
+		ix, err := c.expr(e.ix)
+		if err != nil {
+			return nil, err
+		}
+		t := e.t
+		return &lvalue{
+			get: func(m *Machine, b int) Value {
+				out, err := index(base.get(m, b), intIndex(ix(m, b)))
+				if err != nil {
+					fail(t, err)
+				}
+				return out
+			},
+			set: func(m *Machine, b int, v Value) {
+				i := intIndex(ix(m, b))
+				out, err := setIndex(base.get(m, b), i, v)
+				if err != nil {
+					fail(t, err)
+				}
+				base.set(m, b, out)
+			},
+		}, nil
+	}
+	if write {
+		return nil, errorf(x.tok(), "expression is not assignable")
+	}
+	get, err := c.expr(x)
+	if err != nil {
+		return nil, err
+	}
+	return &lvalue{get: get}, nil
+}
+
+func (c *compiler) unaryExpr(e *unaryExpr) (evalFn, error) {
+	if e.op == "++" || e.op == "--" {
+		return c.incDec(e.t, e.op, e.x, false)
+	}
+	x, err := c.expr(e.x)
+	if err != nil {
+		return nil, err
+	}
+	t := e.t
+	var op func(Value) (Value, error)
+	switch e.op {
+	case "+":
+		return x, nil
+	case "-":
+		op = negate
+	case "!":
+		op = not
+	case "~":
+		op = complement
+	}
+	return func(m *Machine, b int) Value {
+		v, err := op(x(m, b))
+		if err != nil {
+			fail(t, err)
+		}
+		return v
+	}, nil
+}
+
+// incDec compiles "++x", "--x", "x++" and "x--".
+func (c *compiler) incDec(t token, op string, x expr, post bool) (evalFn, error) {
+	lv, err := c.lvalue(x, true)
+	if err != nil {
+		return nil, err
+	}
+	code := opAdd
+	if op == "--" {
+		code = opSub
+	}
+	return func(m *Machine, b int) Value {
+		old := lv.get(m, b)
+		v, err := binop(code, old, Int(1), false)
+		if err != nil {
+			fail(t, err)
+		}
+		v = v.convert(old.k)
+		lv.set(m, b, v)
+		if post {
+			return old
+		}
+		return v
+	}, nil
+}
+
+func (c *compiler) binaryExpr(e *binaryExpr) (evalFn, error) {
+	x, err := c.expr(e.x)
+	if err != nil {
+		return nil, err
+	}
+	y, err := c.expr(e.y)
+	if err != nil {
+		return nil, err
+	}
+	t := e.t
+	switch e.op {
+	case "&&":
+		return func(m *Machine, b int) Value {
+			if v := x(m, b); !v.truth() {
+				return boolValue(false)
+			}
+			v := y(m, b)
+			return boolValue(v.truth())
+		}, nil
+	case "||":
+		return func(m *Machine, b int) Value {
+			if v := x(m, b); v.truth() {
+				return boolValue(true)
+			}
+			v := y(m, b)
+			return boolValue(v.truth())
+		}, nil
+	case "^^":
+		return func(m *Machine, b int) Value {
+			xv, yv := x(m, b), y(m, b)
+			return boolValue(xv.truth() != yv.truth())
+		}, nil
+	}
+	op, ok := opcodes[e.op]
+	if !ok {
+		return nil, errorf(t, "unsupported operator %q", e.op)
+	}
+	vecCompare := c.wgsl
+	return func(m *Machine, b int) Value {
+		xv := x(m, b)
+		yv := y(m, b)
+		v, err := binop(op, xv, yv, vecCompare)
+		if err != nil {
+			fail(t, err)
+		}
+		return v
+	}, nil
+}
+
+func (c *compiler) assignExpr(e *assignExpr) (evalFn, error) {
+	lv, err := c.lvalue(e.lhs, true)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := c.expr(e.rhs)
+	if err != nil {
+		return nil, err
+	}
+	t := e.t
+	if e.op == "=" {
+		return func(m *Machine, b int) Value {
+			v := rhs(m, b)
+			if v.k < kStruct {
+				if old := lv.get(m, b); old.k < kStruct && old.k != v.k {
+					v = v.convert(old.k)
+				}
+			}
+			lv.set(m, b, v.clone())
+			return v
+		}, nil
+	}
+	op, ok := opcodes[e.op[:len(e.op)-1]]
+	if !ok {
+		return nil, errorf(t, "unsupported operator %q", e.op)
+	}
+	vecCompare := c.wgsl
+	return func(m *Machine, b int) Value {
+		y := rhs(m, b)
+		old := lv.get(m, b)
+		v, err := binop(op, old, y, vecCompare)
+		if err != nil {
+			fail(t, err)
+		}
+		v = v.convert(old.k)
+		lv.set(m, b, v)
+		return v
+	}, nil
+}
+
+func (c *compiler) args(args []expr) ([]evalFn, error) {
+	var fns []evalFn
+	for _, a := range args {
+		fn, err := c.expr(a)
+		if err != nil {
+			return nil, err
+		}
+		fns = append(fns, fn)
+	}
+	return fns, nil
+}
+
+// maxArgs is the largest number of constructor arguments evaluated
+// without allocating.
+const maxArgs = 16
+
+func (c *compiler) callExpr(e *callExpr) (evalFn, error) {
+	t := e.t
+
+	if e.name == "length()" {
+		x, err := c.expr(e.args[0])
+		if err != nil {
+			return nil, err
+		}
+		return func(m *Machine, b int) Value {
+			v := x(m, b)
+			if v.k == kArray {
+				return Int(int32(len(v.s)))
+			}
+			return Int(int32(v.r))
+		}, nil
+	}
+
+	// Explicitly-typed constructors and bitcasts.
+	if e.typ != nil {
+		if err := c.resolveType(e.typ, t); err != nil {
+			return nil, err
+		}
+		if e.name == "bitcast" {
+			return c.bitcast(e)
+		}
+		return c.construct(e.typ, e.args, t)
+	}
+
+	if fns, ok := c.funcs[e.name]; ok {
+		return c.userCall(e, fns)
+	}
+	if st, ok := c.structs[e.name]; ok {
+		return c.construct(&typeSpec{k: kStruct, structName: st.name, st: st}, e.args, t)
+	}
+	types := glslTypes
+	if c.wgsl {
+		types = wgslTypes
+	}
+	if typ, ok := types[e.name]; ok && typ.k != kVoid {
+		return c.construct(typ, e.args, t)
+	}
+	if bi, ok := builtins[e.name]; ok {
+		return c.builtinCall(e, bi)
+	}
+	return nil, errorf(t, "undefined function %q", e.name)
+}
+
+func (c *compiler) construct(typ *typeSpec, args []expr, t token) (evalFn, error) {
+	fns, err := c.args(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(fns) > maxArgs {
+		return nil, errorf(t, "too many constructor arguments")
+	}
+	return func(m *Machine, b int) Value {
+		var buf [maxArgs]Value
+		for i, fn := range fns {
+			buf[i] = fn(m, b)
+		}
+		v, err := construct(typ, buf[:len(fns)])
+		if err != nil {
+			fail(t, err)
+		}
+		return v
+	}, nil
+}
+
+func (c *compiler) bitcast(e *callExpr) (evalFn, error) {
+	if len(e.args) != 1 {
+		return nil, errorf(e.t, "bitcast requires exactly one argument")
+	}
+	x, err := c.expr(e.args[0])
+	if err != nil {
+		return nil, err
+	}
+	k := e.typ.k
+	return func(m *Machine, b int) Value {
+		v := x(m, b)
+		v.k = k
+		return v
+	}, nil
+}
+
+func (c *compiler) builtinCall(e *callExpr, bi *builtin) (evalFn, error) {
+	fns, err := c.args(e.args)
+	if err != nil {
+		return nil, err
+	}
+	return c.builtinFn(e.t, e.name, bi, fns)
+}
+
+func (c *compiler) builtinFn(t token, name string, bi *builtin, fns []evalFn) (evalFn, error) {
+	wrap := func(v Value, err error) Value {
+		if err != nil {
+			fail(t, fmt.Errorf("%v: %v", name, err))
+		}
+		return v
+	}
+	switch {
+	case len(fns) == 1 && bi.f1 != nil:
+		x := fns[0]
+		f := bi.f1
+		return func(m *Machine, b int) Value { return wrap(f(x(m, b))) }, nil
+	case len(fns) == 2 && bi.f2 != nil:
+		x, y := fns[0], fns[1]
+		f := bi.f2
+		return func(m *Machine, b int) Value {
+			xv := x(m, b)
+			yv := y(m, b)
+			return wrap(f(xv, yv))
+		}, nil
+	case len(fns) == 3 && bi.f3 != nil:
+		x, y, z := fns[0], fns[1], fns[2]
+		f := bi.f3
+		return func(m *Machine, b int) Value {
+			xv := x(m, b)
+			yv := y(m, b)
+			zv := z(m, b)
+			return wrap(f(xv, yv, zv))
+		}, nil
+	}
+	return nil, errorf(t, "wrong number of arguments (%v) to %v", len(fns), name)
+}
+
+// userCall compiles a call to a user-defined (possibly overloaded) function.
+func (c *compiler) userCall(e *callExpr, fns []*function) (evalFn, error) {
+	t := e.t
+	var cands []*function
+	for _, fn := range fns {
+		if len(fn.params) == len(e.args) {
+			cands = append(cands, fn)
+		}
+	}
+
+	// Fall back to a built-in function of the same name if no user-defined
+	// overload matches at run time.
+	var fallback evalFn
+	if bi, ok := builtins[e.name]; ok {
+		args, err := c.args(e.args)
+		if err != nil {
+			return nil, err
+		}
+		if fb, err := c.builtinFn(t, e.name, bi, args); err == nil {
+			fallback = fb
+		}
+	}
+	if len(cands) == 0 {
+		if fallback != nil {
+			return fallback, nil
+		}
+		return nil, errorf(t, "no overload of %q takes %v arguments", e.name, len(e.args))
+	}
+
+	c.addCall(e, cands)
+
+	args, err := c.args(e.args)
+	if err != nil {
+		return nil, err
+	}
+	// "out" and "inout" arguments must be assignable.
+	outs := make([]*lvalue, len(e.args))
+	for i, a := range e.args {
+		for _, fn := range cands {
+			if fn.params[i].out {
+				if outs[i], err = c.lvalue(a, true); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	}
+
+	return func(m *Machine, b int) Value {
+		// Reserve room for the largest candidate's frame before evaluating
+		// arguments so that nested calls do not overwrite them.
+		n := 0
+		for _, fn := range cands {
+			if fn.nlocals > n {
+				n = fn.nlocals
+			}
+		}
+		nb := m.push(n)
+		for i, a := range args {
+			v := a(m, b)
+			m.stack[nb+i] = v
+		}
+		fn := pickOverload(cands, m.stack[nb:nb+len(args)])
+		if fn == nil {
+			m.sp = nb
+			if fallback != nil {
+				return fallback(m, b)
+			}
+			fail(t, fmt.Errorf("no overload of %q matches the argument types", e.name))
+		}
+		return m.invoke(fn, nb, b, outs, t)
+	}, nil
+}
+
+// pickOverload returns the best candidate for the argument values.
+func pickOverload(cands []*function, args []Value) *function {
+	if len(cands) == 1 {
+		if matchScore(cands[0].params, args) < 0 {
+			return nil
+		}
+		return cands[0]
+	}
+	var best *function
+	bestScore := -1
+	for _, fn := range cands {
+		s := matchScore(fn.params, args)
+		if s >= 0 && (best == nil || s < bestScore) {
+			best, bestScore = fn, s
+		}
+	}
+	return best
+}
+
+// matchScore returns 0 for an exact match, a positive number if
+// implicit conversions are needed and -1 if the arguments don't match.
+func matchScore(params []*param, args []Value) int {
+	score := 0
+	for i, p := range params {
+		a := &args[i]
+		t := p.typ
+		switch t.k {
+		case kStruct:
+			if a.k != kStruct || a.st != t.st {
+				return -1
+			}
+		case kArray:
+			if a.k != kArray || (t.length > 0 && len(a.s) != t.length) {
+				return -1
+			}
+		default:
+			if a.k >= kStruct || a.r != t.r || a.c != t.c {
+				return -1
+			}
+			if a.k != t.k {
+				if t.k == kBool || a.k == kBool {
+					return -1
+				}
+				score++
+			}
+		}
+	}
+	return score
+}
+
+// addCall adds the call e from the function being compiled to the call
+// graph if it resolves to a single one of the overloads cands. Overloads
+// are otherwise picked at run time (see pickOverload).
+func (c *compiler) addCall(e *callExpr, cands []*function) {
+	if c.fn.decl == nil { // a global initializer
+		return
+	}
+	if len(cands) > 1 {
+		var matches []*function
+		for _, fn := range cands {
+			if c.mayMatch(fn.params, e.args) {
+				matches = append(matches, fn)
+			}
+		}
+		cands = matches
+	}
+	if len(cands) == 1 {
+		c.calls[c.fn] = append(c.calls[c.fn], call{t: e.t, fn: cands[0]})
+	}
+}
+
+// mayMatch reports whether the arguments whose types are known at
+// compile time match the params.
+func (c *compiler) mayMatch(params []*param, args []expr) bool {
+	for i, a := range args {
+		at, pt := c.staticType(a), params[i].typ
+		switch {
+		case at == nil:
+		case at.k < kStruct && pt.k < kStruct:
+			if at.r != pt.r || at.c != pt.c {
+				return false
+			}
+		case at.k == kStruct || pt.k == kStruct:
+			if at.st == nil || at.st != pt.st {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// staticType returns the type of x if it is known at compile time
+// (as for variables, literals, constructors and swizzles), or nil.
+func (c *compiler) staticType(x expr) *typeSpec {
+	switch e := x.(type) {
+	case *litExpr:
+		return &typeSpec{k: e.v.k, r: e.v.r, c: e.v.c}
+	case *identExpr:
+		if lv := c.lookupLocal(e.name); lv != nil {
+			return lv.typ
+		}
+		if g, ok := c.globals[e.name]; ok {
+			return g.typ
+		}
+	case *callExpr:
+		if e.typ != nil {
+			return e.typ
+		}
+		if st, ok := c.structs[e.name]; ok {
+			return &typeSpec{k: kStruct, structName: st.name, st: st}
+		}
+		types := glslTypes
+		if c.wgsl {
+			types = wgslTypes
+		}
+		if typ, ok := types[e.name]; ok && typ.k != kVoid {
+			return typ
+		}
+	case *memberExpr:
+		base := c.staticType(e.x)
+		if base == nil {
+			return nil
+		}
+		if base.k == kStruct && base.st != nil {
+			if i, ok := base.st.index[e.name]; ok {
+				return base.st.fields[i].typ
+			}
+			return nil
+		}
+		if swz, ok := swizzleIndices(e.name); ok && base.k < kStruct && base.c == 1 {
+			return &typeSpec{k: base.k, r: uint8(len(swz)), c: 1}
+		}
+	}
+	return nil
+}
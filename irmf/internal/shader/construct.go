@@ -0,0 +1,130 @@
+package shader
+
+import "fmt"
+
+// construct builds a value of type typ from the constructor arguments.
+func construct(typ *typeSpec, args []Value) (Value, error) {
+	switch typ.k {
+	case kStruct:
+		st := typ.st
+		if len(args) != len(st.fields) {
+			return Value{}, fmt.Errorf("struct %v has %v fields, got %v arguments", st.name, len(st.fields), len(args))
+		}
+		v := Value{k: kStruct, st: st, s: make([]Value, len(args))}
+		for i, a := range args {
+			e, err := convertTo(a, st.fields[i].typ)
+			if err != nil {
+				return Value{}, fmt.Errorf("field %v: %v", st.fields[i].name, err)
+			}
+			v.s[i] = e.clone()
+		}
+		return v, nil
+	case kArray:
+		if typ.length > 0 && len(args) != typ.length {
+			return Value{}, fmt.Errorf("array of length %v constructed with %v elements", typ.length, len(args))
+		}
+		v := Value{k: kArray, s: make([]Value, len(args))}
+		for i, a := range args {
+			e, err := convertTo(a, typ.elem)
+			if err != nil {
+				return Value{}, fmt.Errorf("element %v: %v", i, err)
+			}
+			v.s[i] = e.clone()
+		}
+		return v, nil
+	case kVoid:
+		return Value{}, fmt.Errorf("cannot construct void")
+	}
+
+	k := typ.k
+	if k == kInfer {
+		k = kFloat
+		if len(args) > 0 {
+			k = args[0].k
+			for _, a := range args[1:] {
+				if a.k < kStruct && a.k != k {
+					k = promote(k, a.k)
+				}
+			}
+		}
+	}
+	for _, a := range args {
+		if a.k >= kStruct {
+			return Value{}, fmt.Errorf("cannot construct %v from %v", typ, a.k)
+		}
+	}
+
+	out := Value{k: k, r: typ.r, c: typ.c}
+	n := out.Len()
+	switch {
+	case len(args) == 0:
+		return out, nil
+	case len(args) == 1 && args[0].isScalar():
+		// A scalar splats across a vector or fills a matrix diagonal.
+		a := args[0]
+		x := a.num(0)
+		if out.isMatrix() {
+			for i := 0; i < int(out.c) && i < int(out.r); i++ {
+				out.setNum(i*int(out.r)+i, x)
+			}
+			return out, nil
+		}
+		for i := 0; i < n; i++ {
+			out.setNum(i, x)
+		}
+		return out, nil
+	case len(args) == 1 && out.isMatrix() && args[0].isMatrix():
+		// A matrix from a matrix copies the overlap and fills the
+		// remainder from the identity matrix.
+		a := args[0]
+		for j := 0; j < int(out.c); j++ {
+			for i := 0; i < int(out.r); i++ {
+				switch {
+				case j < int(a.c) && i < int(a.r):
+					out.setNum(j*int(out.r)+i, a.num(j*int(a.r)+i))
+				case i == j:
+					out.setNum(j*int(out.r)+i, 1)
+				}
+			}
+		}
+		return out, nil
+	}
+
+	i := 0
+	for _, a := range args {
+		for j := 0; j < a.Len(); j++ {
+			if i >= n {
+				if len(args) == 1 {
+					break // e.g. vec3(v4) drops trailing components
+				}
+				return Value{}, fmt.Errorf("too many arguments to %v constructor", typ)
+			}
+			out.setNum(i, a.num(j))
+			i++
+		}
+	}
+	if i < n {
+		return Value{}, fmt.Errorf("not enough arguments to %v constructor", typ)
+	}
+	return out, nil
+}
+
+// convertTo converts a constructor argument to the type typ.
+func convertTo(v Value, typ *typeSpec) (Value, error) {
+	switch typ.k {
+	case kStruct:
+		if v.k != kStruct || v.st != typ.st {
+			return Value{}, fmt.Errorf("cannot use %v as struct %v", v.k, typ.structName)
+		}
+		return v, nil
+	case kArray:
+		if v.k != kArray || (typ.length > 0 && len(v.s) != typ.length) {
+			return Value{}, fmt.Errorf("cannot use %v as %v", v, typ)
+		}
+		return v, nil
+	}
+	if v.k >= kStruct || v.r != typ.r || v.c != typ.c {
+		return Value{}, fmt.Errorf("cannot use %v as %v", v, typ)
+	}
+	return v.convert(typ.k), nil
+}
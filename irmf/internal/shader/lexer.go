@@ -0,0 +1,184 @@
+package shader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokKind represents the kind of a lexical token.
+type tokKind uint8
+
+const (
+	tEOF tokKind = iota
+	tIdent
+	tInt
+	tFloat
+	tPunct
+)
+
+// token represents a single lexical token.
+type token struct {
+	kind tokKind
+	text string
+	line int
+	col  int
+}
+
+func (t token) String() string {
+	if t.kind == tEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// Error represents a shader compile or runtime error.
+// Line and Col are 1-based positions in the shader source.
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("line %v, col %v: %v", e.Line, e.Col, e.Msg)
+}
+
+func errorf(t token, format string, args ...interface{}) *Error {
+	return &Error{Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// puncts lists the multi-character punctuators, longest first.
+var puncts = []string{
+	"<<=", ">>=",
+	"++", "--", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=",
+	"==", "!=", "<=", ">=", "&&", "||", "^^", "<<", ">>", "->", "::",
+}
+
+// lexer splits shader source into tokens. Comments are skipped.
+// The inComment state is kept across calls to tokenizeLine so that
+// the GLSL preprocessor can work one line at a time.
+type lexer struct {
+	inComment bool
+}
+
+// tokenize returns all tokens in src.
+func tokenize(src string) ([]token, error) {
+	var lx lexer
+	var toks []token
+	for i, line := range strings.Split(src, "\n") {
+		lt, err := lx.tokenizeLine(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, lt...)
+	}
+	return toks, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentChar(c byte) bool { return isIdentStart(c) || isDigit(c) }
+
+// tokenizeLine returns the tokens on a single source line.
+func (lx *lexer) tokenizeLine(line string, lineNum int) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(line) {
+		if lx.inComment {
+			end := strings.Index(line[i:], "*/")
+			if end < 0 {
+				return toks, nil
+			}
+			i += end + 2
+			lx.inComment = false
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(line[i:], "//"):
+			return toks, nil
+		case strings.HasPrefix(line[i:], "/*"):
+			lx.inComment = true
+			i += 2
+		case isIdentStart(c):
+			start := i
+			for i < len(line) && isIdentChar(line[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tIdent, text: line[start:i], line: lineNum, col: start + 1})
+		case isDigit(c) || (c == '.' && i+1 < len(line) && isDigit(line[i+1])):
+			start := i
+			kind := tInt
+			if strings.HasPrefix(line[i:], "0x") || strings.HasPrefix(line[i:], "0X") {
+				i += 2
+				for i < len(line) && strings.IndexByte("0123456789abcdefABCDEF", line[i]) >= 0 {
+					i++
+				}
+			} else {
+				for i < len(line) && isDigit(line[i]) {
+					i++
+				}
+				if i < len(line) && line[i] == '.' {
+					kind = tFloat
+					i++
+					for i < len(line) && isDigit(line[i]) {
+						i++
+					}
+				}
+				if i < len(line) && (line[i] == 'e' || line[i] == 'E') {
+					j := i + 1
+					if j < len(line) && (line[j] == '+' || line[j] == '-') {
+						j++
+					}
+					if j < len(line) && isDigit(line[j]) {
+						kind = tFloat
+						i = j
+						for i < len(line) && isDigit(line[i]) {
+							i++
+						}
+					}
+				}
+			}
+			// Suffixes: u, i (WGSL), f, h (WGSL), lf (GLSL).
+			if i < len(line) {
+				switch line[i] {
+				case 'u', 'U', 'i':
+					i++
+				case 'f', 'F', 'h':
+					kind = tFloat
+					i++
+				case 'l', 'L':
+					if i+1 < len(line) && (line[i+1] == 'f' || line[i+1] == 'F') {
+						kind = tFloat
+						i += 2
+					}
+				}
+			}
+			toks = append(toks, token{kind: kind, text: line[start:i], line: lineNum, col: start + 1})
+		default:
+			text := string(c)
+			for _, p := range puncts {
+				if strings.HasPrefix(line[i:], p) {
+					text = p
+					break
+				}
+			}
+			if strings.IndexByte("+-*/%=<>!&|^~?:;,.()[]{}@#", c) < 0 {
+				return nil, &Error{Line: lineNum, Col: i + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			toks = append(toks, token{kind: tPunct, text: text, line: lineNum, col: i + 1})
+			i += len(text)
+		}
+	}
+	return toks, nil
+}
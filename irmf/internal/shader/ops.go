@@ -0,0 +1,426 @@
+package shader
+
+import (
+	"fmt"
+	"math"
+)
+
+// opcode represents a binary operator.
+type opcode uint8
+
+const (
+	opAdd opcode = iota
+	opSub
+	opMul
+	opDiv
+	opMod
+	opAnd
+	opOr
+	opXor
+	opShl
+	opShr
+	opLt
+	opGt
+	opLe
+	opGe
+	opEq
+	opNe
+)
+
+var opcodes = map[string]opcode{
+	"+": opAdd, "-": opSub, "*": opMul, "/": opDiv, "%": opMod,
+	"&": opAnd, "|": opOr, "^": opXor, "<<": opShl, ">>": opShr,
+	"<": opLt, ">": opGt, "<=": opLe, ">=": opGe, "==": opEq, "!=": opNe,
+}
+
+// broadcast determines the result shape of a component-wise operation.
+// It returns false if the shapes are incompatible.
+func broadcast(a, b *Value) (r, c uint8, ok bool) {
+	switch {
+	case sameShape(a, b):
+		return a.r, a.c, true
+	case a.isScalar():
+		return b.r, b.c, true
+	case b.isScalar():
+		return a.r, a.c, true
+	}
+	return 0, 0, false
+}
+
+// binop applies the binary operator op. vecCompare selects WGSL
+// semantics where comparing vectors yields a vector of bools.
+func binop(op opcode, a, b Value, vecCompare bool) (Value, error) {
+	if a.k >= kStruct || b.k >= kStruct {
+		return Value{}, fmt.Errorf("invalid operands %v and %v", a.k, b.k)
+	}
+	if op >= opLt {
+		return compare(op, a, b, vecCompare)
+	}
+	if op == opMul && !a.isScalar() && !b.isScalar() && (a.isMatrix() || b.isMatrix()) {
+		return matMul(a, b)
+	}
+
+	k := promote(a.k, b.k)
+	if op == opShl || op == opShr {
+		k = a.k // the shift amount does not change the result type
+	}
+	a, b = a.convert(k), b.convert(k)
+	r, c, ok := broadcast(&a, &b)
+	if !ok {
+		return Value{}, fmt.Errorf("mismatched operand shapes %vx%v and %vx%v", a.c, a.r, b.c, b.r)
+	}
+	out := Value{k: k, r: r, c: c}
+	n := out.Len()
+	ai, bi := 1, 1
+	if a.isScalar() {
+		ai = 0
+	}
+	if b.isScalar() {
+		bi = 0
+	}
+
+	switch k {
+	case kFloat:
+		for i := 0; i < n; i++ {
+			x, y := a.f(i*ai), b.f(i*bi)
+			var z float32
+			switch op {
+			case opAdd:
+				z = x + y
+			case opSub:
+				z = x - y
+			case opMul:
+				z = x * y
+			case opDiv:
+				z = x / y
+			case opMod:
+				z = float32(math.Mod(float64(x), float64(y)))
+			default:
+				return Value{}, fmt.Errorf("invalid operator for float operands")
+			}
+			out.setF(i, z)
+		}
+	case kInt:
+		for i := 0; i < n; i++ {
+			x, y := int32(a.v[i*ai]), int32(b.v[i*bi])
+			var z int32
+			switch op {
+			case opAdd:
+				z = x + y
+			case opSub:
+				z = x - y
+			case opMul:
+				z = x * y
+			case opDiv:
+				if y != 0 {
+					z = x / y
+				}
+			case opMod:
+				if y != 0 {
+					z = x % y
+				}
+			case opAnd:
+				z = x & y
+			case opOr:
+				z = x | y
+			case opXor:
+				z = x ^ y
+			case opShl:
+				z = x << (uint32(y) & 31)
+			case opShr:
+				z = x >> (uint32(y) & 31)
+			}
+			out.v[i] = uint32(z)
+		}
+	case kUint:
+		for i := 0; i < n; i++ {
+			x, y := a.v[i*ai], b.v[i*bi]
+			var z uint32
+			switch op {
+			case opAdd:
+				z = x + y
+			case opSub:
+				z = x - y
+			case opMul:
+				z = x * y
+			case opDiv:
+				if y != 0 {
+					z = x / y
+				}
+			case opMod:
+				if y != 0 {
+					z = x % y
+				}
+			case opAnd:
+				z = x & y
+			case opOr:
+				z = x | y
+			case opXor:
+				z = x ^ y
+			case opShl:
+				z = x << (y & 31)
+			case opShr:
+				z = x >> (y & 31)
+			}
+			out.v[i] = z
+		}
+	case kBool:
+		for i := 0; i < n; i++ {
+			x, y := a.v[i*ai] != 0, b.v[i*bi] != 0
+			var z bool
+			switch op {
+			case opAnd:
+				z = x && y
+			case opOr:
+				z = x || y
+			case opXor:
+				z = x != y
+			default:
+				return Value{}, fmt.Errorf("invalid operator for bool operands")
+			}
+			if z {
+				out.v[i] = 1
+			}
+		}
+	}
+	return out, nil
+}
+
+func compare(op opcode, a, b Value, vecCompare bool) (Value, error) {
+	k := promote(a.k, b.k)
+	a, b = a.convert(k), b.convert(k)
+	r, c, ok := broadcast(&a, &b)
+	if !ok {
+		return Value{}, fmt.Errorf("mismatched operand shapes %vx%v and %vx%v", a.c, a.r, b.c, b.r)
+	}
+	n := int(r) * int(c)
+	if n > 1 && !vecCompare && op != opEq && op != opNe {
+		return Value{}, fmt.Errorf("relational operators require scalar operands")
+	}
+	ai, bi := 1, 1
+	if a.isScalar() {
+		ai = 0
+	}
+	if b.isScalar() {
+		bi = 0
+	}
+
+	out := Value{k: kBool, r: r, c: c}
+	all := true
+	for i := 0; i < n; i++ {
+		x, y := a.num(i*ai), b.num(i*bi)
+		var z bool
+		switch op {
+		case opLt:
+			z = x < y
+		case opGt:
+			z = x > y
+		case opLe:
+			z = x <= y
+		case opGe:
+			z = x >= y
+		case opEq:
+			z = x == y
+		case opNe:
+			z = x != y
+		}
+		if z {
+			out.v[i] = 1
+		}
+		if (op == opNe && z) || (op != opNe && !z) {
+			all = false
+		}
+	}
+	if vecCompare || n == 1 {
+		return out, nil
+	}
+	if op == opNe {
+		// Vectors differ if any component differs.
+		return boolValue(!all), nil
+	}
+	return boolValue(all), nil
+}
+
+// matMul implements matrix*matrix, matrix*vector and vector*matrix.
+func matMul(a, b Value) (Value, error) {
+	a, b = a.convert(kFloat), b.convert(kFloat)
+	switch {
+	case a.isMatrix() && b.isMatrix():
+		if a.c != b.r {
+			return Value{}, fmt.Errorf("cannot multiply mat%vx%v by mat%vx%v", a.c, a.r, b.c, b.r)
+		}
+		out := Value{k: kFloat, r: a.r, c: b.c}
+		for j := 0; j < int(b.c); j++ {
+			for i := 0; i < int(a.r); i++ {
+				var sum float32
+				for k := 0; k < int(a.c); k++ {
+					sum += a.f(k*int(a.r)+i) * b.f(j*int(b.r)+k)
+				}
+				out.setF(j*int(a.r)+i, sum)
+			}
+		}
+		return out, nil
+	case a.isMatrix():
+		if a.c != b.r {
+			return Value{}, fmt.Errorf("cannot multiply mat%vx%v by vec%v", a.c, a.r, b.r)
+		}
+		out := Value{k: kFloat, r: a.r, c: 1}
+		for i := 0; i < int(a.r); i++ {
+			var sum float32
+			for k := 0; k < int(a.c); k++ {
+				sum += a.f(k*int(a.r)+i) * b.f(k)
+			}
+			out.setF(i, sum)
+		}
+		return out, nil
+	}
+	if a.r != b.r {
+		return Value{}, fmt.Errorf("cannot multiply vec%v by mat%vx%v", a.r, b.c, b.r)
+	}
+	out := Value{k: kFloat, r: b.c, c: 1}
+	for j := 0; j < int(b.c); j++ {
+		var sum float32
+		for k := 0; k < int(b.r); k++ {
+			sum += a.f(k) * b.f(j*int(b.r)+k)
+		}
+		out.setF(j, sum)
+	}
+	return out, nil
+}
+
+// negate returns -v.
+func negate(v Value) (Value, error) {
+	out := v
+	switch v.k {
+	case kFloat:
+		for i := 0; i < v.Len(); i++ {
+			out.setF(i, -v.f(i))
+		}
+	case kInt, kUint:
+		for i := 0; i < v.Len(); i++ {
+			out.v[i] = uint32(-int32(v.v[i]))
+		}
+	default:
+		return Value{}, fmt.Errorf("cannot negate %v", v.k)
+	}
+	return out, nil
+}
+
+// not returns !v for bool scalars and vectors.
+func not(v Value) (Value, error) {
+	if v.k != kBool {
+		return Value{}, fmt.Errorf("operator ! requires bool operand, found %v", v.k)
+	}
+	out := v
+	for i := 0; i < v.Len(); i++ {
+		out.v[i] = 1 - v.v[i]
+	}
+	return out, nil
+}
+
+// complement returns ~v for integer scalars and vectors.
+func complement(v Value) (Value, error) {
+	if v.k != kInt && v.k != kUint {
+		return Value{}, fmt.Errorf("operator ~ requires integer operand, found %v", v.k)
+	}
+	out := v
+	for i := 0; i < v.Len(); i++ {
+		out.v[i] = ^v.v[i]
+	}
+	return out, nil
+}
+
+// swizzleIndices parses a swizzle such as "xyz" or "rgba".
+func swizzleIndices(name string) ([]int, bool) {
+	if len(name) == 0 || len(name) > 4 {
+		return nil, false
+	}
+	sets := []string{"xyzw", "rgba", "stpq"}
+	for _, set := range sets {
+		var idx []int
+		for i := 0; i < len(name); i++ {
+			j := -1
+			for k := 0; k < 4; k++ {
+				if set[k] == name[i] {
+					j = k
+				}
+			}
+			if j < 0 {
+				idx = nil
+				break
+			}
+			idx = append(idx, j)
+		}
+		if idx != nil {
+			return idx, true
+		}
+	}
+	return nil, false
+}
+
+// index returns v[i].
+func index(v Value, i int) (Value, error) {
+	switch {
+	case v.k == kArray:
+		if i < 0 || i >= len(v.s) {
+			return Value{}, fmt.Errorf("array index %v out of range [0,%v)", i, len(v.s))
+		}
+		return v.s[i], nil
+	case v.isMatrix():
+		if i < 0 || i >= int(v.c) {
+			return Value{}, fmt.Errorf("matrix column %v out of range [0,%v)", i, v.c)
+		}
+		out := Value{k: v.k, r: v.r, c: 1}
+		copy(out.v[:v.r], v.v[i*int(v.r):])
+		return out, nil
+	case v.k < kStruct:
+		if i < 0 || i >= int(v.r) {
+			return Value{}, fmt.Errorf("vector index %v out of range [0,%v)", i, v.r)
+		}
+		out := Value{k: v.k, r: 1, c: 1}
+		out.v[0] = v.v[i]
+		return out, nil
+	}
+	return Value{}, fmt.Errorf("cannot index %v", v.k)
+}
+
+// setIndex returns a copy of v with v[i] = x.
+func setIndex(v Value, i int, x Value) (Value, error) {
+	switch {
+	case v.k == kArray:
+		if i < 0 || i >= len(v.s) {
+			return Value{}, fmt.Errorf("array index %v out of range [0,%v)", i, len(v.s))
+		}
+		s := make([]Value, len(v.s))
+		copy(s, v.s)
+		s[i] = x.clone()
+		v.s = s
+		return v, nil
+	case v.isMatrix():
+		if i < 0 || i >= int(v.c) {
+			return Value{}, fmt.Errorf("matrix column %v out of range [0,%v)", i, v.c)
+		}
+		if x.r != v.r || x.c != 1 {
+			return Value{}, fmt.Errorf("cannot assign %v to matrix column", x)
+		}
+		x = x.convert(v.k)
+		copy(v.v[i*int(v.r):], x.v[:x.r])
+		return v, nil
+	case v.k < kStruct:
+		if i < 0 || i >= int(v.r) {
+			return Value{}, fmt.Errorf("vector index %v out of range [0,%v)", i, v.r)
+		}
+		if !x.isScalar() {
+			return Value{}, fmt.Errorf("cannot assign %v to vector component", x)
+		}
+		x = x.convert(v.k)
+		v.v[i] = x.v[0]
+		return v, nil
+	}
+	return Value{}, fmt.Errorf("cannot index %v", v.k)
+}
+
+// intIndex converts an index value to an int.
+func intIndex(v Value) int {
+	return int(toInt32(v.num(0)))
+}
@@ -0,0 +1,513 @@
+package shader
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parser builds an AST from GLSL or WGSL tokens.
+type parser struct {
+	toks []token
+	pos  int
+	wgsl bool
+	f    *file
+
+	// inTemplate is set while parsing a WGSL template argument,
+	// where ">" ends the template list instead of being an operator.
+	inTemplate bool
+
+	// structs lists known struct (and WGSL alias) names.
+	structs map[string]bool
+	aliases map[string]*typeSpec
+}
+
+func newParser(toks []token, wgsl bool) *parser {
+	return &parser{
+		toks:    toks,
+		wgsl:    wgsl,
+		f:       &file{structTok: map[string]token{}},
+		structs: map[string]bool{},
+		aliases: map[string]*typeSpec{},
+	}
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	t := token{kind: tEOF}
+	if len(p.toks) > 0 {
+		last := p.toks[len(p.toks)-1]
+		t.line, t.col = last.line, last.col+len(last.text)
+	}
+	return t
+}
+
+func (p *parser) peekN(n int) token {
+	if p.pos+n < len(p.toks) {
+		return p.toks[p.pos+n]
+	}
+	return token{kind: tEOF}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return t.kind != tEOF && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) (token, error) {
+	if text == ">" {
+		p.splitGreater()
+	}
+	t := p.peek()
+	if t.kind == tEOF || t.text != text {
+		return t, errorf(t, "expected %q, found %v", text, t)
+	}
+	p.pos++
+	return t, nil
+}
+
+// splitGreater splits a ">>" or ">=" token so that nested
+// WGSL templates like "array<vec3<f32>>" can be closed.
+func (p *parser) splitGreater() bool {
+	t := p.peek()
+	if t.text != ">>" && t.text != ">=" && t.text != ">>=" {
+		return false
+	}
+	first := token{kind: tPunct, text: ">", line: t.line, col: t.col}
+	rest := token{kind: tPunct, text: t.text[1:], line: t.line, col: t.col + 1}
+	p.toks = append(p.toks[:p.pos], append([]token{first, rest}, p.toks[p.pos+1:]...)...)
+	return true
+}
+
+func (p *parser) ident() (token, error) {
+	t := p.peek()
+	if t.kind != tIdent {
+		return t, errorf(t, "expected identifier, found %v", t)
+	}
+	p.pos++
+	return t, nil
+}
+
+// parse parses a complete shader.
+func (p *parser) parse() (*file, error) {
+	for p.peek().kind != tEOF {
+		var err error
+		if p.wgsl {
+			err = p.wgslTopLevel()
+		} else {
+			err = p.glslTopLevel()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.f, nil
+}
+
+// numeric type tables.
+
+func vecType(k kind, n int) *typeSpec { return &typeSpec{k: k, r: uint8(n), c: 1} }
+func matType(c, r int) *typeSpec      { return &typeSpec{k: kFloat, r: uint8(r), c: uint8(c)} }
+
+var glslTypes = map[string]*typeSpec{
+	"void":  {k: kVoid},
+	"float": vecType(kFloat, 1),
+	"int":   vecType(kInt, 1),
+	"uint":  vecType(kUint, 1),
+	"bool":  vecType(kBool, 1),
+}
+
+var wgslTypes = map[string]*typeSpec{
+	"f32":  vecType(kFloat, 1),
+	"f16":  vecType(kFloat, 1),
+	"i32":  vecType(kInt, 1),
+	"u32":  vecType(kUint, 1),
+	"bool": vecType(kBool, 1),
+}
+
+func init() {
+	for n := 2; n <= 4; n++ {
+		s := strconv.Itoa(n)
+		glslTypes["vec"+s] = vecType(kFloat, n)
+		glslTypes["ivec"+s] = vecType(kInt, n)
+		glslTypes["uvec"+s] = vecType(kUint, n)
+		glslTypes["bvec"+s] = vecType(kBool, n)
+		glslTypes["mat"+s] = matType(n, n)
+		wgslTypes["vec"+s+"f"] = vecType(kFloat, n)
+		wgslTypes["vec"+s+"h"] = vecType(kFloat, n)
+		wgslTypes["vec"+s+"i"] = vecType(kInt, n)
+		wgslTypes["vec"+s+"u"] = vecType(kUint, n)
+		// Inferred constructors such as "vec3(1.0)".
+		wgslTypes["vec"+s] = vecType(kInfer, n)
+		for m := 2; m <= 4; m++ {
+			cr := s + "x" + strconv.Itoa(m)
+			glslTypes["mat"+cr] = matType(n, m)
+			wgslTypes["mat"+cr+"f"] = matType(n, m)
+			wgslTypes["mat"+cr+"h"] = matType(n, m)
+			wgslTypes["mat"+cr] = matType(n, m)
+		}
+	}
+}
+
+// builtinType returns the built-in type with the given name.
+func (p *parser) builtinType(name string) *typeSpec {
+	if p.wgsl {
+		if t, ok := p.aliases[name]; ok {
+			return t
+		}
+		return wgslTypes[name]
+	}
+	return glslTypes[name]
+}
+
+// isTypeName reports whether name begins a type.
+func (p *parser) isTypeName(name string) bool {
+	return p.builtinType(name) != nil || p.structs[name] || (p.wgsl && name == "array")
+}
+
+func copyType(t *typeSpec) *typeSpec {
+	c := *t
+	return &c
+}
+
+// literal expressions.
+
+func (p *parser) numberLit(t token) (expr, error) {
+	text := t.text
+	v := Value{r: 1, c: 1}
+	if t.kind == tInt {
+		k := kInt
+		if strings.HasSuffix(text, "u") || strings.HasSuffix(text, "U") {
+			k = kUint
+		}
+		text = strings.TrimRight(text, "uUi")
+		n, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			return nil, errorf(t, "bad integer literal %q", t.text)
+		}
+		v.k = k
+		v.v[0] = uint32(n)
+		return &litExpr{t: t, v: v}, nil
+	}
+	text = strings.TrimRight(text, "fFhlL")
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, errorf(t, "bad float literal %q", t.text)
+	}
+	v.k = kFloat
+	v.v[0] = math.Float32bits(float32(f))
+	return &litExpr{t: t, v: v}, nil
+}
+
+// expressions (shared by GLSL and WGSL).
+
+var binaryPrec = map[string]int{
+	"||": 1, "^^": 2, "&&": 3, "|": 4, "^": 5, "&": 6,
+	"==": 7, "!=": 7, "<": 8, ">": 8, "<=": 8, ">=": 8,
+	"<<": 9, ">>": 9, "+": 10, "-": 10, "*": 11, "/": 11, "%": 11,
+}
+
+var assignOps = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true,
+	"&=": true, "|=": true, "^=": true, "<<=": true, ">>=": true,
+}
+
+// expression parses a comma-separated expression (GLSL only).
+func (p *parser) expression() (expr, error) {
+	x, err := p.assignment()
+	if err != nil || p.wgsl || !p.is(",") {
+		return x, err
+	}
+	seq := &seqExpr{t: x.tok(), exprs: []expr{x}}
+	for p.accept(",") {
+		y, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		seq.exprs = append(seq.exprs, y)
+	}
+	return seq, nil
+}
+
+func (p *parser) assignment() (expr, error) {
+	x, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tPunct && assignOps[t.text] {
+		p.pos++
+		rhs, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		return &assignExpr{t: t, op: t.text, lhs: x, rhs: rhs}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) conditional() (expr, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.wgsl || !p.is("?") {
+		return cond, nil
+	}
+	t := p.next()
+	yes, err := p.assignment()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	no, err := p.assignment()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{t: t, cond: cond, yes: yes, no: no}, nil
+}
+
+func (p *parser) binary(minPrec int) (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := binaryPrec[t.text]
+		if t.kind != tPunct || !ok || prec <= minPrec || (p.inTemplate && t.text == ">") {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.binary(prec)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{t: t, op: t.text, x: x, y: y}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	t := p.peek()
+	switch t.text {
+	case "-", "+", "!", "~", "++", "--":
+		if t.kind != tPunct {
+			break
+		}
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{t: t, op: t.text, x: x}, nil
+	case "&", "*":
+		if p.wgsl && t.kind == tPunct {
+			// Pointers are treated as plain references.
+			p.pos++
+			return p.unary()
+		}
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.text == "[" && t.kind == tPunct:
+			p.pos++
+			ix, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{t: t, x: x, ix: ix}
+		case t.text == "." && t.kind == tPunct:
+			p.pos++
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if !p.wgsl && name.text == "length" && p.is("(") {
+				// GLSL array ".length()" method.
+				p.pos++
+				if _, err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				x = &callExpr{t: name, name: "length()", args: []expr{x}}
+				continue
+			}
+			x = &memberExpr{t: name, x: x, name: name.text}
+		case (t.text == "++" || t.text == "--") && t.kind == tPunct:
+			p.pos++
+			x = &postfixExpr{t: t, op: t.text, x: x}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) callArgs() ([]expr, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expr
+	for !p.is(")") {
+		arg, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	// GLSL allows "f(void)".
+	if len(args) == 1 {
+		if id, ok := args[0].(*identExpr); ok && id.name == "void" {
+			args = nil
+		}
+	}
+	return args, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tInt, tFloat:
+		return p.numberLit(t)
+	case tIdent:
+		switch t.text {
+		case "true":
+			return &litExpr{t: t, v: boolValue(true)}, nil
+		case "false":
+			return &litExpr{t: t, v: boolValue(false)}, nil
+		}
+
+		// Explicitly-typed constructors: "float[3](...)", "vec3<f32>(...)",
+		// "array<f32, 3>(...)" and "bitcast<u32>(...)".
+		if p.wgsl && p.is("<") && (isTemplateName(t.text) || t.text == "bitcast") {
+			p.pos--
+			typ, err := p.wgslType()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &callExpr{t: t, name: t.text, typ: typ, args: args}, nil
+		}
+		if !p.wgsl && p.is("[") && p.isTypeName(t.text) {
+			p.pos--
+			typ, err := p.glslType()
+			if err != nil {
+				return nil, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &callExpr{t: t, name: t.text, typ: typ, args: args}, nil
+		}
+
+		if p.is("(") {
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &callExpr{t: t, name: t.text, args: args}, nil
+		}
+		return &identExpr{t: t, name: t.text}, nil
+	case tPunct:
+		if t.text == "(" {
+			x, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, errorf(t, "unexpected %v", t)
+}
+
+// statements (shared).
+
+func (p *parser) block() (*blockStmt, error) {
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	b := &blockStmt{}
+	for !p.is("}") {
+		if p.peek().kind == tEOF {
+			return nil, errorf(p.peek(), "expected \"}\", found end of file")
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			b.stmts = append(b.stmts, s)
+		}
+	}
+	p.pos++
+	return b, nil
+}
+
+func (p *parser) statement() (stmt, error) {
+	if p.wgsl {
+		return p.wgslStatement()
+	}
+	return p.glslStatement()
+}
+
+// arrayLen parses an array length inside "[...]" or a WGSL template.
+func (p *parser) arrayLen(t *typeSpec) error {
+	if p.is("]") || p.is(">") {
+		return nil
+	}
+	x, err := p.binary(0)
+	if err != nil {
+		return err
+	}
+	if lit, ok := x.(*litExpr); ok && (lit.v.k == kInt || lit.v.k == kUint) {
+		t.length = int(lit.v.v[0])
+		if t.length <= 0 {
+			return errorf(lit.t, "array length must be positive")
+		}
+		return nil
+	}
+	t.lenExpr = x
+	return nil
+}
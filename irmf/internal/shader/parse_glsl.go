@@ -0,0 +1,519 @@
+package shader
+
+// glslQualifiers are storage and precision qualifiers that do not
+// affect CPU evaluation.
+var glslQualifiers = map[string]bool{
+	"const": true, "uniform": true, "in": true, "out": true, "inout": true,
+	"highp": true, "mediump": true, "lowp": true, "flat": true, "smooth": true,
+	"noperspective": true, "invariant": true, "centroid": true, "attribute": true,
+	"varying": true, "precise": true,
+}
+
+// glslType parses a type such as "vec3", "float[3]" or a struct name.
+func (p *parser) glslType() (*typeSpec, error) {
+	t, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	var typ *typeSpec
+	switch {
+	case p.builtinType(t.text) != nil:
+		typ = copyType(p.builtinType(t.text))
+	case p.structs[t.text]:
+		typ = &typeSpec{k: kStruct, structName: t.text}
+	default:
+		return nil, errorf(t, "unknown type %q", t.text)
+	}
+	return p.glslArraySuffix(typ)
+}
+
+// glslArraySuffix parses optional "[N]" suffixes.
+func (p *parser) glslArraySuffix(typ *typeSpec) (*typeSpec, error) {
+	for p.is("[") {
+		p.pos++
+		arr := &typeSpec{k: kArray, elem: typ}
+		if err := p.arrayLen(arr); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		typ = arr
+	}
+	return typ, nil
+}
+
+// skipQualifiers skips qualifiers and reports "const", "out" and "in".
+func (p *parser) skipQualifiers() (konst, out, in bool, err error) {
+	for {
+		t := p.peek()
+		if t.kind != tIdent {
+			return konst, out, in, nil
+		}
+		switch {
+		case t.text == "layout":
+			p.pos++
+			if err := p.skipParens(); err != nil {
+				return false, false, false, err
+			}
+			continue
+		case !glslQualifiers[t.text]:
+			return konst, out, in, nil
+		}
+		switch t.text {
+		case "const":
+			konst = true
+		case "out":
+			out = true
+		case "inout":
+			out, in = true, true
+		case "in":
+			in = true
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) skipParens() error {
+	if _, err := p.expect("("); err != nil {
+		return err
+	}
+	for depth := 1; depth > 0; {
+		t := p.next()
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if t.kind == tEOF {
+			return errorf(t, "unbalanced parentheses")
+		}
+	}
+	return nil
+}
+
+func (p *parser) glslTopLevel() error {
+	t := p.peek()
+	switch t.text {
+	case ";":
+		p.pos++
+		return nil
+	case "precision":
+		for !p.accept(";") {
+			if p.next().kind == tEOF {
+				return errorf(t, "unterminated precision statement")
+			}
+		}
+		return nil
+	case "struct":
+		st, err := p.glslStruct()
+		if err != nil {
+			return err
+		}
+		p.f.structs = append(p.f.structs, st)
+		// Declarators after the struct body are not supported.
+		_, err = p.expect(";")
+		return err
+	}
+
+	konst, _, _, err := p.skipQualifiers()
+	if err != nil {
+		return err
+	}
+	if p.is(";") { // e.g. "layout(...) in;"
+		p.pos++
+		return nil
+	}
+	typ, err := p.glslType()
+	if err != nil {
+		return err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if p.is("(") {
+		return p.glslFunction(typ, name)
+	}
+	decls, err := p.glslDeclarators(typ, name, konst)
+	if err != nil {
+		return err
+	}
+	p.f.globals = append(p.f.globals, decls...)
+	return nil
+}
+
+func (p *parser) glslStruct() (*structType, error) {
+	p.pos++ // "struct"
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	st := &structType{name: name.text, index: map[string]int{}}
+	p.structs[name.text] = true
+	p.f.structTok[name.text] = name
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.accept("}") {
+		if _, _, _, err := p.skipQualifiers(); err != nil {
+			return nil, err
+		}
+		typ, err := p.glslType()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			fname, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			ftyp, err := p.glslArraySuffix(typ)
+			if err != nil {
+				return nil, err
+			}
+			st.index[fname.text] = len(st.fields)
+			st.fields = append(st.fields, field{name: fname.text, typ: ftyp})
+			if !p.accept(",") {
+				break
+			}
+		}
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (p *parser) glslFunction(ret *typeSpec, name token) error {
+	fd := &funcDecl{t: name, name: name.text, ret: ret}
+	p.pos++ // "("
+	for !p.is(")") {
+		if p.is("void") && p.peekN(1).text == ")" {
+			p.pos++
+			break
+		}
+		_, out, in, err := p.skipQualifiers()
+		if err != nil {
+			return err
+		}
+		typ, err := p.glslType()
+		if err != nil {
+			return err
+		}
+		prm := &param{typ: typ, out: out, in: in || !out}
+		if t := p.peek(); t.kind == tIdent {
+			p.pos++
+			prm.t, prm.name = t, t.text
+			if prm.typ, err = p.glslArraySuffix(typ); err != nil {
+				return err
+			}
+		}
+		fd.params = append(fd.params, prm)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect(")"); err != nil {
+		return err
+	}
+	if p.accept(";") {
+		return nil // prototype
+	}
+	body, err := p.block()
+	if err != nil {
+		return err
+	}
+	fd.body = body
+	p.f.funcs = append(p.f.funcs, fd)
+	return nil
+}
+
+// glslDeclarators parses "name[N] = init, name2 = init2;" after the type
+// and first name have been consumed.
+func (p *parser) glslDeclarators(typ *typeSpec, name token, konst bool) ([]*varDecl, error) {
+	var decls []*varDecl
+	for {
+		vtyp, err := p.glslArraySuffix(typ)
+		if err != nil {
+			return nil, err
+		}
+		d := &varDecl{t: name, name: name.text, typ: vtyp, konst: konst}
+		if p.accept("=") {
+			if d.init, err = p.assignment(); err != nil {
+				return nil, err
+			}
+		}
+		decls = append(decls, d)
+		if !p.accept(",") {
+			break
+		}
+		if name, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return decls, nil
+}
+
+// isGLSLDecl reports whether the upcoming tokens start a declaration.
+func (p *parser) isGLSLDecl() bool {
+	t := p.peek()
+	if t.kind != tIdent {
+		return false
+	}
+	if glslQualifiers[t.text] || t.text == "struct" {
+		return true
+	}
+	if !p.isTypeName(t.text) {
+		return false
+	}
+	next := p.peekN(1)
+	if next.kind == tIdent {
+		return true
+	}
+	if next.text != "[" {
+		return false
+	}
+	// "float[3] a" versus "float[3](...)".
+	depth := 0
+	for i := 1; ; i++ {
+		tk := p.peekN(i)
+		switch tk.text {
+		case "[":
+			depth++
+		case "]":
+			depth--
+		}
+		if tk.kind == tEOF {
+			return false
+		}
+		if depth == 0 {
+			return p.peekN(i+1).kind == tIdent
+		}
+	}
+}
+
+func (p *parser) glslStatement() (stmt, error) {
+	t := p.peek()
+	switch t.text {
+	case "{":
+		return p.block()
+	case ";":
+		p.pos++
+		return nil, nil
+	case "if":
+		p.pos++
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		s := &ifStmt{t: t, cond: cond}
+		if s.then, err = p.glslStatement(); err != nil {
+			return nil, err
+		}
+		if p.accept("else") {
+			if s.els, err = p.glslStatement(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case "for":
+		p.pos++
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		s := &forStmt{t: t}
+		var err error
+		if !p.accept(";") {
+			// The init statement consumes its own ";".
+			if s.init, err = p.glslSimpleStatement(); err != nil {
+				return nil, err
+			}
+		}
+		if !p.is(";") {
+			if s.cond, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			if s.post, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if s.body, err = p.glslStatement(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "while":
+		p.pos++
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		body, err := p.glslStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{t: t, cond: cond, body: body}, nil
+	case "do":
+		p.pos++
+		body, err := p.glslStatement()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("while"); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		return &whileStmt{t: t, cond: cond, body: body, do: true}, nil
+	case "return":
+		p.pos++
+		s := &returnStmt{t: t}
+		if !p.is(";") {
+			var err error
+			if s.x, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		_, err := p.expect(";")
+		return s, err
+	case "break", "continue", "discard":
+		p.pos++
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		switch t.text {
+		case "break":
+			return &breakStmt{t: t}, nil
+		case "continue":
+			return &continueStmt{t: t}, nil
+		}
+		return &discardStmt{t: t}, nil
+	case "switch":
+		return p.glslSwitch()
+	}
+	return p.glslSimpleStatement()
+}
+
+// glslSimpleStatement parses a declaration or expression statement
+// including its terminating ";".
+func (p *parser) glslSimpleStatement() (stmt, error) {
+	if p.isGLSLDecl() {
+		if p.is("struct") {
+			return nil, errorf(p.peek(), "local struct declarations are not supported")
+		}
+		konst, _, _, err := p.skipQualifiers()
+		if err != nil {
+			return nil, err
+		}
+		typ, err := p.glslType()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		decls, err := p.glslDeclarators(typ, name, konst)
+		if err != nil {
+			return nil, err
+		}
+		return &declStmt{decls: decls}, nil
+	}
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return &exprStmt{x: x}, nil
+}
+
+func (p *parser) glslSwitch() (stmt, error) {
+	t := p.next()
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	tag, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	s := &switchStmt{t: t, tag: tag}
+	var cur *caseClause
+	for !p.accept("}") {
+		ct := p.peek()
+		switch ct.text {
+		case "case":
+			p.pos++
+			v, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			cur = &caseClause{t: ct, values: []expr{v}}
+			s.cases = append(s.cases, cur)
+		case "default":
+			p.pos++
+			if _, err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			cur = &caseClause{t: ct, isDefault: true}
+			s.cases = append(s.cases, cur)
+		default:
+			if cur == nil {
+				return nil, errorf(ct, "statement before first case in switch")
+			}
+			st, err := p.glslStatement()
+			if err != nil {
+				return nil, err
+			}
+			if st != nil {
+				cur.body = append(cur.body, st)
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,513 @@
+package shader
+
+import "strings"
+
+// isTemplateName reports whether a WGSL identifier may be followed by
+// a template parameter list, e.g. "vec3<f32>".
+func isTemplateName(name string) bool {
+	switch name {
+	case "vec2", "vec3", "vec4", "array", "ptr", "atomic":
+		return true
+	}
+	return strings.HasPrefix(name, "mat") && len(name) == 6 && name[4] == 'x'
+}
+
+// skipAttributes skips "@name" and "@name(...)" attributes.
+func (p *parser) skipAttributes() error {
+	for p.is("@") {
+		p.pos++
+		if _, err := p.ident(); err != nil {
+			return err
+		}
+		if p.is("(") {
+			if err := p.skipParens(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wgslType parses a WGSL type.
+func (p *parser) wgslType() (*typeSpec, error) {
+	t, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.is("<") && (isTemplateName(t.text) || t.text == "bitcast") {
+		p.pos++
+		switch {
+		case t.text == "array":
+			elem, err := p.wgslType()
+			if err != nil {
+				return nil, err
+			}
+			arr := &typeSpec{k: kArray, elem: elem}
+			if p.accept(",") {
+				inTemplate := p.inTemplate
+				p.inTemplate = true
+				err := p.arrayLen(arr)
+				p.inTemplate = inTemplate
+				if err != nil {
+					return nil, err
+				}
+			}
+			if _, err := p.expect(">"); err != nil {
+				return nil, err
+			}
+			return arr, nil
+		case t.text == "ptr":
+			// ptr<function, T> is treated as T.
+			if _, err := p.ident(); err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+			elem, err := p.wgslType()
+			if err != nil {
+				return nil, err
+			}
+			if p.accept(",") {
+				if _, err := p.ident(); err != nil {
+					return nil, err
+				}
+			}
+			_, err = p.expect(">")
+			return elem, err
+		}
+
+		elem, err := p.wgslType()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(">"); err != nil {
+			return nil, err
+		}
+		if t.text == "bitcast" || t.text == "atomic" {
+			return elem, nil
+		}
+		typ := copyType(wgslTypes[t.text])
+		typ.k = elem.k
+		return typ, nil
+	}
+
+	switch {
+	case p.builtinType(t.text) != nil:
+		return copyType(p.builtinType(t.text)), nil
+	case p.structs[t.text]:
+		return &typeSpec{k: kStruct, structName: t.text}, nil
+	}
+	return nil, errorf(t, "unknown type %q", t.text)
+}
+
+func (p *parser) wgslTopLevel() error {
+	if err := p.skipAttributes(); err != nil {
+		return err
+	}
+	t := p.peek()
+	switch t.text {
+	case ";":
+		p.pos++
+		return nil
+	case "enable", "requires", "diagnostic", "const_assert":
+		for !p.accept(";") {
+			if p.next().kind == tEOF {
+				return errorf(t, "unterminated %v directive", t.text)
+			}
+		}
+		return nil
+	case "alias":
+		p.pos++
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if _, err := p.expect("="); err != nil {
+			return err
+		}
+		typ, err := p.wgslType()
+		if err != nil {
+			return err
+		}
+		p.aliases[name.text] = typ
+		_, err = p.expect(";")
+		return err
+	case "struct":
+		st, err := p.wgslStruct()
+		if err != nil {
+			return err
+		}
+		p.f.structs = append(p.f.structs, st)
+		return nil
+	case "fn":
+		return p.wgslFunction()
+	case "const", "var", "let", "override":
+		d, err := p.wgslVarDecl()
+		if err != nil {
+			return err
+		}
+		p.f.globals = append(p.f.globals, d)
+		return nil
+	}
+	return errorf(t, "unexpected %v at module scope", t)
+}
+
+func (p *parser) wgslStruct() (*structType, error) {
+	p.pos++ // "struct"
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	st := &structType{name: name.text, index: map[string]int{}}
+	p.structs[name.text] = true
+	p.f.structTok[name.text] = name
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.accept("}") {
+		if err := p.skipAttributes(); err != nil {
+			return nil, err
+		}
+		fname, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		typ, err := p.wgslType()
+		if err != nil {
+			return nil, err
+		}
+		st.index[fname.text] = len(st.fields)
+		st.fields = append(st.fields, field{name: fname.text, typ: typ})
+		if !p.accept(",") && !p.accept(";") && !p.is("}") {
+			return nil, errorf(p.peek(), "expected \",\" or \"}\", found %v", p.peek())
+		}
+	}
+	p.accept(";")
+	return st, nil
+}
+
+func (p *parser) wgslFunction() error {
+	p.pos++ // "fn"
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	fd := &funcDecl{t: name, name: name.text, ret: &typeSpec{k: kVoid}}
+	if _, err := p.expect("("); err != nil {
+		return err
+	}
+	for !p.is(")") {
+		if err := p.skipAttributes(); err != nil {
+			return err
+		}
+		pname, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if _, err := p.expect(":"); err != nil {
+			return err
+		}
+		isPtr := p.is("ptr")
+		typ, err := p.wgslType()
+		if err != nil {
+			return err
+		}
+		fd.params = append(fd.params, &param{t: pname, name: pname.text, typ: typ, in: true, out: isPtr})
+		if !p.accept(",") {
+			break
+		}
+	}
+	if _, err := p.expect(")"); err != nil {
+		return err
+	}
+	if p.accept("->") {
+		if err := p.skipAttributes(); err != nil {
+			return err
+		}
+		if fd.ret, err = p.wgslType(); err != nil {
+			return err
+		}
+	}
+	if fd.body, err = p.block(); err != nil {
+		return err
+	}
+	p.f.funcs = append(p.f.funcs, fd)
+	return nil
+}
+
+// wgslVarDecl parses "let|var|const|override name (: type)? (= init)?;".
+func (p *parser) wgslVarDecl() (*varDecl, error) {
+	kw := p.next()
+	if kw.text == "var" && p.is("<") {
+		// Address space, e.g. "var<uniform>".
+		for !p.accept(">") {
+			if p.next().kind == tEOF {
+				return nil, errorf(kw, "unterminated address space")
+			}
+		}
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	d := &varDecl{t: name, name: name.text, konst: kw.text == "const" || kw.text == "let"}
+	if p.accept(":") {
+		if d.typ, err = p.wgslType(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("=") {
+		if d.init, err = p.binary(0); err != nil {
+			return nil, err
+		}
+	}
+	if d.typ == nil && d.init == nil {
+		return nil, errorf(name, "declaration of %q needs a type or an initializer", name.text)
+	}
+	_, err = p.expect(";")
+	return d, err
+}
+
+func (p *parser) wgslStatement() (stmt, error) {
+	if err := p.skipAttributes(); err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch t.text {
+	case "{":
+		return p.block()
+	case ";":
+		p.pos++
+		return nil, nil
+	case "let", "var", "const":
+		d, err := p.wgslVarDecl()
+		if err != nil {
+			return nil, err
+		}
+		return &declStmt{decls: []*varDecl{d}}, nil
+	case "if":
+		return p.wgslIf()
+	case "for":
+		p.pos++
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		s := &forStmt{t: t}
+		var err error
+		if !p.is(";") {
+			if s.init, err = p.wgslSimpleStatement(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		if !p.is(";") {
+			if s.cond, err = p.binary(0); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			post, err := p.wgslSimpleStatement()
+			if err != nil {
+				return nil, err
+			}
+			es, ok := post.(*exprStmt)
+			if !ok {
+				return nil, errorf(t, "unsupported for-loop update statement")
+			}
+			s.post = es.x
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if s.body, err = p.block(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "while":
+		p.pos++
+		cond, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{t: t, cond: cond, body: body}, nil
+	case "loop":
+		p.pos++
+		if _, err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		s := &loopStmt{t: t, body: &blockStmt{}}
+		for !p.accept("}") {
+			if p.is("continuing") {
+				p.pos++
+				var err error
+				if s.continuing, err = p.block(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if p.peek().kind == tEOF {
+				return nil, errorf(t, "unterminated loop")
+			}
+			st, err := p.wgslStatement()
+			if err != nil {
+				return nil, err
+			}
+			if st != nil {
+				s.body.stmts = append(s.body.stmts, st)
+			}
+		}
+		return s, nil
+	case "switch":
+		return p.wgslSwitch()
+	case "return":
+		p.pos++
+		s := &returnStmt{t: t}
+		if !p.is(";") {
+			var err error
+			if s.x, err = p.binary(0); err != nil {
+				return nil, err
+			}
+		}
+		_, err := p.expect(";")
+		return s, err
+	case "break":
+		p.pos++
+		s := &breakStmt{t: t}
+		if p.accept("if") {
+			var err error
+			if s.cond, err = p.binary(0); err != nil {
+				return nil, err
+			}
+		}
+		_, err := p.expect(";")
+		return s, err
+	case "continue":
+		p.pos++
+		_, err := p.expect(";")
+		return &continueStmt{t: t}, err
+	case "discard":
+		p.pos++
+		_, err := p.expect(";")
+		return &discardStmt{t: t}, err
+	}
+	s, err := p.wgslSimpleStatement()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.expect(";")
+	return s, err
+}
+
+// wgslSimpleStatement parses a declaration, assignment, increment or call
+// without its terminating ";".
+func (p *parser) wgslSimpleStatement() (stmt, error) {
+	switch p.peek().text {
+	case "let", "var", "const":
+		// wgslVarDecl consumes the ";", so back up one token afterwards.
+		d, err := p.wgslVarDecl()
+		if err != nil {
+			return nil, err
+		}
+		p.pos--
+		return &declStmt{decls: []*varDecl{d}}, nil
+	case "_":
+		// Phony assignment: "_ = expr;"
+		p.pos++
+		if _, err := p.expect("="); err != nil {
+			return nil, err
+		}
+		x, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		return &exprStmt{x: x}, nil
+	}
+	x, err := p.assignment()
+	if err != nil {
+		return nil, err
+	}
+	return &exprStmt{x: x}, nil
+}
+
+func (p *parser) wgslIf() (stmt, error) {
+	t := p.next() // "if"
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	s := &ifStmt{t: t, cond: cond, then: then}
+	if p.accept("else") {
+		if p.is("if") {
+			s.els, err = p.wgslIf()
+		} else {
+			s.els, err = p.block()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) wgslSwitch() (stmt, error) {
+	t := p.next() // "switch"
+	tag, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	s := &switchStmt{t: t, tag: tag}
+	for !p.accept("}") {
+		ct := p.next()
+		cc := &caseClause{t: ct, wgsl: true}
+		switch ct.text {
+		case "default":
+			cc.isDefault = true
+		case "case":
+			for !p.is(":") && !p.is("{") {
+				if p.accept("default") {
+					cc.isDefault = true
+				} else {
+					v, err := p.binary(0)
+					if err != nil {
+						return nil, err
+					}
+					cc.values = append(cc.values, v)
+				}
+				if !p.accept(",") {
+					break
+				}
+			}
+		default:
+			return nil, errorf(ct, "expected \"case\" or \"default\", found %v", ct)
+		}
+		p.accept(":")
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		cc.body = body.stmts
+		s.cases = append(s.cases, cc)
+	}
+	return s, nil
+}
@@ -0,0 +1,471 @@
+package shader
+
+import (
+	"strconv"
+	"strings"
+)
+
+// macro represents a "#define" macro. Object-like macros have nil params.
+type macro struct {
+	params []string
+	body   []token
+}
+
+// preprocessor implements the subset of the GLSL preprocessor used by
+// IRMF shaders and the LYGIA library: object-like and function-like
+// "#define", "#undef", and "#if/#ifdef/#ifndef/#elif/#else/#endif".
+// "#version", "#extension", "#pragma" and "#line" are ignored.
+type preprocessor struct {
+	defines map[string]*macro
+	lx      lexer
+	toks    []token
+
+	// cond tracks nested conditionals.
+	cond []ppCond
+}
+
+type ppCond struct {
+	active    bool // current branch is active
+	taken     bool // some branch has been taken
+	parentOff bool // enclosing block is inactive
+}
+
+// preprocess returns the tokens of src after GLSL preprocessing.
+func preprocess(src string) ([]token, error) {
+	pp := &preprocessor{defines: map[string]*macro{}}
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := lines[i]
+		// Join continuation lines, keeping the original line numbers.
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, "\\") + " " + lines[i]
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if !pp.lx.inComment && strings.HasPrefix(trimmed, "#") {
+			if err := pp.directive(trimmed, lineNum); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		toks, err := pp.lx.tokenizeLine(line, lineNum)
+		if err != nil {
+			return nil, err
+		}
+		if !pp.active() {
+			continue
+		}
+		expanded, err := pp.expand(toks, nil)
+		if err != nil {
+			return nil, err
+		}
+		pp.toks = append(pp.toks, expanded...)
+	}
+	if len(pp.cond) > 0 {
+		return nil, &Error{Line: len(lines), Col: 1, Msg: "missing #endif"}
+	}
+	return pp.toks, nil
+}
+
+func (pp *preprocessor) active() bool {
+	if len(pp.cond) == 0 {
+		return true
+	}
+	c := pp.cond[len(pp.cond)-1]
+	return c.active && !c.parentOff
+}
+
+func (pp *preprocessor) directive(line string, lineNum int) error {
+	var lx lexer
+	toks, err := lx.tokenizeLine(strings.TrimPrefix(line, "#"), lineNum)
+	if err != nil {
+		return err
+	}
+	if len(toks) == 0 {
+		return nil
+	}
+	name, args := toks[0].text, toks[1:]
+	at := token{line: lineNum, col: 1}
+
+	switch name {
+	case "ifdef", "ifndef":
+		if len(args) == 0 {
+			return errorf(at, "#%v requires a macro name", name)
+		}
+		_, defined := pp.defines[args[0].text]
+		pp.push(defined == (name == "ifdef"))
+		return nil
+	case "if":
+		if !pp.active() {
+			pp.push(false)
+			return nil
+		}
+		v, err := pp.evalCond(args, at)
+		if err != nil {
+			return err
+		}
+		pp.push(v)
+		return nil
+	case "elif":
+		if len(pp.cond) == 0 {
+			return errorf(at, "#elif without #if")
+		}
+		c := &pp.cond[len(pp.cond)-1]
+		if c.taken || c.parentOff {
+			c.active = false
+			return nil
+		}
+		v, err := pp.evalCond(args, at)
+		if err != nil {
+			return err
+		}
+		c.active, c.taken = v, v
+		return nil
+	case "else":
+		if len(pp.cond) == 0 {
+			return errorf(at, "#else without #if")
+		}
+		c := &pp.cond[len(pp.cond)-1]
+		c.active = !c.taken
+		c.taken = true
+		return nil
+	case "endif":
+		if len(pp.cond) == 0 {
+			return errorf(at, "#endif without #if")
+		}
+		pp.cond = pp.cond[:len(pp.cond)-1]
+		return nil
+	}
+
+	if !pp.active() {
+		return nil
+	}
+
+	switch name {
+	case "define":
+		if len(args) == 0 || args[0].kind != tIdent {
+			return errorf(at, "#define requires a macro name")
+		}
+		m := &macro{}
+		body := args[1:]
+		// A function-like macro has "(" immediately after its name.
+		if len(body) > 0 && body[0].text == "(" && body[0].col == args[0].col+len(args[0].text) {
+			m.params = []string{}
+			i := 1
+			for ; i < len(body) && body[i].text != ")"; i++ {
+				if body[i].kind == tIdent {
+					m.params = append(m.params, body[i].text)
+				}
+			}
+			if i >= len(body) {
+				return errorf(args[0], "unterminated macro parameter list")
+			}
+			body = body[i+1:]
+		}
+		m.body = body
+		pp.defines[args[0].text] = m
+	case "undef":
+		if len(args) > 0 {
+			delete(pp.defines, args[0].text)
+		}
+	case "version", "extension", "pragma", "line":
+	case "error":
+		return errorf(at, "#error %v", joinTokens(args))
+	default:
+		return errorf(at, "unsupported preprocessor directive #%v", name)
+	}
+	return nil
+}
+
+func (pp *preprocessor) push(v bool) {
+	pp.cond = append(pp.cond, ppCond{active: v, taken: v, parentOff: !pp.active()})
+}
+
+// expand performs macro expansion on toks. hide lists the macros
+// currently being expanded to prevent infinite recursion.
+func (pp *preprocessor) expand(toks []token, hide map[string]bool) ([]token, error) {
+	var out []token
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		m, ok := pp.defines[t.text]
+		if t.kind != tIdent || !ok || hide[t.text] {
+			out = append(out, t)
+			continue
+		}
+
+		var body []token
+		if m.params == nil {
+			body = m.body
+		} else {
+			if i+1 >= len(toks) || toks[i+1].text != "(" {
+				out = append(out, t)
+				continue
+			}
+			args, end, err := splitMacroArgs(toks, i+1)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			if len(args) == 1 && len(args[0]) == 0 && len(m.params) == 0 {
+				args = nil
+			}
+			if len(args) != len(m.params) {
+				return nil, errorf(t, "macro %v expects %v arguments, got %v", t.text, len(m.params), len(args))
+			}
+			for _, bt := range m.body {
+				idx := -1
+				for pi, p := range m.params {
+					if bt.kind == tIdent && bt.text == p {
+						idx = pi
+						break
+					}
+				}
+				if idx < 0 {
+					body = append(body, bt)
+					continue
+				}
+				arg, err := pp.expand(args[idx], hide)
+				if err != nil {
+					return nil, err
+				}
+				body = append(body, arg...)
+			}
+		}
+
+		// Re-position the replacement tokens at the invocation site.
+		repl := make([]token, len(body))
+		for j, bt := range body {
+			bt.line, bt.col = t.line, t.col
+			repl[j] = bt
+		}
+		nh := map[string]bool{t.text: true}
+		for k := range hide {
+			nh[k] = true
+		}
+		expanded, err := pp.expand(repl, nh)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
+
+// splitMacroArgs splits the arguments of a macro invocation starting
+// at the "(" token at index open. It returns the index of the closing ")".
+func splitMacroArgs(toks []token, open int) ([][]token, int, error) {
+	var args [][]token
+	var cur []token
+	depth := 0
+	for i := open + 1; i < len(toks); i++ {
+		t := toks[i]
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			if depth == 0 {
+				return append(args, cur), i, nil
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				args = append(args, cur)
+				cur = nil
+				continue
+			}
+		}
+		cur = append(cur, t)
+	}
+	return nil, 0, errorf(toks[open], "unterminated macro invocation")
+}
+
+// evalCond evaluates a "#if" or "#elif" condition.
+func (pp *preprocessor) evalCond(args []token, at token) (bool, error) {
+	// Replace "defined X" and "defined(X)" before macro expansion.
+	var toks []token
+	for i := 0; i < len(args); i++ {
+		t := args[i]
+		if t.text != "defined" {
+			toks = append(toks, t)
+			continue
+		}
+		var name string
+		switch {
+		case i+3 < len(args) && args[i+1].text == "(" && args[i+3].text == ")":
+			name = args[i+2].text
+			i += 3
+		case i+1 < len(args):
+			name = args[i+1].text
+			i++
+		default:
+			return false, errorf(t, "defined requires a macro name")
+		}
+		v := "0"
+		if _, ok := pp.defines[name]; ok {
+			v = "1"
+		}
+		toks = append(toks, token{kind: tInt, text: v, line: t.line, col: t.col})
+	}
+	toks, err := pp.expand(toks, nil)
+	if err != nil {
+		return false, err
+	}
+	e := &ppExpr{toks: toks, at: at}
+	v, err := e.parse(0)
+	if err != nil {
+		return false, err
+	}
+	if e.pos < len(e.toks) {
+		return false, errorf(e.toks[e.pos], "unexpected %v in #if expression", e.toks[e.pos])
+	}
+	return v != 0, nil
+}
+
+// ppExpr evaluates integer "#if" expressions.
+type ppExpr struct {
+	toks []token
+	pos  int
+	at   token
+}
+
+var ppPrec = map[string]int{
+	"||": 1, "&&": 2, "|": 3, "^": 4, "&": 5,
+	"==": 6, "!=": 6, "<": 7, ">": 7, "<=": 7, ">=": 7,
+	"<<": 8, ">>": 8, "+": 9, "-": 9, "*": 10, "/": 10, "%": 10,
+}
+
+func (e *ppExpr) parse(minPrec int) (int64, error) {
+	x, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+	for e.pos < len(e.toks) {
+		op := e.toks[e.pos].text
+		prec, ok := ppPrec[op]
+		if !ok || prec <= minPrec {
+			break
+		}
+		e.pos++
+		y, err := e.parse(prec)
+		if err != nil {
+			return 0, err
+		}
+		x = ppBinary(op, x, y)
+	}
+	return x, nil
+}
+
+func ppBinary(op string, x, y int64) int64 {
+	b := func(v bool) int64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return b(x != 0 || y != 0)
+	case "&&":
+		return b(x != 0 && y != 0)
+	case "|":
+		return x | y
+	case "^":
+		return x ^ y
+	case "&":
+		return x & y
+	case "==":
+		return b(x == y)
+	case "!=":
+		return b(x != y)
+	case "<":
+		return b(x < y)
+	case ">":
+		return b(x > y)
+	case "<=":
+		return b(x <= y)
+	case ">=":
+		return b(x >= y)
+	case "<<":
+		return x << uint(y)
+	case ">>":
+		return x >> uint(y)
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return 0
+		}
+		return x / y
+	case "%":
+		if y == 0 {
+			return 0
+		}
+		return x % y
+	}
+	return 0
+}
+
+func (e *ppExpr) unary() (int64, error) {
+	if e.pos >= len(e.toks) {
+		return 0, errorf(e.at, "incomplete #if expression")
+	}
+	t := e.toks[e.pos]
+	e.pos++
+	switch {
+	case t.text == "!":
+		v, err := e.unary()
+		if v == 0 {
+			return 1, err
+		}
+		return 0, err
+	case t.text == "-":
+		v, err := e.unary()
+		return -v, err
+	case t.text == "+":
+		return e.unary()
+	case t.text == "~":
+		v, err := e.unary()
+		return ^v, err
+	case t.text == "(":
+		v, err := e.parse(0)
+		if err != nil {
+			return 0, err
+		}
+		if e.pos >= len(e.toks) || e.toks[e.pos].text != ")" {
+			return 0, errorf(t, "missing ) in #if expression")
+		}
+		e.pos++
+		return v, nil
+	case t.kind == tInt:
+		v, err := strconv.ParseInt(strings.TrimRight(t.text, "uU"), 0, 64)
+		if err != nil {
+			return 0, errorf(t, "bad integer %q", t.text)
+		}
+		return v, nil
+	case t.kind == tFloat:
+		f, err := strconv.ParseFloat(strings.TrimRight(t.text, "fF"), 64)
+		if err != nil {
+			return 0, errorf(t, "bad number %q", t.text)
+		}
+		return int64(f), nil
+	case t.kind == tIdent:
+		// Undefined identifiers evaluate to 0.
+		return 0, nil
+	}
+	return 0, errorf(t, "unexpected %v in #if expression", t)
+}
+
+func joinTokens(toks []token) string {
+	var parts []string
+	for _, t := range toks {
+		parts = append(parts, t.text)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,205 @@
+// Package shader implements a small interpreter for the subset of GLSL
+// and WGSL used by IRMF models.
+//
+// It exists so that IRMF models can be evaluated on the CPU, without
+// a GPU or display, for example by the reference renderer and in tests.
+// Shaders are parsed and compiled to a tree of Go closures once, then
+// evaluated by any number of independent Machines.
+package shader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDiscard is returned by Call when the shader executes "discard".
+var ErrDiscard = errors.New("fragment discarded")
+
+// Program represents a compiled shader.
+// A Program is immutable and may be shared between goroutines.
+type Program struct {
+	wgsl     bool
+	funcs    map[string][]*function
+	globals  map[string]int
+	nglobals int
+	inits    []func(m *Machine)
+}
+
+// Compile parses and compiles a shader. lang is either "glsl" or "wgsl".
+// GLSL source is run through the C-style preprocessor first.
+func Compile(lang, src string) (*Program, error) {
+	var toks []token
+	var err error
+	var wgsl bool
+	switch lang {
+	case "glsl":
+		toks, err = preprocess(src)
+	case "wgsl":
+		wgsl = true
+		toks, err = tokenize(src)
+	default:
+		return nil, fmt.Errorf("unsupported shader language %q", lang)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := newParser(toks, wgsl).parse()
+	if err != nil {
+		return nil, err
+	}
+	return compileFile(f, wgsl)
+}
+
+// Machine holds the state needed to evaluate a Program.
+// A Machine must not be used concurrently by multiple goroutines.
+type Machine struct {
+	prog    *Program
+	globals []Value
+	stack   []Value
+	sp      int
+	ret     Value
+	depth   int // the number of active calls
+}
+
+// maxCallDepth bounds the nesting of calls, which catches the recursion
+// through overloads that Compile cannot resolve before the Go stack
+// overflows.
+const maxCallDepth = 1000
+
+// NewMachine returns a new Machine with all global variables initialized.
+func (p *Program) NewMachine() (m *Machine, err error) {
+	m = &Machine{prog: p, globals: make([]Value, p.nglobals), stack: make([]Value, 0, 256)}
+	defer func() {
+		if r := recover(); r != nil {
+			m, err = nil, recoverError(r)
+		}
+	}()
+	for _, init := range p.inits {
+		init(m)
+	}
+	return m, nil
+}
+
+// Set sets the global variable or struct field named by path,
+// such as "u_slice" or "uniforms.u_slice". The numeric kind of v is
+// converted to the kind of the variable.
+func (m *Machine) Set(path string, v Value) error {
+	parts := strings.Split(path, ".")
+	slot, ok := m.prog.globals[parts[0]]
+	if !ok {
+		return fmt.Errorf("undefined global variable %q", parts[0])
+	}
+	out, err := setPath(m.globals[slot], parts[1:], v)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	m.globals[slot] = out
+	return nil
+}
+
+func setPath(dst Value, path []string, v Value) (Value, error) {
+	if len(path) == 0 {
+		if dst.k < kStruct && v.k < kStruct {
+			if dst.r != v.r || dst.c != v.c {
+				return Value{}, fmt.Errorf("cannot assign %v to %v", v, dst)
+			}
+			return v.convert(dst.k), nil
+		}
+		return v.clone(), nil
+	}
+	if dst.k != kStruct {
+		return Value{}, fmt.Errorf("not a struct")
+	}
+	i, ok := dst.st.index[path[0]]
+	if !ok {
+		return Value{}, fmt.Errorf("struct %v has no field %q", dst.st.name, path[0])
+	}
+	f, err := setPath(dst.s[i], path[1:], v)
+	if err != nil {
+		return Value{}, err
+	}
+	s := make([]Value, len(dst.s))
+	copy(s, dst.s)
+	s[i] = f
+	dst.s = s
+	return dst, nil
+}
+
+// Get returns the value of the global variable name.
+func (m *Machine) Get(name string) (Value, bool) {
+	slot, ok := m.prog.globals[name]
+	if !ok {
+		return Value{}, false
+	}
+	return m.globals[slot], true
+}
+
+// Call calls the function name with the provided arguments and returns
+// its result. It returns ErrDiscard if the shader discards the fragment.
+func (m *Machine) Call(name string, args ...Value) (ret Value, err error) {
+	fns := m.prog.funcs[name]
+	var cands []*function
+	for _, fn := range fns {
+		if len(fn.params) == len(args) {
+			cands = append(cands, fn)
+		}
+	}
+	fn := pickOverload(cands, args)
+	if fn == nil {
+		return Value{}, fmt.Errorf("no function %q matches %v arguments", name, len(args))
+	}
+
+	m.sp, m.depth = 0, 0
+	defer func() {
+		if r := recover(); r != nil {
+			ret, err = Value{}, recoverError(r)
+		}
+	}()
+	nb := m.push(fn.nlocals)
+	copy(m.stack[nb:], args)
+	return m.invoke(fn, nb, nb, nil, token{}), nil
+}
+
+func recoverError(r interface{}) error {
+	switch r := r.(type) {
+	case discardSignal:
+		return ErrDiscard
+	case *Error:
+		return r
+	}
+	panic(r)
+}
+
+// push reserves n stack slots and returns the index of the first one.
+func (m *Machine) push(n int) int {
+	nb := m.sp
+	m.sp += n
+	for len(m.stack) < m.sp {
+		m.stack = append(m.stack, Value{})
+	}
+	return nb
+}
+
+// invoke runs fn with its arguments already stored at stack index nb,
+// then copies "out" parameters back through outs in the caller's frame b.
+func (m *Machine) invoke(fn *function, nb, b int, outs []*lvalue, t token) Value {
+	for i, p := range fn.params {
+		v := coerce(m.stack[nb+i], p.typ, t)
+		m.stack[nb+i] = v
+	}
+	if m.depth++; m.depth > maxCallDepth {
+		fail(t, fmt.Errorf("calls of %q are nested more than %v deep; recursion is not allowed", fn.name, maxCallDepth))
+	}
+	m.ret = Value{k: kVoid}
+	fn.body(m, nb)
+	m.depth--
+	ret := m.ret
+	for i, out := range outs {
+		if out != nil && fn.params[i].out {
+			out.set(m, b, m.stack[nb+i])
+		}
+	}
+	m.sp = nb
+	return ret
+}
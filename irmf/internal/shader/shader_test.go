@@ -0,0 +1,388 @@
+package shader
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		src  string
+		fn   string
+		args []Value
+		want []float32
+	}{
+		{
+			name: "glsl sphere",
+			lang: "glsl",
+			src: `void mainModel4(out vec4 materials, in vec3 xyz) {
+  const float radius = 5.0;
+  float r = length(xyz);
+  materials[0] = r <= radius ? 1.0 : 0.0;
+}
+float f(vec3 xyz) { vec4 m; mainModel4(m, xyz); return m.x; }`,
+			fn:   "f",
+			args: []Value{Vec(1, 2, 3)},
+			want: []float32{1},
+		},
+		{
+			name: "wgsl sphere",
+			lang: "wgsl",
+			src: `fn mainModel4(xyz: vec3f) -> vec4f {
+  let radius = 5.0;
+  let r = length(xyz);
+  var materials = vec4f(0.0);
+  materials[0] = select(0.0, 1.0, r <= radius);
+  return materials;
+}`,
+			fn:   "mainModel4",
+			args: []Value{Vec(4, 4, 4)},
+			want: []float32{0, 0, 0, 0},
+		},
+		{
+			name: "wgsl array result",
+			lang: "wgsl",
+			src: `const N = 3;
+fn mainModel3(xyz: vec3f) -> array<f32, N + 1> {
+  var m: array<f32, N + 1>;
+  m[i32(xyz.x)] = 1.0;
+  return m;
+}
+fn f(xyz: vec3f) -> f32 {
+  var m = mainModel3(xyz);
+  return m[2] + m[3] * 2.0;
+}`,
+			fn:   "f",
+			args: []Value{Vec(3, 0, 0)},
+			want: []float32{2},
+		},
+		{
+			name: "glsl macros, loops and swizzles",
+			lang: "glsl",
+			src: `#define N 4
+#define SQ(x) ((x)*(x))
+vec3 f(float a) {
+  float sum = 0.0;
+  for (int i = 0; i < N; i++) {
+    if (i == 2) continue;
+    sum += SQ(float(i));
+  }
+  vec3 v = vec3(sum, a, 0.0);
+  v.zx = v.xy;
+  return v;
+}`,
+			fn:   "f",
+			args: []Value{Scalar(2)},
+			want: []float32{2, 2, 10},
+		},
+		{
+			name: "glsl overloads and matrices",
+			lang: "glsl",
+			src: `float g(float x) { return x + 1.0; }
+float g(vec2 x) { return x.x * x.y; }
+vec2 f() {
+  mat2 m = mat2(1.0, 2.0, 3.0, 4.0);
+  vec2 v = m * vec2(1.0, 1.0);
+  return vec2(g(v.x), g(v));
+}`,
+			fn:   "f",
+			want: []float32{5, 24},
+		},
+		{
+			name: "wgsl structs, loop and switch",
+			lang: "wgsl",
+			src: `struct S { a: f32, b: i32, };
+const K = 3;
+fn f(x: f32) -> f32 {
+  var s = S(x, 0);
+  var i = 0;
+  loop {
+    if i >= K { break; }
+    s.b += i;
+    i++;
+  }
+  switch s.b {
+    case 3: { s.a *= 2.0; }
+    default: { s.a = 0.0; }
+  }
+  return s.a;
+}`,
+			fn:   "f",
+			args: []Value{Scalar(1.5)},
+			want: []float32{3},
+		},
+		{
+			name: "overload calling an overload",
+			lang: "glsl",
+			src:  "float l(vec3 c) { return c.x + c.y; }\nfloat l(vec4 c) { return l(c.rgb) * c.a; }",
+			fn:   "l",
+			args: []Value{Vec(1, 2, 3, 4)},
+			want: []float32{12},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := Compile(tt.lang, tt.src)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			m, err := prog.NewMachine()
+			if err != nil {
+				t.Fatalf("NewMachine: %v", err)
+			}
+			got, err := m.Call(tt.fn, tt.args...)
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if got.Len() != len(tt.want) {
+				t.Fatalf("Call = %v, want %v", got, tt.want)
+			}
+			for i, w := range tt.want {
+				if g := got.At(i); math.Abs(float64(g-w)) > 1e-6 {
+					t.Errorf("Call = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		lang    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "undeclared identifier",
+			lang:    "glsl",
+			src:     "float f() {\n  return y;\n}",
+			wantErr: `line 2, col 10: undeclared identifier "y"`,
+		},
+		{
+			name:    "assign to let",
+			lang:    "wgsl",
+			src:     "fn f() -> f32 {\n  let x = 1.0;\n  x = 2.0;\n  return x;\n}",
+			wantErr: `line 3, col 3: cannot assign to constant "x"`,
+		},
+		{
+			name:    "unknown function",
+			lang:    "glsl",
+			src:     "float f() { return foo(1.0); }",
+			wantErr: `line 1, col 20: undefined function "foo"`,
+		},
+		{
+			name:    "recursion",
+			lang:    "glsl",
+			src:     "float g(float a) {\n  return g(a + 1.0);\n}",
+			wantErr: `line 2, col 10: recursive call of "g" is not allowed: g -> g`,
+		},
+		{
+			name:    "mutual recursion",
+			lang:    "wgsl",
+			src:     "fn f(a: f32) -> f32 { return g(a); }\nfn g(a: f32) -> f32 { return h(a) + 1.0; }\nfn h(a: f32) -> f32 { return f(a); }",
+			wantErr: `line 3, col 30: recursive call of "f" is not allowed: f -> g -> h -> f`,
+		},
+		{
+			name:    "recursion through an overload",
+			lang:    "glsl",
+			src:     "float l(vec3 c) { return c.x; }\nfloat l(vec4 c) { return l(c.xyzw); }",
+			wantErr: `line 2, col 26: recursive call of "l" is not allowed: l -> l`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.lang, tt.src)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Compile error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecursionAtRunTime(t *testing.T) {
+	// The argument type of the call of l is unknown to Compile.
+	prog, err := Compile("glsl", "vec4 k(vec4 c) { return c; }\nfloat l(vec3 c) { return c.x; }\nfloat l(vec4 c) { return l(k(c)); }")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	m, err := prog.NewMachine()
+	if err != nil {
+		t.Fatalf("NewMachine: %v", err)
+	}
+	var se *Error
+	if _, err := m.Call("l", Vec(1, 2, 3, 4)); !errors.As(err, &se) || !strings.Contains(err.Error(), "recursion is not allowed") {
+		t.Errorf("Call error = %v, want a recursion *Error", err)
+	}
+	if got, err := m.Call("l", Vec(1, 2, 3)); err != nil || got.At(0) != 1 {
+		t.Errorf("Call after the error = %v, %v, want 1", got, err)
+	}
+}
+
+func TestSetAndDiscard(t *testing.T) {
+	prog, err := Compile("glsl", `uniform float u_slice;
+out vec4 outputColor;
+void main() {
+  if (u_slice < 0.0) discard;
+  outputColor = vec4(u_slice);
+}`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	m, err := prog.NewMachine()
+	if err != nil {
+		t.Fatalf("NewMachine: %v", err)
+	}
+	if err := m.Set("u_slice", Int(2)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := m.Call("main"); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if got, _ := m.Get("outputColor"); got.At(3) != 2 {
+		t.Errorf("outputColor = %v, want 2", got)
+	}
+	if err := m.Set("u_slice", Scalar(-1)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := m.Call("main"); !errors.Is(err, ErrDiscard) {
+		t.Errorf("Call error = %v, want ErrDiscard", err)
+	}
+}
+
+func TestElem(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		src  string
+		get  func(m *Machine) (Value, error)
+	}{
+		{
+			name: "glsl output array",
+			lang: "glsl",
+			src: `out vec4 outputColors[2];
+void main() {
+  outputColors[0] = vec4(1.0);
+  outputColors[1] = vec4(2.0);
+}`,
+			get: func(m *Machine) (Value, error) {
+				if _, err := m.Call("main"); err != nil {
+					return Value{}, err
+				}
+				v, _ := m.Get("outputColors")
+				return v, nil
+			},
+		},
+		{
+			name: "wgsl struct result",
+			lang: "wgsl",
+			src: `struct Colors {
+  @location(0) c0: vec4f,
+  @location(1) c1: vec4f,
+};
+fn f() -> Colors {
+  return Colors(vec4f(1.0), vec4f(2.0));
+}`,
+			get: func(m *Machine) (Value, error) { return m.Call("f") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := Compile(tt.lang, tt.src)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			m, err := prog.NewMachine()
+			if err != nil {
+				t.Fatalf("NewMachine: %v", err)
+			}
+			v, err := tt.get(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range []float32{1, 2} {
+				if got := v.Elem(i); got.Len() != 4 || got.At(3) != want {
+					t.Errorf("Elem(%v) = %v, want vec4(%v)", i, got, want)
+				}
+			}
+			if got := v.Elem(2); got.Len() != 0 {
+				t.Errorf("Elem(2) = %v, want void", got)
+			}
+		})
+	}
+}
+
+func TestFindFuncs(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		src  string
+		want []string
+	}{
+		{
+			name: "glsl",
+			lang: "glsl",
+			src:  "#define mainModel4(x) x\n// void mainModel4();\nfloat f() { mainModel4(1.0); }\nvoid mainModel4(out highp vec4 m, in vec3 xyz);\nvoid mainModel4(out vec4 m, vec3 xyz) {}",
+			want: []string{
+				"4:6 void mainModel4(out highp vec4 m, in vec3 xyz) body=false",
+				"5:6 void mainModel4(out vec4 m, vec3 xyz) body=true",
+			},
+		},
+		{
+			name: "glsl void parameters",
+			lang: "glsl",
+			src:  "struct S { float mainModel4; };\nvoid mainModel4(void) {}",
+			want: []string{"2:6 void mainModel4() body=true"},
+		},
+		{
+			name: "glsl arrays",
+			lang: "glsl",
+			src:  "void mainModel4(out float m[N + 1], float[3] xyz) {}",
+			want: []string{"1:6 void mainModel4(out float[N+1] m, float[3] xyz) body=true"},
+		},
+		{
+			name: "wgsl",
+			lang: "wgsl",
+			src:  "/* fn mainModel4() */\nfn mainModel4(@location(0) xyz: vec3<f32>,) -> @location(0) vec4f {\n  return mainModel4(xyz);\n}",
+			want: []string{"2:4 vec4f mainModel4(xyz vec3<f32>) body=true"},
+		},
+		{
+			name: "wgsl array",
+			lang: "wgsl",
+			src:  "fn mainModel4(xyz: vec3f) -> array<f32, 24u> {}",
+			want: []string{"1:4 array<f32,24u> mainModel4(xyz vec3f) body=true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decls, err := FindFuncs(tt.lang, tt.src, "mainModel4")
+			if err != nil {
+				t.Fatalf("FindFuncs: %v", err)
+			}
+			var got []string
+			for _, d := range decls {
+				var params []string
+				for _, p := range d.Params {
+					if tt.lang == "wgsl" {
+						params = append(params, p.Name+" "+p.Type)
+					} else {
+						params = append(params, p.String())
+					}
+				}
+				got = append(got, fmt.Sprintf("%v:%v %v %v(%v) body=%v", d.Line, d.Col, d.Result, d.Name, strings.Join(params, ", "), d.Body))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("FindFuncs =\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package shader
+
+import "strings"
+
+// FuncDecl represents a top-level function declaration found by FindFuncs.
+type FuncDecl struct {
+	Name   string
+	Params []Param
+	Result string // return type ("void" in GLSL, "" if none in WGSL)
+	Body   bool   // false for a GLSL prototype
+	Line   int    // position of the function name
+	Col    int
+}
+
+// Param represents a function parameter.
+type Param struct {
+	Qualifiers []string // GLSL only, e.g. "out" or "highp"
+	Type       string   // e.g. "vec3" or "vec3<f32>"
+	Name       string
+	Line       int // position of the parameter
+	Col        int
+}
+
+// String returns the parameter as it would be declared.
+func (p Param) String() string {
+	var parts []string
+	parts = append(parts, p.Qualifiers...)
+	if p.Type != "" {
+		parts = append(parts, p.Type)
+	}
+	if p.Name != "" {
+		parts = append(parts, p.Name)
+	}
+	return strings.Join(parts, " ")
+}
+
+// FindFuncs returns the top-level declarations of the function name in src
+// without compiling it. lang is either "glsl" or "wgsl". Comments and
+// preprocessor directives are skipped, but macros are not expanded.
+func FindFuncs(lang, src, name string) ([]FuncDecl, error) {
+	var lx lexer
+	var toks []token
+	for i, line := range strings.Split(src, "\n") {
+		lt, err := lx.tokenizeLine(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(lt) > 0 && lt[0].text == "#" {
+			continue
+		}
+		toks = append(toks, lt...)
+	}
+
+	var decls []FuncDecl
+	depth := 0
+	for i := 0; i < len(toks); i++ {
+		switch toks[i].text {
+		case "{":
+			depth++
+			continue
+		case "}":
+			depth--
+			continue
+		}
+		if depth != 0 || toks[i].text != name || i+1 >= len(toks) || toks[i+1].text != "(" {
+			continue
+		}
+
+		var d FuncDecl
+		var ok bool
+		if lang == "wgsl" {
+			if i == 0 || toks[i-1].text != "fn" {
+				continue
+			}
+			d, ok = wgslDecl(toks, i)
+		} else {
+			if i == 0 || toks[i-1].kind != tIdent {
+				continue
+			}
+			d, ok = glslDecl(toks, i)
+		}
+		if ok {
+			decls = append(decls, d)
+		}
+	}
+	return decls, nil
+}
+
+// splitParams returns the tokens of each parameter of the function whose
+// "(" is at toks[open], and the index of the closing ")".
+func splitParams(toks []token, open int) ([][]token, int, bool) {
+	var params [][]token
+	var cur []token
+	depth := 0
+	for i := open + 1; i < len(toks); i++ {
+		switch t := toks[i].text; {
+		case (t == ")" || t == ",") && depth == 0:
+			if len(cur) > 0 || t == "," {
+				params = append(params, cur)
+			}
+			if t == ")" {
+				return params, i, true
+			}
+			cur = nil
+			continue
+		case t == "(" || t == "[" || t == "<":
+			depth++
+		case t == ")" || t == "]" || t == ">":
+			depth--
+		}
+		cur = append(cur, toks[i])
+	}
+	return nil, 0, false
+}
+
+func glslDecl(toks []token, i int) (FuncDecl, bool) {
+	d := FuncDecl{Name: toks[i].text, Result: toks[i-1].text, Line: toks[i].line, Col: toks[i].col}
+	params, end, ok := splitParams(toks, i+1)
+	if !ok || end+1 >= len(toks) {
+		return d, false
+	}
+	switch toks[end+1].text {
+	case "{":
+		d.Body = true
+	case ";":
+	default:
+		return d, false // not a declaration
+	}
+	if len(params) == 1 && len(params[0]) == 1 && params[0][0].text == "void" {
+		params = nil
+	}
+
+	for _, pt := range params {
+		var p Param
+		if len(pt) > 0 {
+			p.Line, p.Col = pt[0].line, pt[0].col
+		}
+		j := 0
+		for ; j < len(pt) && glslQualifiers[pt[j].text]; j++ {
+			p.Qualifiers = append(p.Qualifiers, pt[j].text)
+		}
+		if j < len(pt) {
+			p.Type = pt[j].text
+			j++
+		}
+		dims, j := arrayDims(pt, j)
+		if j < len(pt) && pt[j].kind == tIdent {
+			p.Name = pt[j].text
+			j++
+		}
+		// The array size may follow either the type or the name.
+		nameDims, _ := arrayDims(pt, j)
+		p.Type += dims + nameDims
+		d.Params = append(d.Params, p)
+	}
+	return d, true
+}
+
+func wgslDecl(toks []token, i int) (FuncDecl, bool) {
+	d := FuncDecl{Name: toks[i].text, Body: true, Line: toks[i].line, Col: toks[i].col}
+	params, end, ok := splitParams(toks, i+1)
+	if !ok {
+		return d, false
+	}
+
+	for _, pt := range params {
+		pt = skipAttributes(pt)
+		var p Param
+		if len(pt) > 0 {
+			p.Line, p.Col = pt[0].line, pt[0].col
+			p.Name = pt[0].text
+		}
+		if len(pt) > 2 && pt[1].text == ":" {
+			p.Type = typeText(pt[2:])
+		}
+		d.Params = append(d.Params, p)
+	}
+
+	j := end + 1
+	if j < len(toks) && toks[j].text == "->" {
+		var result []token
+		for j++; j < len(toks) && toks[j].text != "{"; j++ {
+			result = append(result, toks[j])
+		}
+		d.Result = typeText(skipAttributes(result))
+	}
+	return d, true
+}
+
+// arrayDims returns the text of the GLSL array sizes (such as "[24]")
+// starting at toks[i], and the index of the token that follows them.
+func arrayDims(toks []token, i int) (string, int) {
+	var b strings.Builder
+	for i < len(toks) && toks[i].text == "[" {
+		for ; i < len(toks); i++ {
+			b.WriteString(toks[i].text)
+			if toks[i].text == "]" {
+				i++
+				break
+			}
+		}
+	}
+	return b.String(), i
+}
+
+// skipAttributes removes leading WGSL attributes such as "@location(0)".
+func skipAttributes(toks []token) []token {
+	for len(toks) >= 2 && toks[0].text == "@" {
+		toks = toks[2:]
+		if len(toks) > 0 && toks[0].text == "(" {
+			depth := 0
+			for len(toks) > 0 {
+				t := toks[0].text
+				toks = toks[1:]
+				if t == "(" {
+					depth++
+				} else if t == ")" {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+		}
+	}
+	return toks
+}
+
+// typeText returns the text of a type, such as "vec3<f32>".
+func typeText(toks []token) string {
+	var b strings.Builder
+	for _, t := range toks {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
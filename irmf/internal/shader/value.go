@@ -0,0 +1,243 @@
+package shader
+
+import (
+	"fmt"
+	"math"
+)
+
+// kind represents the component type of a Value.
+type kind uint8
+
+const (
+	kFloat kind = iota
+	kInt
+	kUint
+	kBool
+	kStruct
+	kArray
+	kVoid
+	kInfer // WGSL constructors like "vec3(...)" infer their component type.
+)
+
+func (k kind) String() string {
+	switch k {
+	case kFloat:
+		return "float"
+	case kInt:
+		return "int"
+	case kUint:
+		return "uint"
+	case kBool:
+		return "bool"
+	case kStruct:
+		return "struct"
+	case kArray:
+		return "array"
+	case kVoid:
+		return "void"
+	}
+	return "unknown"
+}
+
+// Value represents a scalar, vector, matrix, struct or array value.
+//
+// Scalars have 1 row and 1 column, vectors have N rows and 1 column
+// and matrices have C columns of R rows stored in column-major order.
+// Components are stored as raw 32-bit patterns of their kind.
+type Value struct {
+	k    kind
+	r, c uint8
+	v    [16]uint32
+
+	s  []Value     // struct fields or array elements
+	st *structType // struct type
+}
+
+// Scalar returns a float scalar Value.
+func Scalar(f float32) Value {
+	v := Value{k: kFloat, r: 1, c: 1}
+	v.v[0] = math.Float32bits(f)
+	return v
+}
+
+// Int returns an int scalar Value.
+func Int(i int32) Value {
+	v := Value{k: kInt, r: 1, c: 1}
+	v.v[0] = uint32(i)
+	return v
+}
+
+// Vec returns a float vector Value with the provided components.
+func Vec(xs ...float32) Value {
+	v := Value{k: kFloat, r: uint8(len(xs)), c: 1}
+	for i, x := range xs {
+		v.v[i] = math.Float32bits(x)
+	}
+	return v
+}
+
+// Len returns the number of numeric components in the Value.
+func (v Value) Len() int {
+	if v.k == kStruct || v.k == kArray || v.k == kVoid {
+		return 0
+	}
+	return int(v.r) * int(v.c)
+}
+
+// At returns the i-th component of the Value converted to a float32.
+func (v Value) At(i int) float32 {
+	return float32(v.num(i))
+}
+
+func (v Value) String() string {
+	switch v.k {
+	case kStruct, kArray:
+		return fmt.Sprintf("%v%v", v.k, v.s)
+	case kVoid:
+		return "void"
+	}
+	if v.Len() == 1 {
+		return fmt.Sprintf("%v(%v)", v.k, v.num(0))
+	}
+	var parts []float64
+	for i := 0; i < v.Len(); i++ {
+		parts = append(parts, v.num(i))
+	}
+	return fmt.Sprintf("%v%vx%v%v", v.k, v.c, v.r, parts)
+}
+
+func (v *Value) f(i int) float32       { return math.Float32frombits(v.v[i]) }
+func (v *Value) setF(i int, f float32) { v.v[i] = math.Float32bits(f) }
+
+func (v *Value) isScalar() bool { return v.r == 1 && v.c == 1 && v.k < kStruct }
+func (v *Value) isVector() bool { return v.r > 1 && v.c == 1 && v.k < kStruct }
+func (v *Value) isMatrix() bool { return v.c > 1 && v.k < kStruct }
+func (v *Value) isNumeric() bool {
+	return v.k == kFloat || v.k == kInt || v.k == kUint
+}
+
+// num returns the i-th component as a float64.
+func (v *Value) num(i int) float64 {
+	switch v.k {
+	case kFloat:
+		return float64(math.Float32frombits(v.v[i]))
+	case kInt:
+		return float64(int32(v.v[i]))
+	case kUint:
+		return float64(v.v[i])
+	case kBool:
+		if v.v[i] != 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// setNum sets the i-th component from a float64.
+func (v *Value) setNum(i int, x float64) {
+	switch v.k {
+	case kFloat:
+		v.v[i] = math.Float32bits(float32(x))
+	case kInt:
+		v.v[i] = uint32(toInt32(x))
+	case kUint:
+		v.v[i] = toUint32(x)
+	case kBool:
+		if x != 0 {
+			v.v[i] = 1
+		} else {
+			v.v[i] = 0
+		}
+	}
+}
+
+func toInt32(x float64) int32 {
+	switch {
+	case math.IsNaN(x):
+		return 0
+	case x >= math.MaxInt32:
+		return math.MaxInt32
+	case x <= math.MinInt32:
+		return math.MinInt32
+	}
+	return int32(x)
+}
+
+func toUint32(x float64) uint32 {
+	switch {
+	case math.IsNaN(x), x <= 0:
+		if x <= -1 {
+			return uint32(toInt32(x))
+		}
+		return 0
+	case x >= math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(x)
+}
+
+// convert returns the value with its components converted to kind k.
+func (v Value) convert(k kind) Value {
+	if v.k == k || k == kInfer || v.k >= kStruct || k >= kStruct {
+		return v
+	}
+	out := Value{k: k, r: v.r, c: v.c}
+	for i := 0; i < v.Len(); i++ {
+		out.setNum(i, v.num(i))
+	}
+	return out
+}
+
+// clone returns a deep copy of structs and arrays so that
+// they keep their value semantics.
+func (v Value) clone() Value {
+	if v.s == nil {
+		return v
+	}
+	s := make([]Value, len(v.s))
+	for i, e := range v.s {
+		s[i] = e.clone()
+	}
+	v.s = s
+	return v
+}
+
+func boolValue(b bool) Value {
+	v := Value{k: kBool, r: 1, c: 1}
+	if b {
+		v.v[0] = 1
+	}
+	return v
+}
+
+func (v *Value) truth() bool {
+	return v.v[0] != 0
+}
+
+// sameShape reports whether a and b have identical shapes.
+func sameShape(a, b *Value) bool {
+	return a.r == b.r && a.c == b.c
+}
+
+// promote returns the wider of two numeric kinds.
+func promote(a, b kind) kind {
+	if a == kFloat || b == kFloat {
+		return kFloat
+	}
+	if a == kUint || b == kUint {
+		return kUint
+	}
+	if a == kBool && b == kBool {
+		return kBool
+	}
+	return kInt
+}
+
+// Elem returns the i-th element of an array or the i-th field of a struct
+// (in declaration order), or a void Value if there is no such element.
+func (v Value) Elem(i int) Value {
+	if (v.k != kArray && v.k != kStruct) || i < 0 || i >= len(v.s) {
+		return Value{k: kVoid}
+	}
+	return v.s[i]
+}
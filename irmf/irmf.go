@@ -9,9 +9,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 )
 
@@ -34,6 +34,10 @@ type IRMF struct {
 	Version     string          `json:"version,omitempty"`
 
 	Shader string `json:"-"`
+
+	goFunc       ModelFunc          // set by NewGoModel
+	lineMap      []sourceLine       // origin of each line of Shader
+	optionValues map[string]float64 // set by SetOption
 }
 
 var (
@@ -57,20 +61,67 @@ var (
 	trailingCommaRE = regexp.MustCompile(`,[\s\n]*}`)
 )
 
+// Parse parses and validates the IRMF source file without resolving any
+// "#include" lines or using the GPU. See ParseWithIncludes.
+func Parse(src []byte) (*IRMF, []Diagnostic) {
+	return parse(src, nil)
+}
+
+// ParseWithIncludes parses the IRMF source file, resolves its "#include"
+// lines as specified by opts, and validates the result without using the GPU.
+//
+// All problems found are reported as diagnostics, errors and warnings
+// together, in the order they were found. The returned model is nil only
+// if the file could not be parsed at all; it must not be sliced if any
+// of the diagnostics is an error.
+func ParseWithIncludes(src []byte, opts IncludeOptions) (*IRMF, []Diagnostic) {
+	return parse(src, &opts)
+}
+
 // newModel parses the IRMF source file and returns a new IRMF struct.
-func newModel(src []byte) (*IRMF, error) {
+func newModel(src []byte, opts IncludeOptions) (*IRMF, error) {
+	m, diags := parse(src, &opts)
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return nil, d.asError()
+		}
+	}
+	return m, nil
+}
+
+// parse parses the IRMF source file. If opts is nil, "#include" lines are
+// left as-is, and the checks that depend on the shader source are skipped
+// if there are any.
+func parse(src []byte, opts *IncludeOptions) (*IRMF, []Diagnostic) {
+	fail := func(line int, key string, err error) (*IRMF, []Diagnostic) {
+		return nil, []Diagnostic{{Severity: SeverityError, Line: line, Key: key, Message: err.Error(), err: err}}
+	}
+
 	if bytes.Index(src, []byte("/*{")) != 0 {
-		return nil, errors.New(`Unable to find leading "/*{"`)
+		return fail(1, "", errors.New(`Unable to find leading "/*{"`))
 	}
 	endJSON := bytes.Index(src, []byte("\n}*/\n"))
 	if endJSON < 0 {
-		return nil, errors.New(`Unable to find trailing "}*/"`)
+		return fail(0, "", errors.New(`Unable to find trailing "}*/"`))
 	}
 
 	jsonBlobStr := string(src[2 : endJSON+2])
-	jsonBlob, err := parseJSON(jsonBlobStr)
+	jsonBlob, unknownKeys, err := parseJSON(jsonBlobStr)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse JSON blob: %v", err)
+		var line int
+		var key string
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			key = typeErr.Field
+			line = findKeyLine(jsonBlobStr, key)
+		}
+		return fail(line, key, fmt.Errorf("unable to parse JSON blob: %v", err))
+	}
+
+	var diags []Diagnostic
+	for _, key := range unknownKeys {
+		line, col := findKey(jsonBlobStr, key)
+		diags = append(diags, Diagnostic{Severity: SeverityWarning, Line: line, Column: col, Key: key, Message: fmt.Sprintf("unknown key %q is ignored", key)})
 	}
 
 	shaderSrcBuf := src[endJSON+5:]
@@ -90,34 +141,68 @@ func newModel(src []byte) (*IRMF, error) {
 		return nil
 	}
 
+	// Line numbers in errors refer to the IRMF file unless the shader is encoded.
+	firstLine := 1
 	if jsonBlob.Encoding != nil && *jsonBlob.Encoding == "gzip+base64" {
 		data, err := base64.RawStdEncoding.DecodeString(string(shaderSrcBuf))
 		if err != nil {
-			return nil, fmt.Errorf("uudecode error: %v", err)
+			return fail(findKeyLine(jsonBlobStr, "encoding"), "encoding", fmt.Errorf("uudecode error: %v", err))
 		}
 		if err := unzip(data); err != nil {
-			return nil, fmt.Errorf("unzip: %v", err)
+			return fail(findKeyLine(jsonBlobStr, "encoding"), "encoding", fmt.Errorf("unzip: %v", err))
 		}
-		jsonBlob.Encoding = nil
 	} else if jsonBlob.Encoding != nil && *jsonBlob.Encoding == "gzip" {
 		if err := unzip(shaderSrcBuf); err != nil {
-			return nil, fmt.Errorf("unzip: %v", err)
+			return fail(findKeyLine(jsonBlobStr, "encoding"), "encoding", fmt.Errorf("unzip: %v", err))
 		}
-		jsonBlob.Encoding = nil
 	} else {
 		jsonBlob.Shader = string(shaderSrcBuf)
+		firstLine = bytes.Count(src[:endJSON+5], []byte("\n")) + 1
 	}
 
-	jsonBlob.Shader = processIncludes(jsonBlob.Shader)
+	checkShader := opts != nil || !hasIncludes(jsonBlob.Shader)
+	if opts == nil {
+		for n := range strings.Count(jsonBlob.Shader, "\n") + 1 {
+			jsonBlob.lineMap = append(jsonBlob.lineMap, sourceLine{file: "<shader>", line: firstLine + n})
+		}
+	} else {
+		if jsonBlob.Shader, jsonBlob.lineMap, err = newIncludeResolver(*opts).processIncludes(jsonBlob.Shader, firstLine); err != nil {
+			d := Diagnostic{Severity: SeverityError, Message: err.Error(), err: err}
+			var ie *IncludeError
+			if errors.As(err, &ie) {
+				d.Line = ie.Line
+				d.Message = fmt.Sprintf("#include %q: %v", ie.Include, ie.Err)
+			}
+			diags = append(diags, d)
+			checkShader = false
+		}
+	}
 
-	if lineNum, err := jsonBlob.validate(jsonBlobStr, jsonBlob.Shader); err != nil {
-		return nil, fmt.Errorf("invalid JSON blob on line %v: %v", lineNum, err)
+	for _, d := range jsonBlob.check(jsonBlobStr, jsonBlob.Shader, checkShader) {
+		switch {
+		case d.Key != "":
+			d.err = fmt.Errorf("invalid JSON blob on line %v: %v", d.Line, d.err)
+		case d.Line > 0:
+			d.err = fmt.Errorf("line %v: %v", d.Line, d.err)
+		}
+		diags = append(diags, d)
 	}
 
-	return jsonBlob, nil
+	return jsonBlob, diags
+}
+
+// hasIncludes reports whether source has any "#include" lines.
+func hasIncludes(source string) bool {
+	for _, line := range strings.Split(source, "\n") {
+		if includeRE.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+	return false
 }
 
-func parseJSON(s string) (*IRMF, error) {
+// parseJSON parses the JSON blob and also returns any unknown keys in it.
+func parseJSON(s string) (*IRMF, []string, error) {
 	result := &IRMF{}
 
 	// Avoid the trailing comma silliness in JavaScript:
@@ -129,88 +214,137 @@ func parseJSON(s string) (*IRMF, error) {
 			s = strings.Replace(s, key+":", fmt.Sprintf("%q:", key), 1)
 		}
 		if err := json.Unmarshal([]byte(s), result); err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s), &fields); err != nil {
+		return nil, nil, err
+	}
+	var unknownKeys []string
+	for key := range fields {
+		if !slices.Contains(jsonKeys, key) {
+			unknownKeys = append(unknownKeys, key)
 		}
 	}
-	return result, nil
+	sort.Strings(unknownKeys)
+	return result, unknownKeys, nil
 }
 
+// validate returns the first error found by check, and its line number.
 func (i *IRMF) validate(jsonBlobStr, shaderSrc string) (int, error) {
-	if i.IRMFVersion != "1.0" {
-		return findKeyLine(jsonBlobStr, "irmf"), fmt.Errorf("unsupported IRMF version: %v", i.IRMFVersion)
+	for _, d := range i.check(jsonBlobStr, shaderSrc, true) {
+		if d.Severity == SeverityError {
+			return d.Line, d.asError()
+		}
+	}
+	return 0, nil
+}
+
+// check validates the model and returns all problems found. jsonBlobStr
+// is only used to locate the keys. If checkShader is false, the checks
+// that depend on shaderSrc are skipped.
+func (i *IRMF) check(jsonBlobStr, shaderSrc string, checkShader bool) []Diagnostic {
+	var diags []Diagnostic
+	report := func(severity Severity, key string, err error) {
+		line, col := findKey(jsonBlobStr, key)
+		diags = append(diags, Diagnostic{Severity: severity, Line: line, Column: col, Key: key, Message: err.Error(), err: err})
+	}
+	errorf := func(key, format string, args ...interface{}) {
+		report(SeverityError, key, fmt.Errorf(format, args...))
+	}
+	warnf := func(key, format string, args ...interface{}) {
+		report(SeverityWarning, key, fmt.Errorf(format, args...))
+	}
+
+	if i.IRMFVersion != "1.0" && i.IRMFVersion != "1.1" {
+		errorf("irmf", "unsupported IRMF version: %v", i.IRMFVersion)
 	}
 	if len(i.Materials) < 1 {
-		return findKeyLine(jsonBlobStr, "materials"), errors.New("must list at least one material name")
+		errorf("materials", "must list at least one material name")
 	}
-	if len(i.Materials) > 16 {
-		return findKeyLine(jsonBlobStr, "materials"), fmt.Errorf("IRMF 1.0 only supports up to 16 materials, found %v", len(i.Materials))
+	if len(i.Materials) > 16 && i.IRMFVersion == "1.0" {
+		errorf("materials", "IRMF 1.0 only supports up to 16 materials, found %v (use \"irmf\": \"1.1\" for more)", len(i.Materials))
+	}
+	if len(i.Materials) > maxMaterials {
+		errorf("materials", "at most %v materials are supported, found %v", maxMaterials, len(i.Materials))
+	}
+	seen := map[string]bool{}
+	for n, name := range i.Materials {
+		switch {
+		case strings.TrimSpace(name) == "":
+			warnf("materials", "material #%v has no name", n+1)
+		case seen[name]:
+			warnf("materials", "material name %q is used more than once", name)
+		}
+		seen[name] = true
 	}
 	if len(i.Max) != 3 {
-		return findKeyLine(jsonBlobStr, "max"), fmt.Errorf("max must have only 3 values, found %v", len(i.Max))
+		errorf("max", "max must have only 3 values, found %v", len(i.Max))
 	}
 	if len(i.Min) != 3 {
-		return findKeyLine(jsonBlobStr, "min"), fmt.Errorf("min must have only 3 values, found %v", len(i.Min))
+		errorf("min", "min must have only 3 values, found %v", len(i.Min))
 	}
 	if i.Units == "" {
-		return findKeyLine(jsonBlobStr, "units"), errors.New("units are required by IRMF 1.0 (even though the irmf-editor ignores the units)")
+		errorf("units", "units are required by IRMF 1.0 (even though the irmf-editor ignores the units)")
+	} else if _, err := i.MMPerUnit(); err != nil {
+		report(SeverityError, "units", err)
 	}
-	if i.Min[0] >= i.Max[0] {
-		return findKeyLine(jsonBlobStr, "max"), fmt.Errorf("min.x (%v) must be strictly less than max.x (%v)", i.Min[0], i.Max[0])
-	}
-	if i.Min[1] >= i.Max[1] {
-		return findKeyLine(jsonBlobStr, "max"), fmt.Errorf("min.y (%v) must be strictly less than max.y (%v)", i.Min[1], i.Max[1])
+	if len(i.Min) == 3 && len(i.Max) == 3 {
+		for n, axis := range []string{"x", "y", "z"} {
+			if i.Min[n] >= i.Max[n] {
+				errorf("max", "min.%v (%v) must be strictly less than max.%v (%v)", axis, i.Min[n], axis, i.Max[n])
+			}
+		}
 	}
-	if i.Min[2] >= i.Max[2] {
-		return findKeyLine(jsonBlobStr, "max"), fmt.Errorf("min.z (%v) must be strictly less than max.z (%v)", i.Min[2], i.Max[2])
+
+	if _, err := i.OptionDefs(); err != nil {
+		report(SeverityError, "options", err)
 	}
 
 	switch i.Language {
-	case "glsl", "":
-		if len(i.Materials) <= 4 && !strings.Contains(shaderSrc, "mainModel4") {
-			return findKeyLine(jsonBlobStr, "materials"), fmt.Errorf("Found %v materials, but missing 'mainModel4' function", len(i.Materials))
+	case "glsl", "", "wgsl":
+		if i.Language == "wgsl" && i.GLSLVersion != "" {
+			warnf("glslVersion", "glslVersion is ignored for WGSL shaders")
 		}
-
-		if len(i.Materials) > 4 && len(i.Materials) <= 9 && !strings.Contains(shaderSrc, "mainModel9") {
-			return findKeyLine(jsonBlobStr, "materials"), fmt.Errorf("Found %v materials, but missing 'mainModel9' function", len(i.Materials))
-		}
-
-		if len(i.Materials) > 9 && len(i.Materials) <= 16 && !strings.Contains(shaderSrc, "mainModel16") {
-			return findKeyLine(jsonBlobStr, "materials"), fmt.Errorf("Found %v materials, but missing 'mainModel16' function", len(i.Materials))
+		if checkShader {
+			diags = append(diags, i.checkEntryPoint(jsonBlobStr, shaderSrc)...)
 		}
-	case "wgsl":
-		if len(i.Materials) <= 4 && !strings.Contains(shaderSrc, "fn mainModel4") {
-			return findKeyLine(jsonBlobStr, "materials"), fmt.Errorf("Found %v materials, but missing 'fn mainModel4' function", len(i.Materials))
-		}
-
-		if len(i.Materials) > 4 && len(i.Materials) <= 9 && !strings.Contains(shaderSrc, "fn mainModel9") {
-			return findKeyLine(jsonBlobStr, "materials"), fmt.Errorf("Found %v materials, but missing 'fn mainModel9' function", len(i.Materials))
-		}
-
-		if len(i.Materials) > 9 && len(i.Materials) <= 16 && !strings.Contains(shaderSrc, "fn mainModel16") {
-			return findKeyLine(jsonBlobStr, "materials"), fmt.Errorf("Found %v materials, but missing 'fn mainModel16' function", len(i.Materials))
+	case "go":
+		if i.goFunc == nil {
+			errorf("language", "language 'go' is only supported by models created with NewGoModel")
 		}
 	default:
-		return findKeyLine(jsonBlobStr, "language"), fmt.Errorf("unsupported language: %v", i.Language)
+		errorf("language", "unsupported language: %v", i.Language)
 	}
 
 	if i.Encoding != nil && *i.Encoding != "" && *i.Encoding != "gzip" && *i.Encoding != "gzip+base64" {
-		return findKeyLine(jsonBlobStr, "encoding"), errors.New("Unsupported encoding. Possible values are 'gzip' or 'gzip+base64'")
+		errorf("encoding", "Unsupported encoding. Possible values are 'gzip' or 'gzip+base64'")
 	}
 
-	return 0, nil
+	return diags
 }
 
 func findKeyLine(s, key string) int {
-	if i := strings.Index(s, fmt.Sprintf("%q:", key)); i >= 0 {
-		return indexToLineNum(s, i)
-	}
-	if i := strings.Index(s, fmt.Sprintf("%v:", key)); i >= 0 {
-		return indexToLineNum(s, i)
-	}
-	if i := strings.Index(s, key); i >= 0 {
-		return indexToLineNum(s, i)
+	line, _ := findKey(s, key)
+	return line
+}
+
+// findKey returns the 1-based line and column of key in the JSON blob s,
+// falling back to the top of the JSON blob (with an unknown column).
+func findKey(s, key string) (line, col int) {
+	for _, k := range []string{fmt.Sprintf("%q:", key), fmt.Sprintf("%v:", key), key} {
+		if i := strings.Index(s, k); i >= 0 {
+			line = indexToLineNum(s, i)
+			col = i - strings.LastIndex(s[:i], "\n")
+			if line == 1 {
+				col += len("/*")
+			}
+			return line, col
+		}
 	}
-	return 2 // Fall back to top of json blob.
+	return 2, 0 // Fall back to top of json blob.
 }
 
 func indexToLineNum(s string, offset int) int {
@@ -218,24 +352,6 @@ func indexToLineNum(s string, offset int) int {
 	return strings.Count(s, "\n") + 1
 }
 
-func curl(url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Unable to download source from: %v", url)
-		return nil, nil
-	}
-	defer resp.Body.Close()
-
-	buf, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Unable to read response body.")
-		return nil, nil
-	}
-	log.Printf("Read %v bytes from %v", len(buf), url)
-
-	return buf, nil
-}
-
 var (
 	includeRE = regexp.MustCompile(`^#include\s+"([^"]+)"`)
 )
@@ -253,8 +369,12 @@ func parseIncludeURL(trimmed string) string {
 	if len(m) < 2 {
 		return ""
 	}
+	return includeURL(m[1])
+}
 
-	inc := m[1]
+// includeURL returns the download URL of the include file inc,
+// or "" if it is not a recognized LYGIA or GitHub file.
+func includeURL(inc string) string {
 	if !strings.HasSuffix(inc, ".glsl") && !strings.HasSuffix(inc, ".wgsl") {
 		return ""
 	}
@@ -272,26 +392,3 @@ func parseIncludeURL(trimmed string) string {
 		return ""
 	}
 }
-
-// processIncludes converts "#include" lines (with recognized prefixes)
-// into their actual source by retrieving them from the internet.
-// Note that multiline comments ("/*" and "*/") are currently not supported.
-// It is recommended that an ignored "#include" statement should be commented-out
-// with single-line comments ("//...").
-func processIncludes(source string) string {
-	lines := strings.Split(source, "\n")
-	var result []string
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if url := parseIncludeURL(trimmed); url != "" {
-			if buf, err := curl(url); err == nil {
-				result = append(result, string(buf))
-			}
-			continue
-		}
-
-		result = append(result, line)
-	}
-
-	return strings.Join(result, "\n")
-}
@@ -0,0 +1,132 @@
+package irmf
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LockFileName is the name of the lock file that records the SHA-256
+// hashes of the files included by the IRMF models in a directory.
+const LockFileName = "irmf.lock"
+
+// includeHashRE matches a hash annotation following an "#include" line, e.g.
+//
+//	#include "lygia/math/const.glsl" // sha256:7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069
+var includeHashRE = regexp.MustCompile(`\bsha256:([0-9a-fA-F]{64})\b`)
+
+func sha256Hex(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// lockKey returns the key of f in the lock file: its URL if it was
+// downloaded, or its slash-separated path relative to the lock file.
+func (r *includeResolver) lockKey(f *includeFile) string {
+	if f.dir == "" {
+		return f.key
+	}
+	rel, err := filepath.Rel(filepath.Dir(r.opts.LockFile), f.key)
+	if err != nil {
+		return filepath.ToSlash(f.key)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// verify checks the contents of the include file f against the hash
+// annotated on its "#include" line (if any) and against the lock file.
+// When updating the lock file, it records the hash instead.
+func (r *includeResolver) verify(f *includeFile, buf []byte, annotated string) error {
+	got := sha256Hex(buf)
+	if annotated != "" && got != annotated {
+		return fmt.Errorf("SHA-256 mismatch: got %v, want %v from the #include line", got, annotated)
+	}
+	if r.lock == nil {
+		return nil
+	}
+
+	key := r.lockKey(f)
+	if r.opts.UpdateLock {
+		r.lock[key] = got
+		return nil
+	}
+	want, ok := r.lock[key]
+	if !ok {
+		return fmt.Errorf("missing from %v (use -update-lock to add it)", r.opts.LockFile)
+	}
+	if got != want {
+		return fmt.Errorf("SHA-256 mismatch: got %v, want %v from %v", got, want, r.opts.LockFile)
+	}
+	return nil
+}
+
+// readLock reads the lock file, if there is one. Hashes are only
+// checked (or updated) when a lock file is in use.
+func (r *includeResolver) readLock() error {
+	if r.opts.LockFile == "" {
+		return nil
+	}
+	buf, err := os.ReadFile(r.opts.LockFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if r.opts.UpdateLock {
+			r.lock = map[string]string{}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.lock, err = parseLock(buf); err != nil {
+		return fmt.Errorf("%v: %v", r.opts.LockFile, err)
+	}
+	return nil
+}
+
+// parseLock parses a lock file, which has one "<key> sha256:<hash>" line
+// per include file. Blank lines and lines starting with "#" are ignored.
+func parseLock(buf []byte) (map[string]string, error) {
+	lock := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var hash string
+		var ok bool
+		if len(fields) == 2 {
+			hash, ok = strings.CutPrefix(fields[1], "sha256:")
+		}
+		if _, err := hex.DecodeString(hash); !ok || err != nil || len(hash) != 64 {
+			return nil, fmt.Errorf("line %v: want \"<include> sha256:<hash>\", found %q", lineNum, line)
+		}
+		lock[fields[0]] = strings.ToLower(hash)
+	}
+	return lock, s.Err()
+}
+
+// writeLock writes the lock file with its entries sorted by key.
+func (r *includeResolver) writeLock() error {
+	keys := make([]string, 0, len(r.lock))
+	for key := range r.lock {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString("# SHA-256 hashes of the files included by the IRMF models in this directory.\n")
+	buf.WriteString("# Update with: irmf-slicer -update-lock <model.irmf>\n")
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%v sha256:%v\n", key, r.lock[key])
+	}
+	return writeFileAtomic(r.opts.LockFile, buf.Bytes())
+}
@@ -0,0 +1,148 @@
+package irmf
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// multiRenderer is implemented by the renderers that can render every
+// material of a slice at once, evaluating the model only once per pixel.
+// RenderAll returns one image per material, in the format returned by Render.
+type multiRenderer interface {
+	RenderAll(sliceDepth float32) ([]image.Image, error)
+}
+
+// materialPass describes a draw call that renders the materials
+// first, first+1, ... (0-based) packed into the RGBA channels
+// of targets render targets.
+type materialPass struct {
+	first   int
+	targets int
+}
+
+// materialPasses splits numMaterials materials into draws
+// of at most maxTargets render targets each.
+func materialPasses(numMaterials, maxTargets int) []materialPass {
+	var passes []materialPass
+	for first := 0; first < numMaterials; first += 4 * maxTargets {
+		targets := min(maxTargets, (numMaterials-first+3)/4)
+		passes = append(passes, materialPass{first: first, targets: targets})
+	}
+	return passes
+}
+
+// numTargets returns the number of RGBA render targets needed to hold numMaterials.
+func numTargets(numMaterials int) int {
+	return (numMaterials + 3) / 4
+}
+
+// materialExpr returns the shader expression of material i (0-based)
+// in the result m of the mainModel entry point, or "0.0" if there
+// is no such material.
+func materialExpr(numMaterials, i int) string {
+	switch {
+	case i >= numMaterials:
+		return "0.0"
+	case numMaterials <= 4 || numMaterials > 16:
+		return fmt.Sprintf("m[%v]", i)
+	case numMaterials <= 9:
+		return fmt.Sprintf("m[%v][%v]", i/3, i%3)
+	}
+	return fmt.Sprintf("m[%v][%v]", i/4, i%4)
+}
+
+// targetExprs returns the comma-separated expressions of the 4 materials
+// packed into the j-th render target of pass p.
+func (p materialPass) targetExprs(numMaterials, j int) string {
+	var exprs []string
+	for c := 0; c < 4; c++ {
+		exprs = append(exprs, materialExpr(numMaterials, p.first+4*j+c))
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// fsHeaderAll returns fsHeader with its output replaced by an array
+// of the given number of render targets. The header keeps the same
+// number of lines, so the source map of the model still applies.
+func fsHeaderAll(targets int) string {
+	return strings.Replace(fsHeader, "out vec4 outputColor;", fmt.Sprintf("layout(location = 0) out vec4 outputColors[%v];", targets), 1)
+}
+
+// genFooterAll returns the GLSL main function that writes the materials
+// of pass p to outputColors.
+func genFooterAll(numMaterials int, vec3Str string, p materialPass) string {
+	ep := entryPointFor(numMaterials)
+	decl := ep.glslOut + " m"
+	if numMaterials > 16 {
+		decl = fmt.Sprintf("float m[%v]", numMaterials)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nvoid main() {\n  %v;\n  %v(m, vec3(%v));\n", decl, ep.name, vec3Str)
+	for j := 0; j < p.targets; j++ {
+		fmt.Fprintf(&b, "  outputColors[%v] = vec4(%v);\n", j, p.targetExprs(numMaterials, j))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// genWGSLFooterAll returns a fragment entry point fs_all<i> for each pass
+// that returns the materials of pass i in a MaterialColors<i> struct.
+func genWGSLFooterAll(numMaterials int, vec3Str string, passes []materialPass) string {
+	ep := entryPointFor(numMaterials)
+	var b strings.Builder
+	for i, p := range passes {
+		fmt.Fprintf(&b, "\nstruct MaterialColors%v {\n", i)
+		for j := 0; j < p.targets; j++ {
+			fmt.Fprintf(&b, "    @location(%v) c%v: vec4f,\n", j, j)
+		}
+		fmt.Fprintf(&b, "};\n\n@fragment\nfn fs_all%v(@location(0) fragVert: vec3f) -> MaterialColors%v {\n", i, i)
+		fmt.Fprintf(&b, "    let u_slice = uniforms.u_slice;\n    var m = %v(vec3f(%v));\n    var out: MaterialColors%v;\n", ep.name, vec3Str, i)
+		for j := 0; j < p.targets; j++ {
+			fmt.Fprintf(&b, "    out.c%v = vec4f(%v);\n", j, p.targetExprs(numMaterials, j))
+		}
+		b.WriteString("    return out;\n}\n")
+	}
+	return b.String()
+}
+
+// sliceImages holds the images of all materials of a slice.
+type sliceImages struct {
+	imgs   []image.Image
+	opaque bool // RGBA8 images have an alpha of 255 like the WGSL renderers write
+}
+
+// newSliceImages returns numMaterials empty images of the given size and format.
+func newSliceImages(numMaterials, width, height int, format PixelFormat, opaque bool) *sliceImages {
+	s := &sliceImages{opaque: opaque}
+	for i := 0; i < numMaterials; i++ {
+		if format == RGBA8 {
+			s.imgs = append(s.imgs, image.NewRGBA(image.Rect(0, 0, width, height)))
+		} else {
+			s.imgs = append(s.imgs, NewGray32f(image.Rect(0, 0, width, height)))
+		}
+	}
+	return s
+}
+
+// set sets the density of material i (0-based) at the pixel with
+// the given row-major index. RGBA8 densities are quantized like the GPU
+// does, while the densities of float images are stored unchanged.
+func (s *sliceImages) set(i, pixel int, v float32) {
+	switch img := s.imgs[i].(type) {
+	case *image.RGBA:
+		s.setUnorm8(i, pixel, unorm8(v))
+	case *Gray32f:
+		img.Pix[pixel] = v
+	}
+}
+
+// setUnorm8 sets the already-quantized density of material i of an RGBA8 slice.
+func (s *sliceImages) setUnorm8(i, pixel int, v uint8) {
+	img := s.imgs[i].(*image.RGBA)
+	a := v
+	if s.opaque {
+		a = 255
+	}
+	img.Pix[4*pixel], img.Pix[4*pixel+1], img.Pix[4*pixel+2], img.Pix[4*pixel+3] = v, v, v, a
+}
@@ -0,0 +1,181 @@
+package irmf
+
+import (
+	"image"
+	"math"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+type allZSlices [][]image.Image
+
+func (z *allZSlices) ProcessZSlices(sliceNum int, zVal, voxelRadius float32, imgs []image.Image) error {
+	*z = append(*z, imgs)
+	return nil
+}
+
+func TestMaterialPasses(t *testing.T) {
+	tests := []struct {
+		numMaterials, maxTargets int
+		want                     []materialPass
+	}{
+		{numMaterials: 1, maxTargets: 8, want: []materialPass{{first: 0, targets: 1}}},
+		{numMaterials: 16, maxTargets: 8, want: []materialPass{{first: 0, targets: 4}}},
+		{numMaterials: 20, maxTargets: 4, want: []materialPass{{first: 0, targets: 4}, {first: 16, targets: 1}}},
+		{numMaterials: 33, maxTargets: 2, want: []materialPass{{first: 0, targets: 2}, {first: 8, targets: 2}, {first: 16, targets: 2}, {first: 24, targets: 2}, {first: 32, targets: 1}}},
+	}
+	for _, tt := range tests {
+		if got := materialPasses(tt.numMaterials, tt.maxTargets); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("materialPasses(%v, %v) = %+v, want %+v", tt.numMaterials, tt.maxTargets, got, tt.want)
+		}
+	}
+}
+
+func TestMaterialExpr(t *testing.T) {
+	tests := []struct {
+		numMaterials, i int
+		want            string
+	}{
+		{numMaterials: 3, i: 2, want: "m[2]"},
+		{numMaterials: 3, i: 3, want: "0.0"},
+		{numMaterials: 9, i: 5, want: "m[1][2]"},
+		{numMaterials: 16, i: 13, want: "m[3][1]"},
+		{numMaterials: 20, i: 17, want: "m[17]"},
+	}
+	for _, tt := range tests {
+		if got := materialExpr(tt.numMaterials, tt.i); got != tt.want {
+			t.Errorf("materialExpr(%v, %v) = %q, want %q", tt.numMaterials, tt.i, got, tt.want)
+		}
+	}
+}
+
+// renderBothWays slices all materials of the model with RenderZSlices
+// and with RenderAllZSlices using the same renderer, and returns
+// the slices of each material rendered both ways.
+func renderBothWays(t *testing.T, s *Slicer) (perMaterial, all []zSlices) {
+	t.Helper()
+	if err := s.PrepareRenderZ(); err != nil {
+		t.Fatalf("PrepareRenderZ: %v", err)
+	}
+	for n := 1; n <= s.NumMaterials(); n++ {
+		var slices zSlices
+		if err := s.RenderZSlices(n, &slices, MinToMax); err != nil {
+			t.Fatalf("RenderZSlices: %v", err)
+		}
+		perMaterial = append(perMaterial, slices)
+	}
+
+	var slices allZSlices
+	if err := s.RenderAllZSlices(&slices, MinToMax); err != nil {
+		t.Fatalf("RenderAllZSlices: %v", err)
+	}
+	all = make([]zSlices, s.NumMaterials())
+	for n, imgs := range slices {
+		if len(imgs) != s.NumMaterials() {
+			t.Fatalf("slice %v: got %v images, want %v", n, len(imgs), s.NumMaterials())
+		}
+		for m, img := range imgs {
+			all[m] = append(all[m], img)
+		}
+	}
+	return perMaterial, all
+}
+
+func compareRenderings(t *testing.T, perMaterial, all []zSlices) {
+	t.Helper()
+	for m := range perMaterial {
+		if len(all[m]) != len(perMaterial[m]) {
+			t.Fatalf("material %v: got %v slices, want %v", m+1, len(all[m]), len(perMaterial[m]))
+		}
+		for n := range perMaterial[m] {
+			if !reflect.DeepEqual(all[m][n], perMaterial[m][n]) {
+				t.Fatalf("material %v, slice %v: RenderAllZSlices and RenderZSlices differ", m+1, n)
+			}
+		}
+	}
+}
+
+func TestRenderAllCPU(t *testing.T) {
+	for _, name := range []string{"sphere-3-glsl", "sphere-3-wgsl", "layers-20-glsl", "layers-20-wgsl"} {
+		t.Run(name, func(t *testing.T) {
+			buf, err := os.ReadFile("../testdata/" + name + ".irmf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := Init(false, 500, 500, 500)
+			s.UseCPU(true)
+			defer s.Close()
+			if err := s.NewModel(buf); err != nil {
+				t.Fatalf("NewModel: %v", err)
+			}
+			perMaterial, all := renderBothWays(t, s)
+			compareRenderings(t, perMaterial, all)
+		})
+	}
+}
+
+func TestRenderAllGoFunc(t *testing.T) {
+	meta := sphereMeta
+	meta.Materials = []string{"inside", "outside"}
+	model, err := NewGoModel(meta, func(x, y, z float32) (m [16]float32) {
+		m = sphereFunc(x, y, z)
+		m[1] = 1 - m[0]
+		return m
+	})
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+	for _, format := range []PixelFormat{RGBA8, R32F} {
+		t.Run(format.String(), func(t *testing.T) {
+			s := Init(false, 500, 500, 500)
+			s.SetPixelFormat(format)
+			defer s.Close()
+			if err := s.SetModel(model); err != nil {
+				t.Fatalf("SetModel: %v", err)
+			}
+			perMaterial, all := renderBothWays(t, s)
+			compareRenderings(t, perMaterial, all)
+		})
+	}
+}
+
+func TestRenderAllOpenGL(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	buf, err := os.ReadFile("../testdata/layers-20-glsl.irmf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []PixelFormat{RGBA8, R16F, R32F} {
+		t.Run(format.String(), func(t *testing.T) {
+			s := Init(false, 500, 500, 500)
+			s.SetPixelFormat(format)
+			defer s.Close()
+			if err := s.NewModel(buf); err != nil {
+				t.Fatalf("NewModel: %v", err)
+			}
+			perMaterial, all := renderBothWays(t, s)
+			if _, ok := s.renderer.(*OpenGLRenderer); !ok {
+				t.Skip("no OpenGL context is available")
+			}
+			if format == RGBA8 {
+				compareRenderings(t, perMaterial, all)
+				return
+			}
+			// The float renderings only differ by rounding.
+			for m := range perMaterial {
+				for n := range perMaterial[m] {
+					g, w := all[m][n].(*Gray32f), perMaterial[m][n].(*Gray32f)
+					for i := range w.Pix {
+						if math.Abs(float64(g.Pix[i]-w.Pix[i])) > 1e-3 {
+							t.Fatalf("material %v, slice %v, pixel %v: RenderAll = %v, Render = %v", m+1, n, i, g.Pix[i], w.Pix[i])
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package irmf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Option represents a parameter of a parametric model. Options are declared
+// in the "options" of the JSON header, either with their full definition:
+//
+//	"options": {
+//	  "wallThickness": {"type": "float", "default": 1.2, "min": 0.5, "max": 5},
+//	  "numHoles": {"type": "int", "default": 4, "min": 1}
+//	}
+//
+// or with just a default value for a float option, e.g. "scale": 1.5.
+//
+// Each option becomes a uniform of the same name: "uniform float
+// wallThickness;" in GLSL, and a "uniforms.wallThickness" field in WGSL.
+type Option struct {
+	Name    string   `json:"-"`
+	Type    string   `json:"type"` // "float" or "int"
+	Default float64  `json:"default"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+}
+
+// optionValue is the value of an option used when rendering.
+type optionValue struct {
+	Option
+	value float64
+}
+
+var optionNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedNames are the identifiers already used by the generated shader code.
+var reservedNames = map[string]bool{
+	"camera": true, "fragVert": true, "main": true, "model": true, "outputColor": true,
+	"projection": true, "u_materialNum": true, "u_slice": true, "uniforms": true, "vert": true,
+}
+
+// OptionDefs returns the options of the model, sorted by name.
+func (i *IRMF) OptionDefs() ([]Option, error) {
+	if len(i.Options) == 0 {
+		return nil, nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(i.Options, &raw); err != nil {
+		return nil, fmt.Errorf("options must be an object: %v", err)
+	}
+
+	var opts []Option
+	for name, v := range raw {
+		if !optionNameRE.MatchString(name) || strings.HasPrefix(name, "gl_") || reservedNames[name] {
+			return nil, fmt.Errorf("invalid option name %q", name)
+		}
+		opt := Option{Name: name, Type: "float"}
+		if err := json.Unmarshal(v, &opt.Default); err != nil {
+			if err := json.Unmarshal(v, &opt); err != nil {
+				return nil, fmt.Errorf("option %q: %v", name, err)
+			}
+		}
+		if err := opt.check(opt.Default); err != nil {
+			return nil, fmt.Errorf("option %q: default: %v", name, err)
+		}
+		opts = append(opts, opt)
+	}
+	sort.Slice(opts, func(a, b int) bool { return opts[a].Name < opts[b].Name })
+	return opts, nil
+}
+
+// check returns an error if v is not a valid value of the option.
+func (o *Option) check(v float64) error {
+	switch o.Type {
+	case "float":
+	case "int":
+		if v != math.Trunc(v) || v < math.MinInt32 || v > math.MaxInt32 {
+			return fmt.Errorf("%v is not an int", v)
+		}
+	default:
+		return fmt.Errorf("unsupported type %q (must be \"float\" or \"int\")", o.Type)
+	}
+	if o.Min != nil && v < *o.Min {
+		return fmt.Errorf("%v is less than the minimum of %v", v, *o.Min)
+	}
+	if o.Max != nil && v > *o.Max {
+		return fmt.Errorf("%v is greater than the maximum of %v", v, *o.Max)
+	}
+	return nil
+}
+
+// SetOption overrides the default value of the named option
+// for subsequent renderings of the model.
+func (i *IRMF) SetOption(name, value string) error {
+	opts, err := i.OptionDefs()
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		if opt.Name != name {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("option %q: invalid value %q", name, value)
+		}
+		if err := opt.check(v); err != nil {
+			return fmt.Errorf("option %q: %v", name, err)
+		}
+		if i.optionValues == nil {
+			i.optionValues = map[string]float64{}
+		}
+		i.optionValues[name] = v
+		return nil
+	}
+	return fmt.Errorf("unknown option %q", name)
+}
+
+// uniformOptions returns the options with their current values.
+func (i *IRMF) uniformOptions() ([]optionValue, error) {
+	opts, err := i.OptionDefs()
+	if err != nil {
+		return nil, err
+	}
+	var result []optionValue
+	for _, opt := range opts {
+		v, ok := i.optionValues[opt.Name]
+		if !ok {
+			v = opt.Default
+		}
+		result = append(result, optionValue{Option: opt, value: v})
+	}
+	return result, nil
+}
+
+// glslOptionUniforms returns the GLSL declarations of the option uniforms.
+func glslOptionUniforms(opts []optionValue) string {
+	var b strings.Builder
+	for _, opt := range opts {
+		fmt.Fprintf(&b, "uniform %v %v;\n", opt.Type, opt.Name)
+	}
+	return b.String()
+}
+
+// wgslHeader returns wgslVertexShader with the options added to its Uniforms.
+func wgslHeader(opts []optionValue) string {
+	var fields strings.Builder
+	for _, opt := range opts {
+		typ := "f32"
+		if opt.Type == "int" {
+			typ = "i32"
+		}
+		fmt.Fprintf(&fields, "    %v: %v,\n", opt.Name, typ)
+	}
+	const last = "    u_materialNum: f32,\n"
+	return strings.Replace(wgslVertexShader, last, last+fields.String(), 1)
+}
+
+// wgslOptionData returns the contents of the Uniforms that follow
+// u_materialNum, padded to a multiple of 16 bytes.
+func wgslOptionData(opts []optionValue) []float32 {
+	data := make([]float32, 0, len(opts)+3)
+	for _, opt := range opts {
+		if opt.Type == "int" {
+			data = append(data, math.Float32frombits(uint32(int32(opt.value))))
+		} else {
+			data = append(data, float32(opt.value))
+		}
+	}
+	for (len(data)+2)%4 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
@@ -0,0 +1,163 @@
+package irmf
+
+import (
+	"encoding/json"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptionDefs(t *testing.T) {
+	one, five := 1.0, 5.0
+	tests := []struct {
+		name    string
+		options string
+		want    []Option
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "empty", options: "{}"},
+		{
+			name:    "full and shorthand",
+			options: `{"wallThickness": {"type": "float", "default": 1.2, "min": 1, "max": 5}, "numHoles": {"type": "int", "default": 4}, "scale": 1.5}`,
+			want: []Option{
+				{Name: "numHoles", Type: "int", Default: 4},
+				{Name: "scale", Type: "float", Default: 1.5},
+				{Name: "wallThickness", Type: "float", Default: 1.2, Min: &one, Max: &five},
+			},
+		},
+		{name: "not an object", options: `[1]`, wantErr: "options must be an object"},
+		{name: "bad type", options: `{"a": {"type": "vec3"}}`, wantErr: `option "a": default: unsupported type "vec3"`},
+		{name: "bad int default", options: `{"a": {"type": "int", "default": 1.5}}`, wantErr: `option "a": default: 1.5 is not an int`},
+		{name: "default out of range", options: `{"a": {"default": 0, "min": 1}}`, wantErr: `option "a": default: 0 is less than the minimum of 1`},
+		{name: "bad name", options: `{"my-option": 1}`, wantErr: `invalid option name "my-option"`},
+		{name: "reserved name", options: `{"u_slice": 1}`, wantErr: `invalid option name "u_slice"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &IRMF{Options: json.RawMessage(tt.options)}
+			got, err := m.OptionDefs()
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("OptionDefs error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OptionDefs: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OptionDefs = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetOption(t *testing.T) {
+	m := &IRMF{Options: json.RawMessage(`{"radius": {"default": 3, "min": 1, "max": 5}, "count": {"type": "int", "default": 2}}`)}
+	tests := []struct {
+		name, value string
+		wantErr     string
+	}{
+		{name: "radius", value: "4.5"},
+		{name: "count", value: "7"},
+		{name: "radius", value: "6", wantErr: `option "radius": 6 is greater than the maximum of 5`},
+		{name: "radius", value: "big", wantErr: `option "radius": invalid value "big"`},
+		{name: "count", value: "1.5", wantErr: `option "count": 1.5 is not an int`},
+		{name: "height", value: "1", wantErr: `unknown option "height"`},
+	}
+	for _, tt := range tests {
+		err := m.SetOption(tt.name, tt.value)
+		if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+			t.Errorf("SetOption(%q, %q) = %v, want %v", tt.name, tt.value, err, tt.wantErr)
+		}
+	}
+
+	got, err := m.uniformOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].value != 7 || got[1].value != 4.5 {
+		t.Errorf("uniformOptions = %+v, want count=7 and radius=4.5", got)
+	}
+}
+
+const parametricSphereGLSL = `/*{
+  "irmf": "1.0",
+  "language": "glsl",
+  "materials": ["PLA"],
+  "max": [5,5,5],
+  "min": [-5,-5,-5],
+  "options": {"radius": {"default": 2, "min": 0, "max": 5}, "material": {"type": "int", "default": 0}},
+  "units": "mm"
+}*/
+void mainModel4(out vec4 materials, in vec3 xyz) {
+  materials[material] = length(xyz) <= radius ? 1.0 : 0.0;
+}
+`
+
+const parametricSphereWGSL = `/*{
+  "irmf": "1.0",
+  "language": "wgsl",
+  "materials": ["PLA"],
+  "max": [5,5,5],
+  "min": [-5,-5,-5],
+  "options": {"radius": {"default": 2, "min": 0, "max": 5}, "material": {"type": "int", "default": 0}},
+  "units": "mm"
+}*/
+fn mainModel4(xyz: vec3f) -> vec4f {
+  var m = vec4f(0.0);
+  m[uniforms.material] = select(0.0, 1.0, length(xyz) <= uniforms.radius);
+  return m;
+}
+`
+
+func TestOptionsCPU(t *testing.T) {
+	// countPixels returns the number of pixels inside the sphere in the middle slice.
+	countPixels := func(t *testing.T, src string, options map[string]string) int {
+		t.Helper()
+		s := Init(false, 250, 250, 250)
+		s.UseCPU(true)
+		defer s.Close()
+		if err := s.NewModel([]byte(src)); err != nil {
+			t.Fatalf("NewModel: %v", err)
+		}
+		for name, value := range options {
+			if err := s.IRMF().SetOption(name, value); err != nil {
+				t.Fatalf("SetOption: %v", err)
+			}
+		}
+		if err := s.PrepareRenderZ(); err != nil {
+			t.Fatalf("PrepareRenderZ: %v", err)
+		}
+		var slices zSlices
+		if err := s.RenderZSlices(1, &slices, MinToMax); err != nil {
+			t.Fatalf("RenderZSlices: %v", err)
+		}
+		img := slices[len(slices)/2].(*image.RGBA)
+		var n int
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i] != 0 {
+				n++
+			}
+		}
+		return n
+	}
+
+	for lang, src := range map[string]string{"glsl": parametricSphereGLSL, "wgsl": parametricSphereWGSL} {
+		t.Run(lang, func(t *testing.T) {
+			small := countPixels(t, src, nil)
+			large := countPixels(t, src, map[string]string{"radius": "4"})
+			other := countPixels(t, src, map[string]string{"radius": "4", "material": "1"})
+			// The area of the middle slice grows with the square of the radius.
+			if ratio := float64(large) / float64(small); small == 0 || ratio < 3.8 || ratio > 4.2 {
+				t.Errorf("got %v pixels with the default radius and %v with radius 4, want a ratio of about 4", small, large)
+			}
+			if other != 0 {
+				t.Errorf("got %v pixels for material 1 when the sphere is material 2, want 0", other)
+			}
+		})
+	}
+}
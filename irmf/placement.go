@@ -0,0 +1,88 @@
+package irmf
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// SetTransform places the model before slicing it, for example scaled,
+// mirrored or rotated onto its side. transform maps the model coordinates,
+// in millimeters, to the coordinates of the slices, so its translation is
+// in millimeters. The slices cover the MBB of the transformed model, which
+// MBB and the Num*Slices methods return. The transform applies to the
+// current model and all subsequent models, and to subsequent calls to
+// PrepareRender*. It must be an invertible affine transform; the default is
+// mgl32.Ident4().
+func (s *Slicer) SetTransform(transform mgl32.Mat4) error {
+	if transform.Row(3) != (mgl32.Vec4{0, 0, 0, 1}) {
+		return fmt.Errorf("the transform must be affine, but its last row is %v", transform.Row(3))
+	}
+	if transform.Det() == 0 {
+		return fmt.Errorf("the transform must be invertible: %v", transform)
+	}
+	s.transform = transform
+	if s.irmf != nil {
+		s.updatePlacement()
+	}
+	return nil
+}
+
+// Transform returns the placement transform set by SetTransform.
+func (s *Slicer) Transform() mgl32.Mat4 {
+	if s.transform == (mgl32.Mat4{}) {
+		return mgl32.Ident4()
+	}
+	return s.transform
+}
+
+// updatePlacement computes the placement of the current model in model units
+// and the MBB of the placed model, limited to the region (if any).
+func (s *Slicer) updatePlacement() {
+	// Rotations, mirrors and scales are unchanged by the units of the model,
+	// while the translation must be converted from millimeters.
+	s.placement = s.Transform()
+	for i := 0; i < 3; i++ {
+		s.placement.Set(i, 3, s.placement.At(i, 3)/s.mm)
+	}
+	s.toModel = s.placement.Inv()
+
+	inf := float32(math.Inf(1))
+	s.min, s.max = [3]float32{inf, inf, inf}, [3]float32{-inf, -inf, -inf}
+	for _, corner := range s.corners() {
+		for i := 0; i < 3; i++ {
+			s.min[i], s.max[i] = min(s.min[i], corner[i]), max(s.max[i], corner[i])
+		}
+	}
+	s.applyRegion()
+}
+
+// corners returns the corners of the MBB of the current model,
+// in placed model units.
+func (s *Slicer) corners() []mgl32.Vec3 {
+	var corners []mgl32.Vec3
+	for i := 0; i < 8; i++ {
+		var corner mgl32.Vec3
+		for j := 0; j < 3; j++ {
+			corner[j] = s.irmf.Min[j]
+			if i&(1<<j) != 0 {
+				corner[j] = s.irmf.Max[j]
+			}
+		}
+		corners = append(corners, mgl32.TransformCoordinate(corner, s.placement))
+	}
+	return corners
+}
+
+// placedCoords returns the vec3Str and the vec3Map of the model coordinates
+// sampled by the renderers: the placed coordinates placed.(a, b, c), for the
+// shader variables a, b and c, mapped back to the model coordinates.
+// vec3Str is used as is for an untransformed model.
+func (s *Slicer) placedCoords(vec3Str string, placed mgl32.Mat4, a, b, c string) (string, vec3Map) {
+	m := s.toModel.Mul4(placed)
+	if s.toModel != mgl32.Ident4() {
+		vec3Str = affineVec3Str(m, a, b, c)
+	}
+	return vec3Str, affineVec3Map(m, a, b, c)
+}
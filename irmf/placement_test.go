@@ -0,0 +1,162 @@
+package irmf
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+type xSlices []image.Image
+
+func (x *xSlices) ProcessXSlice(sliceNum int, xVal, voxelRadius float32, img image.Image) error {
+	*x = append(*x, img)
+	return nil
+}
+
+func TestSetTransform(t *testing.T) {
+	cm := sphereMeta
+	cm.Units = "cm"
+
+	tests := []struct {
+		name           string
+		meta           IRMF
+		transform      mgl32.Mat4
+		wantMin        [3]float32
+		wantMax        [3]float32
+		wantNumZSlices int
+		wantErr        bool
+	}{
+		{name: "identity", meta: sphereMeta, transform: mgl32.Ident4(), wantMin: [3]float32{-5, -5, -5}, wantMax: [3]float32{5, 5, 5}, wantNumZSlices: 20},
+		{name: "scale", meta: sphereMeta, transform: mgl32.Scale3D(2, 1, 0.5), wantMin: [3]float32{-10, -5, -2.5}, wantMax: [3]float32{10, 5, 2.5}, wantNumZSlices: 10},
+		{name: "translate", meta: sphereMeta, transform: mgl32.Translate3D(1, 2, 3), wantMin: [3]float32{-4, -3, -2}, wantMax: [3]float32{6, 7, 8}, wantNumZSlices: 20},
+		{name: "translate cm", meta: cm, transform: mgl32.Translate3D(10, 0, 0), wantMin: [3]float32{-40, -50, -50}, wantMax: [3]float32{60, 50, 50}, wantNumZSlices: 200},
+		{name: "rotate", meta: sphereMeta, transform: mgl32.HomogRotate3DZ(math.Pi / 4), wantMin: [3]float32{-7.071068, -7.071068, -5}, wantMax: [3]float32{7.071068, 7.071068, 5}, wantNumZSlices: 20},
+		{name: "mirror", meta: sphereMeta, transform: mgl32.Translate3D(1, 0, 0).Mul4(mgl32.Scale3D(-1, 1, 1)), wantMin: [3]float32{-4, -5, -5}, wantMax: [3]float32{6, 5, 5}, wantNumZSlices: 20},
+		{name: "singular", meta: sphereMeta, transform: mgl32.Scale3D(1, 0, 1), wantErr: true},
+		{name: "projective", meta: sphereMeta, transform: mgl32.Perspective(1, 1, 1, 10), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := NewGoModel(tt.meta, sphereFunc)
+			if err != nil {
+				t.Fatalf("NewGoModel: %v", err)
+			}
+			s := Init(false, 500, 500, 500)
+			defer s.Close()
+			if err := s.SetModel(model); err != nil {
+				t.Fatalf("SetModel: %v", err)
+			}
+			err = s.SetTransform(tt.transform)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetTransform error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if s.Transform() != mgl32.Ident4() {
+					t.Errorf("Transform = %v after a failed SetTransform, want the identity", s.Transform())
+				}
+				return
+			}
+			min, max := s.MBB()
+			if !mgl32.Vec3(min).ApproxEqualThreshold(tt.wantMin, 1e-4) || !mgl32.Vec3(max).ApproxEqualThreshold(tt.wantMax, 1e-4) {
+				t.Errorf("MBB = %v, %v, want %v, %v", min, max, tt.wantMin, tt.wantMax)
+			}
+			if got := s.NumZSlices(); got != tt.wantNumZSlices {
+				t.Errorf("NumZSlices = %v, want %v", got, tt.wantNumZSlices)
+			}
+		})
+	}
+}
+
+func TestPlacedRendering(t *testing.T) {
+	linear := func(p mgl32.Vec3) float32 { return 0.5 + (p[0]+2*p[1]+3*p[2])/100 }
+	goModel, err := NewGoModel(sphereMeta, func(x, y, z float32) (m [16]float32) {
+		m[0] = linear(mgl32.Vec3{x, y, z})
+		return m
+	})
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+
+	transforms := []struct {
+		name      string
+		transform mgl32.Mat4
+	}{
+		{name: "identity", transform: mgl32.Ident4()},
+		{name: "on its side", transform: mgl32.Translate3D(1, 2, 3).Mul4(mgl32.HomogRotate3DX(math.Pi / 2))},
+		{name: "scaled and mirrored", transform: mgl32.Scale3D(-2, 1, 0.5)},
+	}
+
+	for _, tt := range transforms {
+		for _, glsl := range []bool{false, true} {
+			name := tt.name + " go"
+			if glsl {
+				name = tt.name + " cpu glsl"
+			}
+			t.Run(name, func(t *testing.T) {
+				s := Init(false, 500, 500, 500)
+				s.UseCPU(true)
+				s.SetPixelFormat(R32F)
+				defer s.Close()
+				if err := s.SetTransform(tt.transform); err != nil {
+					t.Fatalf("SetTransform: %v", err)
+				}
+				if glsl {
+					err = s.NewModel([]byte(linearGLSL))
+				} else {
+					err = s.SetModel(goModel)
+				}
+				if err != nil {
+					t.Fatalf("model: %v", err)
+				}
+				toModel := tt.transform.Inv()
+				min, max := s.MBB()
+
+				if err := s.PrepareRenderZ(); err != nil {
+					t.Fatalf("PrepareRenderZ: %v", err)
+				}
+				var zs zSlices
+				if err := s.RenderZSlices(1, &zs, MinToMax); err != nil {
+					t.Fatalf("RenderZSlices: %v", err)
+				}
+				if len(zs) != s.NumZSlices() {
+					t.Fatalf("got %v Z slices, want %v", len(zs), s.NumZSlices())
+				}
+				for n, img := range zs {
+					z := min[2] + 0.5*(float32(n)+0.5)
+					b := img.Bounds()
+					for _, px := range [][2]int{{0, 0}, {b.Dx() / 3, b.Dy() / 2}, {b.Dx() - 1, b.Dy() - 1}} {
+						x := min[0] + (max[0]-min[0])*(float32(px[0])+0.5)/float32(b.Dx())
+						y := min[1] + (max[1]-min[1])*(float32(px[1])+0.5)/float32(b.Dy())
+						want := linear(mgl32.TransformCoordinate(mgl32.Vec3{x, y, z}, toModel))
+						if got := img.(*Gray32f).Gray32fAt(px[0], px[1]); math.Abs(float64(got-want)) > 1e-4 {
+							t.Fatalf("Z slice %v, pixel %v: density = %v, want %v", n, px, got, want)
+						}
+					}
+				}
+
+				if err := s.PrepareRenderX(); err != nil {
+					t.Fatalf("PrepareRenderX: %v", err)
+				}
+				var xs xSlices
+				if err := s.RenderXSlices(1, &xs, MinToMax); err != nil {
+					t.Fatalf("RenderXSlices: %v", err)
+				}
+				for n, img := range xs {
+					x := min[0] + 0.5*(float32(n)+0.5)
+					b := img.Bounds()
+					for _, px := range [][2]int{{0, 0}, {b.Dx() / 3, b.Dy() / 2}, {b.Dx() - 1, b.Dy() - 1}} {
+						y := min[1] + (max[1]-min[1])*(float32(px[0])+0.5)/float32(b.Dx())
+						z := min[2] + (max[2]-min[2])*(float32(px[1])+0.5)/float32(b.Dy())
+						want := linear(mgl32.TransformCoordinate(mgl32.Vec3{x, y, z}, toModel))
+						if got := img.(*Gray32f).Gray32fAt(px[0], px[1]); math.Abs(float64(got-want)) > 1e-4 {
+							t.Fatalf("X slice %v, pixel %v: density = %v, want %v", n, px, got, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package irmf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// slicePlane is the frame of the slices prepared by PrepareRenderPlane.
+type slicePlane struct {
+	u, v, n  mgl32.Vec3 // unit axes of the image columns, the image rows and the slice depth
+	min, max mgl32.Vec3 // MBB of the model in the (u, v, n) frame, in model units
+}
+
+// newSlicePlane returns the frame of the planes perpendicular to normal
+// whose image rows point along up, and the MBB of the corners in that frame.
+func newSlicePlane(normal, up mgl32.Vec3, corners []mgl32.Vec3) (*slicePlane, error) {
+	if normal.Len() == 0 {
+		return nil, errors.New("the plane normal must not be zero")
+	}
+	n := normal.Normalize()
+	v := up.Sub(n.Mul(up.Dot(n)))
+	if v.Len() < 1e-6*up.Len() || up.Len() == 0 {
+		return nil, fmt.Errorf("the up vector %v must not be zero or parallel to the plane normal %v", up, normal)
+	}
+	v = v.Normalize()
+	p := &slicePlane{u: v.Cross(n), v: v, n: n}
+
+	inf := float32(math.Inf(1))
+	p.min, p.max = mgl32.Vec3{inf, inf, inf}, mgl32.Vec3{-inf, -inf, -inf}
+	for _, corner := range corners {
+		for j, axis := range []mgl32.Vec3{p.u, p.v, p.n} {
+			d := corner.Dot(axis)
+			p.min[j], p.max[j] = min(p.min[j], d), max(p.max[j], d)
+		}
+	}
+	return p, nil
+}
+
+// coords returns the shader expression and the vec3Map of the model
+// coordinates of the point at fragVert.xy in the plane at depth u_slice,
+// where toModel maps the placed coordinates to the model coordinates.
+func (p *slicePlane) coords(toModel mgl32.Mat4) (string, vec3Map) {
+	frame := mgl32.Mat4FromCols(p.u.Vec4(0), p.v.Vec4(0), p.n.Vec4(0), mgl32.Vec4{0, 0, 0, 1})
+	m := toModel.Mul4(frame)
+	return affineVec3Str(m, "fragVert.x", "fragVert.y", "u_slice"), affineVec3Map(m, "fragVert.x", "fragVert.y", "u_slice")
+}
+
+// affineVec3Str returns the vec3Str whose coordinate i is
+// m[i,0]*a + m[i,1]*b + m[i,2]*c + m[i,3] for the shader variables
+// a, b and c. It is valid in both GLSL and WGSL.
+func affineVec3Str(m mgl32.Mat4, a, b, c string) string {
+	coords := make([]string, 3)
+	for i := range coords {
+		var terms []string
+		for j, name := range []string{a, b, c} {
+			switch coef := m.At(i, j); coef {
+			case 0:
+			case 1:
+				terms = append(terms, name)
+			default:
+				terms = append(terms, name+"*("+shaderFloat(coef)+")")
+			}
+		}
+		if constant := m.At(i, 3); constant != 0 {
+			terms = append(terms, "("+shaderFloat(constant)+")")
+		}
+		coords[i] = strings.Join(terms, "+")
+		if len(terms) == 0 {
+			coords[i] = "0.0"
+		}
+	}
+	return strings.Join(coords, ",")
+}
+
+// shaderFloat formats v as a float literal for GLSL and WGSL.
+func shaderFloat(v float32) string {
+	s := strconv.FormatFloat(float64(v), 'f', -1, 32)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// PrepareRenderPlane prepares the renderer to slice the model on planes
+// perpendicular to normal, for example to print a part tilted on its side.
+// The rows of the slice images point along up projected onto the planes,
+// and their columns along up x normal, so normal (0,0,1) and up (0,1,0)
+// render the same slices as PrepareRenderZ. The X, Y and Z resolutions
+// of the Slicer apply to the columns, the rows and the depth of the slices.
+// Use NumSlices, PlaneMBB and RenderSlices to render the slices.
+func (s *Slicer) PrepareRenderPlane(normal, up mgl32.Vec3) error {
+	p, err := newSlicePlane(normal, up, s.corners())
+	if err != nil {
+		return err
+	}
+
+	left, right := p.min[0], p.max[0]
+	bottom, top := p.min[1], p.max[1]
+	camera := mgl32.LookAtV(mgl32.Vec3{0, 0, 3}, mgl32.Vec3{0, 0, 0}, mgl32.Vec3{0, 1, 0})
+	planeVertices := []float32{
+		//  X, Y, Z, U, V
+		left, bottom, 0, 1, 0, // ll
+		right, bottom, 0, 0, 0, // lr
+		left, top, 0, 1, 1, // ul
+		right, bottom, 0, 0, 0, // lr
+		right, top, 0, 0, 1, // ur
+		left, top, 0, 1, 1, // ul
+	}
+
+	aspectRatio := ((right - left) * s.deltaY) / ((top - bottom) * s.deltaX)
+	newWidth := int(0.5 + (right-left)/float32(s.deltaX))
+	newHeight := int(0.5 + (top-bottom)/float32(s.deltaY))
+	if aspectRatio*float32(newHeight) < float32(newWidth) {
+		newHeight = int(0.5 + float32(newWidth)/aspectRatio)
+	}
+
+	vec3Str, coords := p.coords(s.toModel)
+	if err := s.prepareRender(newWidth, newHeight, left, right, bottom, top, camera, vec3Str, coords, planeVertices); err != nil {
+		return err
+	}
+	s.plane = p
+	return nil
+}
+
+// PrepareRenderPlaneContext is like PrepareRenderPlane, but returns
+// ctx.Err() without preparing the renderer if ctx is already done.
+func (s *Slicer) PrepareRenderPlaneContext(ctx context.Context, normal, up mgl32.Vec3) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.PrepareRenderPlane(normal, up)
+}
+
+// PlaneMBB returns the MBB of the IRMF model in millimeters in the frame
+// of the planes prepared by PrepareRenderPlane: along the columns, the rows
+// and the depth of the slices.
+func (s *Slicer) PlaneMBB() (min, max [3]float32) {
+	if s.plane != nil {
+		for i := 0; i < 3; i++ {
+			min[i], max[i] = s.mm*s.plane.min[i], s.mm*s.plane.max[i]
+		}
+	}
+	return min, max
+}
+
+// NumSlices returns the number of slices prepared by PrepareRenderPlane.
+func (s *Slicer) NumSlices() int {
+	if s.plane == nil {
+		return 0
+	}
+	return int(0.5 + (s.plane.max[2]-s.plane.min[2])/s.deltaZ)
+}
+
+// RenderSlices slices the given materialNum (1-based index) on the planes
+// prepared by PrepareRenderPlane, calling the ZSliceProcessor for each slice
+// with its depth along the plane normal.
+func (s *Slicer) RenderSlices(materialNum int, sp ZSliceProcessor, order Order) error {
+	return s.RenderSlicesContext(context.Background(), materialNum, sp, order)
+}
+
+// RenderSlicesContext is like RenderSlices, but stops after the current
+// slice and returns ctx.Err() when ctx is done.
+func (s *Slicer) RenderSlicesContext(ctx context.Context, materialNum int, sp ZSliceProcessor, order Order) error {
+	if s.plane == nil {
+		return errors.New("PrepareRenderPlane must be called before RenderSlices")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for slice, err := range s.PlaneSlices(materialNum, order) {
+		if err != nil {
+			return err
+		}
+		if err := sp.ProcessZSlice(slice.Num, slice.Depth, slice.VoxelRadius, slice.Image); err != nil {
+			return fmt.Errorf("ProcessSlice(%v,%v,%v): %w", slice.Num, slice.Depth, slice.VoxelRadius, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
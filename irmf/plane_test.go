@@ -0,0 +1,237 @@
+package irmf
+
+import (
+	"image"
+	"math"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestAffineVec3Str(t *testing.T) {
+	tests := []struct {
+		name string
+		m    mgl32.Mat4
+		want string
+	}{
+		{name: "identity", m: mgl32.Ident4(), want: "fragVert.x,fragVert.y,u_slice"},
+		{name: "swap", m: mgl32.Mat4FromRows(mgl32.Vec4{0, 0, 1, 0}, mgl32.Vec4{1, 0, 0, 0}, mgl32.Vec4{0, 1, 0, 0}, mgl32.Vec4{0, 0, 0, 1}), want: "u_slice,fragVert.x,fragVert.y"},
+		{name: "affine", m: mgl32.Mat4FromRows(mgl32.Vec4{2, 0, -0.5, 3}, mgl32.Vec4{0, 0, 0, 0}, mgl32.Vec4{0, 1, 0, -1.25}, mgl32.Vec4{0, 0, 0, 1}), want: "fragVert.x*(2.0)+u_slice*(-0.5)+(3.0),0.0,fragVert.y+(-1.25)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := affineVec3Str(tt.m, "fragVert.x", "fragVert.y", "u_slice")
+			if got != tt.want {
+				t.Fatalf("affineVec3Str = %q, want %q", got, tt.want)
+			}
+			// The Go renderer must evaluate it like the shaders.
+			coords := affineVec3Map(tt.m, "fragVert.x", "fragVert.y", "u_slice")
+			xyz := coords.apply(mgl32.Vec3{3, 5, 0}, 7)
+			if want := tt.m.Mul4x1(mgl32.Vec4{3, 5, 7, 1}).Vec3(); mgl32.Vec3(xyz) != want {
+				t.Errorf("affineVec3Map maps (3,5,7) to %v, want %v", xyz, want)
+			}
+		})
+	}
+}
+
+func TestNewSlicePlane(t *testing.T) {
+	s := &Slicer{irmf: &sphereMeta, placement: mgl32.Ident4()}
+	corners := s.corners()
+	r := 5 * float32(math.Sqrt2)
+
+	tests := []struct {
+		name       string
+		normal, up mgl32.Vec3
+		want       slicePlane
+		wantErr    bool
+	}{
+		{
+			name:   "z",
+			normal: mgl32.Vec3{0, 0, 1}, up: mgl32.Vec3{0, 1, 0},
+			want: slicePlane{u: mgl32.Vec3{1, 0, 0}, v: mgl32.Vec3{0, 1, 0}, n: mgl32.Vec3{0, 0, 1}, min: mgl32.Vec3{-5, -5, -5}, max: mgl32.Vec3{5, 5, 5}},
+		},
+		{
+			name:   "tilted 45 degrees",
+			normal: mgl32.Vec3{0, -1, 1}, up: mgl32.Vec3{0, 0, 1},
+			want: slicePlane{
+				u: mgl32.Vec3{1, 0, 0}, v: mgl32.Vec3{0, 1, 1}.Normalize(), n: mgl32.Vec3{0, -1, 1}.Normalize(),
+				min: mgl32.Vec3{-5, -r, -r}, max: mgl32.Vec3{5, r, r},
+			},
+		},
+		{name: "zero normal", up: mgl32.Vec3{0, 1, 0}, wantErr: true},
+		{name: "parallel up", normal: mgl32.Vec3{0, 0, 1}, up: mgl32.Vec3{0, 0, -2}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newSlicePlane(tt.normal, tt.up, corners)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newSlicePlane error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for i, pair := range [][2]mgl32.Vec3{{got.u, tt.want.u}, {got.v, tt.want.v}, {got.n, tt.want.n}, {got.min, tt.want.min}, {got.max, tt.want.max}} {
+				if !pair[0].ApproxEqualThreshold(pair[1], 1e-5) {
+					t.Errorf("newSlicePlane field %v = %v, want %v", i, pair[0], pair[1])
+				}
+			}
+		})
+	}
+}
+
+func TestRenderPlaneMatchesZ(t *testing.T) {
+	renderers := []struct {
+		name  string
+		model func(t *testing.T, s *Slicer) error
+	}{
+		{name: "go", model: func(t *testing.T, s *Slicer) error {
+			model, err := NewGoModel(sphereMeta, sphereFunc)
+			if err != nil {
+				return err
+			}
+			return s.SetModel(model)
+		}},
+		{name: "cpu glsl", model: func(t *testing.T, s *Slicer) error { return newTestModel(t, s, "sphere-3-glsl") }},
+		{name: "cpu wgsl", model: func(t *testing.T, s *Slicer) error { return newTestModel(t, s, "sphere-3-wgsl") }},
+	}
+
+	for _, tt := range renderers {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Init(false, 500, 500, 500)
+			s.UseCPU(true)
+			defer s.Close()
+			if err := tt.model(t, s); err != nil {
+				t.Fatalf("model: %v", err)
+			}
+
+			if err := s.PrepareRenderZ(); err != nil {
+				t.Fatalf("PrepareRenderZ: %v", err)
+			}
+			var want zSlices
+			if err := s.RenderZSlices(1, &want, MinToMax); err != nil {
+				t.Fatalf("RenderZSlices: %v", err)
+			}
+
+			if err := s.PrepareRenderPlane(mgl32.Vec3{0, 0, 1}, mgl32.Vec3{0, 1, 0}); err != nil {
+				t.Fatalf("PrepareRenderPlane: %v", err)
+			}
+			if got, want := s.NumSlices(), s.NumZSlices(); got != want {
+				t.Errorf("NumSlices = %v, want %v", got, want)
+			}
+			var got zSlices
+			if err := s.RenderSlices(1, &got, MinToMax); err != nil {
+				t.Fatalf("RenderSlices: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("RenderSlices and RenderZSlices differ")
+			}
+		})
+	}
+}
+
+// newTestModel loads the named model of the testdata directory into s.
+func newTestModel(t *testing.T, s *Slicer, name string) error {
+	t.Helper()
+	buf, err := os.ReadFile("../testdata/" + name + ".irmf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.NewModel(buf)
+}
+
+const linearGLSL = `/*{
+  "irmf": "1.0",
+  "language": "glsl",
+  "materials": ["PLA"],
+  "max": [5,5,5],
+  "min": [-5,-5,-5],
+  "units": "mm"
+}*/
+
+void mainModel4(out vec4 materials, in vec3 xyz) {
+  materials[0] = 0.5 + dot(xyz, vec3(1.0, 2.0, 3.0)) / 100.0;
+}
+`
+
+func TestRenderTiltedPlane(t *testing.T) {
+	linear := func(x, y, z float32) float32 { return 0.5 + (x+2*y+3*z)/100 }
+	goModel, err := NewGoModel(sphereMeta, func(x, y, z float32) (m [16]float32) {
+		m[0] = linear(x, y, z)
+		return m
+	})
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tests := []struct {
+		name string
+		glsl bool
+		cpu  bool
+	}{
+		{name: "go", cpu: true},
+		{name: "cpu glsl", glsl: true, cpu: true},
+		{name: "opengl", glsl: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Init(false, 500, 500, 500)
+			s.UseCPU(tt.cpu)
+			s.SetPixelFormat(R32F)
+			defer s.Close()
+			if tt.glsl {
+				err = s.NewModel([]byte(linearGLSL))
+			} else {
+				err = s.SetModel(goModel)
+			}
+			if err != nil {
+				t.Fatalf("model: %v", err)
+			}
+
+			normal, up := mgl32.Vec3{0, -1, 1}, mgl32.Vec3{0, 0, 1}
+			if err := s.PrepareRenderPlane(normal, up); err != nil {
+				t.Fatalf("PrepareRenderPlane: %v", err)
+			}
+			if _, ok := s.renderer.(*OpenGLRenderer); !tt.cpu && !ok {
+				t.Skip("no OpenGL context is available")
+			}
+			min, max := s.PlaneMBB()
+			r := 5 * float32(math.Sqrt2)
+			if !mgl32.Vec3(min).ApproxEqualThreshold(mgl32.Vec3{-5, -r, -r}, 1e-5) || !mgl32.Vec3(max).ApproxEqualThreshold(mgl32.Vec3{5, r, r}, 1e-5) {
+				t.Errorf("PlaneMBB = %v, %v, want (-5,%v,%v), (5,%v,%v)", min, max, -r, -r, r, r)
+			}
+			if got, want := s.NumSlices(), 28; got != want {
+				t.Errorf("NumSlices = %v, want %v", got, want)
+			}
+
+			var slices zSlices
+			if err := s.RenderSlices(1, &slices, MinToMax); err != nil {
+				t.Fatalf("RenderSlices: %v", err)
+			}
+			if len(slices) != s.NumSlices() {
+				t.Fatalf("got %v slices, want %v", len(slices), s.NumSlices())
+			}
+
+			u, v, n := mgl32.Vec3{1, 0, 0}, mgl32.Vec3{0, 1, 1}.Normalize(), normal.Normalize()
+			for i, img := range slices {
+				depth := -r + 0.25 + 0.5*float32(i)
+				b := img.Bounds()
+				dx, dy := 10/float32(b.Dx()), 2*r/float32(b.Dy())
+				for _, pixel := range []image.Point{{0, 0}, {b.Dx() / 2, b.Dy() / 3}, {b.Dx() - 1, b.Dy() - 1}} {
+					p := u.Mul(-5 + (float32(pixel.X)+0.5)*dx).Add(v.Mul(-r + (float32(pixel.Y)+0.5)*dy)).Add(n.Mul(depth))
+					got, want := img.(*Gray32f).Gray32fAt(pixel.X, pixel.Y), linear(p[0], p[1], p[2])
+					if math.Abs(float64(got-want)) > 1e-4 {
+						t.Errorf("slice %v, pixel %v: density = %v, want %v at %v", i, pixel, got, want, p)
+					}
+				}
+			}
+		})
+	}
+}
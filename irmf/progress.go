@@ -0,0 +1,31 @@
+package irmf
+
+import "time"
+
+// Progress reports the progress of a run of slices, as passed to the
+// function set by SetProgress.
+type Progress struct {
+	Material int           // the material (1-based), or 0 for all materials at once
+	Slice    int           // the number of slices done, from 1 to Total
+	Total    int           // the number of slices in the run
+	Elapsed  time.Duration // the time since the start of the run
+	ETA      time.Duration // the estimated time until the end of the run
+}
+
+// SetProgress sets a function that is called after each slice is rendered
+// and processed by Render*Slices (or consumed from the *Slices iterators),
+// for example to show a progress bar. A nil f (the default) reports nothing.
+func (s *Slicer) SetProgress(f func(Progress)) {
+	s.progress = f
+}
+
+// reportProgress calls the progress function, if any, after done slices
+// of the run that started at start.
+func (s *Slicer) reportProgress(material, done, total int, start time.Time) {
+	if s.progress == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	eta := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+	s.progress(Progress{Material: material, Slice: done, Total: total, Elapsed: elapsed, ETA: eta})
+}
@@ -0,0 +1,75 @@
+package irmf
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+)
+
+// cancelingSlices is a ZSliceProcessor that cancels its context
+// after processing n slices.
+type cancelingSlices struct {
+	n      int
+	cancel context.CancelFunc
+	zSlices
+}
+
+func (c *cancelingSlices) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	if len(c.zSlices) == c.n-1 {
+		c.cancel()
+	}
+	return c.zSlices.ProcessZSlice(sliceNum, z, voxelRadius, img)
+}
+
+func TestRenderContext(t *testing.T) {
+	model, err := NewGoModel(sphereMeta, sphereFunc)
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+	s := Init(false, 500, 500, 500)
+	defer s.Close()
+	if err := s.SetModel(model); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	var reports []Progress
+	s.SetProgress(func(p Progress) { reports = append(reports, p) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.PrepareRenderZContext(ctx); err != nil {
+		t.Fatalf("PrepareRenderZContext: %v", err)
+	}
+	var all zSlices
+	if err := s.RenderZSlicesContext(ctx, 1, &all, MinToMax); err != nil {
+		t.Fatalf("RenderZSlicesContext: %v", err)
+	}
+	total := s.NumZSlices()
+	if len(reports) != total {
+		t.Fatalf("got %v progress reports, want %v", len(reports), total)
+	}
+	for i, p := range reports {
+		if p.Material != 1 || p.Slice != i+1 || p.Total != total || p.Elapsed < 0 || p.ETA < 0 {
+			t.Errorf("report %v = %+v, want material 1, slice %v of %v", i, p, i+1, total)
+		}
+	}
+	if last := reports[len(reports)-1]; last.ETA != 0 {
+		t.Errorf("last report ETA = %v, want 0", last.ETA)
+	}
+
+	reports = nil
+	c := &cancelingSlices{n: 3, cancel: cancel}
+	err = s.RenderZSlicesContext(ctx, 1, c, MinToMax)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RenderZSlicesContext error = %v, want %v", err, context.Canceled)
+	}
+	if len(c.zSlices) != c.n || len(reports) != c.n-1 {
+		t.Errorf("processed %v slices with %v progress reports after canceling, want %v and %v", len(c.zSlices), len(reports), c.n, c.n-1)
+	}
+
+	if err := s.PrepareRenderZContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("PrepareRenderZContext error = %v, want %v", err, context.Canceled)
+	}
+	if err := s.RenderAllZSlicesContext(ctx, &allZSlices{}, MinToMax); !errors.Is(err, context.Canceled) {
+		t.Errorf("RenderAllZSlicesContext error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,101 @@
+package irmf
+
+import (
+	"fmt"
+	"math"
+)
+
+// region is the sub-box of the model set by SetRegion, in model units.
+type region struct {
+	min, max [3]float32
+}
+
+// SetRegion limits the X, Y and Z slices to the part of the model inside
+// min..max, in the model units of the placed model (see SetTransform), for
+// example to preview a detail at full resolution or to split a huge job
+// across machines. The region is expanded to the voxel grid of the whole
+// model, so its voxels line up with those of the whole model, and Offset
+// returns its position in that grid. MBB and the Num*Slices methods then
+// describe the region. The region applies to the current model and all
+// subsequent models until ClearRegion is called.
+func (s *Slicer) SetRegion(min, max [3]float32) error {
+	for i := 0; i < 3; i++ {
+		if min[i] >= max[i] {
+			return fmt.Errorf("region min.%c (%v) must be strictly less than max.%c (%v)", 'x'+i, min[i], 'x'+i, max[i])
+		}
+	}
+	s.region = &region{min: min, max: max}
+	if s.irmf != nil {
+		s.updatePlacement()
+	}
+	return nil
+}
+
+// SetLayerRange limits the Z slices to the layers [start, end) of the whole
+// model (or of the region set by SetRegion), for example to re-run only part
+// of a failed job. An end of 0 slices through the last layer.
+func (s *Slicer) SetLayerRange(start, end int) error {
+	if start < 0 || (end != 0 && end <= start) {
+		return fmt.Errorf("invalid layer range [%v,%v)", start, end)
+	}
+	s.layerStart, s.layerEnd = start, end
+	if s.irmf != nil {
+		s.updatePlacement()
+	}
+	return nil
+}
+
+// ClearRegion removes the limits set by SetRegion and SetLayerRange.
+func (s *Slicer) ClearRegion() {
+	s.region = nil
+	s.layerStart, s.layerEnd = 0, 0
+	if s.irmf != nil {
+		s.updatePlacement()
+	}
+}
+
+// Offset returns the position of the first voxel of the slices in the voxel
+// grid of the whole model: the numbers of voxels before it along X and Y,
+// and the number of Z slices before it. It is zero unless SetRegion or
+// SetLayerRange is used. The output writers record it so that partial
+// outputs can be merged.
+func (s *Slicer) Offset() [3]int {
+	return s.offset
+}
+
+// applyRegion limits the MBB of the placed model, s.min..s.max, to the
+// voxels of the region and layer range, and sets the offset of the region.
+func (s *Slicer) applyRegion() {
+	s.offset = [3]int{}
+	if s.region == nil && s.layerStart == 0 && s.layerEnd == 0 {
+		return
+	}
+
+	delta := [3]float32{s.deltaX, s.deltaY, s.deltaZ}
+	for i := 0; i < 3; i++ {
+		numVoxels := int(0.5 + (s.max[i]-s.min[i])/delta[i])
+		first, last := 0, numVoxels
+		if s.region != nil {
+			first = max(first, int(math.Floor(float64((s.region.min[i]-s.min[i])/delta[i]))))
+			last = min(last, int(math.Ceil(float64((s.region.max[i]-s.min[i])/delta[i]))))
+		}
+		if i == 2 {
+			first += s.layerStart
+			if s.layerEnd > 0 {
+				last = min(last, first-s.layerStart+s.layerEnd)
+			}
+		}
+		// The renderers need an even width, so widen the region
+		// by a voxel rather than stretch its voxels.
+		if i == 0 && (last-first)%2 == 1 {
+			if last < numVoxels {
+				last++
+			} else if first > 0 {
+				first--
+			}
+		}
+		last = max(first, last)
+		s.offset[i] = first
+		s.min[i], s.max[i] = s.min[i]+float32(first)*delta[i], s.min[i]+float32(last)*delta[i]
+	}
+}
@@ -0,0 +1,157 @@
+package irmf
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestSetRegion(t *testing.T) {
+	tests := []struct {
+		name           string
+		region         *region
+		start, end     int
+		wantOffset     [3]int
+		wantMin        [3]float32
+		wantMax        [3]float32
+		wantNumZSlices int
+		wantErr        bool
+	}{
+		{name: "whole", wantMin: [3]float32{-5, -5, -5}, wantMax: [3]float32{5, 5, 5}, wantNumZSlices: 20},
+		{
+			name:       "region",
+			region:     &region{min: [3]float32{-2.2, 0, 1.1}, max: [3]float32{3.1, 0.4, 7}},
+			wantOffset: [3]int{5, 10, 12},
+			wantMin:    [3]float32{-2.5, 0, 1}, wantMax: [3]float32{3.5, 0.5, 5},
+			wantNumZSlices: 8,
+		},
+		{
+			name:       "odd width",
+			region:     &region{min: [3]float32{4.2, -5, -5}, max: [3]float32{5, 5, 5}},
+			wantOffset: [3]int{18, 0, 0},
+			wantMin:    [3]float32{4, -5, -5}, wantMax: [3]float32{5, 5, 5},
+			wantNumZSlices: 20,
+		},
+		{name: "layers", start: 3, end: 7, wantOffset: [3]int{0, 0, 3}, wantMin: [3]float32{-5, -5, -3.5}, wantMax: [3]float32{5, 5, -1.5}, wantNumZSlices: 4},
+		{name: "layers to the end", start: 15, wantOffset: [3]int{0, 0, 15}, wantMin: [3]float32{-5, -5, 2.5}, wantMax: [3]float32{5, 5, 5}, wantNumZSlices: 5},
+		{
+			name:           "layers of region",
+			region:         &region{min: [3]float32{-5, -5, 0}, max: [3]float32{5, 5, 5}},
+			start:          2,
+			end:            4,
+			wantOffset:     [3]int{0, 0, 12},
+			wantMin:        [3]float32{-5, -5, 1},
+			wantMax:        [3]float32{5, 5, 2},
+			wantNumZSlices: 2,
+		},
+		{name: "empty region", region: &region{min: [3]float32{1, 1, 1}, max: [3]float32{1, 2, 3}}, wantErr: true},
+		{name: "bad layers", start: 5, end: 5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := NewGoModel(sphereMeta, sphereFunc)
+			if err != nil {
+				t.Fatalf("NewGoModel: %v", err)
+			}
+			s := Init(false, 500, 500, 500)
+			defer s.Close()
+			if err := s.SetModel(model); err != nil {
+				t.Fatalf("SetModel: %v", err)
+			}
+			if tt.region != nil {
+				err = s.SetRegion(tt.region.min, tt.region.max)
+			}
+			if err == nil && (tt.start != 0 || tt.end != 0) {
+				err = s.SetLayerRange(tt.start, tt.end)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetRegion error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := s.Offset(); got != tt.wantOffset {
+				t.Errorf("Offset = %v, want %v", got, tt.wantOffset)
+			}
+			min, max := s.MBB()
+			if !mgl32.Vec3(min).ApproxEqualThreshold(tt.wantMin, 1e-5) || !mgl32.Vec3(max).ApproxEqualThreshold(tt.wantMax, 1e-5) {
+				t.Errorf("MBB = %v, %v, want %v, %v", min, max, tt.wantMin, tt.wantMax)
+			}
+			if got := s.NumZSlices(); got != tt.wantNumZSlices {
+				t.Errorf("NumZSlices = %v, want %v", got, tt.wantNumZSlices)
+			}
+
+			s.ClearRegion()
+			if got, want := s.NumZSlices(), 20; s.Offset() != [3]int{} || got != want {
+				t.Errorf("after ClearRegion: Offset = %v, NumZSlices = %v, want zero and %v", s.Offset(), got, want)
+			}
+		})
+	}
+}
+
+func TestRegionRendering(t *testing.T) {
+	model, err := NewGoModel(sphereMeta, func(x, y, z float32) (m [16]float32) {
+		m[0] = 0.5 + (x+2*y+3*z)/100
+		return m
+	})
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+	render := func(t *testing.T, setRegion func(s *Slicer) error) (zSlices, [3]int) {
+		t.Helper()
+		s := Init(false, 500, 500, 500)
+		s.SetPixelFormat(R32F)
+		defer s.Close()
+		if err := s.SetModel(model); err != nil {
+			t.Fatalf("SetModel: %v", err)
+		}
+		if err := setRegion(s); err != nil {
+			t.Fatalf("region: %v", err)
+		}
+		if err := s.PrepareRenderZ(); err != nil {
+			t.Fatalf("PrepareRenderZ: %v", err)
+		}
+		var slices zSlices
+		if err := s.RenderZSlices(1, &slices, MinToMax); err != nil {
+			t.Fatalf("RenderZSlices: %v", err)
+		}
+		return slices, s.Offset()
+	}
+
+	whole, _ := render(t, func(s *Slicer) error { return nil })
+	tests := []struct {
+		name      string
+		setRegion func(s *Slicer) error
+	}{
+		{name: "region", setRegion: func(s *Slicer) error {
+			return s.SetRegion([3]float32{-2.2, 0, 1.1}, [3]float32{3.1, 0.4, 7})
+		}},
+		{name: "layers", setRegion: func(s *Slicer) error { return s.SetLayerRange(3, 7) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slices, offset := render(t, tt.setRegion)
+			for n, img := range slices {
+				want := whole[offset[2]+n].(*Gray32f)
+				got := img.(*Gray32f)
+				b := got.Bounds()
+				if b.Min != (image.Point{}) || b.Dx()+offset[0] > want.Rect.Dx() || b.Dy()+offset[1] > want.Rect.Dy() {
+					t.Fatalf("slice %v: bounds %v at offset %v do not fit in %v", n, b, offset, want.Rect)
+				}
+				for y := 0; y < b.Dy(); y++ {
+					for x := 0; x < b.Dx(); x++ {
+						g, w := got.Gray32fAt(x, y), want.Gray32fAt(x+offset[0], y+offset[1])
+						if math.Abs(float64(g-w)) > 1e-5 {
+							t.Fatalf("slice %v, pixel (%v,%v): density = %v, want %v", n, x, y, g, w)
+						}
+					}
+				}
+			}
+		})
+	}
+}
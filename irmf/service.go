@@ -0,0 +1,125 @@
+package irmf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// ErrServiceClosed is returned for the jobs submitted to a Service
+// after it stops.
+var ErrServiceClosed = errors.New("slicing service closed")
+
+// Service runs slicing jobs one at a time on a single locked OS thread,
+// as the renderers require, so that any goroutine (such as an HTTP handler
+// or a worker of a pool) can slice by submitting a Job.
+//
+//	func main() {
+//		svc := irmf.NewService()
+//		go serve(svc) // calls svc.Do or svc.Submit from any goroutine
+//		if err := svc.Run(ctx); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+type Service struct {
+	jobs     chan *serviceJob
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// Job is a slicing job run by a Service.
+type Job struct {
+	// XRes, YRes and ZRes are the resolution of the Slicer in microns.
+	XRes, YRes, ZRes float32
+
+	// Slice is called on the thread of the Service with a new Slicer,
+	// which is closed when Slice returns. The results of the job are
+	// whatever Slice writes to its slice processors or output files.
+	Slice func(ctx context.Context, s *Slicer) error
+}
+
+// serviceJob is a submitted Job and the channel of its result.
+type serviceJob struct {
+	Job
+	ctx  context.Context
+	done chan error
+}
+
+// NewService returns a new slicing Service. Its jobs are not run until
+// Run is called.
+func NewService() *Service {
+	return &Service{jobs: make(chan *serviceJob), done: make(chan struct{})}
+}
+
+// Run runs the submitted jobs on the calling goroutine, locked to its
+// OS thread, until ctx is done or Close is called; it then returns ctx.Err()
+// or nil. A job that is running finishes first, and the jobs that are still
+// waiting fail with ErrServiceClosed. Run should be called from the main
+// goroutine (the main function), whose OS thread this package locks, when
+// the slices are rendered to a window (see Init) or on macOS.
+func (sv *Service) Run(ctx context.Context) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer sv.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-sv.done:
+			return nil
+		case j := <-sv.jobs:
+			j.done <- j.run()
+		}
+	}
+}
+
+// Close stops the Service after the running job, if any.
+func (sv *Service) Close() {
+	sv.doneOnce.Do(func() { close(sv.done) })
+}
+
+// Do submits the job and waits for its result. It is safe to call from
+// any goroutine. If ctx is done before the job starts, Do returns ctx.Err();
+// once started, the job is expected to stop (e.g. using the Context methods
+// of the Slicer) and return when ctx is done.
+func (sv *Service) Do(ctx context.Context, job Job) error {
+	if job.Slice == nil {
+		return errors.New("Job.Slice must not be nil")
+	}
+	j := &serviceJob{Job: job, ctx: ctx, done: make(chan error, 1)}
+	select {
+	case sv.jobs <- j:
+		return <-j.done
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sv.done:
+		return ErrServiceClosed
+	}
+}
+
+// Submit submits the job without waiting for it, and returns a channel
+// that receives its result, as returned by Do.
+func (sv *Service) Submit(ctx context.Context, job Job) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- sv.Do(ctx, job) }()
+	return result
+}
+
+// run runs the job with a new Slicer. A panic of the job is returned
+// as its error rather than stopping the Service.
+func (j *serviceJob) run() (err error) {
+	if err := j.ctx.Err(); err != nil {
+		return err
+	}
+	s := Init(false, j.XRes, j.YRes, j.ZRes)
+	defer s.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("slicing job panicked: %v", r)
+		}
+	}()
+	return j.Slice(j.ctx, s)
+}
@@ -0,0 +1,95 @@
+package irmf
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestService(t *testing.T) {
+	sliceSphere := func(ctx context.Context, s *Slicer, got *zSlices) error {
+		model, err := NewGoModel(sphereMeta, sphereFunc)
+		if err != nil {
+			return err
+		}
+		if err := s.SetModel(model); err != nil {
+			return err
+		}
+		if err := s.PrepareRenderZContext(ctx); err != nil {
+			return err
+		}
+		return s.RenderZSlicesContext(ctx, 1, got, MinToMax)
+	}
+
+	var want zSlices
+	s := Init(false, 500, 500, 500)
+	if err := sliceSphere(context.Background(), s, &want); err != nil {
+		t.Fatalf("sliceSphere: %v", err)
+	}
+	s.Close()
+
+	svc := NewService()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- svc.Run(ctx) }()
+
+	// Jobs submitted concurrently are all run.
+	const numJobs = 8
+	results := make([]zSlices, numJobs)
+	var wg sync.WaitGroup
+	for i := range numJobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := svc.Do(context.Background(), Job{XRes: 500, YRes: 500, ZRes: 500, Slice: func(ctx context.Context, s *Slicer) error {
+				return sliceSphere(ctx, s, &results[i])
+			}})
+			if err != nil {
+				t.Errorf("job %v: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i, got := range results {
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("job %v: got %v slices different from the %v slices of a Slicer", i, len(got), len(want))
+		}
+	}
+
+	errJob := errors.New("job failed")
+	canceled, cancelJob := context.WithCancel(context.Background())
+	cancelJob()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		job  Job
+		want error
+	}{
+		{name: "error", ctx: context.Background(), job: Job{Slice: func(ctx context.Context, s *Slicer) error { return errJob }}, want: errJob},
+		{name: "canceled", ctx: canceled, job: Job{Slice: func(ctx context.Context, s *Slicer) error { return nil }}, want: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := <-svc.Submit(tt.ctx, tt.job); !errors.Is(err, tt.want) {
+				t.Errorf("Submit = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := svc.Do(context.Background(), Job{Slice: func(ctx context.Context, s *Slicer) error { panic("oops") }}); err == nil {
+		t.Error("panicking job: want an error")
+	}
+	if err := svc.Do(context.Background(), Job{Slice: func(ctx context.Context, s *Slicer) error { return nil }}); err != nil {
+		t.Errorf("job after a panic: %v", err)
+	}
+
+	cancel()
+	if err := <-stopped; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want %v", err, context.Canceled)
+	}
+	if err := svc.Do(context.Background(), Job{Slice: func(ctx context.Context, s *Slicer) error { return nil }}); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("Do after Run returned = %v, want %v", err, ErrServiceClosed)
+	}
+}
@@ -1,9 +1,11 @@
 package irmf
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"image"
-	"log"
+	"image/draw"
 	"runtime"
 
 	"github.com/go-gl/mathgl/mgl32"
@@ -19,50 +21,177 @@ type Slicer struct {
 	irmf   *IRMF
 	width  int
 	height int
-	deltaX float32 // millimeters (model units)
+	resX   float32 // millimeters
+	resY   float32
+	resZ   float32
+	deltaX float32 // model units
 	deltaY float32
 	deltaZ float32
+	mm     float32 // millimeters per model unit
 	view   bool
+	cpu    bool
+	format PixelFormat
+
+	includes IncludeOptions
 
 	renderer Renderer
+
+	// maxTileSize limits the size of the rendered images (0 for the
+	// renderer's limit). Larger slices are rendered in tiles.
+	maxTileSize int
+	tiles       []tile
+
+	// supersampleXY and supersampleZ are the numbers of samples per voxel
+	// across and along the slicing axis, set by SetSupersampling.
+	// xySamples is the value of supersampleXY used by the last PrepareRender*.
+	supersampleXY int
+	supersampleZ  int
+	xySamples     int
+
+	// transform is the placement of the model set by SetTransform, in
+	// millimeters. placement is the same transform in model units, toModel
+	// its inverse, and min and max the MBB of the placed model in model units.
+	transform mgl32.Mat4
+	placement mgl32.Mat4
+	toModel   mgl32.Mat4
+	min, max  [3]float32
+
+	// region and the layer range limit the slices to part of the model;
+	// offset is the position of the first voxel in the whole model.
+	region               *region
+	layerStart, layerEnd int
+	offset               [3]int
+
+	// plane is the frame of the slices prepared by PrepareRenderPlane,
+	// or nil after PrepareRenderX, Y or Z.
+	plane *slicePlane
+
+	// progress is called after each slice, if set by SetProgress.
+	progress func(Progress)
+
+	// fallback is called when the GPU renderer is replaced by the
+	// CPURenderer, if set by SetFallback.
+	fallback func(err *DeviceError)
 }
 
 // Init returns a new Slicer instance.
+// The resolution is in microns, regardless of the units of the model.
 func Init(view bool, umXRes, umYRes, umZRes float32) *Slicer {
-	// TODO: Support units other than millimeters.
-	return &Slicer{deltaX: umXRes / 1000.0, deltaY: umYRes / 1000.0, deltaZ: umZRes / 1000.0, view: view}
+	return &Slicer{resX: umXRes / 1000.0, resY: umYRes / 1000.0, resZ: umZRes / 1000.0, view: view, transform: mgl32.Ident4()}
+}
+
+// UseCPU selects the pure-Go CPURenderer for all subsequent models
+// instead of the GPU renderers. It must be called before NewModel.
+func (s *Slicer) UseCPU(cpu bool) {
+	s.cpu = cpu
+}
+
+// SetFallback sets a function that is called with the error of the GPU
+// renderer when PrepareRender* falls back to the (much slower) CPURenderer
+// because no GPU or display is available, for example to warn the user.
+// A nil f (the default) reports nothing.
+func (s *Slicer) SetFallback(f func(err *DeviceError)) {
+	s.fallback = f
+}
+
+// SetPixelFormat selects the format of the rendered slices for all
+// subsequent calls to PrepareRender*. The default, RGBA8, returns
+// *image.RGBA slices, while R16F and R32F return *Gray32f slices
+// that keep the full precision of the model.
+func (s *Slicer) SetPixelFormat(f PixelFormat) {
+	s.format = f
+}
+
+// PixelFormat returns the format of the rendered slices.
+func (s *Slicer) PixelFormat() PixelFormat {
+	return s.format
+}
+
+// SetMaxTileSize limits the width and height, in pixels, of the images
+// rendered at once by subsequent calls to PrepareRender*. Slices that are
+// larger than this, or than the renderer supports (such as the maximum
+// texture size of the GPU), are rendered in tiles that are stitched together,
+// so the slice processors still receive whole slices. Zero (the default)
+// only applies the renderer's limit.
+func (s *Slicer) SetMaxTileSize(size int) {
+	s.maxTileSize = size
+}
+
+// SetSupersampling sets the number of samples taken per voxel by subsequent
+// calls to PrepareRender* and Render*Slices. Each voxel is sampled on an xy x xy
+// grid in the plane of the slice and at z depths along the slicing axis
+// (the Z axis for Z slices), and the slice processors receive the average of
+// the samples: the fraction of the voxel occupied by each material.
+// This anti-aliases thin walls and small features at the cost of rendering
+// xy*xy*z times as many pixels. Values of 1 or less (the default) sample each
+// voxel once at its center.
+func (s *Slicer) SetSupersampling(xy, z int) {
+	s.supersampleXY, s.supersampleZ = xy, z
+}
+
+// SetIncludeOptions sets how "#include" lines are resolved
+// by subsequent calls to NewModel.
+func (s *Slicer) SetIncludeOptions(opts IncludeOptions) {
+	s.includes = opts
 }
 
 // NewModel prepares the slicer to slice a new shader model.
 func (s *Slicer) NewModel(shaderSrc []byte) error {
-	irmf, err := newModel(shaderSrc)
+	irmf, err := newModel(shaderSrc, s.includes)
+	if err != nil {
+		return err
+	}
+	return s.SetModel(irmf)
+}
+
+// SetModel prepares the slicer to slice an already-parsed model,
+// such as one returned by NewGoModel.
+func (s *Slicer) SetModel(irmf *IRMF) error {
+	if len(irmf.Min) != 3 || len(irmf.Max) != 3 {
+		return &ParseError{Err: fmt.Errorf("Bad IRMF model: min=%#v, max=%#v", irmf.Min, irmf.Max)}
+	}
+	mm, err := irmf.MMPerUnit()
 	if err != nil {
 		return err
 	}
 	s.irmf = irmf
 
+	// The slicer works in model units, so convert the resolution.
+	s.mm = mm
+	s.deltaX, s.deltaY, s.deltaZ = s.resX/mm, s.resY/mm, s.resZ/mm
+	s.updatePlacement()
+
 	// Select renderer based on language.
 	// We might want to delay this until PrepareRender, but for now we can do it here.
-	switch irmf.Language {
-	case "glsl", "":
-		if _, ok := s.renderer.(*OpenGLRenderer); !ok {
-			if s.renderer != nil {
-				s.renderer.Close()
-			}
-			s.renderer = &OpenGLRenderer{}
+	switch {
+	case irmf.Language == "go":
+		if _, ok := s.renderer.(*GoFuncRenderer); !ok {
+			s.setRenderer(&GoFuncRenderer{})
+		}
+	case s.cpu:
+		if _, ok := s.renderer.(*CPURenderer); !ok {
+			s.setRenderer(&CPURenderer{})
 		}
-	case "wgsl":
+	case irmf.Language == "wgsl":
 		if _, ok := s.renderer.(*WebGPURenderer); !ok {
-			if s.renderer != nil {
-				s.renderer.Close()
-			}
-			s.renderer = &WebGPURenderer{}
+			s.setRenderer(&WebGPURenderer{})
+		}
+	default:
+		if _, ok := s.renderer.(*OpenGLRenderer); !ok {
+			s.setRenderer(&OpenGLRenderer{})
 		}
 	}
-
 	return nil
 }
 
+// setRenderer closes the current renderer (if any) and replaces it with r.
+func (s *Slicer) setRenderer(r Renderer) {
+	if s.renderer != nil {
+		s.renderer.Close()
+	}
+	s.renderer = r
+}
+
 func (s *Slicer) IRMF() *IRMF {
 	return s.irmf
 }
@@ -90,14 +219,11 @@ func (s *Slicer) MaterialName(n int) string {
 	return s.irmf.Materials[n-1]
 }
 
-// MBB returns the MBB of the IRMF model.
+// MBB returns the MBB of the IRMF model in millimeters.
 func (s *Slicer) MBB() (min, max [3]float32) {
 	if s.irmf != nil {
-		if len(s.irmf.Min) != 3 || len(s.irmf.Max) != 3 {
-			log.Fatalf("Bad IRMF model: min=%#v, max=%#v", s.irmf.Min, s.irmf.Max)
-		}
-		min[0], min[1], min[2] = s.irmf.Min[0], s.irmf.Min[1], s.irmf.Min[2]
-		max[0], max[1], max[2] = s.irmf.Max[0], s.irmf.Max[1], s.irmf.Max[2]
+		min[0], min[1], min[2] = s.mm*s.min[0], s.mm*s.min[1], s.mm*s.min[2]
+		max[0], max[1], max[2] = s.mm*s.max[0], s.mm*s.max[1], s.mm*s.max[2]
 	}
 	return min, max
 }
@@ -117,6 +243,14 @@ type ZSliceProcessor interface {
 	ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error
 }
 
+// MultiZSliceProcessor represents a Z slice processor that receives
+// the images of all materials of each slice at once.
+type MultiZSliceProcessor interface {
+	// ProcessZSlices is called with one image per material, in the
+	// order of the materials of the model.
+	ProcessZSlices(sliceNum int, z, voxelRadius float32, imgs []image.Image) error
+}
+
 // Order represents the order of slice processing.
 type Order byte
 
@@ -127,7 +261,7 @@ const (
 
 // NumXSlices returns the number of slices in the X direction.
 func (s *Slicer) NumXSlices() int {
-	n := int(0.5 + (s.irmf.Max[0]-s.irmf.Min[0])/s.deltaX)
+	n := int(0.5 + (s.max[0]-s.min[0])/s.deltaX)
 	if n%2 == 1 {
 		n++
 	}
@@ -137,32 +271,24 @@ func (s *Slicer) NumXSlices() int {
 // RenderXSlices slices the given materialNum (1-based index)
 // to an image, calling the SliceProcessor for each slice.
 func (s *Slicer) RenderXSlices(materialNum int, sp XSliceProcessor, order Order) error {
-	numSlices := int(0.5 + (s.irmf.Max[0]-s.irmf.Min[0])/s.deltaX)
-	voxelRadiusX := 0.5 * s.deltaX
-	minVal := s.irmf.Min[0] + voxelRadiusX
-
-	var xFunc func(n int) float32
+	return s.RenderXSlicesContext(context.Background(), materialNum, sp, order)
+}
 
-	switch order {
-	case MinToMax:
-		xFunc = func(n int) float32 {
-			return minVal + float32(n)*s.deltaX
-		}
-	case MaxToMin:
-		xFunc = func(n int) float32 {
-			return minVal + float32(numSlices-n-1)*s.deltaX
-		}
+// RenderXSlicesContext is like RenderXSlices, but stops after the
+// current slice and returns ctx.Err() when ctx is done.
+func (s *Slicer) RenderXSlicesContext(ctx context.Context, materialNum int, sp XSliceProcessor, order Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-
-	for n := 0; n < numSlices; n++ {
-		x := xFunc(n)
-
-		img, err := s.renderSlice(x, materialNum)
+	for slice, err := range s.XSlices(materialNum, order) {
 		if err != nil {
-			return fmt.Errorf("renderXSlice(%v,%v): %v", x, materialNum, err)
+			return err
+		}
+		if err := sp.ProcessXSlice(slice.Num, slice.Depth, slice.VoxelRadius, slice.Image); err != nil {
+			return fmt.Errorf("ProcessSlice(%v,%v,%v): %w", slice.Num, slice.Depth, slice.VoxelRadius, err)
 		}
-		if err := sp.ProcessXSlice(n, x, voxelRadiusX, img); err != nil {
-			return fmt.Errorf("ProcessSlice(%v,%v,%v): %v", n, x, voxelRadiusX, err)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -170,8 +296,8 @@ func (s *Slicer) RenderXSlices(materialNum int, sp XSliceProcessor, order Order)
 
 // NumYSlices returns the number of slices in the Y direction.
 func (s *Slicer) NumYSlices() int {
-	nx := int(0.5 + (s.irmf.Max[0]-s.irmf.Min[0])/s.deltaX)
-	ny := int(0.5 + (s.irmf.Max[1]-s.irmf.Min[1])/s.deltaY)
+	nx := int(0.5 + (s.max[0]-s.min[0])/s.deltaX)
+	ny := int(0.5 + (s.max[1]-s.min[1])/s.deltaY)
 	if nx%2 == 1 {
 		ny++
 	}
@@ -181,32 +307,24 @@ func (s *Slicer) NumYSlices() int {
 // RenderYSlices slices the given materialNum (1-based index)
 // to an image, calling the SliceProcessor for each slice.
 func (s *Slicer) RenderYSlices(materialNum int, sp YSliceProcessor, order Order) error {
-	numSlices := int(0.5 + (s.irmf.Max[1]-s.irmf.Min[1])/s.deltaY)
-	voxelRadiusY := 0.5 * s.deltaY
-	minVal := s.irmf.Min[1] + voxelRadiusY
-
-	var yFunc func(n int) float32
+	return s.RenderYSlicesContext(context.Background(), materialNum, sp, order)
+}
 
-	switch order {
-	case MinToMax:
-		yFunc = func(n int) float32 {
-			return minVal + float32(n)*s.deltaY
-		}
-	case MaxToMin:
-		yFunc = func(n int) float32 {
-			return minVal + float32(numSlices-n-1)*s.deltaY
-		}
+// RenderYSlicesContext is like RenderYSlices, but stops after the
+// current slice and returns ctx.Err() when ctx is done.
+func (s *Slicer) RenderYSlicesContext(ctx context.Context, materialNum int, sp YSliceProcessor, order Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-
-	for n := 0; n < numSlices; n++ {
-		y := yFunc(n)
-
-		img, err := s.renderSlice(y, materialNum)
+	for slice, err := range s.YSlices(materialNum, order) {
 		if err != nil {
-			return fmt.Errorf("renderYSlice(%v,%v): %v", y, materialNum, err)
+			return err
+		}
+		if err := sp.ProcessYSlice(slice.Num, slice.Depth, slice.VoxelRadius, slice.Image); err != nil {
+			return fmt.Errorf("ProcessSlice(%v,%v,%v): %w", slice.Num, slice.Depth, slice.VoxelRadius, err)
 		}
-		if err := sp.ProcessYSlice(n, y, voxelRadiusY, img); err != nil {
-			return fmt.Errorf("ProcessSlice(%v,%v,%v): %v", n, y, voxelRadiusY, err)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -214,58 +332,169 @@ func (s *Slicer) RenderYSlices(materialNum int, sp YSliceProcessor, order Order)
 
 // NumZSlices returns the number of slices in the Z direction.
 func (s *Slicer) NumZSlices() int {
-	return int(0.5 + (s.irmf.Max[2]-s.irmf.Min[2])/s.deltaZ)
+	return int(0.5 + (s.max[2]-s.min[2])/s.deltaZ)
 }
 
 // RenderZSlices slices the given materialNum (1-based index)
 // to an image, calling the SliceProcessor for each slice.
 func (s *Slicer) RenderZSlices(materialNum int, sp ZSliceProcessor, order Order) error {
-	numSlices := int(0.5 + (s.irmf.Max[2]-s.irmf.Min[2])/s.deltaZ)
-	voxelRadiusZ := 0.5 * s.deltaZ
-	minVal := s.irmf.Min[2] + voxelRadiusZ
-
-	var zFunc func(n int) float32
+	return s.RenderZSlicesContext(context.Background(), materialNum, sp, order)
+}
 
-	switch order {
-	case MinToMax:
-		zFunc = func(n int) float32 {
-			return minVal + float32(n)*s.deltaZ
+// RenderZSlicesContext is like RenderZSlices, but stops after the
+// current slice and returns ctx.Err() when ctx is done.
+func (s *Slicer) RenderZSlicesContext(ctx context.Context, materialNum int, sp ZSliceProcessor, order Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for slice, err := range s.ZSlices(materialNum, order) {
+		if err != nil {
+			return err
+		}
+		if err := sp.ProcessZSlice(slice.Num, slice.Depth, slice.VoxelRadius, slice.Image); err != nil {
+			return fmt.Errorf("ProcessSlice(%v,%v,%v): %w", slice.Num, slice.Depth, slice.VoxelRadius, err)
 		}
-	case MaxToMin:
-		zFunc = func(n int) float32 {
-			return minVal + float32(numSlices-n-1)*s.deltaZ
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	for n := 0; n < numSlices; n++ {
-		z := zFunc(n)
+// RenderAllZSlices slices all materials of the model at once, calling
+// the MultiZSliceProcessor with the images of every material for each slice.
+// The renderers evaluate the model only once per pixel, writing the
+// materials to multiple render targets, so this is much faster than
+// calling RenderZSlices for each material of a multi-material model.
+func (s *Slicer) RenderAllZSlices(sp MultiZSliceProcessor, order Order) error {
+	return s.RenderAllZSlicesContext(context.Background(), sp, order)
+}
 
-		img, err := s.renderSlice(z, materialNum)
+// RenderAllZSlicesContext is like RenderAllZSlices, but stops after the
+// current slice and returns ctx.Err() when ctx is done.
+func (s *Slicer) RenderAllZSlicesContext(ctx context.Context, sp MultiZSliceProcessor, order Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for slice, err := range s.AllZSlices(order) {
 		if err != nil {
-			return fmt.Errorf("renderZSlice(%v,%v): %v", z, materialNum, err)
+			return err
 		}
-		if err := sp.ProcessZSlice(n, z, voxelRadiusZ, img); err != nil {
-			return fmt.Errorf("ProcessSlice(%v,%v,%v): %v", n, z, voxelRadiusZ, err)
+		if err := sp.ProcessZSlices(slice.Num, slice.Depth, slice.VoxelRadius, slice.Images); err != nil {
+			return fmt.Errorf("ProcessSlices(%v,%v,%v): %w", slice.Num, slice.Depth, slice.VoxelRadius, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (s *Slicer) renderSlice(sliceDepth float32, materialNum int) (image.Image, error) {
+// renderSlice renders the given material of the slice whose voxels
+// are delta thick.
+func (s *Slicer) renderSlice(sliceDepth, delta float32, materialNum int) (image.Image, error) {
+	imgs, err := s.renderSamples(sliceDepth, delta, func(depth float32) ([]image.Image, error) {
+		img, err := s.renderer.Render(depth, materialNum)
+		return []image.Image{img}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imgs[0], nil
+}
+
+// renderAllSlices renders every material of the slice, in a single
+// pass if the renderer supports it.
+func (s *Slicer) renderAllSlices(sliceDepth, delta float32) ([]image.Image, error) {
+	return s.renderSamples(sliceDepth, delta, func(depth float32) ([]image.Image, error) {
+		if r, ok := s.renderer.(multiRenderer); ok {
+			return r.RenderAll(depth)
+		}
+		var imgs []image.Image
+		for materialNum := 1; materialNum <= s.NumMaterials(); materialNum++ {
+			img, err := s.renderer.Render(depth, materialNum)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, img)
+		}
+		return imgs, nil
+	})
+}
+
+// renderSamples calls render at the depth of each sub-slice of the slice,
+// rendered in tiles if needed, and returns the images averaged over the
+// sub-slices and box-filtered down to one pixel per voxel.
+func (s *Slicer) renderSamples(sliceDepth, delta float32, render func(depth float32) ([]image.Image, error)) ([]image.Image, error) {
 	if s.renderer == nil {
 		return nil, fmt.Errorf("renderer not initialized")
 	}
-	return s.renderer.Render(sliceDepth, materialNum)
+	depths := subSliceDepths(sliceDepth, delta, s.supersampleZ)
+	if len(depths) == 1 && s.xySamples <= 1 {
+		return s.renderTiles(func() ([]image.Image, error) { return render(sliceDepth) })
+	}
+
+	var sums []*sampleSum
+	for _, depth := range depths {
+		imgs, err := s.renderTiles(func() ([]image.Image, error) { return render(depth) })
+		if err != nil {
+			return nil, err
+		}
+		for i, img := range imgs {
+			if i == len(sums) {
+				sums = append(sums, newSampleSum(img, max(1, s.xySamples)))
+			}
+			sums[i].add(img)
+		}
+	}
+	imgs := make([]image.Image, len(sums))
+	for i, sum := range sums {
+		imgs[i] = sum.image()
+	}
+	return imgs, nil
+}
+
+// renderTiles calls render once, or once per tile with the projection
+// of the tile if the slice is rendered in tiles, and returns the
+// stitched images.
+func (s *Slicer) renderTiles(render func() ([]image.Image, error)) ([]image.Image, error) {
+	if s.tiles == nil {
+		return render()
+	}
+	last := s.tiles[len(s.tiles)-1].rect.Max
+	var result []draw.Image
+	for _, t := range s.tiles {
+		if err := s.renderer.(projectionSetter).setProjection(t.projection); err != nil {
+			return nil, err
+		}
+		imgs, err := render()
+		if err != nil {
+			return nil, fmt.Errorf("tile %v: %w", t.rect, err)
+		}
+		for i, img := range imgs {
+			if i == len(result) {
+				result = append(result, newImageLike(img, last.X, last.Y))
+			}
+			pasteTile(result[i], img, t.rect)
+		}
+	}
+	imgs := make([]image.Image, len(result))
+	for i, img := range result {
+		imgs[i] = img
+	}
+	return imgs, nil
 }
 
 // PrepareRenderX prepares the GPU to render along the X axis.
 func (s *Slicer) PrepareRenderX() error {
-	left := float32(s.irmf.Min[1])
-	right := float32(s.irmf.Max[1])
-	bottom := float32(s.irmf.Min[2])
-	top := float32(s.irmf.Max[2])
+	left := float32(s.min[1])
+	right := float32(s.max[1])
+	bottom := float32(s.min[2])
+	top := float32(s.max[2])
 	camera := mgl32.LookAtV(mgl32.Vec3{3, 0, 0}, mgl32.Vec3{0, 0, 0}, mgl32.Vec3{0, 0, 1})
-	vec3Str := "u_slice,fragVert.yz"
+	vec3Str, coords := s.placedCoords("u_slice,fragVert.yz", mgl32.Mat4FromRows(
+		mgl32.Vec4{0, 0, 1, 0}, mgl32.Vec4{1, 0, 0, 0}, mgl32.Vec4{0, 1, 0, 0}, mgl32.Vec4{0, 0, 0, 1},
+	), "fragVert.y", "fragVert.z", "u_slice")
 
 	xPlaneVertices[1], xPlaneVertices[11], xPlaneVertices[26] = left, left, left
 	xPlaneVertices[6], xPlaneVertices[16], xPlaneVertices[21] = right, right, right
@@ -279,17 +508,19 @@ func (s *Slicer) PrepareRenderX() error {
 		newHeight = int(0.5 + float32(newWidth)/aspectRatio)
 	}
 
-	return s.prepareRender(newWidth, newHeight, left, right, bottom, top, camera, vec3Str, xPlaneVertices)
+	return s.prepareRender(newWidth, newHeight, left, right, bottom, top, camera, vec3Str, coords, xPlaneVertices)
 }
 
 // PrepareRenderY prepares the GPU to render along the Y axis.
 func (s *Slicer) PrepareRenderY() error {
-	left := float32(s.irmf.Min[0])
-	right := float32(s.irmf.Max[0])
-	bottom := float32(s.irmf.Min[2])
-	top := float32(s.irmf.Max[2])
+	left := float32(s.min[0])
+	right := float32(s.max[0])
+	bottom := float32(s.min[2])
+	top := float32(s.max[2])
 	camera := mgl32.LookAtV(mgl32.Vec3{0, -3, 0}, mgl32.Vec3{0, 0, 0}, mgl32.Vec3{0, 0, 1})
-	vec3Str := "fragVert.x,u_slice,fragVert.z"
+	vec3Str, coords := s.placedCoords("fragVert.x,u_slice,fragVert.z", mgl32.Mat4FromRows(
+		mgl32.Vec4{1, 0, 0, 0}, mgl32.Vec4{0, 0, 1, 0}, mgl32.Vec4{0, 1, 0, 0}, mgl32.Vec4{0, 0, 0, 1},
+	), "fragVert.x", "fragVert.z", "u_slice")
 
 	yPlaneVertices[0], yPlaneVertices[10], yPlaneVertices[25] = left, left, left
 	yPlaneVertices[5], yPlaneVertices[15], yPlaneVertices[20] = right, right, right
@@ -303,17 +534,17 @@ func (s *Slicer) PrepareRenderY() error {
 		newHeight = int(0.5 + float32(newWidth)/aspectRatio)
 	}
 
-	return s.prepareRender(newWidth, newHeight, left, right, bottom, top, camera, vec3Str, yPlaneVertices)
+	return s.prepareRender(newWidth, newHeight, left, right, bottom, top, camera, vec3Str, coords, yPlaneVertices)
 }
 
 // PrepareRenderZ prepares the GPU to render along the Z axis.
 func (s *Slicer) PrepareRenderZ() error {
-	left := float32(s.irmf.Min[0])
-	right := float32(s.irmf.Max[0])
-	bottom := float32(s.irmf.Min[1])
-	top := float32(s.irmf.Max[1])
+	left := float32(s.min[0])
+	right := float32(s.max[0])
+	bottom := float32(s.min[1])
+	top := float32(s.max[1])
 	camera := mgl32.LookAtV(mgl32.Vec3{0, 0, 3}, mgl32.Vec3{0, 0, 0}, mgl32.Vec3{0, 1, 0})
-	vec3Str := "fragVert.xy,u_slice"
+	vec3Str, coords := s.placedCoords("fragVert.xy,u_slice", mgl32.Ident4(), "fragVert.x", "fragVert.y", "u_slice")
 
 	zPlaneVertices[0], zPlaneVertices[10], zPlaneVertices[25] = left, left, left
 	zPlaneVertices[5], zPlaneVertices[15], zPlaneVertices[20] = right, right, right
@@ -327,30 +558,119 @@ func (s *Slicer) PrepareRenderZ() error {
 		newHeight = int(0.5 + float32(newWidth)/aspectRatio)
 	}
 
-	return s.prepareRender(newWidth, newHeight, left, right, bottom, top, camera, vec3Str, zPlaneVertices)
+	return s.prepareRender(newWidth, newHeight, left, right, bottom, top, camera, vec3Str, coords, zPlaneVertices)
 }
 
-func (s *Slicer) prepareRender(newWidth, newHeight int, left, right, bottom, top float32, camera mgl32.Mat4, vec3Str string, planeVertices []float32) error {
+// PrepareRenderXContext is like PrepareRenderX, but returns ctx.Err()
+// without preparing the renderer if ctx is already done.
+func (s *Slicer) PrepareRenderXContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.PrepareRenderX()
+}
+
+// PrepareRenderYContext is like PrepareRenderY, but returns ctx.Err()
+// without preparing the renderer if ctx is already done.
+func (s *Slicer) PrepareRenderYContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.PrepareRenderY()
+}
+
+// PrepareRenderZContext is like PrepareRenderZ, but returns ctx.Err()
+// without preparing the renderer if ctx is already done.
+func (s *Slicer) PrepareRenderZContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.PrepareRenderZ()
+}
+
+func (s *Slicer) prepareRender(newWidth, newHeight int, left, right, bottom, top float32, camera mgl32.Mat4, vec3Str string, coords vec3Map, planeVertices []float32) error {
 	if newWidth%2 == 1 {
 		newWidth++
 		newHeight++
 	}
+	if newWidth <= 0 || newHeight <= 0 {
+		return fmt.Errorf("nothing to slice: the slices are %vx%v pixels", newWidth, newHeight)
+	}
 
 	if s.renderer == nil {
 		return fmt.Errorf("renderer not initialized")
 	}
-
-	if err := s.renderer.Init(newWidth, newHeight, s.view); err != nil {
-		return err
-	}
+	s.plane = nil
 
 	near, far := float32(0.1), float32(100.0)
 	projection := mgl32.Ortho(left, right, bottom, top, near, far)
 	model := mgl32.Ident4()
 
+	// Supersampled slices are rendered at a higher resolution
+	// and box-filtered by renderSamples.
+	s.xySamples = max(1, s.supersampleXY)
+	newWidth, newHeight = s.xySamples*newWidth, s.xySamples*newHeight
+
+	// The size limit of a GPU renderer is only known once it is
+	// initialized, so it is initialized again at the size of the tiles
+	// if the slices exceed it.
+	width, height := newWidth, newHeight
+	s.tiles = nil
+	if err := s.initRenderer(width, height); err != nil {
+		var deviceErr *DeviceError
+		if !errors.As(err, &deviceErr) {
+			return err
+		}
+		// No usable GPU or display; fall back to the CPU renderer.
+		s.setRenderer(&CPURenderer{})
+		if err := s.initRenderer(width, height); err != nil {
+			return err
+		}
+		if s.fallback != nil {
+			s.fallback(deviceErr)
+		}
+	}
+
+	if limit := s.tileLimit(); limit > 0 && (newWidth > limit || newHeight > limit) {
+		if _, ok := s.renderer.(projectionSetter); !ok {
+			return fmt.Errorf("%T is unable to render %vx%v slices, which exceed its maximum size of %v", s.renderer, newWidth, newHeight, limit)
+		}
+		s.tiles = newTiles(newWidth, newHeight, limit, left, right, bottom, top, near, far)
+		width, height = s.tiles[0].rect.Dx(), s.tiles[0].rect.Dy()
+		projection = s.tiles[0].projection
+		if err := s.initRenderer(width, height); err != nil {
+			return err
+		}
+	}
+
+	if r, ok := s.renderer.(coordsSetter); ok {
+		r.setCoords(coords)
+	}
 	return s.renderer.Prepare(s.irmf, vec3Str, planeVertices, projection, camera, model)
 }
 
+// tileLimit returns the maximum width and height of the images
+// rendered at once, or 0 if there is no limit.
+func (s *Slicer) tileLimit() int {
+	limit := s.maxTileSize
+	if r, ok := s.renderer.(maxSizer); ok {
+		if n := r.maxImageSize(); n > 0 && (limit <= 0 || n < limit) {
+			limit = n
+		}
+	}
+	return limit
+}
+
+// initRenderer sets the pixel format of the renderer and initializes it.
+func (s *Slicer) initRenderer(width, height int) error {
+	if r, ok := s.renderer.(pixelFormatSetter); ok {
+		r.setPixelFormat(s.format)
+	} else if s.format != RGBA8 {
+		return fmt.Errorf("%T does not support the %v pixel format", s.renderer, s.format)
+	}
+	return s.renderer.Init(width, height, s.view)
+}
+
 var xPlaneVertices = []float32{
 	//  X, Y, Z, U, V
 	0.0, -1.0, -1.0, 1.0, 0.0, // ll
@@ -0,0 +1,138 @@
+package irmf
+
+import (
+	"fmt"
+	"image"
+	"iter"
+	"time"
+)
+
+// Slice is a slice of one material, as yielded by the *Slices iterators.
+type Slice struct {
+	Num         int     // the index of the slice in the order of the iteration
+	Depth       float32 // the coordinate of the slice along the slicing axis, in model units
+	VoxelRadius float32 // half the thickness of the slice, in model units
+	Image       image.Image
+}
+
+// MultiSlice is a slice of all materials, as yielded by AllZSlices.
+type MultiSlice struct {
+	Num         int
+	Depth       float32
+	VoxelRadius float32
+	Images      []image.Image // one per material, in the order of the materials of the model
+}
+
+// XSlices returns an iterator over the X slices of the given materialNum
+// (1-based index), prepared by PrepareRenderX. Rendering stops when the
+// loop over the slices stops. If a slice cannot be rendered, the iterator
+// yields the error and stops.
+func (s *Slicer) XSlices(materialNum int, order Order) iter.Seq2[Slice, error] {
+	return singleSlices(s.slices(materialNum, s.min[0], s.max[0], s.deltaX, order, func(x float32) ([]image.Image, error) {
+		img, err := s.renderSlice(x, s.deltaX, materialNum)
+		if err != nil {
+			return nil, fmt.Errorf("renderXSlice(%v,%v): %w", x, materialNum, err)
+		}
+		return []image.Image{img}, nil
+	}))
+}
+
+// YSlices returns an iterator over the Y slices of the given materialNum
+// (1-based index), prepared by PrepareRenderY. See XSlices.
+func (s *Slicer) YSlices(materialNum int, order Order) iter.Seq2[Slice, error] {
+	return singleSlices(s.slices(materialNum, s.min[1], s.max[1], s.deltaY, order, func(y float32) ([]image.Image, error) {
+		img, err := s.renderSlice(y, s.deltaY, materialNum)
+		if err != nil {
+			return nil, fmt.Errorf("renderYSlice(%v,%v): %w", y, materialNum, err)
+		}
+		return []image.Image{img}, nil
+	}))
+}
+
+// ZSlices returns an iterator over the Z slices of the given materialNum
+// (1-based index), prepared by PrepareRenderZ. See XSlices.
+func (s *Slicer) ZSlices(materialNum int, order Order) iter.Seq2[Slice, error] {
+	return singleSlices(s.slices(materialNum, s.min[2], s.max[2], s.deltaZ, order, func(z float32) ([]image.Image, error) {
+		img, err := s.renderSlice(z, s.deltaZ, materialNum)
+		if err != nil {
+			return nil, fmt.Errorf("renderZSlice(%v,%v): %w", z, materialNum, err)
+		}
+		return []image.Image{img}, nil
+	}))
+}
+
+// AllZSlices returns an iterator over the Z slices of all materials at once,
+// prepared by PrepareRenderZ. See RenderAllZSlices and XSlices.
+func (s *Slicer) AllZSlices(order Order) iter.Seq2[MultiSlice, error] {
+	return s.slices(0, s.min[2], s.max[2], s.deltaZ, order, func(z float32) ([]image.Image, error) {
+		imgs, err := s.renderAllSlices(z, s.deltaZ)
+		if err != nil {
+			return nil, fmt.Errorf("renderAllZSlices(%v): %w", z, err)
+		}
+		return imgs, nil
+	})
+}
+
+// PlaneSlices returns an iterator over the slices of the given materialNum
+// (1-based index) on the planes prepared by PrepareRenderPlane, whose depths
+// are along the plane normal. See XSlices.
+func (s *Slicer) PlaneSlices(materialNum int, order Order) iter.Seq2[Slice, error] {
+	if s.plane == nil {
+		return func(yield func(Slice, error) bool) {
+			yield(Slice{}, fmt.Errorf("PrepareRenderPlane must be called before rendering its slices"))
+		}
+	}
+	return singleSlices(s.slices(materialNum, s.plane.min[2], s.plane.max[2], s.deltaZ, order, func(depth float32) ([]image.Image, error) {
+		img, err := s.renderSlice(depth, s.deltaZ, materialNum)
+		if err != nil {
+			return nil, fmt.Errorf("renderSlice(%v,%v): %w", depth, materialNum, err)
+		}
+		return []image.Image{img}, nil
+	}))
+}
+
+// slices returns an iterator over the slices of delta thickness between
+// min and max along an axis, rendered by render at the depth of each slice.
+// The progress of the given material (0 for all) is reported after each
+// slice is consumed.
+func (s *Slicer) slices(materialNum int, min, max, delta float32, order Order, render func(depth float32) ([]image.Image, error)) iter.Seq2[MultiSlice, error] {
+	return func(yield func(MultiSlice, error) bool) {
+		numSlices := int(0.5 + (max-min)/delta)
+		voxelRadius := 0.5 * delta
+		minVal := min + voxelRadius
+		start := time.Now()
+
+		for n := 0; n < numSlices; n++ {
+			i := n
+			if order == MaxToMin {
+				i = numSlices - n - 1
+			}
+			depth := minVal + float32(i)*delta
+
+			imgs, err := render(depth)
+			if err != nil {
+				yield(MultiSlice{}, err)
+				return
+			}
+			if !yield(MultiSlice{Num: n, Depth: depth, VoxelRadius: voxelRadius, Images: imgs}, nil) {
+				return
+			}
+			s.reportProgress(materialNum, n+1, numSlices, start)
+		}
+	}
+}
+
+// singleSlices converts an iterator over the slices of a single material.
+func singleSlices(seq iter.Seq2[MultiSlice, error]) iter.Seq2[Slice, error] {
+	return func(yield func(Slice, error) bool) {
+		for slice, err := range seq {
+			if err != nil {
+				yield(Slice{}, err)
+				return
+			}
+			if !yield(Slice{Num: slice.Num, Depth: slice.Depth, VoxelRadius: slice.VoxelRadius, Image: slice.Images[0]}, nil) {
+				return
+			}
+		}
+	}
+}
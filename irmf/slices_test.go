@@ -0,0 +1,90 @@
+package irmf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSlicesIterators(t *testing.T) {
+	var calls int
+	model, err := NewGoModel(sphereMeta, func(x, y, z float32) [16]float32 {
+		calls++
+		return sphereFunc(x, y, z)
+	})
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+	s := Init(false, 500, 500, 500)
+	defer s.Close()
+	if err := s.SetModel(model); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	if err := s.PrepareRenderZ(); err != nil {
+		t.Fatalf("PrepareRenderZ: %v", err)
+	}
+
+	var pixels int
+	for _, order := range []Order{MinToMax, MaxToMin} {
+		var want zSlices
+		if err := s.RenderZSlices(1, &want, order); err != nil {
+			t.Fatalf("RenderZSlices: %v", err)
+		}
+		var got zSlices
+		var prevDepth float32
+		for slice, err := range s.ZSlices(1, order) {
+			if err != nil {
+				t.Fatalf("ZSlices: %v", err)
+			}
+			if slice.Num != len(got) || slice.VoxelRadius != 0.25 {
+				t.Errorf("slice %v: Num = %v, VoxelRadius = %v, want %v and 0.25", len(got), slice.Num, slice.VoxelRadius, len(got))
+			}
+			if slice.Num > 0 && (order == MinToMax) != (slice.Depth > prevDepth) {
+				t.Errorf("order %v: slice %v at depth %v after %v", order, slice.Num, slice.Depth, prevDepth)
+			}
+			prevDepth = slice.Depth
+			got = append(got, slice.Image)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("order %v: ZSlices and RenderZSlices differ", order)
+		}
+		pixels = want[0].Bounds().Dx() * want[0].Bounds().Dy()
+	}
+
+	// Stopping early renders no more slices.
+	calls = 0
+	for slice, err := range s.ZSlices(1, MinToMax) {
+		if err != nil {
+			t.Fatalf("ZSlices: %v", err)
+		}
+		if slice.Num == 2 {
+			break
+		}
+	}
+	if want := 3 * pixels; calls != want {
+		t.Errorf("model evaluated %v times after 3 slices, want %v", calls, want)
+	}
+
+	var all allZSlices
+	if err := s.RenderAllZSlices(&all, MinToMax); err != nil {
+		t.Fatalf("RenderAllZSlices: %v", err)
+	}
+	var n int
+	for slice, err := range s.AllZSlices(MinToMax) {
+		if err != nil {
+			t.Fatalf("AllZSlices: %v", err)
+		}
+		if !reflect.DeepEqual(slice.Images, all[n]) {
+			t.Errorf("slice %v: AllZSlices and RenderAllZSlices differ", n)
+		}
+		n++
+	}
+	if n != len(all) {
+		t.Errorf("AllZSlices yielded %v slices, want %v", n, len(all))
+	}
+
+	for _, err := range s.PlaneSlices(1, MinToMax) {
+		if err == nil {
+			t.Error("PlaneSlices without PrepareRenderPlane: want an error")
+		}
+	}
+}
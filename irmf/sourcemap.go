@@ -0,0 +1,130 @@
+package irmf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sourceLine identifies a line of an IRMF file or one of its include files.
+type sourceLine struct {
+	file     string
+	line     int  // 1-based
+	included bool // file is an include file rather than the IRMF file
+}
+
+// sourceMap maps each line of an assembled shader (header, IRMF shader
+// with its includes expanded, and footer) back to where it came from.
+type sourceMap struct {
+	lines []string     // lines of the assembled shader
+	from  []sourceLine // origin of each line
+}
+
+// assembleShader concatenates header, the IRMF shader and footer and
+// returns the result along with its source map. The header must end
+// with a newline and the footer must start with one so that every line
+// of the IRMF shader keeps its own line (and columns) in the result.
+func (i *IRMF) assembleShader(header, footer string) (string, *sourceMap) {
+	src := header + i.Shader + footer
+	sm := &sourceMap{lines: strings.Split(src, "\n")}
+	add := func(file string, text string) {
+		for n := 1; n <= strings.Count(text, "\n")+1; n++ {
+			sm.from = append(sm.from, sourceLine{file: file, line: n})
+		}
+	}
+
+	add("<header>", strings.TrimSuffix(header, "\n"))
+	shaderLines := strings.Count(i.Shader, "\n") + 1
+	if len(i.lineMap) == shaderLines {
+		sm.from = append(sm.from, i.lineMap...)
+	} else {
+		add("<shader>", i.Shader)
+	}
+	add("<footer>", strings.TrimPrefix(footer, "\n"))
+	return src, sm
+}
+
+// locPattern matches a location in a compiler log. Only the text between
+// prefix and suffix bytes of the match is replaced by the original location.
+type locPattern struct {
+	re             *regexp.Regexp
+	prefix, suffix int
+}
+
+// Locations reported by the various GLSL compilers, naga (WGSL) and
+// internal/shader, respectively.
+var locPatterns = []locPattern{
+	{re: regexp.MustCompile(`\b0:(\d+)\((\d+)\)`)},                     // Mesa: "0:12(5): error: ..."
+	{re: regexp.MustCompile(`\b0\((\d+)\)`)},                           // NVIDIA: "0(12) : error C0000: ..."
+	{re: regexp.MustCompile(`\b0:(\d+):`), suffix: 1},                  // AMD, Intel, ANGLE: "ERROR: 0:12: ..."
+	{re: regexp.MustCompile(`┌─ \S*:(\d+):(\d+)`), prefix: len("┌─ ")}, // naga: "┌─ wgsl:12:5"
+	{re: regexp.MustCompile(`\bline (\d+), col (\d+)`)},                // internal/shader: "line 12, col 5: ..."
+}
+
+// rewrite replaces the assembled-shader locations in a compiler log with
+// "file:line:col" locations in the original source, and follows each
+// rewritten log line with a snippet of the offending source line.
+// A nil sourceMap returns the log unchanged.
+func (sm *sourceMap) rewrite(log string) string {
+	if sm == nil {
+		return log
+	}
+
+	var result []string
+	for _, logLine := range strings.Split(strings.TrimRight(log, "\x00\n"), "\n") {
+		var snippet string
+		for _, p := range locPatterns {
+			m := p.re.FindStringSubmatchIndex(logLine)
+			if m == nil {
+				continue
+			}
+			line, _ := strconv.Atoi(logLine[m[2]:m[3]])
+			var col int
+			if len(m) > 4 {
+				col, _ = strconv.Atoi(logLine[m[4]:m[5]])
+			}
+			if loc, ok := sm.location(line, col); ok {
+				logLine = logLine[:m[0]+p.prefix] + loc + logLine[m[1]-p.suffix:]
+				snippet = sm.snippet(line, col)
+			}
+			break
+		}
+		result = append(result, logLine)
+		if snippet != "" {
+			result = append(result, snippet)
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+// location returns the original "file:line:col" of the given 1-based
+// line and column of the assembled shader. col may be 0 if unknown.
+func (sm *sourceMap) location(line, col int) (string, bool) {
+	if line < 1 || line > len(sm.from) {
+		return "", false
+	}
+	from := sm.from[line-1]
+	if col <= 0 {
+		return fmt.Sprintf("%v:%v", from.file, from.line), true
+	}
+	return fmt.Sprintf("%v:%v:%v", from.file, from.line, col), true
+}
+
+// snippet returns the given line of the assembled shader,
+// followed by a caret under col if it is known.
+func (sm *sourceMap) snippet(line, col int) string {
+	text := strings.TrimRight(sm.lines[line-1], "\x00")
+	s := "    " + text
+	if col > 0 && col <= len(text)+1 {
+		// Preserve tabs so that the caret lines up.
+		pad := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, text[:col-1])
+		s += "\n    " + pad + "^"
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package irmf
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sourceMapModel = `/*{
+  irmf: "1.0",
+  materials: ["PLA"],
+  max: [1,1,1],
+  min: [-1,-1,-1],
+  units: "mm",
+}*/
+#include "util.glsl"
+
+void mainModel4(out vec4 materials, in vec3 xyz) {
+  materials[0] = bogus(xyz);
+}
+`
+
+const sourceMapUtil = "float helper(vec3 p) {\n  return length(p) - undefinedVar;\n}"
+
+func TestSourceMapRewrite(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "util.glsl"), sourceMapUtil)
+	model, err := newModel([]byte(sourceMapModel), IncludeOptions{Dir: dir, Filename: "model.irmf"})
+	if err != nil {
+		t.Fatalf("newModel: %v", err)
+	}
+	_, sm := model.assembleShader(fsHeader, genFooter(1, "fragVert"))
+
+	// fsHeader has 7 lines, so util.glsl starts on line 8 and
+	// model.irmf:11 ends up on line 13 of the assembled shader.
+	const mainSnippet = "    " + "  materials[0] = bogus(xyz);"
+	tests := []struct {
+		name string
+		log  string
+		want string
+	}{
+		{
+			name: "mesa",
+			log:  "0:13(18): error: `bogus' undeclared\x00",
+			want: "model.irmf:11:18: error: `bogus' undeclared\n" + mainSnippet + "\n    " + strings.Repeat(" ", 17) + "^",
+		},
+		{
+			name: "nvidia",
+			log:  `0(9) : error C1008: undefined variable "undefinedVar"`,
+			want: `util.glsl:2 : error C1008: undefined variable "undefinedVar"` + "\n    " + "  return length(p) - undefinedVar;",
+		},
+		{
+			name: "amd",
+			log:  "ERROR: 0:13: 'bogus' : no matching overloaded function found\nERROR: 1 compilation errors.  No code generated.\n",
+			want: "ERROR: model.irmf:11: 'bogus' : no matching overloaded function found\n" + mainSnippet + "\nERROR: 1 compilation errors.  No code generated.",
+		},
+		{
+			name: "naga",
+			log:  "error: no definition in scope for identifier: 'bogus'\n   ┌─ wgsl:13:18\n   │",
+			want: "error: no definition in scope for identifier: 'bogus'\n   ┌─ model.irmf:11:18\n" + mainSnippet + "\n    " + strings.Repeat(" ", 17) + "^\n   │",
+		},
+		{
+			name: "header",
+			log:  "0:2(1): error: bad precision",
+			want: "<header>:2:1: error: bad precision\n    precision highp float;\n    ^",
+		},
+		{
+			name: "footer",
+			log:  "0:18(3): error: bad footer",
+			want: "<footer>:3:3: error: bad footer\n      mainModel4(m, vec3(fragVert));\n      ^",
+		},
+		{
+			name: "out of range",
+			log:  "0:999(1): error: no such line",
+			want: "0:999(1): error: no such line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sm.rewrite(tt.log); got != tt.want {
+				t.Errorf("rewrite =\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceMapCPUErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		util    string
+		wantErr string
+	}{
+		{
+			name:    "error in IRMF file",
+			util:    "float helper(vec3 p) {\n  return length(p);\n}",
+			wantErr: "model.irmf:11:18: undefined function \"bogus\"\n    " + "  materials[0] = bogus(xyz);",
+		},
+		{
+			name:    "error in include file",
+			util:    sourceMapUtil,
+			wantErr: "util.glsl:2:22: undeclared identifier \"undefinedVar\"\n    " + "  return length(p) - undefinedVar;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "util.glsl"), tt.util)
+
+			s := Init(false, 500, 500, 500)
+			defer s.Close()
+			s.UseCPU(true)
+			s.SetIncludeOptions(IncludeOptions{Dir: dir, Filename: "model.irmf"})
+			if err := s.NewModel([]byte(sourceMapModel)); err != nil {
+				t.Fatalf("NewModel: %v", err)
+			}
+			err := s.PrepareRenderZ()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("PrepareRenderZ error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
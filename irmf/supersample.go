@@ -0,0 +1,94 @@
+package irmf
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// subSliceDepths returns the depths of the n sub-slices that sample
+// the voxels of the slice at sliceDepth whose thickness is delta.
+// The sub-slices are centered in n equal layers of the voxels.
+func subSliceDepths(sliceDepth, delta float32, n int) []float32 {
+	if n <= 1 {
+		return []float32{sliceDepth}
+	}
+	depths := make([]float32, n)
+	for k := range depths {
+		depths[k] = sliceDepth + delta*float32(2*k+1-n)/float32(2*n)
+	}
+	return depths
+}
+
+// sampleSum accumulates the samples of a slice image, box-filtered
+// into voxels of factor x factor rendered pixels.
+type sampleSum struct {
+	gray     bool // *Gray32f slices; otherwise RGBA slices
+	factor   int
+	width    int // in voxels
+	height   int
+	channels int
+	sum      []float32
+	samples  int // number of rendered pixels added to each voxel
+}
+
+// newSampleSum returns an empty sampleSum for images like img,
+// which is rendered at factor times the resolution of the slice.
+func newSampleSum(img image.Image, factor int) *sampleSum {
+	b := img.Bounds()
+	s := &sampleSum{factor: factor, width: b.Dx() / factor, height: b.Dy() / factor, channels: 4}
+	if _, ok := img.(*Gray32f); ok {
+		s.gray, s.channels = true, 1
+	}
+	s.sum = make([]float32, s.channels*s.width*s.height)
+	return s
+}
+
+// add adds the pixels of the rendered image img to the sum.
+func (s *sampleSum) add(img image.Image) {
+	s.samples += s.factor * s.factor
+	if g, ok := img.(*Gray32f); ok && s.gray {
+		for y := 0; y < s.height*s.factor; y++ {
+			row := g.Pix[g.PixOffset(g.Rect.Min.X, g.Rect.Min.Y+y):]
+			sum := s.sum[(y/s.factor)*s.width:]
+			for x := 0; x < s.width*s.factor; x++ {
+				sum[x/s.factor] += row[x]
+			}
+		}
+		return
+	}
+
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	}
+	for y := 0; y < s.height*s.factor; y++ {
+		row := rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y+y):]
+		sum := s.sum[4*(y/s.factor)*s.width:]
+		for x := 0; x < s.width*s.factor; x++ {
+			for c := 0; c < 4; c++ {
+				sum[4*(x/s.factor)+c] += float32(row[4*x+c])
+			}
+		}
+	}
+}
+
+// image returns the average of the added samples of each voxel,
+// in the format of the rendered images.
+func (s *sampleSum) image() image.Image {
+	r := image.Rect(0, 0, s.width, s.height)
+	scale := 1 / float32(s.samples)
+	if s.gray {
+		img := NewGray32f(r)
+		for i, v := range s.sum {
+			img.Pix[i] = v * scale
+		}
+		return img
+	}
+	img := image.NewRGBA(r)
+	for i, v := range s.sum {
+		img.Pix[i] = uint8(math.Round(float64(v * scale)))
+	}
+	return img
+}
@@ -0,0 +1,113 @@
+package irmf
+
+import (
+	"image"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSubSliceDepths(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []float32
+	}{
+		{n: 0, want: []float32{2}},
+		{n: 1, want: []float32{2}},
+		{n: 2, want: []float32{1.5, 2.5}},
+		{n: 4, want: []float32{1.25, 1.75, 2.25, 2.75}},
+	}
+	for _, tt := range tests {
+		if got := subSliceDepths(2, 2, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("subSliceDepths(2, 2, %v) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSampleSum(t *testing.T) {
+	gray := NewGray32f(image.Rect(0, 0, 4, 2))
+	copy(gray.Pix, []float32{1, 0, 1, 1, 0, 0, 1, 1})
+	sum := newSampleSum(gray, 2)
+	sum.add(gray)
+	if got, want := sum.image().(*Gray32f).Pix, []float32{0.25, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Gray32f average = %v, want %v", got, want)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	copy(rgba.Pix, []uint8{255, 255, 255, 255, 0, 0, 0, 255, 0, 0, 0, 255, 0, 0, 0, 255})
+	sum = newSampleSum(rgba, 2)
+	sum.add(rgba)
+	sum.add(image.NewRGBA(rgba.Rect))
+	if got, want := sum.image().(*image.RGBA).Pix, []uint8{32, 32, 32, 128}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RGBA average = %v, want %v", got, want)
+	}
+}
+
+func TestSupersampling(t *testing.T) {
+	// Slab of the 10mm box with x < -3.75 and z <= -4.5: the voxels of
+	// the first slice are half occupied in Z, and the voxels of its second
+	// column are a quarter occupied in X.
+	model, err := NewGoModel(sphereMeta, func(x, y, z float32) (m [16]float32) {
+		if x < -3.75 && z <= -4.5 {
+			m[0] = 1
+		}
+		return m
+	})
+	if err != nil {
+		t.Fatalf("NewGoModel: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		xy, z       int
+		maxTileSize int
+		want        [2][2]float32 // [slice][column]
+	}{
+		{name: "none", want: [2][2]float32{{1, 0}, {0, 0}}},
+		{name: "xy", xy: 4, want: [2][2]float32{{1, 0.25}, {0, 0}}},
+		{name: "z", z: 4, want: [2][2]float32{{0.5, 0}, {0, 0}}},
+		{name: "both", xy: 4, z: 4, want: [2][2]float32{{0.5, 0.125}, {0, 0}}},
+		{name: "both tiled", xy: 4, z: 4, maxTileSize: 7, want: [2][2]float32{{0.5, 0.125}, {0, 0}}},
+	}
+
+	for _, tt := range tests {
+		for _, format := range []PixelFormat{RGBA8, R32F} {
+			t.Run(tt.name+" "+format.String(), func(t *testing.T) {
+				s := Init(false, 1000, 1000, 1000)
+				s.SetPixelFormat(format)
+				s.SetSupersampling(tt.xy, tt.z)
+				s.SetMaxTileSize(tt.maxTileSize)
+				defer s.Close()
+				if err := s.SetModel(model); err != nil {
+					t.Fatalf("SetModel: %v", err)
+				}
+				perMaterial, all := renderBothWays(t, s)
+				compareRenderings(t, perMaterial, all)
+
+				slices := all[0]
+				if len(slices) != 10 {
+					t.Fatalf("got %v slices, want 10", len(slices))
+				}
+				for n, row := range tt.want {
+					img := slices[n]
+					if got, want := img.Bounds(), image.Rect(0, 0, 10, 10); got != want {
+						t.Fatalf("slice %v bounds = %v, want %v", n, got, want)
+					}
+					for x, want := range row {
+						var got float32
+						switch img := img.(type) {
+						case *Gray32f:
+							got = img.Gray32fAt(x, 5)
+						case *image.RGBA:
+							got = float32(img.RGBAAt(x, 5).R) / 255
+							want = float32(math.Round(float64(want*255))) / 255
+						}
+						if math.Abs(float64(got-want)) > 1e-6 {
+							t.Errorf("slice %v, column %v: density = %v, want %v", n, x, got, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
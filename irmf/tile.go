@@ -0,0 +1,79 @@
+package irmf
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// maxSizer is implemented by the renderers whose images are limited
+// in size, such as by the maximum texture size of the GPU.
+type maxSizer interface {
+	// maxImageSize returns the maximum width and height of the rendered
+	// images, or 0 if it is unknown until the renderer is initialized.
+	maxImageSize() int
+}
+
+// projectionSetter is implemented by the renderers that can change
+// the projection after Prepare, which is used to render slices in tiles.
+type projectionSetter interface {
+	setProjection(projection mgl32.Mat4) error
+}
+
+// tile is the part of a slice rendered at once when the slice
+// is larger than the renderer supports.
+type tile struct {
+	rect       image.Rectangle // the pixels of the slice covered by the tile
+	projection mgl32.Mat4
+}
+
+// newTiles splits a slice of width x height pixels, whose orthographic
+// projection is given by left, right, bottom, top, near and far, into tiles
+// of at most maxSize x maxSize pixels. All the tiles are rendered at the size
+// of the first one; the tiles at the right and top edges of the slice are
+// cropped.
+func newTiles(width, height, maxSize int, left, right, bottom, top, near, far float32) []tile {
+	nx, ny := (width+maxSize-1)/maxSize, (height+maxSize-1)/maxSize
+	tileWidth, tileHeight := (width+nx-1)/nx, (height+ny-1)/ny
+	dx, dy := (right-left)/float32(width), (top-bottom)/float32(height)
+
+	var tiles []tile
+	for y := 0; y < height; y += tileHeight {
+		for x := 0; x < width; x += tileWidth {
+			l, b := left+float32(x)*dx, bottom+float32(y)*dy
+			tiles = append(tiles, tile{
+				rect:       image.Rect(x, y, min(x+tileWidth, width), min(y+tileHeight, height)),
+				projection: mgl32.Ortho(l, l+float32(tileWidth)*dx, b, b+float32(tileHeight)*dy, near, far),
+			})
+		}
+	}
+	return tiles
+}
+
+// newImageLike returns an empty image of the given size with the
+// pixel type of img.
+func newImageLike(img image.Image, width, height int) draw.Image {
+	r := image.Rect(0, 0, width, height)
+	if _, ok := img.(*Gray32f); ok {
+		return NewGray32f(r)
+	}
+	return image.NewRGBA(r)
+}
+
+// pasteTile copies the rendered tile src to the rect of dst. src may be larger
+// than rect, in which case its extra columns and rows are dropped. Both images
+// have row 0 at the bottom of the slice, so no flipping is needed.
+func pasteTile(dst draw.Image, src image.Image, rect image.Rectangle) {
+	sb := src.Bounds()
+	rect = rect.Intersect(dst.Bounds()).Intersect(sb.Sub(sb.Min).Add(rect.Min))
+	if d, ok := dst.(*Gray32f); ok {
+		if s, ok := src.(*Gray32f); ok {
+			for y := 0; y < rect.Dy(); y++ {
+				copy(d.Pix[d.PixOffset(rect.Min.X, rect.Min.Y+y):][:rect.Dx()], s.Pix[s.PixOffset(sb.Min.X, sb.Min.Y+y):])
+			}
+			return
+		}
+	}
+	draw.Draw(dst, rect, src, sb.Min, draw.Src)
+}
@@ -0,0 +1,118 @@
+package irmf
+
+import (
+	"image"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestNewTiles(t *testing.T) {
+	tiles := newTiles(10, 7, 4, 0, 20, 0, 14, 0.1, 100)
+	var got []image.Rectangle
+	for _, tile := range tiles {
+		got = append(got, tile.rect)
+	}
+	want := []image.Rectangle{
+		image.Rect(0, 0, 4, 4), image.Rect(4, 0, 8, 4), image.Rect(8, 0, 10, 4),
+		image.Rect(0, 4, 4, 7), image.Rect(4, 4, 8, 7), image.Rect(8, 4, 10, 7),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("newTiles rects = %v, want %v", got, want)
+	}
+
+	// Each pixel is 2x2 model units, and every tile is rendered at 4x4 pixels.
+	for _, tile := range tiles {
+		min := tile.rect.Min
+		lowerLeft := mgl32.TransformCoordinate(mgl32.Vec3{2 * float32(min.X), 2 * float32(min.Y), -1}, tile.projection)
+		upperRight := mgl32.TransformCoordinate(mgl32.Vec3{2 * float32(min.X+4), 2 * float32(min.Y+4), -1}, tile.projection)
+		if !lowerLeft.Vec2().ApproxEqual(mgl32.Vec2{-1, -1}) || !upperRight.Vec2().ApproxEqual(mgl32.Vec2{1, 1}) {
+			t.Errorf("tile %v: corners project to %v and %v, want (-1,-1) and (1,1)", tile.rect, lowerLeft, upperRight)
+		}
+	}
+}
+
+// renderTiled renders all materials of the model with RenderZSlices and
+// with RenderAllZSlices, either whole or in tiles of at most maxTileSize pixels.
+func renderTiled(t *testing.T, src []byte, cpu bool, format PixelFormat, maxTileSize int) (perMaterial, all []zSlices) {
+	t.Helper()
+	s := Init(false, 500, 500, 500)
+	s.UseCPU(cpu)
+	s.SetPixelFormat(format)
+	s.SetMaxTileSize(maxTileSize)
+	defer s.Close()
+	if err := s.NewModel(src); err != nil {
+		t.Fatalf("NewModel: %v", err)
+	}
+	perMaterial, all = renderBothWays(t, s)
+	if _, ok := s.renderer.(*OpenGLRenderer); !cpu && !ok {
+		t.Skip("no OpenGL context is available")
+	}
+	if maxTileSize > 0 && len(s.tiles) < 2 {
+		t.Fatalf("got %v tiles, want several", len(s.tiles))
+	}
+	return perMaterial, all
+}
+
+func TestTiledRendering(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// The OpenGL renderings of the 45 degree cuts in sphere-3 differ
+	// by rounding, so use sphere-1 for OpenGL.
+	tests := []struct {
+		name   string
+		model  string
+		cpu    bool
+		format PixelFormat
+	}{
+		{name: "cpu", model: "sphere-3", cpu: true},
+		{name: "cpu r32f", model: "sphere-3", cpu: true, format: R32F},
+		{name: "opengl", model: "sphere-1"},
+		{name: "opengl r32f", model: "sphere-1", format: R32F},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := os.ReadFile("../testdata/" + tt.model + "-glsl.irmf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantPerMaterial, wantAll := renderTiled(t, buf, tt.cpu, tt.format, 0)
+			gotPerMaterial, gotAll := renderTiled(t, buf, tt.cpu, tt.format, 7)
+			for i, got := range [][]zSlices{gotPerMaterial, gotAll} {
+				want := [][]zSlices{wantPerMaterial, wantAll}[i]
+				for m := range want {
+					for n := range want[m] {
+						g, w := got[m][n], want[m][n]
+						if g.Bounds() != w.Bounds() {
+							t.Fatalf("material %v, slice %v: tiled bounds %v, want %v", m+1, n, g.Bounds(), w.Bounds())
+						}
+						if diffs := countDiffs(g, w); diffs > g.Bounds().Dx()*g.Bounds().Dy()/100 {
+							t.Errorf("material %v, slice %v: tiled and whole renderings differ in %v pixels", m+1, n, diffs)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// countDiffs returns the number of pixels whose densities differ.
+func countDiffs(a, b image.Image) int {
+	var diffs int
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ar, _, _, _ := a.At(x, y).RGBA()
+			br, _, _, _ := b.At(x, y).RGBA()
+			if ar>>8 != br>>8 {
+				diffs++
+			}
+		}
+	}
+	return diffs
+}
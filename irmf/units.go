@@ -0,0 +1,23 @@
+package irmf
+
+import "fmt"
+
+// unitsToMM maps the supported IRMF "units" values to millimeters.
+var unitsToMM = map[string]float32{
+	"µm": 0.001, // micro sign (U+00B5)
+	"μm": 0.001, // Greek small letter mu (U+03BC)
+	"um": 0.001,
+	"mm": 1,
+	"cm": 10,
+	"m":  1000,
+	"in": 25.4,
+}
+
+// MMPerUnit returns the number of millimeters in one model unit.
+func (i *IRMF) MMPerUnit() (float32, error) {
+	mm, ok := unitsToMM[i.Units]
+	if !ok {
+		return 0, fmt.Errorf("unsupported units %q: must be one of: µm, mm, cm, m, in", i.Units)
+	}
+	return mm, nil
+}
@@ -0,0 +1,73 @@
+package irmf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		units  string
+		radius float32 // 5mm in model units
+	}{
+		{units: "mm", radius: 5},
+		{units: "cm", radius: 0.5},
+		{units: "m", radius: 0.005},
+		{units: "in", radius: 5 / 25.4},
+		{units: "µm", radius: 5000},
+		{units: "um", radius: 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.units, func(t *testing.T) {
+			r := tt.radius
+			model, err := NewGoModel(IRMF{
+				Materials: []string{"PLA"},
+				Min:       []float32{-r, -r, -r},
+				Max:       []float32{r, r, r},
+				Units:     tt.units,
+			}, sphereFunc)
+			if err != nil {
+				t.Fatalf("NewGoModel: %v", err)
+			}
+
+			s := Init(false, 500, 500, 500)
+			if err := s.SetModel(model); err != nil {
+				t.Fatalf("SetModel: %v", err)
+			}
+			if got, want := s.NumZSlices(), 20; got != want {
+				t.Errorf("NumZSlices = %v, want %v", got, want)
+			}
+			min, max := s.MBB()
+			for i := 0; i < 3; i++ {
+				if min[i] < -5.001 || min[i] > -4.999 || max[i] < 4.999 || max[i] > 5.001 {
+					t.Errorf("MBB = %v-%v, want (-5,-5,-5)-(5,5,5)", min, max)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownUnits(t *testing.T) {
+	src := `/*{
+  "irmf": "1.0",
+  "language": "glsl",
+  "materials": ["PLA"],
+  "max": [5,5,5],
+  "min": [-5,-5,-5],
+  "units": "furlongs",
+}*/
+
+void mainModel4(out vec4 materials, in vec3 xyz) {
+  materials[0] = 1.0;
+}
+`
+	_, err := newModel([]byte(src), IncludeOptions{})
+	if err == nil {
+		t.Fatal("newModel: expected error for unknown units")
+	}
+	if want := "invalid JSON blob on line 7: unsupported units"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("newModel error = %v, want prefix %q", err, want)
+	}
+}
@@ -1,8 +1,10 @@
 package irmf
 
 import (
+	"encoding/binary"
 	"fmt"
 	"image"
+	"math"
 	"strings"
 
 	"github.com/cogentcore/webgpu/wgpu"
@@ -17,6 +19,7 @@ type WebGPURenderer struct {
 	width       int
 	height      int
 	view        bool
+	format      PixelFormat
 	bytesPerRow uint32
 
 	instance *wgpu.Instance
@@ -35,10 +38,29 @@ type WebGPURenderer struct {
 	surface       *wgpu.Surface
 	surfaceFormat wgpu.TextureFormat
 
+	// The shader module holds an fs_all<i> entry point for each of allPasses.
+	// Their pipelines and targets are created when RenderAll is first called.
+	shaderModule   *wgpu.ShaderModule
+	pipelineLayout *wgpu.PipelineLayout
+	allPasses      []materialPass
+	allPipelines   []*wgpu.RenderPipeline
+	allTextures    []*wgpu.Texture
+	allViews       []*wgpu.TextureView
+	allReadBuffer  *wgpu.Buffer
+	allBytesPerRow uint32
+
 	irmf *IRMF
 }
 
 func (r *WebGPURenderer) Init(width, height int, view bool) error {
+	if err := r.initDevice(width, height, view); err != nil {
+		return &DeviceError{Renderer: "WebGPU", Err: err}
+	}
+	return nil
+}
+
+// initDevice creates the window (if any) and the WebGPU device.
+func (r *WebGPURenderer) initDevice(width, height int, view bool) error {
 	if r.window != nil && (r.width != width || r.height != height) {
 		r.Close()
 	}
@@ -90,18 +112,74 @@ func (r *WebGPURenderer) Init(width, height int, view bool) error {
 	return nil
 }
 
+func (r *WebGPURenderer) setPixelFormat(f PixelFormat) { r.format = f }
+
+// textureFormat returns the format of the target texture
+// and the size of its pixels in bytes.
+func (r *WebGPURenderer) textureFormat() (wgpu.TextureFormat, int) {
+	switch r.format {
+	case R16F:
+		return wgpu.TextureFormatR16Float, 2
+	case R32F:
+		return wgpu.TextureFormatR32Float, 4
+	}
+	return wgpu.TextureFormatRGBA8Unorm, 4
+}
+
+// allTextureFormat returns the format of the targets used by RenderAll,
+// the size of their pixels in bytes and their cost towards the
+// maxColorAttachmentBytesPerSample limit.
+func (r *WebGPURenderer) allTextureFormat() (format wgpu.TextureFormat, pixelSize, cost int) {
+	switch r.format {
+	case R16F:
+		return wgpu.TextureFormatRGBA16Float, 8, 8
+	case R32F:
+		return wgpu.TextureFormatRGBA32Float, 16, 16
+	}
+	return wgpu.TextureFormatRGBA8Unorm, 4, 8
+}
+
+// wgpuClipSpace maps the OpenGL clip space depth of [-1,1] to [0,1].
+var wgpuClipSpace = mgl32.Mat4{
+	1, 0, 0, 0,
+	0, 1, 0, 0,
+	0, 0, 0.5, 0,
+	0, 0, 0.5, 1,
+}
+
+// maxImageSize returns the maximum texture size of the device,
+// or 0 if it is unknown because the device is not created yet.
+func (r *WebGPURenderer) maxImageSize() int {
+	if r.device == nil {
+		return 0
+	}
+	return int(r.device.GetLimits().Limits.MaxTextureDimension2D)
+}
+
+// setProjection replaces the projection matrix set by Prepare.
+func (r *WebGPURenderer) setProjection(projection mgl32.Mat4) error {
+	projection = wgpuClipSpace.Mul4(projection)
+	return r.queue.WriteBuffer(r.uniformBuffer, 0, wgpu.ToBytes(projection[:]))
+}
+
 func (r *WebGPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []float32, projection, camera, model mgl32.Mat4) error {
 	r.irmf = irmf
+	r.closeAll()
 
-	projection = mgl32.Mat4{
-		1, 0, 0, 0,
-		0, 1, 0, 0,
-		0, 0, 0.5, 0,
-		0, 0, 0.5, 1,
-	}.Mul4(projection)
+	projection = wgpuClipSpace.Mul4(projection)
 
 	// Define WGSL shader
-	shaderSource := wgslVertexShader + wgslFSHeader + irmf.Shader + genWGSLFooter(len(irmf.Materials), vec3Str)
+	opts, err := irmf.uniformOptions()
+	if err != nil {
+		return err
+	}
+	numMaterials := len(irmf.Materials)
+	limits := r.device.GetLimits().Limits
+	_, _, cost := r.allTextureFormat()
+	maxTargets := min(int(limits.MaxColorAttachments), int(limits.MaxColorAttachmentBytesPerSample)/cost, numTargets(numMaterials))
+	r.allPasses = materialPasses(numMaterials, max(maxTargets, 1))
+	footer := genWGSLFooter(numMaterials, vec3Str) + genWGSLFooterAll(numMaterials, vec3Str, r.allPasses)
+	shaderSource, sm := irmf.assembleShader(wgslHeader(opts)+wgslFSHeader, footer)
 
 	shaderModule, err := r.device.CreateShaderModule(&wgpu.ShaderModuleDescriptor{
 		WGSLDescriptor: &wgpu.ShaderModuleWGSLDescriptor{
@@ -109,9 +187,9 @@ func (r *WebGPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create shader module: %w", err)
+		return fmt.Errorf("failed to create shader module: %w", &CompileError{Language: "wgsl", Log: sm.rewrite(err.Error())})
 	}
-	defer shaderModule.Release()
+	r.shaderModule = shaderModule
 
 	// Create Buffers
 	r.vertexBuffer, err = r.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
@@ -124,12 +202,13 @@ func (r *WebGPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 	}
 
 	// Uniforms
-	uniformData := make([]float32, 16*3+4) // 3 matrices + u_slice + u_materialNum + 2 floats padding
+	uniformData := make([]float32, 16*3+2) // 3 matrices + u_slice + u_materialNum, then the options and padding
 	copy(uniformData[0:16], projection[:])
 	copy(uniformData[16:32], camera[:])
 	copy(uniformData[32:48], model[:])
 	uniformData[48] = 0.0 // u_slice
 	uniformData[49] = 1.0 // u_materialNum
+	uniformData = append(uniformData, wgslOptionData(opts)...)
 
 	r.uniformBuffer, err = r.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
 		Label:    "Uniform Buffer",
@@ -177,9 +256,10 @@ func (r *WebGPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 	if err != nil {
 		return fmt.Errorf("failed to create pipeline layout: %w", err)
 	}
-	defer pipelineLayout.Release()
+	r.pipelineLayout = pipelineLayout
 
 	// Target Texture for offscreen rendering
+	targetFormat, pixelSize := r.textureFormat()
 	r.targetTexture, err = r.device.CreateTexture(&wgpu.TextureDescriptor{
 		Label: "Target Texture",
 		Size: wgpu.Extent3D{
@@ -190,7 +270,7 @@ func (r *WebGPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 		MipLevelCount: 1,
 		SampleCount:   1,
 		Dimension:     wgpu.TextureDimension2D,
-		Format:        wgpu.TextureFormatRGBA8Unorm,
+		Format:        targetFormat,
 		Usage:         wgpu.TextureUsageRenderAttachment | wgpu.TextureUsageCopySrc,
 	})
 	if err != nil {
@@ -239,7 +319,7 @@ func (r *WebGPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 			EntryPoint: "fs_main",
 			Targets: []wgpu.ColorTargetState{
 				{
-					Format:    wgpu.TextureFormatRGBA8Unorm,
+					Format:    targetFormat,
 					WriteMask: wgpu.ColorWriteMaskAll,
 				},
 			},
@@ -299,7 +379,7 @@ func (r *WebGPURenderer) Prepare(irmf *IRMF, vec3Str string, planeVertices []flo
 	}
 
 	// Read buffer for capturing results
-	r.bytesPerRow = (uint32(r.width*4) + 255) &^ 255
+	r.bytesPerRow = (uint32(r.width*pixelSize) + 255) &^ 255
 	r.readBuffer, err = r.device.CreateBuffer(&wgpu.BufferDescriptor{
 		Label: "Read Buffer",
 		Size:  uint64(r.bytesPerRow * uint32(r.height)),
@@ -405,9 +485,27 @@ func (r *WebGPURenderer) Render(sliceDepth float32, materialNum int) (image.Imag
 	}
 
 	// Map buffer and read image
+	var img image.Image
+	err = r.mapRead(r.readBuffer, uint64(r.bytesPerRow*uint32(r.height)), func(data []byte) {
+		img = r.readImage(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if r.view {
+		glfw.PollEvents()
+	}
+
+	return img, nil
+}
+
+// mapRead maps the first size bytes of buffer, calls read with them
+// and unmaps the buffer.
+func (r *WebGPURenderer) mapRead(buffer *wgpu.Buffer, size uint64, read func(data []byte)) error {
 	done := make(chan struct{})
 	var mapStatus wgpu.BufferMapAsyncStatus
-	r.readBuffer.MapAsync(wgpu.MapModeRead, 0, uint64(r.bytesPerRow*uint32(r.height)), func(status wgpu.BufferMapAsyncStatus) {
+	buffer.MapAsync(wgpu.MapModeRead, 0, size, func(status wgpu.BufferMapAsyncStatus) {
 		mapStatus = status
 		close(done)
 	})
@@ -424,31 +522,283 @@ func (r *WebGPURenderer) Render(sliceDepth float32, materialNum int) (image.Imag
 
 mapped:
 	if mapStatus != wgpu.BufferMapAsyncStatusSuccess {
-		return nil, fmt.Errorf("failed to map read buffer: %v", mapStatus)
+		return fmt.Errorf("failed to map read buffer: %v", mapStatus)
 	}
 
-	data := r.readBuffer.GetMappedRange(0, uint(r.bytesPerRow*uint32(r.height)))
-	rgba := &image.RGBA{
-		Pix:    make([]uint8, r.width*r.height*4),
-		Stride: r.width * 4,
-		Rect:   image.Rect(0, 0, r.width, r.height),
+	read(buffer.GetMappedRange(0, uint(size)))
+	buffer.Unmap()
+	return nil
+}
+
+// RenderAll renders every material of the slice into multiple render
+// targets, four materials per RGBA target, so the model is evaluated
+// only once per pixel unless its materials need more targets than
+// the device supports. It returns the same images as calling Render
+// for each material.
+func (r *WebGPURenderer) RenderAll(sliceDepth float32) ([]image.Image, error) {
+	if r.shaderModule == nil {
+		return nil, fmt.Errorf("WebGPURenderer: Prepare must be called before RenderAll")
+	}
+	if r.allPipelines == nil {
+		if err := r.initAll(); err != nil {
+			return nil, err
+		}
 	}
-	for y := 0; y < r.height; y++ {
-		srcStart := uint32(y) * r.bytesPerRow
-		srcEnd := srcStart + uint32(r.width*4)
-		destStart := y * r.width * 4
-		copy(rgba.Pix[destStart:destStart+r.width*4], data[srcStart:srcEnd])
+
+	uniformData := []float32{sliceDepth, 1}
+	r.queue.WriteBuffer(r.uniformBuffer, 48*4, wgpu.ToBytes(uniformData))
+
+	numMaterials := len(r.irmf.Materials)
+	imgs := newSliceImages(numMaterials, r.width, r.height, r.format, true)
+	imageSize := uint64(r.allBytesPerRow) * uint64(r.height)
+	for i, p := range r.allPasses {
+		encoder, err := r.device.CreateCommandEncoder(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var attachments []wgpu.RenderPassColorAttachment
+		for j := 0; j < p.targets; j++ {
+			attachments = append(attachments, wgpu.RenderPassColorAttachment{
+				View:       r.allViews[j],
+				LoadOp:     wgpu.LoadOpClear,
+				StoreOp:    wgpu.StoreOpStore,
+				ClearValue: wgpu.Color{R: 0, G: 0, B: 0, A: 0},
+			})
+		}
+		renderPass := encoder.BeginRenderPass(&wgpu.RenderPassDescriptor{ColorAttachments: attachments})
+		renderPass.SetPipeline(r.allPipelines[i])
+		renderPass.SetBindGroup(0, r.bindGroup, nil)
+		renderPass.SetVertexBuffer(0, r.vertexBuffer, 0, r.vertexBuffer.GetSize())
+		renderPass.Draw(6, 1, 0, 0)
+		if err := renderPass.End(); err != nil {
+			renderPass.Release()
+			return nil, err
+		}
+		renderPass.Release()
+
+		for j := 0; j < p.targets; j++ {
+			encoder.CopyTextureToBuffer(
+				r.allTextures[j].AsImageCopy(),
+				&wgpu.ImageCopyBuffer{
+					Buffer: r.allReadBuffer,
+					Layout: wgpu.TextureDataLayout{
+						Offset:       uint64(j) * imageSize,
+						BytesPerRow:  r.allBytesPerRow,
+						RowsPerImage: uint32(r.height),
+					},
+				},
+				&wgpu.Extent3D{
+					Width:              uint32(r.width),
+					Height:             uint32(r.height),
+					DepthOrArrayLayers: 1,
+				},
+			)
+		}
+
+		commandBuffer, err := encoder.Finish(nil)
+		if err != nil {
+			return nil, err
+		}
+		r.queue.Submit(commandBuffer)
+		commandBuffer.Release()
+		encoder.Release()
+
+		err = r.mapRead(r.allReadBuffer, uint64(p.targets)*imageSize, func(data []byte) {
+			r.readTargets(data, imageSize, p, imgs)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	r.readBuffer.Unmap()
 
 	if r.view {
 		glfw.PollEvents()
 	}
 
-	return rgba, nil
+	return imgs.imgs, nil
+}
+
+// readTargets copies the materials of pass p from the mapped
+// targets, each of imageSize bytes, to imgs.
+func (r *WebGPURenderer) readTargets(data []byte, imageSize uint64, p materialPass, imgs *sliceImages) {
+	numMaterials := len(imgs.imgs)
+	for j := 0; j < p.targets; j++ {
+		target := data[uint64(j)*imageSize:]
+		for c := 0; c < 4; c++ {
+			n := p.first + 4*j + c
+			if n >= numMaterials {
+				break
+			}
+			for y := 0; y < r.height; y++ {
+				row := target[uint32(y)*r.allBytesPerRow:]
+				for x := 0; x < r.width; x++ {
+					switch r.format {
+					case RGBA8:
+						imgs.setUnorm8(n, y*r.width+x, row[4*x+c])
+					case R16F:
+						imgs.set(n, y*r.width+x, halfToFloat32(binary.LittleEndian.Uint16(row[8*x+2*c:])))
+					default:
+						imgs.set(n, y*r.width+x, math.Float32frombits(binary.LittleEndian.Uint32(row[16*x+4*c:])))
+					}
+				}
+			}
+		}
+	}
+}
+
+// initAll creates the pipelines, targets and read buffer used by RenderAll.
+func (r *WebGPURenderer) initAll() error {
+	format, pixelSize, _ := r.allTextureFormat()
+	var maxTargets int
+	for _, p := range r.allPasses {
+		maxTargets = max(maxTargets, p.targets)
+	}
+
+	for j := 0; j < maxTargets; j++ {
+		texture, err := r.device.CreateTexture(&wgpu.TextureDescriptor{
+			Label: fmt.Sprintf("Material Texture %v", j),
+			Size: wgpu.Extent3D{
+				Width:              uint32(r.width),
+				Height:             uint32(r.height),
+				DepthOrArrayLayers: 1,
+			},
+			MipLevelCount: 1,
+			SampleCount:   1,
+			Dimension:     wgpu.TextureDimension2D,
+			Format:        format,
+			Usage:         wgpu.TextureUsageRenderAttachment | wgpu.TextureUsageCopySrc,
+		})
+		if err != nil {
+			r.closeAll()
+			return fmt.Errorf("failed to create material texture: %w", err)
+		}
+		r.allTextures = append(r.allTextures, texture)
+		view, err := texture.CreateView(nil)
+		if err != nil {
+			r.closeAll()
+			return fmt.Errorf("failed to create texture view: %w", err)
+		}
+		r.allViews = append(r.allViews, view)
+	}
+
+	for i, p := range r.allPasses {
+		targets := make([]wgpu.ColorTargetState, p.targets)
+		for j := range targets {
+			targets[j] = wgpu.ColorTargetState{Format: format, WriteMask: wgpu.ColorWriteMaskAll}
+		}
+		pipeline, err := r.device.CreateRenderPipeline(&wgpu.RenderPipelineDescriptor{
+			Layout: r.pipelineLayout,
+			Vertex: wgpu.VertexState{
+				Module:     r.shaderModule,
+				EntryPoint: "vs_main",
+				Buffers: []wgpu.VertexBufferLayout{
+					{
+						ArrayStride: 5 * 4,
+						Attributes: []wgpu.VertexAttribute{
+							{
+								Format:         wgpu.VertexFormatFloat32x3,
+								Offset:         0,
+								ShaderLocation: 0,
+							},
+						},
+					},
+				},
+			},
+			Fragment: &wgpu.FragmentState{
+				Module:     r.shaderModule,
+				EntryPoint: fmt.Sprintf("fs_all%v", i),
+				Targets:    targets,
+			},
+			Primitive: wgpu.PrimitiveState{
+				Topology: wgpu.PrimitiveTopologyTriangleList,
+			},
+			Multisample: wgpu.MultisampleState{
+				Count: 1,
+				Mask:  0xFFFFFFFF,
+			},
+		})
+		if err != nil {
+			r.closeAll()
+			return fmt.Errorf("failed to create render pipeline: %w", err)
+		}
+		r.allPipelines = append(r.allPipelines, pipeline)
+	}
+
+	var err error
+	r.allBytesPerRow = (uint32(r.width*pixelSize) + 255) &^ 255
+	r.allReadBuffer, err = r.device.CreateBuffer(&wgpu.BufferDescriptor{
+		Label: "Material Read Buffer",
+		Size:  uint64(maxTargets) * uint64(r.allBytesPerRow) * uint64(r.height),
+		Usage: wgpu.BufferUsageMapRead | wgpu.BufferUsageCopyDst,
+	})
+	if err != nil {
+		r.closeAll()
+		return fmt.Errorf("failed to create read buffer: %w", err)
+	}
+	return nil
+}
+
+// closeAll releases the resources used by RenderAll,
+// as well as the shader module and pipeline layout.
+func (r *WebGPURenderer) closeAll() {
+	for _, pipeline := range r.allPipelines {
+		pipeline.Release()
+	}
+	for _, view := range r.allViews {
+		view.Release()
+	}
+	for _, texture := range r.allTextures {
+		texture.Release()
+	}
+	if r.allReadBuffer != nil {
+		r.allReadBuffer.Release()
+		r.allReadBuffer = nil
+	}
+	if r.pipelineLayout != nil {
+		r.pipelineLayout.Release()
+		r.pipelineLayout = nil
+	}
+	if r.shaderModule != nil {
+		r.shaderModule.Release()
+		r.shaderModule = nil
+	}
+	r.allPipelines, r.allViews, r.allTextures = nil, nil, nil
+}
+
+// readImage converts the mapped read buffer to an image.
+func (r *WebGPURenderer) readImage(data []byte) image.Image {
+	if r.format == RGBA8 {
+		rgba := &image.RGBA{
+			Pix:    make([]uint8, r.width*r.height*4),
+			Stride: r.width * 4,
+			Rect:   image.Rect(0, 0, r.width, r.height),
+		}
+		for y := 0; y < r.height; y++ {
+			srcStart := uint32(y) * r.bytesPerRow
+			srcEnd := srcStart + uint32(r.width*4)
+			destStart := y * r.width * 4
+			copy(rgba.Pix[destStart:destStart+r.width*4], data[srcStart:srcEnd])
+		}
+		return rgba
+	}
+
+	gray := NewGray32f(image.Rect(0, 0, r.width, r.height))
+	for y := 0; y < r.height; y++ {
+		row := data[uint32(y)*r.bytesPerRow:]
+		for x := 0; x < r.width; x++ {
+			if r.format == R16F {
+				gray.Pix[y*r.width+x] = halfToFloat32(binary.LittleEndian.Uint16(row[2*x:]))
+			} else {
+				gray.Pix[y*r.width+x] = math.Float32frombits(binary.LittleEndian.Uint32(row[4*x:]))
+			}
+		}
+	}
+	return gray
 }
 
 func (r *WebGPURenderer) Close() {
+	r.closeAll()
 	if r.window != nil {
 		r.window.Destroy()
 		glfw.Terminate()
@@ -537,13 +887,15 @@ func genWGSLFooter(numMaterials int, vec3Str string) string {
 	wgslVec3 := strings.Replace(vec3Str, "fragVert", "fragVert", -1)
 
 	switch numMaterials {
-	default:
-		return fmt.Sprintf(wgslFSFooterFmt4, wgslVec3)
 	case 5, 6, 7, 8, 9:
 		return fmt.Sprintf(wgslFSFooterFmt9, wgslVec3)
 	case 10, 11, 12, 13, 14, 15, 16:
 		return fmt.Sprintf(wgslFSFooterFmt16, wgslVec3)
 	}
+	if numMaterials > 16 {
+		return fmt.Sprintf(wgslFSFooterFmtN, numMaterials, wgslVec3)
+	}
+	return fmt.Sprintf(wgslFSFooterFmt4, wgslVec3)
 }
 
 const wgslFSFooterFmt4 = `
@@ -616,3 +968,16 @@ fn fs_main(@location(0) fragVert: vec3f) -> @location(0) vec4f {
     return vec4f(color, color, color, 1.0);
 }
 `
+
+// wgslFSFooterFmtN is used by IRMF 1.1 models with more than 16 materials.
+// The array is copied to a var so that it can be indexed dynamically.
+const wgslFSFooterFmtN = `
+@fragment
+fn fs_main(@location(0) fragVert: vec3f) -> @location(0) vec4f {
+    let u_slice = uniforms.u_slice;
+    let u_materialNum = i32(uniforms.u_materialNum);
+    var m = mainModel%v(vec3f(%v));
+    let color = m[u_materialNum - 1];
+    return vec4f(color, color, color, 1.0);
+}
+`
@@ -74,10 +74,10 @@ func (d *dlp) writeHeader(img image.Image) error {
 		PlateY:                       120.96, // default
 		PlateZ:                       150.0,  // default
 		LayerThickness:               d.zRes / 1000.0,
-		NormalExposureTime:           6,  // default
-		BottomExposureTime:           50, // default
-		OffTime:                      0,  // default
-		BottomLayers:                 8,  // default
+		NormalExposureTime:           d.exposure.normal,
+		BottomExposureTime:           d.exposure.bottom,
+		OffTime:                      0, // default
+		BottomLayers:                 uint32(d.exposure.bottomLayers),
 		ScreenHeight:                 screenHeight,
 		ScreenWidth:                  screenWidth,
 		PreviewHeaderOffset:          uint32(previewHeaderOffset),
@@ -103,7 +103,7 @@ func (d *dlp) writeHeader(img image.Image) error {
 
 	for i := 0; i < d.numSlices; i++ {
 		expTime := header.NormalExposureTime
-		if i < int(header.BottomLayers) {
+		if d.firstLayer+i < int(header.BottomLayers) {
 			expTime = header.BottomExposureTime
 		}
 		imageDataSize := uint32(i)
@@ -111,7 +111,7 @@ func (d *dlp) writeHeader(img image.Image) error {
 			imageDataSize = uint32(len(layer0))
 		}
 		d.layerHeaders = append(d.layerHeaders, binCompatLayerHeader{
-			AbsoluteHeight:  float32(i) * d.zRes / 1000.0,
+			AbsoluteHeight:  float32(d.firstLayer+i) * d.zRes / 1000.0,
 			ExposureTime:    expTime,
 			PerLayerOffTime: 0,                           // default
 			ImageDataOffset: uint32(layerDataOffsets[i]), // will be overwritten later.
@@ -7,32 +7,112 @@
 package photon
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
+	"image/draw"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/gmlewis/irmf-slicer/v3/format"
 	"github.com/gmlewis/irmf-slicer/v3/irmf"
 )
 
 // Slicer represents a slicer that provides slices of voxels for multiple
-// materials (from an IRMF file).
-type Slicer interface {
-	NumMaterials() int
-	MaterialName(materialNum int) string // 1-based
-	MBB() (min, max [3]float32)          // in millimeters
-
-	PrepareRenderZ() error
-	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
-	NumZSlices() int
+// materials (from an IRMF model). It is shared by all output formats.
+type Slicer = format.Slicer
+
+func init() {
+	format.Register(format.Format{
+		Name:        "dlp",
+		Extension:   ".cbddlp",
+		Description: "ChiTuBox .cbddlp files (same as AnyCubic .photon), one per material",
+		Resolution:  [3]float32{47.25, 47.25, 50},
+		Options: []format.Option{
+			{Name: "exposure", Default: "6", Usage: "Exposure time of the layers in seconds"},
+			{Name: "bottom-exposure", Default: "50", Usage: "Exposure time of the bottom layers in seconds"},
+			{Name: "bottom-layers", Default: "8", Usage: "Number of bottom layers of the whole model"},
+		},
+		Write: func(ctx context.Context, baseFilename string, slicer format.Slicer, params format.Params) error {
+			exp, err := parseExposure(params.Options)
+			if err != nil {
+				return err
+			}
+			return slice(ctx, baseFilename, params.XRes, params.YRes, params.ZRes, exp, slicer)
+		},
+	})
+}
+
+// exposure represents the exposure of the layers, set by the options
+// of the "dlp" format.
+type exposure struct {
+	normal       float32 // seconds
+	bottom       float32 // seconds
+	bottomLayers int
+}
+
+var defaultExposure = exposure{normal: 6, bottom: 50, bottomLayers: 8}
+
+// parseExposure parses the options of the "dlp" format.
+func parseExposure(options map[string]string) (exposure, error) {
+	exp := defaultExposure
+	for name, value := range options {
+		var err error
+		switch name {
+		case "exposure", "bottom-exposure":
+			var f float64
+			if f, err = strconv.ParseFloat(value, 32); err == nil && f <= 0 {
+				err = fmt.Errorf("must be positive, got %v", f)
+			}
+			if name == "exposure" {
+				exp.normal = float32(f)
+			} else {
+				exp.bottom = float32(f)
+			}
+		case "bottom-layers":
+			if exp.bottomLayers, err = strconv.Atoi(value); err == nil && exp.bottomLayers < 0 {
+				err = fmt.Errorf("must not be negative, got %v", exp.bottomLayers)
+			}
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return exposure{}, fmt.Errorf("dlp option %q: %v", name, err)
+		}
+	}
+	return exp, nil
 }
 
 // Slice slices an IRMF shader into one or more .cbddlp files
 // containing many voxel slices as PNG images (one file per material).
+// All materials are rendered at once. The layer heights of a partial
+// slicing (see irmf.Slicer.Offset) start at the height of its first layer.
 func Slice(baseFilename string, xRes, yRes, zRes float32, slicer Slicer) error {
+	return SliceContext(context.Background(), baseFilename, xRes, yRes, zRes, slicer)
+}
+
+// SliceContext is like Slice, but stops slicing, removes the partially
+// written files and returns ctx.Err() when ctx is done.
+func SliceContext(ctx context.Context, baseFilename string, xRes, yRes, zRes float32, slicer Slicer) error {
+	return slice(ctx, baseFilename, xRes, yRes, zRes, defaultExposure, slicer)
+}
+
+// slice slices the model into .cbddlp files whose layers have the given exposure.
+func slice(ctx context.Context, baseFilename string, xRes, yRes, zRes float32, exp exposure, slicer Slicer) error {
+	min, max := slicer.MBB()
+	log.Printf("MBB=(%v,%v,%v)-(%v,%v,%v)", min[0], min[1], min[2], max[0], max[1], max[2])
+
+	if err := slicer.PrepareRenderZContext(ctx); err != nil {
+		return fmt.Errorf("PrepareRenderZ: %w", err)
+	}
+
+	var ds dlps
+	var files []*os.File
 	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
 		materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
 
@@ -40,45 +120,59 @@ func Slice(baseFilename string, xRes, yRes, zRes float32, slicer Slicer) error {
 
 		w, err := os.Create(dlpName)
 		if err != nil {
-			return fmt.Errorf("Create: %v", err)
+			removeFiles(files)
+			return &irmf.WriteError{Filename: dlpName, Err: err}
 		}
 
-		min, max := slicer.MBB()
-		log.Printf("MBB=(%v,%v,%v)-(%v,%v,%v)", min[0], min[1], min[2], max[0], max[1], max[2])
-
-		if err := slicer.PrepareRenderZ(); err != nil {
-			return fmt.Errorf("PrepareRenderZ: %v", err)
-		}
+		ds = append(ds, &dlp{w: w, name: dlpName, numSlices: slicer.NumZSlices(), firstLayer: slicer.Offset()[2], exposure: exp, xRes: xRes, yRes: yRes, zRes: zRes})
+		files = append(files, w)
+	}
 
-		d := &dlp{w: w, numSlices: slicer.NumZSlices(), xRes: xRes, yRes: yRes, zRes: zRes}
-		if err := slicer.RenderZSlices(materialNum, d, irmf.MinToMax); err != nil {
-			return err
-		}
+	if err := slicer.RenderAllZSlicesContext(ctx, ds, irmf.MinToMax); err != nil {
+		removeFiles(files)
+		return err
+	}
 
+	for i, d := range ds {
+		w := files[i]
 		// Go back and write all the image offset data.
 		if _, err := w.Seek(d.layerHeaderOffset0, io.SeekStart); err != nil {
-			return fmt.Errorf("seek: %v", err)
+			removeFiles(files)
+			return &irmf.WriteError{Filename: d.name, Err: fmt.Errorf("seek: %v", err)}
 		}
 		if err := binary.Write(w, binary.LittleEndian, d.layerHeaders); err != nil {
-			return err
+			removeFiles(files)
+			return &irmf.WriteError{Filename: d.name, Err: err}
 		}
 
 		if err := w.Close(); err != nil {
-			return fmt.Errorf("Unable to close file: %v", err)
+			removeFiles(files)
+			return &irmf.WriteError{Filename: d.name, Err: fmt.Errorf("Unable to close file: %v", err)}
 		}
 	}
 	return nil
 }
 
+// removeFiles closes and removes the partially written files.
+func removeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
 // dlp represents a SliceProcessor that writes its results
 // to a ChiTuBox .cbddlp (aka AnyCubic .photon) file.
 type dlp struct {
-	w io.Writer
+	w    io.Writer
+	name string // the name of the file, for errors
 
-	numSlices int
-	xRes      float32
-	yRes      float32
-	zRes      float32
+	numSlices  int
+	firstLayer int // the number of layers below the first slice
+	exposure   exposure
+	xRes       float32
+	yRes       float32
+	zRes       float32
 
 	layerHeaderOffset0 int64
 	layerHeaders       []binCompatLayerHeader
@@ -87,10 +181,37 @@ type dlp struct {
 // dlp implements the ZSliceProcessor interface.
 var _ irmf.ZSliceProcessor = &dlp{}
 
+// dlps writes the slices of each material with its own dlp.
+type dlps []*dlp
+
+// dlps implements the MultiZSliceProcessor interface.
+var _ irmf.MultiZSliceProcessor = dlps{}
+
+func (ds dlps) ProcessZSlices(n int, z, voxelRadius float32, imgs []image.Image) error {
+	for i, img := range imgs {
+		if err := ds[i].ProcessZSlice(n, z, voxelRadius, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *dlp) ProcessZSlice(n int, z, voxelRadius float32, img image.Image) error {
-	if n == 0 {
-		return d.writeHeader(img)
+	if _, ok := img.(*image.RGBA); !ok {
+		// e.g. an *irmf.Gray32f slice.
+		rgba := image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = rgba
 	}
 
-	return d.writeSlice(n, img)
+	var err error
+	if n == 0 {
+		err = d.writeHeader(img)
+	} else {
+		err = d.writeSlice(n, img)
+	}
+	if err != nil {
+		return &irmf.WriteError{Filename: d.name, Err: err}
+	}
+	return nil
 }
@@ -2,88 +2,141 @@
 package voxels
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"log"
 	"strings"
 
+	"github.com/gmlewis/irmf-slicer/v3/format"
 	"github.com/gmlewis/irmf-slicer/v3/irmf"
 	"github.com/gmlewis/stldice/v4/binvox"
 )
 
 // Slicer represents a slicer that provides slices of voxels for multiple
-// materials (from an IRMF model).
-type Slicer interface {
-	NumMaterials() int
-	MaterialName(materialNum int) string // 1-based
-	MBB() (min, max [3]float32)          // in millimeters
-
-	// PrepareRenderX() error
-	// RenderXSlices(materialNum int, sp irmf.XSliceProcessor, order irmf.Order) error
-	// PrepareRenderY() error
-	// RenderYSlices(materialNum int, sp irmf.YSliceProcessor, order irmf.Order) error
-	PrepareRenderZ() error
-	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
-	NumXSlices() int
-	NumYSlices() int
-	NumZSlices() int
+// materials (from an IRMF model). It is shared by all output formats.
+type Slicer = format.Slicer
+
+func init() {
+	format.Register(format.Format{
+		Name:        "stl",
+		Extension:   ".stl",
+		Description: "STL files, one per material",
+		Write: func(ctx context.Context, baseFilename string, slicer format.Slicer, params format.Params) error {
+			return SliceContext(ctx, baseFilename, slicer)
+		},
+	})
 }
 
+// maxAllVoxels bounds the number of voxels of all materials (as if every
+// voxel were filled) kept in memory to render the materials at once.
+// The materials of larger models are rendered and converted one at a time.
+var maxAllVoxels = 1 << 26
+
 // Slice slices an IRMF model into one or more STL files (one per material).
+// All materials are rendered at once unless the model is too large to keep
+// them all in memory. The meshes are placed at the MBB of the sliced region,
+// so the outputs of a partial slicing (see irmf.Slicer.SetRegion) keep their
+// position in the whole model.
 func Slice(baseFilename string, slicer Slicer) error {
-	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
-		materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
-
-		stlFile := fmt.Sprintf("%v-mat%02d-%v.stl", baseFilename, materialNum, materialName)
-
-		min, max := slicer.MBB()
-		scale := float64(max[2] - min[2])
-		model := binvox.New(
-			slicer.NumXSlices(),
-			slicer.NumYSlices(),
-			slicer.NumZSlices(),
-			float64(min[0]),
-			float64(min[1]),
-			float64(min[2]),
-			scale,
-			false,
-		)
-
-		c := &client{model: model, slicer: slicer}
-
-		log.Printf("Rendering...")
-		if err := slicer.PrepareRenderZ(); err != nil {
-			return fmt.Errorf("PrepareRenderZ: %v", err)
-		}
+	return SliceContext(context.Background(), baseFilename, slicer)
+}
 
-		if err := slicer.RenderZSlices(materialNum, c, irmf.MaxToMin); err != nil {
-			return fmt.Errorf("RenderZSlices: %v", err)
+// SliceContext is like Slice, but stops slicing and returns ctx.Err()
+// when ctx is done. Only the files of the materials that were completely
+// sliced are written.
+func SliceContext(ctx context.Context, baseFilename string, slicer Slicer) error {
+	log.Printf("Rendering...")
+	if err := slicer.PrepareRenderZContext(ctx); err != nil {
+		return fmt.Errorf("PrepareRenderZ: %w", err)
+	}
+
+	numMaterials := slicer.NumMaterials()
+	if numMaterials*slicer.NumXSlices()*slicer.NumYSlices()*slicer.NumZSlices() > maxAllVoxels {
+		for materialNum := 1; materialNum <= numMaterials; materialNum++ {
+			c := &client{models: []*binvox.BinVOX{newModel(slicer)}, slicer: slicer}
+			if err := slicer.RenderZSlicesContext(ctx, materialNum, c, irmf.MaxToMin); err != nil {
+				return fmt.Errorf("RenderZSlices: %w", err)
+			}
+			if err := saveSTL(baseFilename, slicer, materialNum, c.models[0]); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
+
+	var models []*binvox.BinVOX
+	for range numMaterials {
+		models = append(models, newModel(slicer))
+	}
+	c := &client{models: models, slicer: slicer}
+
+	if err := slicer.RenderAllZSlicesContext(ctx, c, irmf.MaxToMin); err != nil {
+		return fmt.Errorf("RenderAllZSlices: %w", err)
+	}
 
-		log.Printf("Converting to STL...")
-		mesh := model.MarchingCubes()
-		log.Printf("Writing: %v", stlFile)
-		if err := mesh.SaveSTL(stlFile); err != nil {
-			log.Fatalf("SaveSTL: %v", err)
+	for i, model := range models {
+		if err := saveSTL(baseFilename, slicer, i+1, model); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// newModel returns an empty model of the voxels of a material.
+func newModel(slicer Slicer) *binvox.BinVOX {
+	min, max := slicer.MBB()
+	scale := float64(max[2] - min[2])
+	return binvox.New(
+		slicer.NumXSlices(),
+		slicer.NumYSlices(),
+		slicer.NumZSlices(),
+		float64(min[0]),
+		float64(min[1]),
+		float64(min[2]),
+		scale,
+		false,
+	)
+}
+
+// saveSTL converts the voxels of the material to a mesh and writes its STL file.
+func saveSTL(baseFilename string, slicer Slicer, materialNum int, model *binvox.BinVOX) error {
+	materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
+	stlFile := fmt.Sprintf("%v-mat%02d-%v.stl", baseFilename, materialNum, materialName)
 
+	log.Printf("Converting material %v to STL...", materialName)
+	mesh := model.MarchingCubes()
+	log.Printf("Writing: %v", stlFile)
+	if err := mesh.SaveSTL(stlFile); err != nil {
+		return &irmf.WriteError{Filename: stlFile, Err: fmt.Errorf("SaveSTL: %v", err)}
+	}
 	return nil
 }
 
 // client represents a voxels-to-STL converter.
-// It implements the irmf.SliceProcessor interface.
+// It implements the irmf.MultiZSliceProcessor interface, and the
+// irmf.ZSliceProcessor interface for a single material.
 type client struct {
-	model  *binvox.BinVOX
+	models []*binvox.BinVOX // one per material
 	slicer Slicer
 }
 
-// client implements the ZSliceProcessor interface.
-var _ irmf.ZSliceProcessor = &client{}
+// client implements the MultiZSliceProcessor and ZSliceProcessor interfaces.
+var (
+	_ irmf.MultiZSliceProcessor = &client{}
+	_ irmf.ZSliceProcessor      = &client{}
+)
+
+func (c *client) ProcessZSlices(sliceNum int, z, voxelRadius float32, imgs []image.Image) error {
+	for i, img := range imgs {
+		scanImage(img, c.models[i], sliceNum)
+	}
+	return nil
+}
 
 func (c *client) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
-	scanImage(img, c.model, sliceNum)
+	scanImage(img, c.models[0], sliceNum)
 	return nil
 }
 
@@ -2,15 +2,26 @@ package zipper
 
 import (
 	"archive/zip"
+	"context"
 	"fmt"
 	"time"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
 )
 
 // SVXSlice slices an IRMF shader into one or more SVX files
 // containing many voxel slices as PNG images (one file per material).
+// The offset of a partial slicing (see irmf.Slicer.Offset) is recorded
+// in the metadata of the manifest.
 func SVXSlice(baseFilename string, slicer Slicer) error {
+	return SVXSliceContext(context.Background(), baseFilename, slicer)
+}
+
+// SVXSliceContext is like SVXSlice, but stops slicing, removes the
+// partially written files and returns ctx.Err() when ctx is done.
+func SVXSliceContext(ctx context.Context, baseFilename string, slicer Slicer) error {
 	zp := &zipper{fmtStr: "density/slice%04d.png", suffix: "svx", manifest: true}
-	return processMaterials(baseFilename, slicer, zp)
+	return processMaterials(ctx, baseFilename, slicer, zp)
 }
 
 func (zp *zipper) writeManifest(slicer Slicer) error {
@@ -20,29 +31,43 @@ func (zp *zipper) writeManifest(slicer Slicer) error {
 	}
 	f, err := zp.w.CreateHeader(fh)
 	if err != nil {
-		return fmt.Errorf("Unable to create ZIP file %q: %v", fh.Name, err)
+		return &irmf.WriteError{Filename: zp.filename, Err: fmt.Errorf("Unable to create ZIP file %q: %v", fh.Name, err)}
 	}
 
 	min, max := slicer.MBB()
 	voxelSize := (max[2] - min[2]) / float32(slicer.NumZSlices())
 
+	// Float slices are written as 16-bit PNGs.
+	bits := 8
+	if slicer.PixelFormat() != irmf.RGBA8 {
+		bits = 16
+	}
+
+	var metadata string
+	if offset := slicer.Offset(); offset != [3]int{} {
+		metadata = fmt.Sprintf(offsetFmt, offset[0], offset[1], offset[2])
+	}
+
 	fmt.Fprintf(f, manifestFmt,
 		slicer.NumXSlices(),
 		slicer.NumYSlices(),
 		slicer.NumZSlices(),
 		voxelSize/1000.0, // voxelSize in meters
+		bits,
+		bits,
 		zp.irmf.Author,
-		zp.irmf.Date)
+		zp.irmf.Date,
+		metadata)
 	return nil
 }
 
 var manifestFmt = `<?xml version="1.0"?>
 
 <grid version="1.0" gridSizeX="%v" gridSizeY="%v" gridSizeZ="%v"
-   voxelSize="%0.6f" subvoxelBits="8" slicesOrientation="Z" >
+   voxelSize="%0.6f" subvoxelBits="%v" slicesOrientation="Z" >
 
     <channels>
-        <channel type="DENSITY" bits="8" slices="density/slice%%04d.png" />
+        <channel type="DENSITY" bits="%v" slices="density/slice%%04d.png" />
     </channels>
 
     <materials>
@@ -51,6 +76,11 @@ var manifestFmt = `<?xml version="1.0"?>
 
     <metadata>
         <entry key="author" value=%q />
-        <entry key="creationDate" value=%q />
+        <entry key="creationDate" value=%q />%v
     </metadata>
 </grid>`
+
+var offsetFmt = `
+        <entry key="offsetX" value="%v" />
+        <entry key="offsetY" value="%v" />
+        <entry key="offsetZ" value="%v" />`
@@ -4,6 +4,7 @@ package zipper
 
 import (
 	"archive/zip"
+	"context"
 	"fmt"
 	"image"
 	"image/png"
@@ -12,32 +13,63 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gmlewis/irmf-slicer/v3/format"
 	"github.com/gmlewis/irmf-slicer/v3/irmf"
 )
 
 // Slicer represents a slicer that provides slices of voxels for multiple
-// materials (from an IRMF file).
-type Slicer interface {
-	IRMF() *irmf.IRMF
-	NumMaterials() int
-	NumXSlices() int
-	NumYSlices() int
-	NumZSlices() int
-	MaterialName(materialNum int) string // 1-based
-	MBB() (min, max [3]float32)          // in millimeters
-
-	PrepareRenderZ() error
-	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+// materials (from an IRMF model). It is shared by all output formats.
+type Slicer = format.Slicer
+
+func init() {
+	format.Register(format.Format{
+		Name:        "zip",
+		Extension:   ".zip",
+		Description: "ZIP files of PNG slices, one per material",
+		Resolution:  [3]float32{65, 60, 30},
+		Write: func(ctx context.Context, baseFilename string, slicer format.Slicer, params format.Params) error {
+			return SliceContext(ctx, baseFilename, slicer)
+		},
+	})
+
+	format.Register(format.Format{
+		Name:        "svx",
+		Extension:   ".svx",
+		Description: "SVX voxel files, one per material",
+		Write: func(ctx context.Context, baseFilename string, slicer format.Slicer, params format.Params) error {
+			return SVXSliceContext(ctx, baseFilename, slicer)
+		},
+	})
 }
 
 // Slice slices an IRMF shader into one or more ZIP files
 // containing many voxel slices as PNG images (one file per material).
+// The slices are numbered from the start of the whole model, and the
+// offset of a partial slicing (see irmf.Slicer.Offset) is recorded
+// in the ZIP comment.
 func Slice(baseFilename string, slicer Slicer) error {
-	zp := &zipper{fmtStr: "out%04d.png", suffix: "zip"}
-	return processMaterials(baseFilename, slicer, zp)
+	return SliceContext(context.Background(), baseFilename, slicer)
 }
 
-func processMaterials(baseFilename string, slicer Slicer, baseZipper *zipper) error {
+// SliceContext is like Slice, but stops slicing, removes the partially
+// written files and returns ctx.Err() when ctx is done.
+func SliceContext(ctx context.Context, baseFilename string, slicer Slicer) error {
+	zp := &zipper{fmtStr: "out%04d.png", suffix: "zip", globalNames: true}
+	return processMaterials(ctx, baseFilename, slicer, zp)
+}
+
+// processMaterials renders all materials at once, writing each
+// to its own ZIP file.
+func processMaterials(ctx context.Context, baseFilename string, slicer Slicer, baseZipper *zipper) error {
+	min, max := slicer.MBB()
+	log.Printf("MBB=(%v,%v,%v)-(%v,%v,%v)", min[0], min[1], min[2], max[0], max[1], max[2])
+
+	if err := slicer.PrepareRenderZContext(ctx); err != nil {
+		return fmt.Errorf("PrepareRenderZ: %w", err)
+	}
+
+	var zps zippers
+	var files []*os.File
 	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
 		materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
 
@@ -45,52 +77,79 @@ func processMaterials(baseFilename string, slicer Slicer, baseZipper *zipper) er
 
 		zf, err := os.Create(zipName)
 		if err != nil {
-			return fmt.Errorf("Create: %v", err)
+			removeFiles(files)
+			return &irmf.WriteError{Filename: zipName, Err: err}
 		}
+		files = append(files, zf)
 		w := zip.NewWriter(zf)
-
-		min, max := slicer.MBB()
-		log.Printf("MBB=(%v,%v,%v)-(%v,%v,%v)", min[0], min[1], min[2], max[0], max[1], max[2])
-
-		if err := slicer.PrepareRenderZ(); err != nil {
-			return fmt.Errorf("PrepareRenderZ: %v", err)
+		offset := slicer.Offset()
+		if offset != [3]int{} {
+			if err := w.SetComment(fmt.Sprintf("offset=%v,%v,%v", offset[0], offset[1], offset[2])); err != nil {
+				removeFiles(files)
+				return &irmf.WriteError{Filename: zipName, Err: err}
+			}
 		}
 
-		zp := &zipper{w: w, fmtStr: baseZipper.fmtStr, irmf: slicer.IRMF()}
+		zp := &zipper{w: w, filename: zipName, fmtStr: baseZipper.fmtStr, irmf: slicer.IRMF()}
+		if baseZipper.globalNames {
+			zp.firstSlice = offset[2]
+		}
 		if baseZipper.manifest {
 			if err := zp.writeManifest(slicer); err != nil {
+				removeFiles(files)
 				return err
 			}
 		}
-		if err := slicer.RenderZSlices(materialNum, zp, irmf.MinToMax); err != nil {
-			return err
-		}
+		zps = append(zps, zp)
+	}
+
+	if err := slicer.RenderAllZSlicesContext(ctx, zps, irmf.MinToMax); err != nil {
+		removeFiles(files)
+		return err
+	}
 
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("Unable to close ZIP writer: %v", err)
+	for i, zp := range zps {
+		if err := zp.w.Close(); err != nil {
+			removeFiles(files)
+			return &irmf.WriteError{Filename: zp.filename, Err: fmt.Errorf("Unable to close ZIP writer: %v", err)}
 		}
 
-		if err := zf.Close(); err != nil {
-			return fmt.Errorf("Unable to close ZIP file: %v", err)
+		if err := files[i].Close(); err != nil {
+			removeFiles(files)
+			return &irmf.WriteError{Filename: zp.filename, Err: fmt.Errorf("Unable to close ZIP file: %v", err)}
 		}
 	}
 	return nil
 }
 
+// removeFiles closes and removes the partially written files.
+func removeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
 // zipper represents a SliceProcessor that writes its results to a ZIP file.
 type zipper struct {
 	w        *zip.Writer
+	filename string // the name of the ZIP file, for errors
 	fmtStr   string
 	irmf     *irmf.IRMF
 	manifest bool
 	suffix   string
+
+	// globalNames numbers the slices from the start of the whole model,
+	// so the first slice written is firstSlice.
+	globalNames bool
+	firstSlice  int
 }
 
 // zipper implements the ZSliceProcessor interface.
 var _ irmf.ZSliceProcessor = &zipper{}
 
 func (zp *zipper) ProcessZSlice(n int, z, voxelRadius float32, img image.Image) error {
-	filename := fmt.Sprintf(zp.fmtStr, n)
+	filename := fmt.Sprintf(zp.fmtStr, zp.firstSlice+n)
 	fh := &zip.FileHeader{
 		Name:     filename,
 		Comment:  fmt.Sprintf("z=%0.2f", z),
@@ -98,11 +157,26 @@ func (zp *zipper) ProcessZSlice(n int, z, voxelRadius float32, img image.Image)
 	}
 	f, err := zp.w.CreateHeader(fh)
 	if err != nil {
-		return fmt.Errorf("Unable to create ZIP file %q: %v", filename, err)
+		return &irmf.WriteError{Filename: zp.filename, Err: fmt.Errorf("Unable to create ZIP file %q: %v", filename, err)}
 	}
 	if err := png.Encode(f, img); err != nil {
-		return fmt.Errorf("PNG encode: %v", err)
+		return &irmf.WriteError{Filename: zp.filename, Err: fmt.Errorf("PNG encode: %v", err)}
 	}
 
 	return nil
 }
+
+// zippers writes the slices of each material with its own zipper.
+type zippers []*zipper
+
+// zippers implements the MultiZSliceProcessor interface.
+var _ irmf.MultiZSliceProcessor = zippers{}
+
+func (zps zippers) ProcessZSlices(n int, z, voxelRadius float32, imgs []image.Image) error {
+	for i, img := range imgs {
+		if err := zps[i].ProcessZSlice(n, z, voxelRadius, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}